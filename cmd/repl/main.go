@@ -2,6 +2,8 @@ package main
 
 import (
 	"bufio"
+	"encoding/binary"
+	"flag"
 	"fmt"
 	"os"
 	"strings"
@@ -12,18 +14,26 @@ import (
 )
 
 const (
-	dbFile   = "sharingan.db"
-	walFile  = "sharingan.wal"
-	metaFile = "sharingan.wal.meta"
+	dbFile  = "sharingan.db"
+	walFile = "sharingan.wal"
 )
 
 func main() {
+	syncFlag := flag.String("sync", "full", "durability mode: full, normal or off")
+	flag.Parse()
+
+	syncMode, err := storage.ParseSyncMode(*syncFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
 	fmt.Println("🔥 Sharingan DB - Interactive Shell")
 	fmt.Println("Type 'help' for commands, 'exit' to quit")
 	fmt.Println()
 
 	// Initialize database
-	tree, pager, bufferPool, err := initDatabase()
+	tree, pager, bufferPool, err := initDatabase(syncMode)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize database: %v\n", err)
 		os.Exit(1)
@@ -35,57 +45,45 @@ func main() {
 }
 
 // initDatabase initializes or loads existing database
-func initDatabase() (*bptree.BPTree, storage.Pager, *storage.BufferPool, error) {
+func initDatabase(syncMode storage.SyncMode) (*bptree.BPTree, storage.Pager, *storage.BufferPool, error) {
 	// Check if database exists
-	dbExists := fileExists(dbFile)
-	walExists := fileExists(walFile) && fileExists(metaFile)
-
-	if !dbExists {
+	if !fileExists(dbFile) {
 		fmt.Println("📁 Creating new database...")
-		return createFreshDatabase()
+		return createFreshDatabase(syncMode)
 	}
 
-	if walExists {
+	if fileExists(walFile) {
 		fmt.Println("⚠️  WAL detected - recovering database...")
-		tree, pager, bufferPool, err := recoverFromWAL()
-		if err != nil {
-			return nil, nil, nil, err
-		}
-		fmt.Println("✅ Recovery completed")
-		return tree, pager, bufferPool, nil
+	} else {
+		fmt.Println("📂 Loading existing database...")
 	}
 
-	// Load existing database without recovery
-	fmt.Println("📂 Loading existing database...")
 	pager, err := storage.NewFilePager(dbFile)
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	pager.SetSyncMode(syncMode)
 
 	bufferPool := storage.NewBufferPool(pager, 128)
 
-	// Load metadata
-	rootPageID, order, err := bptree.LoadMetadata(metaFile)
-	if err != nil {
-		bufferPool.Close()
-		return nil, nil, nil, fmt.Errorf("failed to load metadata: %w", err)
-	}
-
-	tree, err := bptree.LoadBPTree(bufferPool, rootPageID, order, walFile)
+	// Root page and order come from the superblock, WAL is replayed automatically
+	tree, err := bptree.OpenBPTree(bufferPool, 100, walFile)
 	if err != nil {
 		bufferPool.Close()
 		return nil, nil, nil, err
 	}
+	tree.SetSyncMode(syncMode)
 
 	return tree, pager, bufferPool, nil
 }
 
 // createFreshDatabase creates a new database
-func createFreshDatabase() (*bptree.BPTree, storage.Pager, *storage.BufferPool, error) {
+func createFreshDatabase(syncMode storage.SyncMode) (*bptree.BPTree, storage.Pager, *storage.BufferPool, error) {
 	pager, err := storage.NewFilePager(dbFile)
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	pager.SetSyncMode(syncMode)
 
 	bufferPool := storage.NewBufferPool(pager, 128)
 
@@ -94,33 +92,7 @@ func createFreshDatabase() (*bptree.BPTree, storage.Pager, *storage.BufferPool,
 		bufferPool.Close()
 		return nil, nil, nil, err
 	}
-
-	return tree, pager, bufferPool, nil
-}
-
-// recoverFromWAL recovers database from WAL
-func recoverFromWAL() (*bptree.BPTree, storage.Pager, *storage.BufferPool, error) {
-	// Load metadata
-	rootPageID, order, err := bptree.LoadMetadata(metaFile)
-	if err != nil {
-		return nil, nil, nil, err
-	}
-
-	// Open pager
-	pager, err := storage.NewFilePager(dbFile)
-	if err != nil {
-		return nil, nil, nil, err
-	}
-
-	// Create buffer pool
-	bufferPool := storage.NewBufferPool(pager, 128)
-
-	// Load tree (will replay WAL automatically)
-	tree, err := bptree.LoadBPTree(bufferPool, rootPageID, order, walFile)
-	if err != nil {
-		bufferPool.Close()
-		return nil, nil, nil, err
-	}
+	tree.SetSyncMode(syncMode)
 
 	return tree, pager, bufferPool, nil
 }
@@ -197,6 +169,15 @@ func handleMetaCommand(cmd string, tree *bptree.BPTree, bufferPool *storage.Buff
 	case ".keys":
 		showAllKeys(tree)
 
+	case ".checkpoint":
+		runCheckpoint(tree)
+
+	case ".vacuum":
+		runVacuum(tree)
+
+	case ".check":
+		runCheck(tree)
+
 	default:
 		fmt.Printf("Unknown meta command: %s\n", cmd)
 		fmt.Println("Type '.help' for available meta commands")
@@ -207,8 +188,9 @@ func handleMetaCommand(cmd string, tree *bptree.BPTree, bufferPool *storage.Buff
 func showStats(tree *bptree.BPTree, bufferPool *storage.BufferPool) {
 	fmt.Println("\n📊 Database Statistics:")
 	fmt.Printf("   Root Page: %d\n", tree.GetRootPageID())
-	fmt.Printf("   Tree Order: %d\n", tree.GetOrder())
+	fmt.Printf("   Tree Order: %d (informational)\n", tree.GetOrder())
 	fmt.Printf("   WAL Syncs: %d\n", tree.GetWALSyncCount())
+	fmt.Printf("   Sync Mode: %s\n", tree.SyncMode())
 
 	stats := bufferPool.GetStats()
 	fmt.Printf("\n📦 Buffer Pool:\n")
@@ -221,12 +203,12 @@ func showStats(tree *bptree.BPTree, bufferPool *storage.BufferPool) {
 	fmt.Printf("   Dirty Pages: %d\n", stats.DirtyPages)
 
 	// Get all keys for count
-	keys, err := tree.InOrderTraversal()
+	keys, err := tree.InOrderTraversalKeys()
 	if err == nil {
 		fmt.Printf("\n📚 Data:\n")
 		fmt.Printf("   Total Keys: %d\n", len(keys))
 		if len(keys) > 0 {
-			fmt.Printf("   Key Range: [%d, %d]\n", keys[0], keys[len(keys)-1])
+			fmt.Printf("   Key Range: [%s, %s]\n", formatKey(keys[0]), formatKey(keys[len(keys)-1]))
 		}
 	}
 
@@ -246,10 +228,10 @@ func showStats(tree *bptree.BPTree, bufferPool *storage.BufferPool) {
 func showTreeInfo(tree *bptree.BPTree) {
 	fmt.Println("\n🌲 B+ Tree Information:")
 	fmt.Printf("   Root Page ID: %d\n", tree.GetRootPageID())
-	fmt.Printf("   Order (max keys per node): %d\n", tree.GetOrder())
+	fmt.Printf("   Order (informational, nodes split by page space): %d\n", tree.GetOrder())
 	fmt.Printf("   WAL Syncs: %d\n", tree.GetWALSyncCount())
 
-	keys, err := tree.InOrderTraversal()
+	keys, err := tree.InOrderTraversalKeys()
 	if err != nil {
 		fmt.Printf("   Error getting keys: %v\n", err)
 		return
@@ -258,8 +240,8 @@ func showTreeInfo(tree *bptree.BPTree) {
 	fmt.Printf("   Total Keys: %d\n", len(keys))
 
 	if len(keys) > 0 {
-		fmt.Printf("   Min Key: %d\n", keys[0])
-		fmt.Printf("   Max Key: %d\n", keys[len(keys)-1])
+		fmt.Printf("   Min Key: %s\n", formatKey(keys[0]))
+		fmt.Printf("   Max Key: %s\n", formatKey(keys[len(keys)-1]))
 	}
 
 	fmt.Println()
@@ -273,6 +255,7 @@ func showBufferPoolStats(bufferPool *storage.BufferPool) {
 	fmt.Printf("   Capacity: %d pages (%.2f KB)\n", stats.Capacity, float64(stats.Capacity*4)/1024)
 	fmt.Printf("   Current Size: %d pages\n", stats.Size)
 	fmt.Printf("   Utilization: %.2f%%\n", float64(stats.Size)/float64(stats.Capacity)*100)
+	fmt.Printf("   Shards: %d (%s)\n", stats.Shards, stats.Policy)
 	fmt.Println()
 
 	fmt.Println("   Performance:")
@@ -280,47 +263,137 @@ func showBufferPoolStats(bufferPool *storage.BufferPool) {
 	fmt.Printf("     Cache Misses: %d\n", stats.Misses)
 	fmt.Printf("     Hit Rate: %.2f%%\n", stats.HitRate*100)
 	fmt.Printf("     Evictions: %d\n", stats.Evictions)
+	fmt.Printf("     Eviction Writes: %d\n", stats.EvictionWrites)
 	fmt.Println()
 
+	if stats.BackgroundRounds > 0 {
+		fmt.Println("   Background Writer:")
+		fmt.Printf("     Rounds: %d\n", stats.BackgroundRounds)
+		fmt.Printf("     Pages Written: %d\n", stats.BackgroundWrites)
+		fmt.Printf("     Waits for WAL: %d\n", stats.BackgroundWALWaits)
+		fmt.Println()
+	}
+
+	if stats.Prefetched > 0 {
+		fmt.Println("   Read-Ahead:")
+		fmt.Printf("     Pages Prefetched: %d\n", stats.Prefetched)
+		fmt.Printf("     Prefetch Hits: %d\n", stats.PrefetchHits)
+		fmt.Println()
+	}
+
 	fmt.Println("   Memory:")
 	fmt.Printf("     Dirty Pages: %d\n", stats.DirtyPages)
 	fmt.Printf("     Clean Pages: %d\n", stats.Size-stats.DirtyPages)
 	fmt.Println()
+
+	if err := bufferPool.Err(); err != nil {
+		fmt.Printf("   ⚠️  Read-only: %v\n", err)
+		fmt.Println()
+	}
 }
 
 // showAllKeys displays all keys in the database
 func showAllKeys(tree *bptree.BPTree) {
-	keys, err := tree.InOrderTraversal()
-	if err != nil {
+	it := tree.ScanKeys(nil, nil)
+	defer it.Close()
+
+	// Show first 50 keys, count the rest without loading them
+	limit := 50
+	var sb strings.Builder
+	count := 0
+
+	for it.Next() {
+		if count < limit {
+			if count > 0 && count%10 == 0 {
+				sb.WriteString("\n")
+			}
+			fmt.Fprintf(&sb, "%s ", formatKey(it.KeyBytes()))
+		}
+		count++
+	}
+
+	if err := it.Err(); err != nil {
 		fmt.Printf("Error: %v\n", err)
 		return
 	}
 
-	if len(keys) == 0 {
+	if count == 0 {
 		fmt.Println("(empty database)")
 		return
 	}
 
-	fmt.Printf("\n📋 All Keys (%d total):\n", len(keys))
+	fmt.Printf("\n📋 All Keys (%d total):\n", count)
+	fmt.Print(sb.String())
 
-	// Show first 50 keys
-	limit := 50
-	if len(keys) < limit {
-		limit = len(keys)
+	if count > limit {
+		fmt.Printf("\n... (%d more keys)", count-limit)
 	}
 
-	for i := 0; i < limit; i++ {
-		if i > 0 && i%10 == 0 {
-			fmt.Println()
-		}
-		fmt.Printf("%d ", keys[i])
+	fmt.Print("\n\n")
+}
+
+// runCheckpoint flushes dirty pages and truncates the WAL
+func runCheckpoint(tree *bptree.BPTree) {
+	walSize, _ := tree.WALSize()
+
+	if err := tree.Checkpoint(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	lsn, _ := tree.CheckpointLSN()
+	fmt.Printf("✓ Checkpoint complete at LSN %d (%.2f KB of WAL truncated)\n", lsn, float64(walSize)/1024)
+}
+
+// runVacuum compacts the database file
+func runVacuum(tree *bptree.BPTree) {
+	stats, err := tree.Vacuum()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
 	}
 
-	if len(keys) > limit {
-		fmt.Printf("\n... (%d more keys)", len(keys)-limit)
+	fmt.Printf("✓ Vacuum complete: %d -> %d pages (%.2f KB reclaimed)\n",
+		stats.PagesBefore, stats.PagesAfter, float64(stats.BytesReclaimed)/1024)
+}
+
+// runCheck verifies the database file and lists what is wrong with it
+func runCheck(tree *bptree.BPTree) {
+	report, err := tree.Check()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
 	}
 
-	fmt.Println("\n")
+	fmt.Println("\n🔍 Integrity Check:")
+	fmt.Printf("   Pages: %d\n", report.Pages)
+	fmt.Printf("   Tree Pages: %d (%d leaves)\n", report.TreePages, report.Leaves)
+	fmt.Printf("   Free Pages: %d\n", report.FreePages)
+
+	if report.OK() {
+		fmt.Print("   ✓ No violations found\n\n")
+		return
+	}
+
+	fmt.Printf("   ✗ %d violations:\n", len(report.Violations))
+	for _, v := range report.Violations {
+		fmt.Printf("     %s\n", v)
+	}
+	fmt.Println()
+}
+
+// formatKey prints a 4-byte key as its uint32 number, a printable byte-string
+// key quoted and any other key in hex
+func formatKey(key []byte) string {
+	if len(key) == 4 {
+		return fmt.Sprintf("%d", binary.BigEndian.Uint32(key))
+	}
+	for _, b := range key {
+		if b < 0x20 || b > 0x7e {
+			return fmt.Sprintf("%x", key)
+		}
+	}
+	return fmt.Sprintf("%q", key)
 }
 
 // showHelp displays available commands
@@ -330,12 +403,16 @@ func showHelp() {
 	fmt.Println("  SQL Commands:")
 	fmt.Println("    INSERT INTO kv VALUES (<key>, '<value>');  - Insert a key-value pair")
 	fmt.Println("    SELECT * FROM kv WHERE key = <key>;        - Query by key")
+	fmt.Println("    VACUUM;                                    - Compact the database file")
 	fmt.Println()
 	fmt.Println("  Meta Commands (start with .):")
 	fmt.Println("    .stats         - Show database statistics")
 	fmt.Println("    .tree          - Show B+ Tree information")
 	fmt.Println("    .buffer        - Show buffer pool statistics")
 	fmt.Println("    .keys          - List all keys")
+	fmt.Println("    .checkpoint    - Flush dirty pages and truncate the WAL")
+	fmt.Println("    .vacuum        - Compact the database file")
+	fmt.Println("    .check         - Verify the integrity of the database file")
 	fmt.Println("    .clear         - Clear screen")
 	fmt.Println("    .help          - Show this help")
 	fmt.Println()
@@ -92,8 +92,14 @@ func TestREPLInitialization(t *testing.T) {
 		_ = oldWALFile
 	}()
 
+	// createFreshDatabase writes to the default files, don't leave them behind
+	os.Remove(dbFile)
+	os.Remove(walFile)
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
 	// Test creating fresh database
-	tree, pager, bufferPool, err := createFreshDatabase()
+	tree, pager, bufferPool, err := createFreshDatabase(storage.SyncFull)
 	if err != nil {
 		t.Fatalf("Failed to create fresh database: %v", err)
 	}
@@ -158,6 +164,16 @@ func TestREPLMetaCommands(t *testing.T) {
 			cmd:      ".keys",
 			contains: []string{"All Keys", "10 total"},
 		},
+		{
+			name:     "Vacuum command",
+			cmd:      ".vacuum",
+			contains: []string{"Vacuum complete"},
+		},
+		{
+			name:     "Check command",
+			cmd:      ".check",
+			contains: []string{"Integrity Check", "No violations found"},
+		},
 	}
 
 	for _, tt := range tests {
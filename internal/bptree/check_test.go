@@ -0,0 +1,245 @@
+package bptree
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+// checkViolations runs Check and fails unless it reports exactly the given
+// kinds on the given page, none counts as a healthy tree
+func checkViolations(t *testing.T, tree *BPTree, pageID uint64, kinds ...ViolationKind) *CheckReport {
+	t.Helper()
+	report, err := tree.Check()
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	found := make(map[ViolationKind]bool)
+	for _, v := range report.Violations {
+		found[v.Kind] = true
+		expected := false
+		for _, kind := range kinds {
+			expected = expected || (v.Kind == kind && v.PageID == pageID)
+		}
+		if !expected {
+			t.Errorf("Unexpected violation %s", v)
+		}
+	}
+	for _, kind := range kinds {
+		if !found[kind] {
+			t.Errorf("Check missed a %s violation on page %d", kind, pageID)
+		}
+	}
+	if report.OK() != (len(kinds) == 0) {
+		t.Errorf("OK() = %v with %d violations", report.OK(), len(report.Violations))
+	}
+	return report
+}
+
+// corruptPage rewrites a page of tree through mutate and returns a func
+// putting it back
+func corruptPage(t *testing.T, tree *BPTree, pageID uint64, mutate func(page *storage.Page)) func() {
+	t.Helper()
+	data, err := tree.pager.ReadPage(pageID)
+	if err != nil {
+		t.Fatalf("Failed to read page %d: %v", pageID, err)
+	}
+	original := append([]byte(nil), data...)
+
+	page, err := storage.DeserializePage(data)
+	if err != nil {
+		t.Fatalf("Failed to decode page %d: %v", pageID, err)
+	}
+	mutate(page)
+	if err := tree.pager.WritePage(pageID, page.Serialize()); err != nil {
+		t.Fatalf("Failed to write page %d: %v", pageID, err)
+	}
+
+	return func() {
+		if err := tree.pager.WritePage(pageID, original); err != nil {
+			t.Fatalf("Failed to restore page %d: %v", pageID, err)
+		}
+	}
+}
+
+func TestBPTreeCheck(t *testing.T) {
+	dbFile := "test_check.db"
+	walFile := "test_check.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	const n = 3000
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	bufferPool := storage.NewBufferPool(pager, 64)
+	tree, err := NewBPTree(bufferPool, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	report := checkViolations(t, tree, 0)
+	if report.Leaves != 1 || report.TreePages != 1 {
+		t.Errorf("Empty tree has %d leaves in %d pages, expected 1", report.Leaves, report.TreePages)
+	}
+
+	// Every page is accounted for as the tree grows, shrinks and moves
+	fillAndThin(t, tree, n)
+	report = checkViolations(t, tree, 0)
+	if report.Pages != pager.NumPages() || uint64(report.TreePages+report.FreePages) != report.Pages-2 {
+		t.Errorf("Check counted %d tree and %d free pages in %d, file has %d",
+			report.TreePages, report.FreePages, report.Pages, pager.NumPages())
+	}
+
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	checkViolations(t, tree, 0)
+
+	if _, err := tree.Vacuum(); err != nil {
+		t.Fatalf("Vacuum failed: %v", err)
+	}
+	report = checkViolations(t, tree, 0)
+	if report.FreePages != 0 || uint64(report.TreePages) != report.Pages-2 {
+		t.Errorf("Check counted %d tree and %d free pages in %d after vacuum",
+			report.TreePages, report.FreePages, report.Pages)
+	}
+}
+
+func TestBPTreeCheckCorruption(t *testing.T) {
+	dbFile := "test_check_corruption.db"
+	walFile := "test_check_corruption.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	// Straight to the file, so a corrupted page is what the tree reads next
+	tree, err := NewBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	for i := uint32(0); i < 2000; i++ {
+		if err := tree.Insert(i, vacuumTestValue(i)); err != nil {
+			t.Fatalf("Failed to insert key=%d: %v", i, err)
+		}
+	}
+	checkViolations(t, tree, 0)
+
+	// Find the root and its two leftmost leaves
+	root := tree.rootPage
+	rootPage, err := readPageStruct(pager, root)
+	if err != nil || rootPage.Header.PageType != storage.PageTypeInternal {
+		t.Fatalf("Root page %d isn't an internal page: %v", root, err)
+	}
+	firstLeaf := root
+	for {
+		page, err := readPageStruct(pager, firstLeaf)
+		if err != nil {
+			t.Fatalf("Failed to read page %d: %v", firstLeaf, err)
+		}
+		if page.Header.PageType == storage.PageTypeLeaf {
+			break
+		}
+		if firstLeaf, err = tree.internalPage(page).GetChild(0); err != nil {
+			t.Fatalf("Failed to read child of page %d: %v", firstLeaf, err)
+		}
+	}
+	leafPage, err := readPageStruct(pager, firstLeaf)
+	if err != nil {
+		t.Fatalf("Failed to read page %d: %v", firstLeaf, err)
+	}
+	secondLeaf := uint64(leafPage.Header.NextPage)
+	records, err := tree.leafPage(leafPage).GetAllRecords()
+	if err != nil || !records[0].IsOverflow() {
+		t.Fatalf("First leaf doesn't start with an overflow record: %v", err)
+	}
+	firstKey, overflow := records[0].Key, records[0].Overflow
+
+	restore := corruptPage(t, tree, firstLeaf, func(page *storage.Page) {
+		page.Header.Parent = 999
+	})
+	checkViolations(t, tree, firstLeaf, ViolationParent)
+	restore()
+
+	restore = corruptPage(t, tree, firstLeaf, func(page *storage.Page) {
+		page.Header.NextPage = 0
+	})
+	checkViolations(t, tree, firstLeaf, ViolationLeafChain)
+	restore()
+
+	// A key of the first leaf pushed to the front of the second
+	restore = corruptPage(t, tree, secondLeaf, func(page *storage.Page) {
+		records, err := tree.leafPage(page).GetAllRecords()
+		if err != nil {
+			t.Fatalf("Failed to read leaf %d: %v", secondLeaf, err)
+		}
+		header := page.Header
+		*page = *storage.NewPage(storage.PageTypeLeaf)
+		page.Header.Parent, page.Header.NextPage = header.Parent, header.NextPage
+
+		leaf := tree.leafPage(page)
+		moved := &storage.Record{Key: firstKey, Value: []byte("moved")}
+		for _, record := range append([]*storage.Record{moved}, records[1:]...) {
+			if err := leaf.AppendRecord(record); err != nil {
+				t.Fatalf("Failed to append record: %v", err)
+			}
+		}
+	})
+	checkViolations(t, tree, secondLeaf, ViolationKeyOrder, ViolationSeparator)
+	restore()
+
+	// A cut overflow chain strands its tail
+	restore = corruptPage(t, tree, overflow, func(page *storage.Page) {
+		page.Header.NextPage = 0
+	})
+	report, err := tree.Check()
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	overflowFound, leaked := false, 0
+	for _, v := range report.Violations {
+		switch {
+		case v.Kind == ViolationOverflow && v.PageID == firstLeaf:
+			overflowFound = true
+		case v.Kind == ViolationLeaked:
+			leaked++
+		default:
+			t.Errorf("Unexpected violation %s", v)
+		}
+	}
+	if !overflowFound || leaked != overflowPages(vacuumTestValue(0))-1 {
+		t.Errorf("Cut overflow chain reported overflow=%v with %d pages leaked", overflowFound, leaked)
+	}
+	restore()
+	checkViolations(t, tree, 0)
+
+	// A page allocated and never used leaks
+	leakedPage, err := pager.AllocatePage()
+	if err != nil {
+		t.Fatalf("AllocatePage failed: %v", err)
+	}
+	checkViolations(t, tree, leakedPage, ViolationLeaked)
+
+	// A page freed while the tree still points at it
+	if err := pager.FreePage(leakedPage); err != nil {
+		t.Fatalf("FreePage failed: %v", err)
+	}
+	if err := pager.FreePage(secondLeaf); err != nil {
+		t.Fatalf("FreePage failed: %v", err)
+	}
+	checkViolations(t, tree, secondLeaf, ViolationFreeInUse)
+}
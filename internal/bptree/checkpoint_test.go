@@ -0,0 +1,106 @@
+package bptree
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+func TestBPTreeCheckpoint(t *testing.T) {
+	dbFile := "test_checkpoint.db"
+	walFile := "test_checkpoint.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	// Phase 1: checkpoint halfway, then crash without flushing the buffer pool
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+
+		bufferPool := storage.NewBufferPool(pager, 64)
+
+		tree, err := NewBPTree(bufferPool, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+
+		for i := uint32(0); i < 1000; i++ {
+			if err := tree.Insert(i, fmt.Sprintf("value-%d", i)); err != nil {
+				t.Fatalf("Failed to insert key=%d: %v", i, err)
+			}
+		}
+
+		if err := tree.Checkpoint(); err != nil {
+			t.Fatalf("Checkpoint failed: %v", err)
+		}
+
+		if size, _ := tree.WALSize(); size != 0 {
+			t.Errorf("WAL size after checkpoint = %d, expected 0", size)
+		}
+		if age := tree.WALAge(); age != 0 {
+			t.Errorf("WAL age after checkpoint = %v, expected 0", age)
+		}
+		if stats := bufferPool.GetStats(); stats.DirtyPages != 0 {
+			t.Errorf("%d dirty pages after checkpoint", stats.DirtyPages)
+		}
+
+		checkpointLSN, err := tree.CheckpointLSN()
+		if err != nil {
+			t.Fatalf("Failed to read checkpoint LSN: %v", err)
+		}
+		if checkpointLSN != 1001 {
+			t.Errorf("CheckpointLSN = %d, expected 1001", checkpointLSN)
+		}
+
+		for i := uint32(1000); i < 1200; i++ {
+			if err := tree.Insert(i, fmt.Sprintf("value-%d", i)); err != nil {
+				t.Fatalf("Failed to insert key=%d: %v", i, err)
+			}
+		}
+		if _, err := tree.Delete(7); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+
+		if size, _ := tree.WALSize(); size == 0 {
+			t.Error("WAL is empty after post-checkpoint writes")
+		}
+
+		tree.wal.Close()
+		pager.Close()
+	}
+
+	// Phase 2: only the post-checkpoint tail needs replaying
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to reopen pager: %v", err)
+		}
+		defer pager.Close()
+
+		tree, err := OpenBPTree(pager, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to open tree: %v", err)
+		}
+		defer tree.Close()
+
+		for i := uint32(0); i < 1200; i++ {
+			value, found, err := tree.Search(i)
+			if err != nil {
+				t.Fatalf("Search(%d) failed: %v", i, err)
+			}
+			if i == 7 {
+				if found {
+					t.Error("Deleted key=7 reappeared")
+				}
+				continue
+			}
+			if !found || value != fmt.Sprintf("value-%d", i) {
+				t.Fatalf("Key=%d: (%q, %v) after recovery", i, value, found)
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package bptree
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+// latchTestValue builds a value that names its key, padded so leaves split often
+func latchTestValue(key uint32, version string) string {
+	return fmt.Sprintf("%d-%s-%s", key, version, strings.Repeat("x", 40))
+}
+
+// checkScan scans the whole tree, checking keys ascend and values match them
+func checkScan(t *testing.T, it *Iterator) []uint32 {
+	t.Helper()
+	defer it.Close()
+
+	var keys []uint32
+	for it.Next() {
+		if len(keys) > 0 && it.Key() <= keys[len(keys)-1] {
+			t.Errorf("Scan returned %d after %d", it.Key(), keys[len(keys)-1])
+		}
+		if !strings.HasPrefix(it.Value(), fmt.Sprintf("%d-", it.Key())) {
+			t.Errorf("Scan returned value %q for key %d", it.Value(), it.Key())
+		}
+		keys = append(keys, it.Key())
+	}
+	if err := it.Err(); err != nil {
+		t.Errorf("Scan failed: %v", err)
+	}
+	return keys
+}
+
+func TestConcurrentReadersAndWriters(t *testing.T) {
+	dbFile := "test_latch.db"
+	walFile := "test_latch.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(storage.NewBufferPool(pager, 64), 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+	tree.SetSyncMode(storage.SyncOff)
+
+	const (
+		numWriters    = 8
+		numReaders    = 4
+		keysPerWriter = 300
+	)
+
+	// Writers own interleaved keys: they insert, delete every third key and
+	// update the next one, so leaves split and merge under the readers
+	var writers sync.WaitGroup
+	for w := 0; w < numWriters; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			key := func(i int) uint32 { return uint32(i*numWriters + w) }
+
+			for i := 0; i < keysPerWriter; i++ {
+				if err := tree.Insert(key(i), latchTestValue(key(i), "v1")); err != nil {
+					t.Errorf("Insert(%d) failed: %v", key(i), err)
+					return
+				}
+			}
+			for i := 0; i < keysPerWriter; i++ {
+				switch i % 3 {
+				case 0:
+					if deleted, err := tree.Delete(key(i)); err != nil || !deleted {
+						t.Errorf("Delete(%d) = (%v, %v), expected (true, nil)", key(i), deleted, err)
+						return
+					}
+				case 1:
+					if err := tree.Insert(key(i), latchTestValue(key(i), "v2")); err != nil {
+						t.Errorf("Update(%d) failed: %v", key(i), err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+
+	var stop atomic.Bool
+	var readers sync.WaitGroup
+	for r := 0; r < numReaders; r++ {
+		readers.Add(1)
+		go func(r int) {
+			defer readers.Done()
+			rng := rand.New(rand.NewSource(int64(r)))
+
+			for !stop.Load() {
+				key := uint32(rng.Intn(numWriters * keysPerWriter))
+				value, found, err := tree.Search(key)
+				if err != nil {
+					t.Errorf("Search(%d) failed: %v", key, err)
+					return
+				}
+				if found && !strings.HasPrefix(value, fmt.Sprintf("%d-", key)) {
+					t.Errorf("Search(%d) returned %q", key, value)
+				}
+
+				if rng.Intn(20) == 0 {
+					checkScan(t, tree.Scan(0, numWriters*keysPerWriter))
+				}
+			}
+		}(r)
+	}
+
+	// Snapshots see the same keys however often they are scanned
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for !stop.Load() {
+			snap := tree.Snapshot()
+			first := checkScan(t, snap.Scan(0, numWriters*keysPerWriter))
+			second := checkScan(t, snap.Scan(0, numWriters*keysPerWriter))
+			if len(first) != len(second) {
+				t.Errorf("Snapshot scans returned %d and %d keys", len(first), len(second))
+			}
+			snap.Close()
+
+			if err := tree.Checkpoint(); err != nil {
+				t.Errorf("Checkpoint failed: %v", err)
+			}
+			if _, err := tree.GarbageCollect(); err != nil {
+				t.Errorf("GarbageCollect failed: %v", err)
+			}
+		}
+	}()
+
+	writers.Wait()
+	stop.Store(true)
+	readers.Wait()
+
+	keys := checkScan(t, tree.Scan(0, numWriters*keysPerWriter))
+	if expected := numWriters * (keysPerWriter - (keysPerWriter+2)/3); len(keys) != expected {
+		t.Errorf("%d keys left, expected %d", len(keys), expected)
+	}
+
+	for i := 0; i < numWriters*keysPerWriter; i++ {
+		key := uint32(i)
+		value, found, err := tree.Search(key)
+		if err != nil {
+			t.Fatalf("Search(%d) failed: %v", key, err)
+		}
+
+		switch (i / numWriters) % 3 {
+		case 0:
+			if found {
+				t.Errorf("Deleted key %d still present", key)
+			}
+		case 1:
+			if value != latchTestValue(key, "v2") {
+				t.Errorf("Search(%d) = %q, expected the update", key, value)
+			}
+		case 2:
+			if value != latchTestValue(key, "v1") {
+				t.Errorf("Search(%d) = %q, expected the first insert", key, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package bptree
+
+import (
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+// ErrDeadlock is returned to the victim of a cycle in the wait-for graph
+// Roll the transaction back and retry it.
+var ErrDeadlock = errors.New("deadlock detected, transaction aborted")
+
+// LockMode is the strength of a key lock
+type LockMode int
+
+const (
+	// LockShared lets other shared holders in, used for reads
+	LockShared LockMode = iota
+	// LockExclusive is held alone, used for writes
+	LockExclusive
+)
+
+// String returns the mode name
+func (m LockMode) String() string {
+	if m == LockExclusive {
+		return "X"
+	}
+	return "S"
+}
+
+// LockManager grants key-level shared/exclusive locks to transactions
+// Locks are held until ReleaseAll (strict two-phase locking). Requests
+// queue behind conflicting waiters, so a stream of readers can't starve a
+// writer and an upgrade can't overtake a request that came first. A waiter's edges in the
+// wait-for graph are checked every time they change. When they close a cycle
+// the youngest owner in it (highest ID) gets ErrDeadlock, so the oldest
+// transaction always makes progress and retries can't livelock.
+// LockManager is safe for concurrent use.
+type LockManager struct {
+	mu      sync.Mutex
+	changed *sync.Cond // Broadcast whenever locks are granted or released
+
+	locks    map[string]map[uint64]LockMode // Key -> holder -> mode
+	queues   map[string][]lockRequest       // Key -> requests waiting, oldest first
+	held     map[uint64][]string            // Holder -> keys it has locked
+	waitsFor map[uint64][]uint64            // Waiter -> owners it waits for
+	victims  map[uint64]bool                // Waiters chosen to break a cycle
+
+	deadlocks int
+}
+
+// lockRequest is a request waiting for a key
+type lockRequest struct {
+	owner uint64
+	mode  LockMode
+}
+
+// NewLockManager creates an empty lock manager
+func NewLockManager() *LockManager {
+	lm := &LockManager{
+		locks:    make(map[string]map[uint64]LockMode),
+		queues:   make(map[string][]lockRequest),
+		held:     make(map[uint64][]string),
+		waitsFor: make(map[uint64][]uint64),
+		victims:  make(map[uint64]bool),
+	}
+	lm.changed = sync.NewCond(&lm.mu)
+	return lm
+}
+
+// Lock acquires key in mode for owner, blocking while other owners hold a
+// conflicting lock. Holding a shared lock and asking for exclusive upgrades it.
+// Returns ErrDeadlock, without the lock, if owner is picked to break a deadlock.
+func (lm *LockManager) Lock(owner uint64, key uint32, mode LockMode) error {
+	return lm.LockKey(owner, storage.Uint32Key(key), mode)
+}
+
+// LockKey is Lock for a byte-string key
+func (lm *LockManager) LockKey(owner uint64, keyBytes []byte, mode LockMode) error {
+	key := string(keyBytes)
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	queued := false
+	for {
+		if lm.victims[owner] {
+			delete(lm.victims, owner)
+			lm.leaveQueue(key, owner, queued)
+			return ErrDeadlock
+		}
+
+		holders := lm.locks[key]
+		current, holds := holders[owner]
+		if holds && current >= mode {
+			return nil
+		}
+
+		blockers := conflicting(holders, owner, mode)
+		blockers = append(blockers, lm.queuedAhead(key, owner, mode)...)
+
+		if len(blockers) == 0 {
+			lm.leaveQueue(key, owner, queued)
+			if holders == nil {
+				holders = make(map[uint64]LockMode)
+				lm.locks[key] = holders
+			}
+			if !holds {
+				lm.held[owner] = append(lm.held[owner], key)
+			}
+			holders[owner] = mode
+
+			// Waiters on this key now wait for one more holder, let them
+			// recompute their edges so a cycle through it is still found
+			lm.changed.Broadcast()
+			return nil
+		}
+
+		if !queued {
+			lm.queues[key] = append(lm.queues[key], lockRequest{owner: owner, mode: mode})
+			queued = true
+		}
+
+		lm.waitsFor[owner] = blockers
+		if cycle := lm.findCycle(owner); cycle != nil {
+			victim := slices.Max(cycle)
+			delete(lm.waitsFor, victim)
+			lm.deadlocks++
+			if victim == owner {
+				lm.leaveQueue(key, owner, queued)
+				return ErrDeadlock
+			}
+			lm.victims[victim] = true
+			lm.changed.Broadcast()
+		}
+
+		lm.changed.Wait()
+	}
+}
+
+// queuedAhead returns the conflicting owners queued for key before owner
+func (lm *LockManager) queuedAhead(key string, owner uint64, mode LockMode) []uint64 {
+	var blockers []uint64
+	for _, request := range lm.queues[key] {
+		if request.owner == owner {
+			break
+		}
+		if mode == LockExclusive || request.mode == LockExclusive {
+			blockers = append(blockers, request.owner)
+		}
+	}
+	return blockers
+}
+
+// leaveQueue removes owner's request for key once it stops waiting
+func (lm *LockManager) leaveQueue(key string, owner uint64, queued bool) {
+	delete(lm.waitsFor, owner)
+	if !queued {
+		return
+	}
+
+	queue := slices.DeleteFunc(lm.queues[key], func(r lockRequest) bool { return r.owner == owner })
+	if len(queue) == 0 {
+		delete(lm.queues, key)
+	} else {
+		lm.queues[key] = queue
+	}
+
+	// Requests queued behind this one may be grantable now
+	lm.changed.Broadcast()
+}
+
+// conflicting returns the holders other than owner incompatible with mode
+func conflicting(holders map[uint64]LockMode, owner uint64, mode LockMode) []uint64 {
+	var blockers []uint64
+	for holder, held := range holders {
+		if holder == owner {
+			continue
+		}
+		if mode == LockExclusive || held == LockExclusive {
+			blockers = append(blockers, holder)
+		}
+	}
+	return blockers
+}
+
+// findCycle returns the owners on a wait-for cycle through owner, nil if there is none
+func (lm *LockManager) findCycle(owner uint64) []uint64 {
+	parent := map[uint64]uint64{owner: owner}
+	stack := []uint64{owner}
+
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		for _, next := range lm.waitsFor[current] {
+			if next == owner {
+				cycle := []uint64{owner}
+				for node := current; node != owner; node = parent[node] {
+					cycle = append(cycle, node)
+				}
+				return cycle
+			}
+			if _, seen := parent[next]; !seen {
+				parent[next] = current
+				stack = append(stack, next)
+			}
+		}
+	}
+	return nil
+}
+
+// ReleaseAll drops every lock held by owner and wakes the waiters
+func (lm *LockManager) ReleaseAll(owner uint64) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	for _, key := range lm.held[owner] {
+		holders := lm.locks[key]
+		delete(holders, owner)
+		if len(holders) == 0 {
+			delete(lm.locks, key)
+		}
+	}
+	delete(lm.held, owner)
+	delete(lm.waitsFor, owner)
+	delete(lm.victims, owner)
+
+	lm.changed.Broadcast()
+}
+
+// Held returns the mode owner holds key in, false if it holds no lock on key
+func (lm *LockManager) Held(owner uint64, key uint32) (LockMode, bool) {
+	return lm.HeldKey(owner, storage.Uint32Key(key))
+}
+
+// HeldKey is Held for a byte-string key
+func (lm *LockManager) HeldKey(owner uint64, key []byte) (LockMode, bool) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	mode, ok := lm.locks[string(key)][owner]
+	return mode, ok
+}
+
+// Deadlocks returns the number of deadlocks broken
+func (lm *LockManager) Deadlocks() int {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.deadlocks
+}
@@ -0,0 +1,291 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+// ErrSnapshotClosed is returned when reading through a closed snapshot
+var ErrSnapshotClosed = errors.New("snapshot is closed")
+
+// Snapshot is a read view of the tree at one point in time
+// Versions replaced or deleted after the snapshot was taken stay in the
+// leaves until it is closed, so its reads never see later writes.
+type Snapshot struct {
+	tree   *BPTree
+	lsn    uint64
+	closed bool
+}
+
+// Snapshot opens a read view of every write applied so far
+// Writes in flight are waited for, the snapshot sees each of them whole.
+func (tree *BPTree) Snapshot() *Snapshot {
+	tree.quiesce.Lock()
+	defer tree.quiesce.Unlock()
+
+	// Every logged write is applied, and later ones see the registration
+	// before they retire a version the snapshot needs
+	lsn := tree.wal.LastLSN()
+
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	if tree.snapshots == nil {
+		tree.snapshots = make(map[uint64]int)
+	}
+	tree.snapshots[lsn]++
+
+	return &Snapshot{tree: tree, lsn: lsn}
+}
+
+// LSN returns the LSN of the last write the snapshot sees
+func (s *Snapshot) LSN() uint64 {
+	return s.lsn
+}
+
+// Get retrieves a value as of the snapshot
+func (s *Snapshot) Get(key uint32) (string, bool, error) {
+	return s.GetKey(storage.Uint32Key(key))
+}
+
+// GetKey retrieves the value of a byte-string key as of the snapshot
+func (s *Snapshot) GetKey(key []byte) (string, bool, error) {
+	if s.closed {
+		return "", false, ErrSnapshotClosed
+	}
+
+	var record *storage.Record
+	err := s.tree.readLeaf(key, func(leaf *storage.LeafPage) error {
+		found := false
+		if record, found = leaf.SearchVisible(key, s.lsn); !found {
+			return nil
+		}
+		return s.tree.loadValue(record)
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to find leaf page: %w", err)
+	}
+	if record == nil {
+		return "", false, nil
+	}
+	return record.GetValueAsString(), true, nil
+}
+
+// Scan returns an iterator over keys in [start, end] as of the snapshot
+func (s *Snapshot) Scan(start, end uint32) *Iterator {
+	return s.ScanKeys(storage.Uint32Key(start), storage.Uint32Key(end))
+}
+
+// ScanKeys returns an iterator over byte-string keys in [start, end] as of
+// the snapshot, a nil start or end leaves that side unbounded
+func (s *Snapshot) ScanKeys(start, end []byte) *Iterator {
+	if s.closed {
+		return &Iterator{err: ErrSnapshotClosed, done: true}
+	}
+
+	return s.tree.scan(start, end, s)
+}
+
+// Close releases the snapshot so the versions only it could see can be pruned
+func (s *Snapshot) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	tree := s.tree
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	if tree.snapshots[s.lsn]--; tree.snapshots[s.lsn] == 0 {
+		delete(tree.snapshots, s.lsn)
+	}
+	return nil
+}
+
+// visible reports whether the snapshot sees a version
+func (s *Snapshot) visible(record *storage.Record) bool {
+	return record.VisibleAt(s.lsn)
+}
+
+// OpenSnapshots returns the number of snapshots not yet closed
+func (tree *BPTree) OpenSnapshots() int {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	n := 0
+	for _, count := range tree.snapshots {
+		n += count
+	}
+	return n
+}
+
+// DeadVersions returns how many deleted versions are kept for snapshots
+func (tree *BPTree) DeadVersions() int {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	return tree.deadVersions
+}
+
+// addDeadVersions records a change in the number of deleted versions kept
+func (tree *BPTree) addDeadVersions(n int) {
+	if n == 0 {
+		return
+	}
+	tree.mu.Lock()
+	tree.deadVersions += n
+	tree.mu.Unlock()
+}
+
+// versionNeeded reports whether an open snapshot can see a version
+func (tree *BPTree) versionNeeded(record *storage.Record) bool {
+	deletedBy := record.DeletedBy
+	if deletedBy == 0 {
+		deletedBy = math.MaxUint64
+	}
+
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	for lsn := range tree.snapshots {
+		if record.CreatedBy <= lsn && lsn < deletedBy {
+			return true
+		}
+	}
+	return false
+}
+
+// retireVersion makes room for a new version of key: the live version is
+// kept as a deleted version if a snapshot can see it, removed otherwise
+func (op *writeOp) retireVersion(leaf *storage.LeafPage, key []byte) {
+	if live, found := leaf.SearchRecord(key); found {
+		live.DeletedBy = op.lsn
+		if op.tree.versionNeeded(live) {
+			leaf.MarkDeleted(key, op.lsn)
+			op.deadVersions++
+		} else {
+			leaf.DeleteRecord(key)
+			op.removed = append(op.removed, live)
+		}
+	}
+
+	op.pruneVersions(leaf, key)
+}
+
+// pruneVersions drops the deleted versions of key no snapshot can see
+func (op *writeOp) pruneVersions(leaf *storage.LeafPage, key []byte) {
+	if op.tree.DeadVersions() == 0 {
+		return
+	}
+	removed := leaf.PruneVersions(key, op.tree.versionNeeded)
+	op.deadVersions -= len(removed)
+	op.removed = append(op.removed, removed...)
+}
+
+// versionBoundary moves a split index to the nearest position that doesn't
+// separate versions of the same key, lookups only search one leaf per key
+func versionBoundary(records []*storage.Record, index int, cmp storage.Comparator) (int, error) {
+	sameKey := func(i int) bool {
+		return cmp.Compare(records[i-1].Key, records[i].Key) == 0
+	}
+
+	for offset := 0; offset < len(records); offset++ {
+		if i := index + offset; i > 0 && i < len(records) && !sameKey(i) {
+			return i, nil
+		}
+		if i := index - offset; i > 0 && i < len(records) && !sameKey(i) {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("page holds versions of a single key only, close old snapshots")
+}
+
+// GarbageCollect prunes deleted versions that no open snapshot can see
+// Writes wait while it runs, reads carry on.
+// Returns the number of versions removed
+func (tree *BPTree) GarbageCollect() (int, error) {
+	tree.quiesce.Lock()
+	defer tree.quiesce.Unlock()
+
+	leafPageID, err := tree.findLeftmostLeaf()
+	if err != nil {
+		return 0, fmt.Errorf("failed to find leftmost leaf: %w", err)
+	}
+
+	// Collect first, pruning can merge leaves under the walk
+	var keys [][]byte
+	remaining := 0
+	for leafPageID != 0 {
+		records, nextPageID, err := tree.leafRecords(leafPageID)
+		if err != nil {
+			return 0, err
+		}
+		for _, record := range records {
+			if record.IsLive() {
+				continue
+			}
+			if tree.versionNeeded(record) {
+				remaining++
+				continue
+			}
+			if len(keys) == 0 || !bytes.Equal(keys[len(keys)-1], record.Key) {
+				keys = append(keys, record.Key)
+			}
+		}
+
+		leafPageID = nextPageID
+	}
+
+	pruned := 0
+	for _, key := range keys {
+		n, err := tree.pruneKey(key)
+		if err != nil {
+			return pruned, err
+		}
+		pruned += n
+	}
+
+	// Versions left behind by a crash weren't counted, recount from the walk
+	tree.mu.Lock()
+	tree.deadVersions = remaining
+	tree.mu.Unlock()
+
+	return pruned, nil
+}
+
+// pruneKey removes the unneeded deleted versions of one key, rebalancing the leaf
+func (tree *BPTree) pruneKey(key []byte) (int, error) {
+	op := tree.newWriteOp(0)
+	defer op.release()
+
+	leafPageID, leafPage, err := op.latchLeaf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	leaf := tree.leafPage(leafPage)
+	op.removed = leaf.PruneVersions(key, tree.versionNeeded)
+	n := len(op.removed)
+	if n == 0 {
+		return 0, nil
+	}
+
+	if err := op.writePage(leafPageID, leafPage); err != nil {
+		return 0, err
+	}
+	if err := op.finish(); err != nil {
+		return 0, err
+	}
+
+	if leafPage.Header.Parent != 0 && leafUnderflow(leaf) {
+		op.release()
+		if err := op.rebalance(key); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,442 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+func TestTxCommitAndRollback(t *testing.T) {
+	dbFile := "test_tx.db"
+	walFile := "test_tx.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(storage.NewBufferPool(pager, 64), 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	for i := uint32(0); i < 10; i++ {
+		if err := tree.Insert(i, fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+
+	t.Run("Commit", func(t *testing.T) {
+		tx, err := tree.Begin()
+		if err != nil {
+			t.Fatalf("Begin failed: %v", err)
+		}
+
+		if err := tx.Put(100, "new"); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := tx.Put(1, "updated"); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if found, err := tx.Delete(2); err != nil || !found {
+			t.Fatalf("Delete = (%v, %v), expected (true, nil)", found, err)
+		}
+
+		// The transaction sees its own writes, the tree doesn't yet
+		if value, found, _ := tx.Get(1); !found || value != "updated" {
+			t.Errorf("tx.Get(1) = (%q, %v)", value, found)
+		}
+		if _, found, _ := tx.Get(2); found {
+			t.Error("tx.Get(2) found a key deleted in the transaction")
+		}
+		if _, found, _ := tree.Search(100); found {
+			t.Error("Uncommitted key=100 visible in the tree")
+		}
+		if value, _, _ := tree.Search(1); value != "value-1" {
+			t.Errorf("Uncommitted update visible: key=1 value=%s", value)
+		}
+
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+
+		if value, found, _ := tree.Search(100); !found || value != "new" {
+			t.Errorf("Key=100 = (%q, %v) after commit", value, found)
+		}
+		if value, _, _ := tree.Search(1); value != "updated" {
+			t.Errorf("Key=1 = %s after commit", value)
+		}
+		if _, found, _ := tree.Search(2); found {
+			t.Error("Key=2 still present after committed delete")
+		}
+
+		if err := tx.Put(5, "late"); err != ErrTxDone {
+			t.Errorf("Put after commit: err=%v, expected ErrTxDone", err)
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		tx, err := tree.Begin()
+		if err != nil {
+			t.Fatalf("Begin failed: %v", err)
+		}
+		if err := tx.Put(200, "discarded"); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if _, err := tx.Delete(3); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("Rollback failed: %v", err)
+		}
+
+		if _, found, _ := tree.Search(200); found {
+			t.Error("Rolled back key=200 present")
+		}
+		if _, found, _ := tree.Search(3); !found {
+			t.Error("Rolled back delete removed key=3")
+		}
+		if err := tx.Commit(); err != ErrTxDone {
+			t.Errorf("Commit after rollback: err=%v, expected ErrTxDone", err)
+		}
+	})
+
+	t.Run("Scan", func(t *testing.T) {
+		tx, err := tree.Begin()
+		if err != nil {
+			t.Fatalf("Begin failed: %v", err)
+		}
+		defer tx.Rollback()
+
+		tx.Put(4, "changed")
+		tx.Put(7, "changed")
+		tx.Delete(5)
+		tx.Put(50, "outside")
+
+		it := tx.Scan(3, 8)
+		defer it.Close()
+
+		var keys []uint32
+		for it.Next() {
+			keys = append(keys, it.Key())
+			if (it.Key() == 4 || it.Key() == 7) && it.Value() != "changed" {
+				t.Errorf("Key=%d: value=%s, expected changed", it.Key(), it.Value())
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+
+		expected := []uint32{3, 4, 6, 7, 8}
+		if fmt.Sprint(keys) != fmt.Sprint(expected) {
+			t.Errorf("Scan = %v, expected %v", keys, expected)
+		}
+	})
+
+	if n := tree.ActiveTxs(); n != 0 {
+		t.Errorf("%d transactions still active", n)
+	}
+}
+
+func TestTxRecovery(t *testing.T) {
+	dbFile := "test_tx_recovery.db"
+	walFile := "test_tx_recovery.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	// Phase 1: interleave transactions, checkpoint while one is open, then crash
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+
+		tree, err := NewBPTree(storage.NewBufferPool(pager, 64), 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+
+		if err := tree.Insert(1, "base"); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+
+		a, _ := tree.Begin()
+		b, _ := tree.Begin()
+		aborted, _ := tree.Begin()
+		unfinished, _ := tree.Begin()
+
+		// a begins first but commits last, after overwriting key 1, so its value wins
+		a.Put(10, "a")
+		b.Put(1, "from-b")
+		b.Put(20, "b")
+		aborted.Put(30, "aborted")
+		unfinished.Put(40, "unfinished")
+
+		if err := b.Commit(); err != nil {
+			t.Fatalf("Commit b failed: %v", err)
+		}
+		a.Put(1, "from-a")
+		if err := aborted.Rollback(); err != nil {
+			t.Fatalf("Rollback failed: %v", err)
+		}
+
+		// The log must survive this checkpoint: a and unfinished are still open
+		if err := tree.Checkpoint(); err != nil {
+			t.Fatalf("Checkpoint failed: %v", err)
+		}
+		if size, _ := tree.WALSize(); size == 0 {
+			t.Fatal("Checkpoint truncated the WAL under open transactions")
+		}
+
+		if err := a.Commit(); err != nil {
+			t.Fatalf("Commit a failed: %v", err)
+		}
+		unfinished.Delete(1)
+
+		// Crash: dirty pages are lost, unfinished never commits
+		tree.wal.Close()
+		pager.Close()
+	}
+
+	// Phase 2: only committed transactions survive, in commit order
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to reopen pager: %v", err)
+		}
+		defer pager.Close()
+
+		tree, err := OpenBPTree(pager, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to open tree: %v", err)
+		}
+		defer tree.Close()
+
+		expected := map[uint32]string{1: "from-a", 10: "a", 20: "b"}
+		for key, value := range expected {
+			if got, found, _ := tree.Search(key); !found || got != value {
+				t.Errorf("Key=%d: (%q, %v), expected %q", key, got, found, value)
+			}
+		}
+		for _, key := range []uint32{30, 40} {
+			if _, found, _ := tree.Search(key); found {
+				t.Errorf("Key=%d from an uncommitted transaction survived recovery", key)
+			}
+		}
+	}
+}
+
+// TestTxCommitAsyncLogsBeforeData checks that pages a commit evicts before its
+// record is durable wait for the WAL
+func TestTxCommitAsyncLogsBeforeData(t *testing.T) {
+	dbFile := "test_tx_log_first.db"
+	walFile := "test_tx_log_first.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	// Few frames, so applying the writes evicts pages
+	tree, err := NewBPTree(storage.NewBufferPool(pager, 8), 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	tx, err := tree.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	for i := uint32(0); i < 2000; i++ {
+		if err := tx.Put(i, fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	commit, err := tx.CommitAsync()
+	if err != nil {
+		t.Fatalf("CommitAsync failed: %v", err)
+	}
+
+	// Read before Wait: whatever reached the file is covered by the WAL
+	data, err := os.ReadFile(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", dbFile, err)
+	}
+	durable := tree.DurableLSN()
+	written := 0
+	for id := storage.SuperblockPageID + 1; (id+1)*storage.PageSize <= len(data); id++ {
+		page, err := storage.DeserializePage(data[id*storage.PageSize : (id+1)*storage.PageSize])
+		if err != nil {
+			continue
+		}
+		if page.Header.PageLSN == commit.LSN() {
+			written++
+		}
+		if page.Header.PageLSN > durable {
+			t.Errorf("Page %d on disk with LSN %d, WAL durable to %d", id, page.Header.PageLSN, durable)
+		}
+	}
+	if written == 0 {
+		t.Fatal("No page of the commit was evicted, the test proves nothing")
+	}
+
+	if err := commit.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	checkViolations(t, tree, 0)
+}
+
+// TestTxByteKeys runs a transaction over byte-string keys next to uint32 keys
+func TestTxByteKeys(t *testing.T) {
+	dbFile := "test_tx_byte_keys.db"
+	walFile := "test_tx_byte_keys.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(storage.NewBufferPool(pager, 64), 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	expected := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		if err := tree.Insert(uint32(i), fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+		expected[string(storage.Uint32Key(uint32(i)))] = fmt.Sprintf("value-%d", i)
+		if err := tree.InsertKey(tenantKey(i), fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+		expected[string(tenantKey(i))] = fmt.Sprintf("value-%d", i)
+	}
+
+	tx, err := tree.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+
+	newKey := []byte("tenant-00/new")
+	if err := tx.PutKey(newKey, "new"); err != nil {
+		t.Fatalf("PutKey failed: %v", err)
+	}
+	if err := tx.PutKey(tenantKey(1), "updated"); err != nil {
+		t.Fatalf("PutKey failed: %v", err)
+	}
+	if found, err := tx.DeleteKey(tenantKey(2)); err != nil || !found {
+		t.Fatalf("DeleteKey = (%v, %v), expected (true, nil)", found, err)
+	}
+	expected[string(newKey)] = "new"
+	expected[string(tenantKey(1))] = "updated"
+	delete(expected, string(tenantKey(2)))
+
+	tooLong := bytes.Repeat([]byte{'k'}, storage.MaxKeySize+1)
+	if err := tx.PutKey(tooLong, "x"); !errors.Is(err, storage.ErrKeyTooLarge) {
+		t.Errorf("PutKey of a %d-byte key returned %v, expected ErrKeyTooLarge", len(tooLong), err)
+	}
+	if value, found, _ := tx.GetKey(tenantKey(1)); !found || value != "updated" {
+		t.Errorf("tx.GetKey = (%q, %v), expected its own write", value, found)
+	}
+	if value, _, _ := tree.SearchKey(tenantKey(1)); value != "value-1" {
+		t.Errorf("Uncommitted update visible: %s", value)
+	}
+
+	// Locks are taken on the key bytes
+	if mode, held := tree.Locks().HeldKey(tx.ID(), tenantKey(1)); !held || mode != LockExclusive {
+		t.Errorf("HeldKey = (%s, %v), expected an exclusive lock", mode, held)
+	}
+	other, err := tree.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if err := other.LockKey(tenantKey(3), LockExclusive); err != nil {
+		t.Fatalf("Lock on an unrelated key failed: %v", err)
+	}
+	granted := make(chan error, 1)
+	go func() { granted <- other.LockKey(tenantKey(1), LockShared) }()
+	select {
+	case err := <-granted:
+		t.Fatalf("Lock on a key written by another transaction granted: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The transaction's scan keeps byte keys, with its own writes merged in
+	sorted := make([]string, 0, len(expected))
+	for key := range expected {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	if got := scanKeys(t, tx.ScanKeys(nil, nil)); strings.Join(got, ",") != strings.Join(sorted, ",") {
+		t.Errorf("tx.ScanKeys returned %d keys, expected %d", len(got), len(sorted))
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := <-granted; err != nil {
+		t.Errorf("Lock after commit failed: %v", err)
+	}
+	other.Rollback()
+
+	for key, value := range expected {
+		if got, found, err := tree.SearchKey([]byte(key)); err != nil || !found || got != value {
+			t.Errorf("SearchKey(%q) = (%q, %v, %v), expected %q", key, got, found, err, value)
+		}
+	}
+	if _, found, _ := tree.SearchKey(tenantKey(2)); found {
+		t.Error("Key deleted in the transaction still present")
+	}
+
+	keys, err := tree.InOrderTraversalKeys()
+	if err != nil {
+		t.Fatalf("InOrderTraversalKeys failed: %v", err)
+	}
+	got := make([]string, len(keys))
+	for i, key := range keys {
+		got[i] = string(key)
+	}
+	if strings.Join(got, ",") != strings.Join(sorted, ",") {
+		t.Errorf("InOrderTraversalKeys returned %d keys, expected %d", len(got), len(sorted))
+	}
+
+	// Byte keys fail the uint32 traversal rather than being dropped or read as 0
+	if uintKeys, err := tree.InOrderTraversal(); !errors.Is(err, ErrNotUint32Key) {
+		t.Errorf("InOrderTraversal = (%v, %v), expected ErrNotUint32Key", uintKeys, err)
+	}
+
+	it := tree.ScanKeys(nil, nil)
+	defer it.Close()
+	var uint32Keys int
+	for it.Next() {
+		if key, ok := it.Uint32Key(); ok {
+			if key != uint32(uint32Keys) {
+				t.Errorf("Uint32Key = %d, expected %d", key, uint32Keys)
+			}
+			uint32Keys++
+		}
+	}
+	if uint32Keys != 10 {
+		t.Errorf("Uint32Key decoded %d keys, expected 10", uint32Keys)
+	}
+}
@@ -0,0 +1,475 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+// VacuumStats reports what a vacuum did
+type VacuumStats struct {
+	PagesBefore    uint64 // Pages in the file before the vacuum
+	PagesAfter     uint64 // Pages in the file after it
+	BytesReclaimed int64  // Bytes cut off the end of the file
+}
+
+// Vacuum rebuilds the tree into densely packed pages at the front of the
+// file and truncates the rest
+//
+// Steps:
+//  1. Prune dead versions and checkpoint, so the file holds the whole tree
+//  2. Log a vacuum record, recovery finishes an interrupted vacuum from it
+//  3. Copy the tree past the end of the file and switch the root to the copy
+//  4. Copy it again to the front of the file and switch the root back
+//  5. Truncate the file after the copy and checkpoint
+//
+// Each switch is a superblock write after the copy is flushed, so a crash
+// leaves either tree whole. Writes wait while it runs, reads carry on: the
+// old tree is only overwritten once every reader has left it.
+// Refuses to run while transactions are open, their commits are still to
+// be logged.
+func (tree *BPTree) Vacuum() (*VacuumStats, error) {
+	resizer, ok := tree.pager.(storage.Resizer)
+	if !ok {
+		return nil, fmt.Errorf("pager %T doesn't support vacuum", tree.pager)
+	}
+
+	if _, err := tree.GarbageCollect(); err != nil {
+		return nil, fmt.Errorf("failed to prune versions: %w", err)
+	}
+
+	tree.quiesce.Lock()
+	defer tree.quiesce.Unlock()
+
+	if n := tree.ActiveTxs(); n > 0 {
+		return nil, fmt.Errorf("cannot vacuum with %d open transactions", n)
+	}
+	if err := tree.checkpointLocked(); err != nil {
+		return nil, err
+	}
+
+	before := resizer.NumPages()
+	stats := &VacuumStats{PagesBefore: before, PagesAfter: before}
+
+	plan, err := tree.planVacuum()
+	if err != nil {
+		return nil, err
+	}
+	front := uint64(storage.SuperblockPageID + 1)
+	if front+plan.pages >= before {
+		return stats, nil // Already packed
+	}
+
+	record := &wal.Entry{OpType: wal.OpVacuum}
+	if err := tree.wal.Append(record); err != nil {
+		return nil, fmt.Errorf("failed to write vacuum record: %w", err)
+	}
+
+	if err := tree.vacuumInto(resizer, plan, before, front, record.LSN); err != nil {
+		// Give back whatever pages the copies left behind
+		return nil, errors.Join(err, tree.reclaimPages())
+	}
+
+	if err := tree.checkpointLocked(); err != nil {
+		return nil, err
+	}
+
+	stats.PagesAfter = resizer.NumPages()
+	stats.BytesReclaimed = int64(before-stats.PagesAfter) * storage.PageSize
+	return stats, nil
+}
+
+// vacuumInto copies the tree past the end of the file, then to front, and
+// truncates the file after it
+func (tree *BPTree) vacuumInto(resizer storage.Resizer, plan *vacuumPlan, end, front, lsn uint64) error {
+	// The free list is emptied so none of its pages is in the way of a copy
+	if err := resizer.Resize(end+plan.pages, nil); err != nil {
+		return fmt.Errorf("failed to grow file: %w", err)
+	}
+
+	for _, base := range []uint64{end, front} {
+		if err := tree.copyTree(plan, base, lsn); err != nil {
+			return err
+		}
+	}
+
+	return tree.reclaimPages()
+}
+
+// vacuumPlan lays out a densely packed copy of the tree
+// Leaves are filled greedily in key order, each followed by the overflow
+// pages of its records, and internal pages are packed the same way above
+// them. The internal pages come first, root first.
+type vacuumPlan struct {
+	levels [][]*plannedPage // levels[0] holds the leaves, the last level the root
+	pages  uint64           // Pages the copy takes
+}
+
+// plannedPage is a page of the copy
+type plannedPage struct {
+	pageID   uint64
+	parent   uint64
+	firstKey []byte // Smallest key under the page, its separator in the parent
+	size     int    // Records of a leaf, children of an internal page
+	overflow int    // Overflow pages of a leaf's records
+}
+
+// root returns the root page of the copy
+func (plan *vacuumPlan) root() *plannedPage {
+	return plan.levels[len(plan.levels)-1][0]
+}
+
+// planVacuum lays out the copy of the tree
+// Latches aren't taken, writers must be quiesced
+func (tree *BPTree) planVacuum() (*vacuumPlan, error) {
+	room := storage.NewLeafPage(storage.NewPage(storage.PageTypeLeaf)).AvailableSpace()
+
+	var leaves []*plannedPage
+	used := 0
+	err := tree.walkGroups(func(group []*storage.Record) error {
+		size, overflow := 0, 0
+		for _, record := range group {
+			size += record.Size() + 2
+			if record.IsOverflow() {
+				overflow += storage.OverflowPages(record.ValueSize)
+			}
+		}
+
+		// Versions of a key share a leaf
+		if len(leaves) == 0 || used+size > room {
+			if size > room {
+				return fmt.Errorf("versions of one key take %d bytes, more than a leaf holds", size)
+			}
+			leaves = append(leaves, &plannedPage{firstKey: group[0].Key})
+			used = 0
+		}
+
+		leaf := leaves[len(leaves)-1]
+		leaf.size += len(group)
+		leaf.overflow += overflow
+		used += size
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// An empty tree keeps an empty root leaf
+	if len(leaves) == 0 {
+		leaves = append(leaves, &plannedPage{})
+	}
+
+	plan := &vacuumPlan{levels: [][]*plannedPage{leaves}}
+	for len(plan.levels[len(plan.levels)-1]) > 1 {
+		plan.levels = append(plan.levels, planInternal(plan.levels[len(plan.levels)-1]))
+	}
+	plan.assign(0)
+	return plan, nil
+}
+
+// planInternal packs the pages of one level under as few internal pages as fit
+func planInternal(children []*plannedPage) []*plannedPage {
+	room := storage.NewInternalPage(storage.NewPage(storage.PageTypeInternal)).Capacity()
+
+	nodes := []*plannedPage{{firstKey: children[0].firstKey, size: 1}}
+	used := 0
+	for _, child := range children[1:] {
+		entry := storage.InternalEntrySize(len(child.firstKey))
+		if used+entry > room {
+			nodes = append(nodes, &plannedPage{firstKey: child.firstKey, size: 1})
+			used = 0
+			continue
+		}
+		nodes[len(nodes)-1].size++
+		used += entry
+	}
+
+	// A last page with a single child takes one from the page before it,
+	// which holds plenty since keys are at most MaxKeySize
+	if last := len(nodes) - 1; last > 0 && nodes[last].size == 1 {
+		nodes[last-1].size--
+		nodes[last].size++
+		moved := len(children) - 2
+		nodes[last].firstKey = children[moved].firstKey
+	}
+
+	return nodes
+}
+
+// assign gives the pages of the copy IDs from base up and links parents
+func (plan *vacuumPlan) assign(base uint64) {
+	next := base
+	for level := len(plan.levels) - 1; level > 0; level-- {
+		for _, node := range plan.levels[level] {
+			node.pageID = next
+			next++
+		}
+	}
+	for _, leaf := range plan.levels[0] {
+		leaf.pageID = next
+		next += 1 + uint64(leaf.overflow)
+	}
+	plan.pages = next - base
+
+	for level := 1; level < len(plan.levels); level++ {
+		children := plan.levels[level-1]
+		for _, node := range plan.levels[level] {
+			for _, child := range children[:node.size] {
+				child.parent = node.pageID
+			}
+			children = children[node.size:]
+		}
+	}
+	plan.root().parent = 0
+}
+
+// copyTree writes the copy laid out by plan at base, switches the root to
+// it once it is on disk and waits for readers to leave the old tree
+// Pages are written whole, the new ones past the end of the file were never
+// written before and don't read back.
+// Latches aren't taken, writers must be quiesced
+func (tree *BPTree) copyTree(plan *vacuumPlan, base, lsn uint64) error {
+	plan.assign(base)
+
+	for level := 1; level < len(plan.levels); level++ {
+		children := plan.levels[level-1]
+		for _, node := range plan.levels[level] {
+			page := storage.NewPage(storage.PageTypeInternal)
+			page.Header.Parent = uint32(node.parent)
+			page.Header.PageLSN = lsn
+
+			internal := tree.internalPage(page)
+			if err := internal.SetLeftmostPointer(children[0].pageID); err != nil {
+				return err
+			}
+			for _, child := range children[1:node.size] {
+				if err := internal.InsertEntry(child.firstKey, child.pageID); err != nil {
+					return fmt.Errorf("failed to fill internal page %d: %w", node.pageID, err)
+				}
+			}
+			if err := tree.pager.WritePage(node.pageID, page.Serialize()); err != nil {
+				return fmt.Errorf("failed to write internal page %d: %w", node.pageID, err)
+			}
+			children = children[node.size:]
+		}
+	}
+
+	if err := tree.copyLeaves(plan.levels[0], lsn); err != nil {
+		return err
+	}
+
+	// The superblock may only point at the copy once all of it is on disk
+	if err := tree.pager.Flush(); err != nil {
+		return fmt.Errorf("failed to flush copied tree: %w", err)
+	}
+
+	tree.rootLatch.Lock()
+	oldRoot := tree.rootPage
+	err := tree.setRoot(plan.root().pageID)
+	tree.rootLatch.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return tree.drainReaders(oldRoot)
+}
+
+// copyLeaves writes the leaves of the copy, each followed by copies of the
+// overflow chains of its records
+func (tree *BPTree) copyLeaves(leaves []*plannedPage, lsn uint64) error {
+	i := 0
+	var leaf *storage.LeafPage
+	var page *storage.Page
+	nextOverflow := uint64(0)
+
+	flush := func() error {
+		page.Header.Parent = uint32(leaves[i].parent)
+		page.Header.PageLSN = lsn
+		if i+1 < len(leaves) {
+			page.Header.NextPage = uint32(leaves[i+1].pageID)
+		}
+		if err := tree.pager.WritePage(leaves[i].pageID, page.Serialize()); err != nil {
+			return fmt.Errorf("failed to write leaf %d: %w", leaves[i].pageID, err)
+		}
+		i++
+		page = nil
+		return nil
+	}
+
+	err := tree.walkGroups(func(group []*storage.Record) error {
+		if page != nil && leaf.NumRecords() == leaves[i].size {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if i == len(leaves) {
+			return fmt.Errorf("tree changed during vacuum")
+		}
+		if page == nil {
+			page = storage.NewPage(storage.PageTypeLeaf)
+			leaf = tree.leafPage(page)
+			nextOverflow = leaves[i].pageID + 1
+		}
+
+		for _, record := range group {
+			if record.IsOverflow() {
+				pageIDs := make([]uint64, storage.OverflowPages(record.ValueSize))
+				for j := range pageIDs {
+					pageIDs[j] = nextOverflow
+					nextOverflow++
+				}
+				if err := storage.CopyOverflow(tree.pager, record, pageIDs, lsn); err != nil {
+					return err
+				}
+			}
+			if err := leaf.AppendRecord(record); err != nil {
+				return fmt.Errorf("failed to fill leaf %d: %w", leaves[i].pageID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// An empty tree still gets its root leaf
+	if page == nil && i == 0 {
+		page = storage.NewPage(storage.PageTypeLeaf)
+	}
+	if page != nil {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	if i != len(leaves) {
+		return fmt.Errorf("tree changed during vacuum")
+	}
+	return nil
+}
+
+// walkGroups calls visit with the records of the tree in key order, each
+// call with every version of one key
+// Latches aren't taken, writers must be quiesced
+func (tree *BPTree) walkGroups(visit func(group []*storage.Record) error) error {
+	leafPageID, err := tree.findLeftmostLeaf()
+	if err != nil {
+		return fmt.Errorf("failed to find leftmost leaf: %w", err)
+	}
+
+	var group []*storage.Record
+	seen := make(map[uint64]bool)
+	for leafPageID != 0 {
+		if seen[leafPageID] {
+			return fmt.Errorf("leaf chain loops back to page %d", leafPageID)
+		}
+		seen[leafPageID] = true
+
+		records, nextPageID, err := tree.leafRecords(leafPageID)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if len(group) > 0 && tree.cmp.Compare(group[0].Key, record.Key) != 0 {
+				if err := visit(group); err != nil {
+					return err
+				}
+				group = nil
+			}
+			group = append(group, record)
+		}
+
+		leafPageID = nextPageID
+	}
+
+	if len(group) > 0 {
+		return visit(group)
+	}
+	return nil
+}
+
+// reclaimPages truncates the file after the last page the tree uses and
+// puts every other page it doesn't use on the free list
+// Latches aren't taken, writers must be quiesced
+func (tree *BPTree) reclaimPages() error {
+	resizer, ok := tree.pager.(storage.Resizer)
+	if !ok {
+		return fmt.Errorf("pager %T can't be resized", tree.pager)
+	}
+
+	used, err := tree.usedPages()
+	if err != nil {
+		return err
+	}
+
+	end := uint64(storage.SuperblockPageID + 1)
+	for pageID := range used {
+		end = max(end, pageID+1)
+	}
+
+	var free []uint64
+	for pageID := uint64(storage.SuperblockPageID + 1); pageID < end; pageID++ {
+		if !used[pageID] {
+			free = append(free, pageID)
+		}
+	}
+
+	if err := resizer.Resize(end, free); err != nil {
+		return fmt.Errorf("failed to reclaim pages: %w", err)
+	}
+	return nil
+}
+
+// usedPages returns every page the tree reaches, its nodes and the overflow
+// pages of its records
+// Latches aren't taken, writers must be quiesced
+func (tree *BPTree) usedPages() (map[uint64]bool, error) {
+	used := make(map[uint64]bool)
+	mark := func(pageID uint64) error {
+		if used[pageID] {
+			return fmt.Errorf("page %d is reached twice", pageID)
+		}
+		used[pageID] = true
+		return nil
+	}
+
+	stack := []uint64{tree.rootPage}
+	for len(stack) > 0 {
+		pageID := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if err := mark(pageID); err != nil {
+			return nil, err
+		}
+
+		children, err := tree.children(pageID)
+		if err != nil {
+			return nil, err
+		}
+		if children != nil {
+			stack = append(stack, children...)
+			continue
+		}
+
+		records, _, err := tree.leafRecords(pageID)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			if !record.IsOverflow() {
+				continue
+			}
+			chain, err := storage.OverflowChain(tree.pager, record.Overflow)
+			if err != nil {
+				return nil, err
+			}
+			for _, overflowID := range chain {
+				if err := mark(overflowID); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return used, nil
+}
@@ -0,0 +1,277 @@
+package bptree
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+// vacuumTestValue returns the value inserted at key, every 100th spills into overflow pages
+func vacuumTestValue(key uint32) string {
+	if key%100 == 0 {
+		return jsonBlob(int(key), 10000)
+	}
+	return fmt.Sprintf("value-%d", key)
+}
+
+// fillAndThin inserts keys [0, n) and deletes all but every tenth
+func fillAndThin(t *testing.T, tree *BPTree, n uint32) {
+	t.Helper()
+	for i := uint32(0); i < n; i++ {
+		if err := tree.Insert(i, vacuumTestValue(i)); err != nil {
+			t.Fatalf("Failed to insert key=%d: %v", i, err)
+		}
+	}
+	for i := uint32(0); i < n; i++ {
+		if i%10 == 0 {
+			continue
+		}
+		if _, err := tree.Delete(i); err != nil {
+			t.Fatalf("Failed to delete key=%d: %v", i, err)
+		}
+	}
+}
+
+// checkThinned verifies the keys fillAndThin left behind
+func checkThinned(t *testing.T, tree *BPTree, n uint32) {
+	t.Helper()
+	for i := uint32(0); i < n; i++ {
+		value, found, err := tree.Search(i)
+		if err != nil {
+			t.Fatalf("Search(%d) failed: %v", i, err)
+		}
+		if i%10 != 0 {
+			if found {
+				t.Fatalf("Deleted key=%d found", i)
+			}
+			continue
+		}
+		if !found || value != vacuumTestValue(i) {
+			t.Fatalf("Key=%d: %d bytes, %v, expected %d bytes", i, len(value), found, len(vacuumTestValue(i)))
+		}
+	}
+
+	keys, err := tree.InOrderTraversal()
+	if err != nil {
+		t.Fatalf("InOrderTraversal failed: %v", err)
+	}
+	if len(keys) != int(n/10) {
+		t.Errorf("Tree holds %d keys, expected %d", len(keys), n/10)
+	}
+}
+
+func TestBPTreeVacuum(t *testing.T) {
+	dbFile := "test_vacuum.db"
+	walFile := "test_vacuum.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	const n = 5000
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+
+	bufferPool := storage.NewBufferPool(pager, 64)
+	tree, err := NewBPTree(bufferPool, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+
+	fillAndThin(t, tree, n)
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	before := pager.NumPages()
+
+	// Readers carry on while the tree moves
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := uint32(r); ; i = (i + 40) % n {
+				select {
+				case <-stop:
+					return
+				case <-time.After(time.Millisecond):
+				}
+
+				value, found, err := tree.Search(i)
+				if err != nil || !found || value != vacuumTestValue(i) {
+					errs <- fmt.Errorf("Search(%d) during vacuum = %d bytes, %v, %v", i, len(value), found, err)
+					return
+				}
+
+				it := tree.Scan(i, i+200)
+				count := 0
+				for it.Next() {
+					count++
+				}
+				it.Close()
+				if it.Err() != nil || (i+200 < n && count != 21) {
+					errs <- fmt.Errorf("Scan(%d, %d) during vacuum = %d keys, %v", i, i+200, count, it.Err())
+					return
+				}
+			}
+		}(r * 10)
+	}
+
+	stats, err := tree.Vacuum()
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if err != nil {
+		t.Fatalf("Vacuum failed: %v", err)
+	}
+
+	// What's left is the overflow pages of the kept values and a few packed leaves
+	overflow := uint64(0)
+	for i := uint32(0); i < n; i += 100 {
+		overflow += uint64(overflowPages(vacuumTestValue(i)))
+	}
+	if stats.PagesBefore != before || stats.PagesAfter > 2+overflow+8 {
+		t.Errorf("Vacuum went from %d to %d pages, expected %d to at most %d", stats.PagesBefore, stats.PagesAfter, before, 2+overflow+8)
+	}
+	if stats.BytesReclaimed != int64(before-stats.PagesAfter)*storage.PageSize {
+		t.Errorf("BytesReclaimed = %d, expected %d pages", stats.BytesReclaimed, before-stats.PagesAfter)
+	}
+	if info, err := os.Stat(dbFile); err != nil {
+		t.Errorf("Failed to stat file: %v", err)
+	} else if info.Size() != int64(stats.PagesAfter)*storage.PageSize {
+		t.Errorf("File is %d bytes after vacuum, expected %d pages", info.Size(), stats.PagesAfter)
+	}
+	if pager.FreeListSize() != 0 {
+		t.Errorf("Free list holds %d pages after vacuum, expected none", pager.FreeListSize())
+	}
+	if size, _ := tree.WALSize(); size != 0 {
+		t.Errorf("WAL size after vacuum = %d, expected 0", size)
+	}
+	checkThinned(t, tree, n)
+
+	// A packed tree has nothing left to reclaim
+	again, err := tree.Vacuum()
+	if err != nil || again.BytesReclaimed != 0 {
+		t.Errorf("Second vacuum = %+v, %v, expected nothing reclaimed", again, err)
+	}
+
+	// The tree takes writes and reopens after the move
+	if err := tree.Insert(n+1, "after"); err != nil {
+		t.Fatalf("Insert after vacuum failed: %v", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	pager.Close()
+
+	pager, err = storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err = OpenBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to open tree: %v", err)
+	}
+	defer tree.Close()
+
+	if value, found, err := tree.Search(n + 1); err != nil || !found || value != "after" {
+		t.Errorf("Search(%d) after reopen = %q, %v, %v", n+1, value, found, err)
+	}
+	if _, err := tree.Delete(n + 1); err != nil {
+		t.Fatalf("Delete after reopen failed: %v", err)
+	}
+	checkThinned(t, tree, n)
+}
+
+func TestBPTreeVacuumRecovery(t *testing.T) {
+	dbFile := "test_vacuum_recovery.db"
+	walFile := "test_vacuum_recovery.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	const n = 3000
+	var end, copyPages uint64
+
+	// Phase 1: crash once the tree is copied past the end of the file
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+
+		bufferPool := storage.NewBufferPool(pager, 64)
+		tree, err := NewBPTree(bufferPool, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+
+		fillAndThin(t, tree, n)
+		if err := tree.Checkpoint(); err != nil {
+			t.Fatalf("Checkpoint failed: %v", err)
+		}
+
+		plan, err := tree.planVacuum()
+		if err != nil {
+			t.Fatalf("planVacuum failed: %v", err)
+		}
+		end, copyPages = pager.NumPages(), plan.pages
+
+		record := &wal.Entry{OpType: wal.OpVacuum}
+		if err := tree.wal.Append(record); err != nil {
+			t.Fatalf("Failed to log vacuum: %v", err)
+		}
+		if err := bufferPool.Resize(end+copyPages, nil); err != nil {
+			t.Fatalf("Resize failed: %v", err)
+		}
+		if err := tree.copyTree(plan, end, record.LSN); err != nil {
+			t.Fatalf("copyTree failed: %v", err)
+		}
+
+		tree.wal.Close()
+		pager.Close()
+	}
+
+	// Phase 2: recovery frees the pages of the old tree
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := OpenBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to open tree: %v", err)
+	}
+	defer tree.Close()
+
+	if pager.NumPages() != end+copyPages || pager.FreeListSize() != int(end-2) {
+		t.Errorf("Recovered file has %d pages, %d free, expected %d pages with the %d before the copy free",
+			pager.NumPages(), pager.FreeListSize(), end+copyPages, end-2)
+	}
+	if size, _ := tree.WALSize(); size != 0 {
+		t.Errorf("WAL size after recovery = %d, expected 0", size)
+	}
+	checkThinned(t, tree, n)
+
+	stats, err := tree.Vacuum()
+	if err != nil {
+		t.Fatalf("Vacuum after recovery failed: %v", err)
+	}
+	if stats.PagesAfter != 2+copyPages {
+		t.Errorf("Vacuum after recovery left %d pages, expected %d", stats.PagesAfter, 2+copyPages)
+	}
+	checkThinned(t, tree, n)
+}
@@ -2,6 +2,7 @@ package sql
 
 import (
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/spaghetti-lover/sharingan-db/internal/bptree"
@@ -79,6 +80,17 @@ func TestSQLIntegration(t *testing.T) {
 	} else {
 		t.Logf("✓ Correctly returned error: %v", err)
 	}
+
+	// Test VACUUM
+	t.Log("\nTesting VACUUM...")
+	result, err := ParseAndExecute("VACUUM;", tree)
+	if err != nil || !strings.HasPrefix(result, "OK") {
+		t.Errorf("VACUUM = %q, %v", result, err)
+	}
+	if result, err := ParseAndExecute("SELECT * FROM kv WHERE key = 100;", tree); err != nil || result != "100 | Naruto" {
+		t.Errorf("SELECT after VACUUM = %q, %v", result, err)
+	}
+	t.Logf("✓ VACUUM -> %s", result)
 }
 
 func TestSQLSyntaxErrors(t *testing.T) {
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// BackgroundWriterConfig configures the background writer
+type BackgroundWriterConfig struct {
+	// Interval between rounds, 0 means 10ms. A round also starts early when
+	// eviction has to write a dirty page itself.
+	Interval time.Duration
+	// ScanDepth is how many of the coldest pages a round looks at, 0 means a
+	// quarter of the pool
+	ScanDepth int
+	// DurableLSN returns the last LSN durable in the WAL. A page stamped with
+	// a later LSN stays dirty until the WAL catches up, so no page reaches
+	// disk ahead of its log records. Nil writes pages regardless.
+	DurableLSN func() uint64
+}
+
+// backgroundWriter is the goroutine cleaning pages ahead of eviction
+type backgroundWriter struct {
+	config BackgroundWriterConfig
+	kick   chan struct{} // Starts a round early, holds at most one wake-up
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// wake starts a round early, a nil writer ignores it
+func (w *backgroundWriter) wake() {
+	if w == nil {
+		return
+	}
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+}
+
+// StartBackgroundWriter starts a goroutine that writes dirty pages near the
+// eviction end of the pool, so eviction usually finds them clean and reads
+// don't wait for a write. Does nothing if the writer already runs.
+func (bp *BufferPool) StartBackgroundWriter(config BackgroundWriterConfig) {
+	if config.Interval <= 0 {
+		config.Interval = 10 * time.Millisecond
+	}
+	if config.ScanDepth <= 0 {
+		config.ScanDepth = max(bp.capacity/4, 1)
+	}
+
+	w := &backgroundWriter{
+		config: config,
+		kick:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if bp.writer.CompareAndSwap(nil, w) {
+		go bp.runBackgroundWriter(w)
+	}
+}
+
+// StopBackgroundWriter stops the background writer and waits for its round to end
+func (bp *BufferPool) StopBackgroundWriter() {
+	if w := bp.writer.Swap(nil); w != nil {
+		close(w.stop)
+		<-w.done
+	}
+}
+
+// runBackgroundWriter runs rounds until stopped or the pool fails
+func (bp *BufferPool) runBackgroundWriter(w *backgroundWriter) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		case <-w.kick:
+		}
+
+		if err := bp.writeColdPages(w.config); err != nil {
+			return
+		}
+	}
+}
+
+// writeColdPages writes the dirty pages among the coldest ones of each shard
+// Each page is latched while still under the shard lock, so it can't be
+// evicted or freed before its write lands: both take the frame latch first.
+// Pages in use are skipped rather than waited for, they aren't about to be
+// evicted.
+func (bp *BufferPool) writeColdPages(config BackgroundWriterConfig) error {
+	durable := uint64(0)
+	if config.DurableLSN != nil {
+		durable = config.DurableLSN()
+	}
+
+	// Every shard evicts on its own, so each gets its share of the scan
+	depth := (config.ScanDepth + len(bp.shards) - 1) / len(bp.shards)
+
+	defer bp.bgRounds.Add(1)
+	for _, s := range bp.shards {
+		if err := bp.Err(); err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		var latched []*cacheNode
+		for _, pageID := range s.policy.Coldest(depth) {
+			node := s.cache[pageID]
+			if !node.dirty.Load() || !node.latch.TryLock() {
+				continue
+			}
+			if config.DurableLSN != nil && pageLSN(node.data) > durable {
+				node.latch.Unlock()
+				bp.bgWALWaits.Add(1)
+				continue
+			}
+			latched = append(latched, node)
+		}
+		s.mu.Unlock()
+
+		var writeErr error
+		for _, node := range latched {
+			if writeErr == nil {
+				var ok bool
+				if ok, writeErr = bp.writeBackLatched(node); writeErr != nil {
+					writeErr = fmt.Errorf("failed to write page %d in the background: %w", node.pageID, writeErr)
+				} else if ok {
+					bp.bgWrites.Add(1)
+				}
+			}
+			node.latch.Unlock()
+		}
+
+		if writeErr != nil {
+			bp.fail(writeErr)
+			return writeErr
+		}
+	}
+	return nil
+}
+
+// pageLSN reads the PageLSN stamped in a serialized page header
+func pageLSN(data []byte) uint64 {
+	return binary.LittleEndian.Uint64(data[16:24])
+}
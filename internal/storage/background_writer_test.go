@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"encoding/binary"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForStats polls the pool until cond holds or a second passes
+func waitForStats(t *testing.T, bp *BufferPool, cond func(BufferPoolStats) bool) BufferPoolStats {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		stats := bp.GetStats()
+		if cond(stats) {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the background writer: %s", stats)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBackgroundWriter(t *testing.T) {
+	dbFile := "test_background_writer.db"
+	defer os.Remove(dbFile)
+
+	pager, err := NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	bp := NewBufferPool(pager, 8)
+
+	// Half the pages carry LSNs the WAL hasn't made durable yet
+	var durable atomic.Uint64
+	durable.Store(10)
+
+	pageIDs := make([]uint64, 8)
+	for i := range pageIDs {
+		if pageIDs[i], err = bp.AllocatePage(); err != nil {
+			t.Fatalf("Failed to allocate page: %v", err)
+		}
+
+		data := make([]byte, PageSize)
+		lsn := uint64(5)
+		if i >= 4 {
+			lsn = 50
+		}
+		binary.LittleEndian.PutUint64(data[16:24], lsn)
+		data[PageHeaderSize] = byte(i + 1)
+		if err := bp.WritePage(pageIDs[i], data); err != nil {
+			t.Fatalf("Failed to write page %d: %v", pageIDs[i], err)
+		}
+	}
+
+	bp.StartBackgroundWriter(BackgroundWriterConfig{
+		Interval:   time.Millisecond,
+		ScanDepth:  8,
+		DurableLSN: durable.Load,
+	})
+
+	stats := waitForStats(t, bp, func(s BufferPoolStats) bool { return s.BackgroundWrites == 4 })
+	if stats.DirtyPages != 4 || stats.BackgroundWALWaits == 0 {
+		t.Errorf("DirtyPages = %d, BackgroundWALWaits = %d, expected pages ahead of the WAL held back",
+			stats.DirtyPages, stats.BackgroundWALWaits)
+	}
+	for i, pageID := range pageIDs {
+		data, err := pager.ReadPage(pageID)
+		if err != nil {
+			t.Fatalf("Failed to read page %d from disk: %v", pageID, err)
+		}
+		expected := byte(i + 1)
+		if i >= 4 {
+			expected = 0
+		}
+		if data[PageHeaderSize] != expected {
+			t.Errorf("Page %d on disk holds %d, expected %d", pageID, data[PageHeaderSize], expected)
+		}
+	}
+
+	// Once the WAL catches up the rest are cleaned, and eviction finds
+	// nothing left to write
+	durable.Store(100)
+	waitForStats(t, bp, func(s BufferPoolStats) bool { return s.DirtyPages == 0 })
+
+	bp.StopBackgroundWriter()
+	for i := 0; i < 8; i++ {
+		pageID, err := bp.AllocatePage()
+		if err != nil {
+			t.Fatalf("Failed to allocate page: %v", err)
+		}
+		if _, err := bp.ReadPage(pageID); err != nil {
+			t.Fatalf("Failed to read page %d: %v", pageID, err)
+		}
+	}
+
+	stats = bp.GetStats()
+	if stats.Evictions != 8 || stats.EvictionWrites != 0 {
+		t.Errorf("Evictions = %d, EvictionWrites = %d, expected 8 evictions of clean pages",
+			stats.Evictions, stats.EvictionWrites)
+	}
+	if stats.BackgroundWrites != 8 {
+		t.Errorf("BackgroundWrites = %d, expected 8", stats.BackgroundWrites)
+	}
+
+	if err := bp.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+}
@@ -1,8 +1,11 @@
 package storage
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"os"
+	"sync/atomic"
 )
 
 const (
@@ -11,9 +14,14 @@ const (
 
 // FilePager implement Pager interface using file system
 type FilePager struct {
-	file     *os.File
-	numPages uint64
-	freeList *FreeList
+	file       *os.File
+	numPages   atomic.Uint64 // Read without locks by buffer pool prefetches
+	freeList   *FreeList
+	pending    []uint64 // Freed since the last Flush
+	flushing   []uint64 // Freed before the last Flush, reusable after the next
+	superblock *Superblock
+	syncMode   SyncMode
+	walSync    func(lsn uint64) error // Forces the WAL up to a page's LSN before the page is written, nil if unset
 }
 
 // NewFilePager create nerw or open database file
@@ -33,17 +41,26 @@ func NewFilePager(path string) (*FilePager, error) {
 	numPages := uint64(stat.Size()) / PageSize
 
 	pager := &FilePager{
-		file:     file,
-		numPages: numPages,
-		freeList: NewFreeList(),
+		file:       file,
+		freeList:   NewFreeList(),
+		superblock: NewSuperblock(),
 	}
+	pager.numPages.Store(numPages)
 
 	if numPages == 0 {
 		if err := pager.initializeFreeList(); err != nil {
 			file.Close()
 			return nil, err
 		}
+		if err := pager.initializeSuperblock(); err != nil {
+			file.Close()
+			return nil, err
+		}
 	} else {
+		if err := pager.loadSuperblock(); err != nil {
+			file.Close()
+			return nil, err
+		}
 		if err := pager.loadFreeList(); err != nil {
 			file.Close()
 			return nil, err
@@ -55,7 +72,7 @@ func NewFilePager(path string) (*FilePager, error) {
 
 // initializeFreeList creat new free list page (page 0)
 func (p *FilePager) initializeFreeList() error {
-	page := p.freeList.SerializeToPage()
+	page := p.freeList.SerializeTrunk(0)
 
 	// Allocate page 0 without using AllocatePage (avoid recursion)
 	emptyPage := make([]byte, PageSize)
@@ -63,32 +80,125 @@ func (p *FilePager) initializeFreeList() error {
 		return fmt.Errorf("failed to initialize free list: %w", err)
 	}
 
-	p.numPages = 1
+	p.numPages.Store(1)
 
 	// Write free list data
 	return p.WritePageStruct(FreeListPageID, page)
 }
 
-// loadFreeList read free list from disk
-func (p *FilePager) loadFreeList() error {
-	page, err := p.ReadPageStruct(FreeListPageID)
+// initializeSuperblock create the superblock page (page 1)
+func (p *FilePager) initializeSuperblock() error {
+	pageID, err := p.AllocatePage()
 	if err != nil {
-		return fmt.Errorf("failed to read free list: %w", err)
+		return fmt.Errorf("failed to allocate superblock: %w", err)
+	}
+	if pageID != SuperblockPageID {
+		return fmt.Errorf("superblock allocated at page %d, expected %d", pageID, SuperblockPageID)
+	}
+
+	return p.WriteSuperblock(p.superblock)
+}
+
+// loadSuperblock read and validate the newest intact superblock copy from disk
+// A file from before the superblock fails with ErrPreSuperblock.
+func (p *FilePager) loadSuperblock() error {
+	if p.numPages.Load() <= SuperblockPageID {
+		return fmt.Errorf("database file too small: %d pages", p.numPages.Load())
+	}
+
+	// Page 1 holds the copies rather than a page, it has no page checksum
+	data := make([]byte, PageSize)
+	if _, err := p.file.ReadAt(data, SuperblockPageID*PageSize); err != nil {
+		return fmt.Errorf("failed to read superblock: %w", err)
+	}
+
+	var newest *Superblock
+	var errs []error
+	for slot := 0; slot < superblockSlots; slot++ {
+		sb, err := decodeSuperblock(data[slot*superblockSlotSize:])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("copy %d: %w", slot, err))
+			continue
+		}
+		// An intact copy this build can't read stops the open, the other may be stale
+		if err := sb.validate(); err != nil {
+			return fmt.Errorf("failed to load superblock: %w", err)
+		}
+		if newest == nil || sb.Sequence > newest.Sequence {
+			newest = sb
+		}
+	}
+	if newest != nil {
+		p.superblock = newest
+		return nil
+	}
+
+	// Files from before the superblock keep the root node in page 1
+	if pageType := PageType(binary.LittleEndian.Uint16(data[0:2])); pageType == PageTypeLeaf || pageType == PageTypeInternal {
+		return fmt.Errorf("failed to load superblock: %w: page 1 holds a %v node, "+
+			"copy the keys into a new database with the release that wrote it", ErrPreSuperblock, pageType)
+	}
+	return fmt.Errorf("failed to load superblock: %w", errors.Join(errs...))
+}
+
+// ReadSuperblock returns a copy of the in-memory superblock
+func (p *FilePager) ReadSuperblock() (*Superblock, error) {
+	sb := *p.superblock
+	return &sb, nil
+}
+
+// WriteSuperblock writes the superblock over the older of the two copies,
+// followed by fsync
+func (p *FilePager) WriteSuperblock(sb *Superblock) error {
+	sb.Magic = SuperblockMagic
+	sb.Version = FormatVersion
+	sb.PageSize = PageSize
+	sb.Sequence = p.superblock.Sequence + 1
+
+	if err := p.writeSuperblockCopy(sb); err != nil {
+		return fmt.Errorf("failed to write superblock: %w", err)
 	}
 
-	freeList, err := DeserializeFreeList(page)
+	saved := *sb
+	p.superblock = &saved
+	return nil
+}
+
+// writeSuperblockCopy writes sb into the slot its sequence picks
+// A superblock change is a checkpoint boundary, only OFF skips the fsync.
+func (p *FilePager) writeSuperblockCopy(sb *Superblock) error {
+	slot := int64(sb.Sequence % superblockSlots)
+	offset := SuperblockPageID*PageSize + slot*superblockSlotSize
+	if _, err := p.file.WriteAt(sb.Serialize(), offset); err != nil {
+		return err
+	}
+
+	if p.syncMode != SyncOff {
+		if err := p.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync superblock: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadFreeList read free list chain from disk
+func (p *FilePager) loadFreeList() error {
+	freeList, err := ReadFreeList(p)
 	if err != nil {
-		return fmt.Errorf("failed to deserialize free list: %w", err)
+		return fmt.Errorf("failed to load free list: %w", err)
 	}
 
 	p.freeList = freeList
 	return nil
 }
 
-// saveFreeList write free list to disk
-func (p *FilePager) saveFreeList() error {
-	page := p.freeList.SerializeToPage()
-	return p.WritePageStruct(FreeListPageID, page)
+// saveFreeTrunk write one trunk page of the free list to disk
+func (p *FilePager) saveFreeTrunk(index int) error {
+	page := p.freeList.SerializeTrunk(index)
+	if err := p.WritePageStruct(p.freeList.TrunkPageID(index), page); err != nil {
+		return fmt.Errorf("failed to save free list: %w", err)
+	}
+	return nil
 }
 
 // FreePage mark page is free and add into free list
@@ -96,25 +206,61 @@ func (p *FilePager) FreePage(pageID uint64) error {
 	if pageID == FreeListPageID {
 		return fmt.Errorf("cannot free the free list page")
 	}
+	if pageID == SuperblockPageID {
+		return fmt.Errorf("cannot free the superblock page")
+	}
 
-	if pageID >= p.numPages {
+	if pageID >= p.numPages.Load() {
 		return fmt.Errorf("page %d out of bounds", pageID)
 	}
 
-	// Thêm vào free list
-	p.freeList.Push(pageID)
+	// Pages that still point at it may not be on disk yet, it joins the
+	// free list once a later Flush has written them
+	p.pending = append(p.pending, pageID)
+	return nil
+}
+
+// releaseFreed moves the pages freed before the previous Flush onto the free list
+// The writes this Flush synced dropped every pointer to them.
+func (p *FilePager) releaseFreed() error {
+	released := p.flushing
+	p.flushing, p.pending = p.pending, nil
+	if len(released) == 0 {
+		return nil
+	}
+
+	trunks := p.freeList.NumTrunks()
+	for _, pageID := range released {
+		p.freeList.Push(pageID)
+	}
+
+	// Each time page 0 filled up, a freed page took its IDs as a new trunk.
+	// Write those before page 0 links to them, a crash in between only leaks pages.
+	for i := p.freeList.NumTrunks() - trunks; i > 0; i-- {
+		if err := p.saveFreeTrunk(i); err != nil {
+			return err
+		}
+	}
 
 	// Lưu free list
-	return p.saveFreeList()
+	return p.saveFreeTrunk(0)
 }
 
 // FreeListSize trả về số lượng free pages
 func (p *FilePager) FreeListSize() int {
-	return p.freeList.Size()
+	return p.freeList.Size() + len(p.pending) + len(p.flushing)
+}
+
+// FreePageIDs returns every freed page, trunk pages past page 0 and pages
+// waiting for a Flush included
+func (p *FilePager) FreePageIDs() []uint64 {
+	pageIDs := p.freeList.PageIDs()
+	pageIDs = append(pageIDs, p.flushing...)
+	return append(pageIDs, p.pending...)
 }
 
 func (p *FilePager) ReadPage(id uint64) ([]byte, error) {
-	if id >= p.numPages {
+	if id >= p.numPages.Load() {
 		return nil, fmt.Errorf("page %d out of bounds", id)
 	}
 
@@ -126,6 +272,11 @@ func (p *FilePager) ReadPage(id uint64) ([]byte, error) {
 		return nil, fmt.Errorf("failed to read page %d: %w", id, err)
 	}
 
+	// Reject torn or bit-rotted pages before anyone interprets them
+	if err := VerifyPageChecksum(id, buf); err != nil {
+		return nil, err
+	}
+
 	return buf, nil
 }
 
@@ -134,29 +285,91 @@ func (p *FilePager) WritePage(id uint64, data []byte) error {
 		return fmt.Errorf("invalid page size: %d, expected %d", len(data), PageSize)
 	}
 
+	// Every write path ends here, so this is where the log goes first
+	if lsn := pageLSN(data); lsn > 0 && p.walSync != nil {
+		if err := p.walSync(lsn); err != nil {
+			return fmt.Errorf("failed to sync WAL before page %d: %w", id, err)
+		}
+	}
+
 	offset := int64(id * PageSize)
 
+	// Every page leaving the pager carries a checksum, whoever formatted it
+	SetPageChecksum(data)
+
 	_, err := p.file.WriteAt(data, offset)
 	if err != nil {
 		return fmt.Errorf("failed to write page %d: %w", id, err)
 	}
 
-	return p.file.Sync()
+	// Other modes wait for Flush at a checkpoint
+	if p.syncMode == SyncFull {
+		return p.file.Sync()
+	}
+	return nil
 }
 
+// SetWALSync sets the function forcing the WAL up to a page's LSN before the
+// page is written
+func (p *FilePager) SetWALSync(sync func(lsn uint64) error) {
+	p.walSync = sync
+}
+
+// AllocatePage returns an empty page, reusing a page on the free list before
+// growing the file
 func (p *FilePager) AllocatePage() (uint64, error) {
-	pageID := p.numPages
-	p.numPages++
+	if pageID, ok := p.freeList.Pop(); ok {
+		return p.reusePage(pageID)
+	}
+
+	pageID := p.numPages.Add(1) - 1
 
 	emptyPage := make([]byte, PageSize)
 	if err := p.WritePage(pageID, emptyPage); err != nil {
-		p.numPages-- // rollback
+		p.numPages.Add(^uint64(0)) // rollback
 		return 0, err
 	}
 
 	return pageID, nil
 }
 
+// reusePage clears a page taken off the free list
+// Page 0 is written first, so a crash leaks the page rather than leaving it
+// both free and in use.
+func (p *FilePager) reusePage(pageID uint64) (uint64, error) {
+	if err := p.saveFreeTrunk(0); err != nil {
+		return 0, err
+	}
+
+	emptyPage := make([]byte, PageSize)
+	if err := p.WritePage(pageID, emptyPage); err != nil {
+		return 0, err
+	}
+
+	return pageID, nil
+}
+
+// Flush syncs the database file to disk, then puts the pages freed before
+// the previous Flush on the free list
+func (p *FilePager) Flush() error {
+	if p.syncMode != SyncOff {
+		if err := p.file.Sync(); err != nil {
+			return err
+		}
+	}
+	return p.releaseFreed()
+}
+
+// SetSyncMode sets when page writes are fsynced
+func (p *FilePager) SetSyncMode(mode SyncMode) {
+	p.syncMode = mode
+}
+
+// SyncMode returns the pager's sync mode
+func (p *FilePager) SyncMode() SyncMode {
+	return p.syncMode
+}
+
 func (p *FilePager) Close() error {
 	if p.file != nil {
 		return p.file.Close()
@@ -196,6 +409,42 @@ func (p *FilePager) AllocatePageWithType(pageType PageType) (uint64, *Page, erro
 	return pageID, page, nil
 }
 
+// NumPages returns the number of pages in the file
 func (p *FilePager) NumPages() uint64 {
-	return p.numPages
+	return p.numPages.Load()
+}
+
+// Resize sets the file to numPages pages and replaces the free list with free
+// The free list is written before the file shrinks, page 0 last, so a crash
+// leaves the old list or the new one.
+func (p *FilePager) Resize(numPages uint64, free []uint64) error {
+	if numPages <= SuperblockPageID {
+		return fmt.Errorf("cannot resize to %d pages, the superblock is page %d", numPages, SuperblockPageID)
+	}
+
+	freeList := NewFreeList()
+	for _, pageID := range free {
+		if pageID <= SuperblockPageID || pageID >= numPages {
+			return fmt.Errorf("free page %d out of bounds", pageID)
+		}
+		freeList.Push(pageID)
+	}
+
+	p.freeList = freeList
+	p.pending, p.flushing = nil, nil
+	for i := freeList.NumTrunks() - 1; i >= 0; i-- {
+		if err := p.saveFreeTrunk(i); err != nil {
+			return err
+		}
+	}
+
+	if err := p.file.Truncate(int64(numPages * PageSize)); err != nil {
+		return fmt.Errorf("failed to resize file to %d pages: %w", numPages, err)
+	}
+	p.numPages.Store(numPages)
+
+	if p.syncMode == SyncOff {
+		return nil
+	}
+	return p.file.Sync()
 }
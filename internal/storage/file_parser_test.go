@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"errors"
+	"os"
 	"testing"
 )
 
@@ -9,6 +11,7 @@ func TestPageSerialization(t *testing.T) {
 	page.Header.NumKeys = 10
 	page.Header.NextPage = 42
 	page.Header.Parent = 5
+	page.Header.PageLSN = 1234
 
 	copy(page.Data, []byte("test data"))
 
@@ -38,6 +41,17 @@ func TestPageSerialization(t *testing.T) {
 	if deserialized.Header.Parent != 5 {
 		t.Errorf("Parent = %d, expected 5", deserialized.Header.Parent)
 	}
+	if deserialized.Header.PageLSN != 1234 {
+		t.Errorf("PageLSN = %d, expected 1234", deserialized.Header.PageLSN)
+	}
+
+	// Verify checksum round-trips through the header
+	if deserialized.Header.Checksum != page.Header.Checksum {
+		t.Errorf("Checksum = %08x, expected %08x", deserialized.Header.Checksum, page.Header.Checksum)
+	}
+	if err := VerifyPageChecksum(0, serialized); err != nil {
+		t.Errorf("Serialized page failed verification: %v", err)
+	}
 
 	// Verify data
 	if string(deserialized.Data[:9]) != "test data" {
@@ -61,3 +75,55 @@ func TestPageTypes(t *testing.T) {
 		t.Error("IsFree() should return true")
 	}
 }
+
+func TestPageChecksumDetectsCorruption(t *testing.T) {
+	dbFile := "test_checksum.db"
+	defer os.Remove(dbFile)
+
+	pager, err := NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+
+	pageID, page, err := pager.AllocatePageWithType(PageTypeLeaf)
+	if err != nil {
+		t.Fatalf("Failed to allocate page: %v", err)
+	}
+	copy(page.Data, []byte("important data"))
+	if err := pager.WritePageStruct(pageID, page); err != nil {
+		t.Fatalf("Failed to write page: %v", err)
+	}
+
+	// Clean read passes verification
+	if _, err := pager.ReadPage(pageID); err != nil {
+		t.Fatalf("ReadPage failed on intact page: %v", err)
+	}
+	pager.Close()
+
+	// Flip one bit in the page body behind the pager's back
+	file, err := os.OpenFile(dbFile, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	offset := int64(pageID*PageSize) + PageHeaderSize + 3
+	buf := make([]byte, 1)
+	file.ReadAt(buf, offset)
+	buf[0] ^= 0x10
+	file.WriteAt(buf, offset)
+	file.Close()
+
+	pager2, err := NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen pager: %v", err)
+	}
+	defer pager2.Close()
+
+	_, err = pager2.ReadPage(pageID)
+	var corrupt *ErrPageCorrupt
+	if !errors.As(err, &corrupt) {
+		t.Fatalf("Expected ErrPageCorrupt, got %v", err)
+	}
+	if corrupt.PageID != pageID {
+		t.Errorf("ErrPageCorrupt.PageID = %d, expected %d", corrupt.PageID, pageID)
+	}
+}
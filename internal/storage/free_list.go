@@ -6,51 +6,114 @@ import (
 )
 
 // FreeList manage deleted page for reused purpose
+//
+// Free page IDs are kept in a chain of trunk pages starting at page 0.
+// Trunk page: header NextPage links the next trunk, 0 on the last one
+// Data: [count: 4][pageID: 8]...
+//
+// Only page 0 takes pushes and pops. When it fills up, its IDs move into
+// the page being freed, which becomes the second trunk. When it runs empty,
+// the second trunk's IDs move back into it and that trunk page is handed out.
+// Every trunk past page 0 is itself a free page.
 type FreeList struct {
-	freePageIDs []uint64
+	trunks []freeTrunk // trunks[0] is page 0, in chain order
+}
+
+// freeTrunk is one page of the free list chain
+type freeTrunk struct {
+	pageID  uint64
+	pageIDs []uint64
 }
 
 // NewFreeList create new free list
 func NewFreeList() *FreeList {
 	return &FreeList{
-		freePageIDs: make([]uint64, 0),
+		trunks: []freeTrunk{{pageID: FreeListPageID}},
 	}
 }
 
 // Pop a page ID from free list
 func (fl *FreeList) Pop() (uint64, bool) {
-	if len(fl.freePageIDs) == 0 {
+	head := &fl.trunks[0]
+	if len(head.pageIDs) > 0 {
+		pageID := head.pageIDs[len(head.pageIDs)-1]
+		head.pageIDs = head.pageIDs[:len(head.pageIDs)-1]
+		return pageID, true
+	}
+
+	if len(fl.trunks) == 1 {
 		return 0, false
 	}
 
-	pageID := fl.freePageIDs[len(fl.freePageIDs)-1]
-	fl.freePageIDs = fl.freePageIDs[:len(fl.freePageIDs)-1] // ✅ Fixed: remove -1
-	return pageID, true
+	// Drain the second trunk into page 0 and hand out its page
+	next := fl.trunks[1]
+	head.pageIDs = next.pageIDs
+	fl.trunks = append(fl.trunks[:1], fl.trunks[2:]...)
+	return next.pageID, true
 }
 
 // Push a new page ID to free list
 func (fl *FreeList) Push(pageID uint64) {
-	fl.freePageIDs = append(fl.freePageIDs, pageID)
+	head := &fl.trunks[0]
+	if len(head.pageIDs) < MaxFreePageIDs() {
+		head.pageIDs = append(head.pageIDs, pageID)
+		return
+	}
+
+	// Page 0 is full, the freed page takes its IDs as the second trunk
+	trunk := freeTrunk{pageID: pageID, pageIDs: head.pageIDs}
+	head.pageIDs = nil
+	fl.trunks = append(fl.trunks[:1], append([]freeTrunk{trunk}, fl.trunks[1:]...)...)
 }
 
 // IsEmpty check if free list is empty
 func (fl *FreeList) IsEmpty() bool {
-	return len(fl.freePageIDs) == 0
+	return fl.Size() == 0
 }
 
-// Size return number of free pages
+// Size return number of free pages, trunk pages past page 0 included
 func (fl *FreeList) Size() int {
-	return len(fl.freePageIDs)
+	size := len(fl.trunks) - 1
+	for _, trunk := range fl.trunks {
+		size += len(trunk.pageIDs)
+	}
+	return size
+}
+
+// NumTrunks returns the number of pages the free list takes, page 0 included
+func (fl *FreeList) NumTrunks() int {
+	return len(fl.trunks)
+}
+
+// TrunkPageID returns the page holding trunk index of the chain
+func (fl *FreeList) TrunkPageID(index int) uint64 {
+	return fl.trunks[index].pageID
 }
 
-// SerializeToPage change FreeList into Page to write to disk
-func (fl *FreeList) SerializeToPage() *Page {
+// PageIDs returns every free page, trunk pages past page 0 included
+func (fl *FreeList) PageIDs() []uint64 {
+	pageIDs := make([]uint64, 0, fl.Size())
+	for i, trunk := range fl.trunks {
+		if i > 0 {
+			pageIDs = append(pageIDs, trunk.pageID)
+		}
+		pageIDs = append(pageIDs, trunk.pageIDs...)
+	}
+	return pageIDs
+}
+
+// SerializeTrunk change trunk index of the FreeList into Page to write to disk
+func (fl *FreeList) SerializeTrunk(index int) *Page {
 	page := NewPage(PageTypeFree)
+	if index+1 < len(fl.trunks) {
+		page.Header.NextPage = uint32(fl.trunks[index+1].pageID)
+	}
 
-	binary.LittleEndian.PutUint32(page.Data[0:4], uint32(len(fl.freePageIDs)))
+	trunk := fl.trunks[index]
+	binary.LittleEndian.PutUint32(page.Data[0:4], uint32(len(trunk.pageIDs)))
 
 	offset := 4
-	for _, pageID := range fl.freePageIDs {
+	for _, pageID := range trunk.pageIDs {
 		binary.LittleEndian.PutUint64(page.Data[offset:offset+8], pageID)
 		offset += 8
 	}
@@ -58,24 +121,58 @@ func (fl *FreeList) SerializeToPage() *Page {
 	return page
 }
 
-// DeserializeFreeList read FreeList from Page
-func DeserializeFreeList(page *Page) (*FreeList, error) {
+// ReadFreeList read the FreeList chain starting at page 0
+func ReadFreeList(pager Pager) (*FreeList, error) {
+	fl := &FreeList{}
+	seen := make(map[uint64]bool)
+
+	for pageID := uint64(FreeListPageID); ; {
+		if seen[pageID] {
+			return nil, fmt.Errorf("free list loops back to page %d", pageID)
+		}
+		seen[pageID] = true
+
+		data, err := pager.ReadPage(pageID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read free list page %d: %w", pageID, err)
+		}
+		page, err := DeserializePage(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode free list page %d: %w", pageID, err)
+		}
+
+		pageIDs, err := deserializeTrunk(page)
+		if err != nil {
+			return nil, fmt.Errorf("free list page %d: %w", pageID, err)
+		}
+		fl.trunks = append(fl.trunks, freeTrunk{pageID: pageID, pageIDs: pageIDs})
+
+		if page.Header.NextPage == 0 {
+			return fl, nil
+		}
+		pageID = uint64(page.Header.NextPage)
+	}
+}
+
+// deserializeTrunk read the page IDs of one trunk page
+func deserializeTrunk(page *Page) ([]uint64, error) {
 	if page.Header.PageType != PageTypeFree {
 		return nil, fmt.Errorf("invalid page type: %v, expected Free", page.Header.PageType)
 	}
 
-	fl := NewFreeList()
-
 	count := binary.LittleEndian.Uint32(page.Data[0:4])
+	if count > uint32(MaxFreePageIDs()) {
+		return nil, fmt.Errorf("trunk holds %d page IDs, at most %d fit", count, MaxFreePageIDs())
+	}
 
+	pageIDs := make([]uint64, 0, count)
 	offset := 4
 	for i := uint32(0); i < count; i++ {
-		pageID := binary.LittleEndian.Uint64(page.Data[offset : offset+8])
-		fl.freePageIDs = append(fl.freePageIDs, pageID)
+		pageIDs = append(pageIDs, binary.LittleEndian.Uint64(page.Data[offset:offset+8]))
 		offset += 8
 	}
 
-	return fl, nil
+	return pageIDs, nil
 }
 
 // MaxFreePageIDs calculate max number of page IDs that can be store in one page
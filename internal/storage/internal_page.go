@@ -6,25 +6,39 @@ import (
 )
 
 // InternalPage represents a B+ Tree internal node
-// Layout: [leftmost_ptr: 8 bytes][key1: 4 bytes][ptr1: 8 bytes][key2: 4 bytes][ptr2: 8 bytes]...
 // Structure: P0 | K1 P1 | K2 P2 | K3 P3 | ...
 // Where P0 is for keys < K1, P1 for [K1, K2), P2 for [K2, K3), etc.
+//
+// Keys vary in length, so entries are slotted like leaf records:
+// [leftmost_ptr: 8][entriesStart: 2][slot1: 2][slot2: 2]...[free space]...[entries]
+// Each slot holds the offset of an entry [ptr: 8][keySize: 2][key], slots
+// are in key order and entries are packed at the end of the page.
 type InternalPage struct {
 	page *Page
+	cmp  Comparator // Orders the separator keys
 }
 
-// InternalEntry represents a key-pointer pair
-type InternalEntry struct {
-	Key    uint32
-	PageID uint64
+const (
+	internalHeaderSize      = 10 // Leftmost pointer and entriesStart
+	internalEntryHeaderSize = 10 // Pointer and key size
+)
+
+// InternalEntrySize returns the bytes an entry with a key of keySize takes, slot included
+func InternalEntrySize(keySize int) int {
+	return 2 + internalEntryHeaderSize + keySize
 }
 
-// NewInternalPage creates a new internal page
+// NewInternalPage creates a new internal page ordered by BytewiseComparator
 func NewInternalPage(page *Page) *InternalPage {
+	return NewInternalPageWithComparator(page, BytewiseComparator)
+}
+
+// NewInternalPageWithComparator creates a new internal page ordered by cmp
+func NewInternalPageWithComparator(page *Page, cmp Comparator) *InternalPage {
 	if page.Header.PageType != PageTypeInternal {
 		panic("page must be of type Internal")
 	}
-	return &InternalPage{page: page}
+	return &InternalPage{page: page, cmp: cmp}
 }
 
 // GetLeftmostPointer returns the leftmost child pointer (P0)
@@ -45,135 +59,242 @@ func (ip *InternalPage) SetLeftmostPointer(pageID uint64) error {
 	return nil
 }
 
-// GetKeyPointer returns the key and pointer at index (0-based)
-// index 0 returns key[0] and pointer[1]
-// index i returns key[i] and pointer[i+1]
-func (ip *InternalPage) GetKeyPointer(index int) (uint32, uint64, error) {
-	if index < 0 || index >= int(ip.page.Header.NumKeys) {
-		return 0, 0, fmt.Errorf("index %d out of bounds", index)
+// entriesStart returns where the packed entries begin
+// A new page has no entries, its zero value stands for the end of the page
+func (ip *InternalPage) entriesStart() int {
+	start := int(binary.LittleEndian.Uint16(ip.page.Data[8:10]))
+	if start == 0 {
+		return len(ip.page.Data)
 	}
+	return start
+}
 
-	// Offset: leftmost_ptr(8) + index * (key(4) + ptr(8))
-	offset := 8 + index*12
+// setEntriesStart records where the packed entries begin
+func (ip *InternalPage) setEntriesStart(start int) {
+	binary.LittleEndian.PutUint16(ip.page.Data[8:10], uint16(start))
+}
+
+// slot returns the offset of the entry at index
+func (ip *InternalPage) slot(index int) int {
+	pos := internalHeaderSize + index*2
+	return int(binary.LittleEndian.Uint16(ip.page.Data[pos : pos+2]))
+}
 
-	if offset+12 > len(ip.page.Data) {
-		return 0, 0, fmt.Errorf("insufficient data at offset %d", offset)
+// setSlot sets the offset of the entry at index
+func (ip *InternalPage) setSlot(index int, offset int) {
+	pos := internalHeaderSize + index*2
+	binary.LittleEndian.PutUint16(ip.page.Data[pos:pos+2], uint16(offset))
+}
+
+// entryAt returns the key and pointer at index, the key aliases the page
+func (ip *InternalPage) entryAt(index int) ([]byte, uint64, error) {
+	if index < 0 || index >= int(ip.page.Header.NumKeys) {
+		return nil, 0, fmt.Errorf("index %d out of bounds", index)
+	}
+
+	offset := ip.slot(index)
+	if offset+internalEntryHeaderSize > len(ip.page.Data) {
+		return nil, 0, fmt.Errorf("insufficient data at offset %d", offset)
 	}
 
-	key := binary.LittleEndian.Uint32(ip.page.Data[offset : offset+4])
-	ptr := binary.LittleEndian.Uint64(ip.page.Data[offset+4 : offset+12])
+	ptr := binary.LittleEndian.Uint64(ip.page.Data[offset : offset+8])
+	keySize := int(binary.LittleEndian.Uint16(ip.page.Data[offset+8 : offset+10]))
+	keyStart := offset + internalEntryHeaderSize
+	if keyStart+keySize > len(ip.page.Data) {
+		return nil, 0, fmt.Errorf("insufficient data for key at offset %d", offset)
+	}
 
-	return key, ptr, nil
+	return ip.page.Data[keyStart : keyStart+keySize], ptr, nil
 }
 
-// SetKeyPointer sets key and pointer at index
-func (ip *InternalPage) SetKeyPointer(index int, key uint32, pageID uint64) error {
-	// Allow setting at NumKeys position for insertion
-	if index < 0 || index > int(ip.page.Header.NumKeys) {
-		return fmt.Errorf("index %d out of bounds", index)
+// GetKeyPointer returns a copy of the key and the pointer at index (0-based)
+// index 0 returns key[0] and pointer[1]
+// index i returns key[i] and pointer[i+1]
+func (ip *InternalPage) GetKeyPointer(index int) ([]byte, uint64, error) {
+	key, ptr, err := ip.entryAt(index)
+	if err != nil {
+		return nil, 0, err
 	}
+	return append([]byte(nil), key...), ptr, nil
+}
 
-	offset := 8 + index*12
+// AvailableSpace returns free bytes between the slot table and the entries
+func (ip *InternalPage) AvailableSpace() int {
+	return ip.entriesStart() - internalHeaderSize - 2*int(ip.page.Header.NumKeys)
+}
 
-	if offset+12 > len(ip.page.Data) {
-		return fmt.Errorf("insufficient data at offset %d", offset)
-	}
+// Capacity returns the total number of bytes available for slots and entries
+func (ip *InternalPage) Capacity() int {
+	return len(ip.page.Data) - internalHeaderSize
+}
 
-	binary.LittleEndian.PutUint32(ip.page.Data[offset:offset+4], key)
-	binary.LittleEndian.PutUint64(ip.page.Data[offset+4:offset+12], pageID)
+// UsedSpace returns bytes occupied by the slot table and entries
+func (ip *InternalPage) UsedSpace() int {
+	return ip.Capacity() - ip.AvailableSpace()
+}
 
-	return nil
+// HasRoomFor reports whether an entry with a key of keySize fits
+func (ip *InternalPage) HasRoomFor(keySize int) bool {
+	return ip.AvailableSpace() >= InternalEntrySize(keySize)
 }
 
 // InsertEntry inserts a key-pointer pair at the correct position
-func (ip *InternalPage) InsertEntry(key uint32, pageID uint64) error {
-	// Check space (12 bytes per entry = 4 bytes key + 8 bytes pointer)
-	maxEntries := (len(ip.page.Data) - 8) / 12 // -8 for leftmost ptr
-	if int(ip.page.Header.NumKeys) >= maxEntries {
+func (ip *InternalPage) InsertEntry(key []byte, pageID uint64) error {
+	// Check space
+	if !ip.HasRoomFor(len(key)) {
 		return fmt.Errorf("internal page full")
 	}
 
 	// Find insert position (keep keys sorted)
 	insertPos := ip.findInsertPosition(key)
 
-	// Shift entries to make room
-	for i := int(ip.page.Header.NumKeys) - 1; i >= insertPos; i-- {
-		k, p, _ := ip.GetKeyPointer(i)
-		ip.SetKeyPointer(i+1, k, p)
-	}
+	// Write the entry below the others
+	offset := ip.entriesStart() - internalEntryHeaderSize - len(key)
+	binary.LittleEndian.PutUint64(ip.page.Data[offset:offset+8], pageID)
+	binary.LittleEndian.PutUint16(ip.page.Data[offset+8:offset+10], uint16(len(key)))
+	copy(ip.page.Data[offset+internalEntryHeaderSize:], key)
+	ip.setEntriesStart(offset)
 
-	// Insert new entry
-	ip.SetKeyPointer(insertPos, key, pageID)
+	// Shift slots to make room
+	for i := int(ip.page.Header.NumKeys); i > insertPos; i-- {
+		ip.setSlot(i, ip.slot(i-1))
+	}
+	ip.setSlot(insertPos, offset)
 	ip.page.Header.NumKeys++
 
 	return nil
 }
 
-// findInsertPosition finds where to insert key to maintain sorted order
-func (ip *InternalPage) findInsertPosition(key uint32) int {
+// RemoveEntry removes key[index] together with the pointer to its right
+func (ip *InternalPage) RemoveEntry(index int) error {
+	if index < 0 || index >= int(ip.page.Header.NumKeys) {
+		return fmt.Errorf("index %d out of bounds", index)
+	}
+
+	keys, ptrs, err := ip.entries()
+	if err != nil {
+		return err
+	}
+	keys = append(keys[:index], keys[index+1:]...)
+	ptrs = append(ptrs[:index], ptrs[index+1:]...)
+	return ip.rebuild(keys, ptrs)
+}
+
+// SetKey replaces the key at index, keeping its pointer
+// Fails without changing the page if a longer key doesn't fit.
+func (ip *InternalPage) SetKey(index int, key []byte) error {
+	if index < 0 || index >= int(ip.page.Header.NumKeys) {
+		return fmt.Errorf("index %d out of bounds", index)
+	}
+	if !ip.CanSetKey(index, key) {
+		return fmt.Errorf("internal page full")
+	}
+
+	keys, ptrs, err := ip.entries()
+	if err != nil {
+		return err
+	}
+	keys[index] = key
+	return ip.rebuild(keys, ptrs)
+}
+
+// CanSetKey reports whether SetKey can replace the key at index with key
+func (ip *InternalPage) CanSetKey(index int, key []byte) bool {
+	old, _, err := ip.entryAt(index)
+	if err != nil {
+		return false
+	}
+	return ip.AvailableSpace() >= len(key)-len(old)
+}
+
+// entries returns copies of every key and the pointer right of each
+func (ip *InternalPage) entries() ([][]byte, []uint64, error) {
+	keys := make([][]byte, 0, ip.page.Header.NumKeys)
+	ptrs := make([]uint64, 0, ip.page.Header.NumKeys)
 	for i := 0; i < int(ip.page.Header.NumKeys); i++ {
-		k, _, err := ip.GetKeyPointer(i)
+		key, ptr, err := ip.GetKeyPointer(i)
 		if err != nil {
-			break
+			return nil, nil, err
 		}
-		if key < k {
-			return i
+		keys = append(keys, key)
+		ptrs = append(ptrs, ptr)
+	}
+	return keys, ptrs, nil
+}
+
+// rebuild rewrites the entries packed at the end of the page, keeping the
+// leftmost pointer. keys must be sorted.
+func (ip *InternalPage) rebuild(keys [][]byte, ptrs []uint64) error {
+	ip.Reset()
+	for i, key := range keys {
+		if err := ip.InsertEntry(key, ptrs[i]); err != nil {
+			return err
 		}
 	}
-	return int(ip.page.Header.NumKeys)
+	return nil
 }
 
-// SearchChild finds the child page ID for a given key
-// Logic: For keys [K1, K2, K3] and pointers [P0, P1, P2, P3]
-// - key < K1 → P0
-// - K1 <= key < K2 → P1
-// - K2 <= key < K3 → P2
-// - key >= K3 → P3
-func (ip *InternalPage) SearchChild(key uint32) (uint64, error) {
-	// If no keys, return leftmost pointer
-	if ip.page.Header.NumKeys == 0 {
+// GetChild returns the child pointer at index
+// index 0 is the leftmost pointer, index i is the pointer right of key[i-1]
+func (ip *InternalPage) GetChild(index int) (uint64, error) {
+	if index == 0 {
 		return ip.GetLeftmostPointer()
 	}
+	_, ptr, err := ip.entryAt(index - 1)
+	return ptr, err
+}
 
-	// Search through keys to find the correct child
-	for i := 0; i < int(ip.page.Header.NumKeys); i++ {
-		k, ptr, err := ip.GetKeyPointer(i)
+// ChildIndex returns the index of the child pointer equal to pageID, or -1
+func (ip *InternalPage) ChildIndex(pageID uint64) int {
+	for i := 0; i <= int(ip.page.Header.NumKeys); i++ {
+		ptr, err := ip.GetChild(i)
 		if err != nil {
-			return 0, err
-		}
-
-		// If search key < current key, return appropriate pointer
-		if key < k {
-			if i == 0 {
-				// key < K1, return P0 (leftmost)
-				return ip.GetLeftmostPointer()
-			}
-			// This case shouldn't happen because we already processed previous keys
-			// But for safety, return leftmost
-			return ip.GetLeftmostPointer()
+			return -1
 		}
-
-		// If this is the last key
-		if i == int(ip.page.Header.NumKeys)-1 {
-			// key >= last key, return last pointer
-			return ptr, nil
+		if ptr == pageID {
+			return i
 		}
+	}
+	return -1
+}
 
-		// Check next key to determine range
-		nextKey, _, err := ip.GetKeyPointer(i + 1)
+// upperBound returns the number of keys <= key
+func (ip *InternalPage) upperBound(key []byte) int {
+	left, right := 0, int(ip.page.Header.NumKeys)
+	for left < right {
+		mid := (left + right) / 2
+		k, _, err := ip.entryAt(mid)
 		if err != nil {
-			// If error reading next key, return current pointer
-			return ptr, nil
+			return right
 		}
-
-		// If key is in range [Ki, Ki+1), return Pi
-		if key >= k && key < nextKey {
-			return ptr, nil
+		if ip.cmp.Compare(k, key) <= 0 {
+			left = mid + 1
+		} else {
+			right = mid
 		}
 	}
+	return left
+}
+
+// findInsertPosition finds where to insert key to maintain sorted order
+func (ip *InternalPage) findInsertPosition(key []byte) int {
+	return ip.upperBound(key)
+}
+
+// SearchChild finds the child page ID for a given key
+// Logic: For keys [K1, K2, K3] and pointers [P0, P1, P2, P3]
+// - key < K1 → P0
+// - K1 <= key < K2 → P1
+// - K2 <= key < K3 → P2
+// - key >= K3 → P3
+func (ip *InternalPage) SearchChild(key []byte) (uint64, error) {
+	return ip.GetChild(ip.upperBound(key))
+}
 
-	// Fallback: should not reach here, but return leftmost for safety
-	return ip.GetLeftmostPointer()
+// Reset removes all keys from the page
+func (ip *InternalPage) Reset() {
+	ip.page.Header.NumKeys = 0
+	ip.setEntriesStart(len(ip.page.Data))
 }
 
 // NumKeys returns number of keys
@@ -183,5 +304,6 @@ func (ip *InternalPage) NumKeys() int {
 
 // String returns string representation
 func (ip *InternalPage) String() string {
-	return fmt.Sprintf("InternalPage{NumKeys: %d}", ip.page.Header.NumKeys)
+	return fmt.Sprintf("InternalPage{NumKeys: %d, AvailableSpace: %d bytes}",
+		ip.page.Header.NumKeys, ip.AvailableSpace())
 }
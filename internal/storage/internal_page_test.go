@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -24,7 +25,7 @@ func TestInternalPageInsertAndSearch(t *testing.T) {
 	}
 
 	for _, entry := range entries {
-		if err := internalPage.InsertEntry(entry.key, entry.pageID); err != nil {
+		if err := internalPage.InsertEntry(Uint32Key(entry.key), entry.pageID); err != nil {
 			t.Fatalf("Failed to insert entry: %v", err)
 		}
 	}
@@ -47,7 +48,7 @@ func TestInternalPageInsertAndSearch(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		pageID, err := internalPage.SearchChild(tc.key)
+		pageID, err := internalPage.SearchChild(Uint32Key(tc.key))
 		if err != nil {
 			t.Errorf("SearchChild(%d) failed: %v", tc.key, err)
 		}
@@ -56,3 +57,115 @@ func TestInternalPageInsertAndSearch(t *testing.T) {
 		}
 	}
 }
+
+func TestInternalPageRemoveEntry(t *testing.T) {
+	page := NewPage(PageTypeInternal)
+	internalPage := NewInternalPage(page)
+
+	internalPage.SetLeftmostPointer(100)
+	internalPage.InsertEntry(Uint32Key(50), 101)
+	internalPage.InsertEntry(Uint32Key(100), 102)
+	internalPage.InsertEntry(Uint32Key(150), 103)
+
+	if idx := internalPage.ChildIndex(102); idx != 2 {
+		t.Errorf("ChildIndex(102) = %d, expected 2", idx)
+	}
+
+	// Remove key 100 together with its right pointer 102
+	if err := internalPage.RemoveEntry(1); err != nil {
+		t.Fatalf("RemoveEntry failed: %v", err)
+	}
+
+	if internalPage.NumKeys() != 2 {
+		t.Errorf("NumKeys = %d, expected 2", internalPage.NumKeys())
+	}
+	if idx := internalPage.ChildIndex(102); idx != -1 {
+		t.Errorf("ChildIndex(102) = %d after removal, expected -1", idx)
+	}
+
+	key, ptr, _ := internalPage.GetKeyPointer(1)
+	if !bytes.Equal(key, Uint32Key(150)) || ptr != 103 {
+		t.Errorf("Entry 1 = (%x, %d), expected (150, 103)", key, ptr)
+	}
+
+	if err := internalPage.SetKey(1, Uint32Key(120)); err != nil {
+		t.Fatalf("SetKey failed: %v", err)
+	}
+	if child, _ := internalPage.SearchChild(Uint32Key(125)); child != 103 {
+		t.Errorf("SearchChild(125) = %d, expected 103", child)
+	}
+}
+
+func TestInternalPageVariableKeys(t *testing.T) {
+	page := NewPage(PageTypeInternal)
+	internalPage := NewInternalPage(page)
+	internalPage.SetLeftmostPointer(100)
+
+	keys := []string{"tenant-b/users/42", "a", "tenant-a/z", "tenant-b/users/7", "m"}
+	for i, key := range keys {
+		if err := internalPage.InsertEntry([]byte(key), uint64(101+i)); err != nil {
+			t.Fatalf("Failed to insert %q: %v", key, err)
+		}
+	}
+
+	// Entries come back in key order whatever their length
+	expected := []string{"a", "m", "tenant-a/z", "tenant-b/users/42", "tenant-b/users/7"}
+	for i, want := range expected {
+		key, _, err := internalPage.GetKeyPointer(i)
+		if err != nil || string(key) != want {
+			t.Errorf("Key %d = %q (%v), expected %q", i, key, err, want)
+		}
+	}
+
+	testCases := []struct {
+		key            string
+		expectedPageID uint64
+	}{
+		{"0", 100},                // Before every key → leftmost
+		{"a", 102},                // = "a"
+		{"b", 102},                // "a" < x < "m"
+		{"tenant-a", 105},         // "m" < x < "tenant-a/z"
+		{"tenant-b/users/5", 101}, // "tenant-b/users/42" < x < "tenant-b/users/7"
+		{"zzz", 104},              // After every key → rightmost
+	}
+	for _, tc := range testCases {
+		if child, _ := internalPage.SearchChild([]byte(tc.key)); child != tc.expectedPageID {
+			t.Errorf("SearchChild(%q) = %d, expected %d", tc.key, child, tc.expectedPageID)
+		}
+	}
+
+	// Fill the page with long keys, space is counted in bytes
+	long := bytes.Repeat([]byte("x"), MaxKeySize)
+	n := 0
+	for internalPage.HasRoomFor(MaxKeySize) {
+		key := append([]byte{'y', byte(n)}, long[2:]...)
+		if err := internalPage.InsertEntry(key, uint64(200+n)); err != nil {
+			t.Fatalf("Insert with room failed: %v", err)
+		}
+		n++
+	}
+	if err := internalPage.InsertEntry(long, 999); err == nil {
+		t.Fatal("Inserted a key into a full page")
+	}
+	for internalPage.HasRoomFor(2) {
+		if err := internalPage.InsertEntry([]byte{'z', byte(n)}, uint64(200+n)); err != nil {
+			t.Fatalf("Insert with room failed: %v", err)
+		}
+		n++
+	}
+	if internalPage.CanSetKey(0, long) || internalPage.SetKey(0, long) == nil {
+		t.Error("Replaced a short key with a long one in a full page")
+	}
+
+	// Removing an entry gives its bytes back
+	before := internalPage.AvailableSpace()
+	if err := internalPage.RemoveEntry(internalPage.NumKeys() - 1); err != nil {
+		t.Fatalf("RemoveEntry failed: %v", err)
+	}
+	if reclaimed := internalPage.AvailableSpace() - before; reclaimed != InternalEntrySize(2) {
+		t.Errorf("Removing an entry reclaimed %d bytes, expected %d", reclaimed, InternalEntrySize(2))
+	}
+	if leftmost, _ := internalPage.GetLeftmostPointer(); leftmost != 100 {
+		t.Errorf("Leftmost pointer = %d after compaction, expected 100", leftmost)
+	}
+}
@@ -8,14 +8,20 @@ import (
 // LeafPage represents a B+ Tree leaf node with slot-based layout
 type LeafPage struct {
 	page *Page
+	cmp  Comparator // Orders the records by key
 }
 
-// NewLeafPage creates a new leaf page
+// NewLeafPage creates a new leaf page ordered by BytewiseComparator
 func NewLeafPage(page *Page) *LeafPage {
+	return NewLeafPageWithComparator(page, BytewiseComparator)
+}
+
+// NewLeafPageWithComparator creates a new leaf page ordered by cmp
+func NewLeafPageWithComparator(page *Page, cmp Comparator) *LeafPage {
 	if page.Header.PageType != PageTypeLeaf {
 		panic("page must be of type Leaf")
 	}
-	return &LeafPage{page: page}
+	return &LeafPage{page: page, cmp: cmp}
 }
 
 // SlotOffset returns the offset of a record in the data area
@@ -72,12 +78,29 @@ func (lp *LeafPage) InsertRecord(record *Record) error {
 		return fmt.Errorf("leaf page full: need %d bytes, have %d", recordSize+slotSize, lp.AvailableSpace())
 	}
 
+	lp.insertAt(lp.findInsertPosition(record), record)
+	return nil
+}
+
+// AppendRecord adds a record after every other one, the caller keeps them
+// in order. Versions of a key go newest first, as InsertRecord leaves them.
+// Returns error if page is full
+func (lp *LeafPage) AppendRecord(record *Record) error {
+	if lp.AvailableSpace() < record.Size()+2 {
+		return fmt.Errorf("leaf page full: need %d bytes, have %d", record.Size()+2, lp.AvailableSpace())
+	}
+
+	lp.insertAt(int(lp.page.Header.NumKeys), record)
+	return nil
+}
+
+// insertAt stores a record and puts its slot at insertPos, space was checked
+func (lp *LeafPage) insertAt(insertPos int, record *Record) {
+	recordSize := record.Size()
+
 	// Serialize record
 	serialized := record.Serialize()
 
-	// Find insertion position (binary search for sorted order)
-	insertPos := lp.findInsertPosition(record)
-
 	// Allocate space for record at end of data area
 	recordOffset := lp.freeSpaceEnd() - recordSize
 	copy(lp.page.Data[recordOffset:recordOffset+recordSize], serialized)
@@ -97,32 +120,51 @@ func (lp *LeafPage) InsertRecord(record *Record) error {
 
 	// Write numSlots at beginning
 	binary.LittleEndian.PutUint16(lp.page.Data[0:2], lp.page.Header.NumKeys)
-
-	return nil
 }
 
 // findInsertPosition finds where to insert record to maintain sorted order
+// A new version goes in front of the older versions of its key
 func (lp *LeafPage) findInsertPosition(record *Record) int {
-	key, err := record.GetKeyAsUint32()
-	if err != nil {
-		return int(lp.page.Header.NumKeys) // Insert at end if key is not uint32
+	return lp.lowerBound(record.Key)
+}
+
+// GetRecord retrieves a record by slot index
+func (lp *LeafPage) GetRecord(index int) (*Record, error) {
+	if index < 0 || index >= int(lp.page.Header.NumKeys) {
+		return nil, fmt.Errorf("index %d out of bounds", index)
 	}
 
-	// Binary search
+	offset := int(lp.getSlotOffset(index))
+	record, _, err := DeserializeRecord(lp.page.Data[offset:])
+	return record, err
+}
+
+// keyAt returns the key of the record in a slot without copying it
+func (lp *LeafPage) keyAt(index int) ([]byte, error) {
+	offset := int(lp.getSlotOffset(index))
+	if offset+4 > len(lp.page.Data) {
+		return nil, fmt.Errorf("slot %d points past the page", index)
+	}
+
+	keySize := int(binary.LittleEndian.Uint32(lp.page.Data[offset : offset+4]))
+	if offset+4+keySize > len(lp.page.Data) {
+		return nil, fmt.Errorf("insufficient data for key in slot %d", index)
+	}
+	return lp.page.Data[offset+4 : offset+4+keySize], nil
+}
+
+// lowerBound returns the index of the first record whose key is >= key
+func (lp *LeafPage) lowerBound(key []byte) int {
 	left, right := 0, int(lp.page.Header.NumKeys)
+
 	for left < right {
 		mid := (left + right) / 2
-		midRecord, err := lp.GetRecord(mid)
-		if err != nil {
-			return int(lp.page.Header.NumKeys)
-		}
-
-		midKey, err := midRecord.GetKeyAsUint32()
+		recordKey, err := lp.keyAt(mid)
 		if err != nil {
 			return int(lp.page.Header.NumKeys)
 		}
 
-		if midKey < key {
+		if lp.cmp.Compare(recordKey, key) < 0 {
 			left = mid + 1
 		} else {
 			right = mid
@@ -132,44 +174,109 @@ func (lp *LeafPage) findInsertPosition(record *Record) int {
 	return left
 }
 
-// GetRecord retrieves a record by slot index
-func (lp *LeafPage) GetRecord(index int) (*Record, error) {
-	if index < 0 || index >= int(lp.page.Header.NumKeys) {
-		return nil, fmt.Errorf("index %d out of bounds", index)
+// findVersion returns the slot index of the first version of key matching
+// the predicate, or -1 if there is none
+func (lp *LeafPage) findVersion(key []byte, match func(*Record) bool) (int, *Record) {
+	for i := lp.lowerBound(key); i < int(lp.page.Header.NumKeys); i++ {
+		record, err := lp.GetRecord(i)
+		if err != nil {
+			return -1, nil
+		}
+
+		if lp.cmp.Compare(record.Key, key) != 0 {
+			return -1, nil
+		}
+
+		if match(record) {
+			return i, record
+		}
 	}
 
-	offset := int(lp.getSlotOffset(index))
-	record, _, err := DeserializeRecord(lp.page.Data[offset:])
-	return record, err
+	return -1, nil
 }
 
-// SearchRecord searches for a record by key (binary search)
+// SearchRecord searches for the live version of a key (binary search)
 // Returns (record, found)
-func (lp *LeafPage) SearchRecord(key uint32) (*Record, bool) {
-	left, right := 0, int(lp.page.Header.NumKeys)
+func (lp *LeafPage) SearchRecord(key []byte) (*Record, bool) {
+	_, record := lp.findVersion(key, (*Record).IsLive)
+	return record, record != nil
+}
 
-	for left < right {
-		mid := (left + right) / 2
-		record, err := lp.GetRecord(mid)
-		if err != nil {
-			return nil, false
-		}
+// SearchVisible searches for the version of a key a snapshot at lsn sees
+func (lp *LeafPage) SearchVisible(key []byte, lsn uint64) (*Record, bool) {
+	_, record := lp.findVersion(key, func(r *Record) bool { return r.VisibleAt(lsn) })
+	return record, record != nil
+}
 
-		recordKey, err := record.GetKeyAsUint32()
-		if err != nil {
-			return nil, false
-		}
+// MarkDeleted stamps the live version of a key as deleted by txID in place,
+// keeping it for older snapshots
+// Returns true if a live version was found
+func (lp *LeafPage) MarkDeleted(key []byte, txID uint64) bool {
+	index, record := lp.findVersion(key, (*Record).IsLive)
+	if index < 0 {
+		return false
+	}
 
-		if recordKey == key {
-			return record, true
-		} else if recordKey < key {
-			left = mid + 1
+	// DeletedBy is the last field of the record
+	end := int(lp.getSlotOffset(index)) + record.Size()
+	binary.LittleEndian.PutUint64(lp.page.Data[end-8:end], txID)
+	return true
+}
+
+// DeleteRecord removes the live version of a key and compacts the page
+// Returns true if a record was removed
+func (lp *LeafPage) DeleteRecord(key []byte) bool {
+	index, _ := lp.findVersion(key, (*Record).IsLive)
+	if index < 0 {
+		return false
+	}
+
+	return len(lp.removeWhere(func(i int, _ *Record) bool { return i == index })) > 0
+}
+
+// PruneVersions removes the deleted versions of a key that keep reports
+// as unneeded, returns the versions removed
+func (lp *LeafPage) PruneVersions(key []byte, keep func(*Record) bool) []*Record {
+	return lp.removeWhere(func(_ int, r *Record) bool {
+		return lp.cmp.Compare(r.Key, key) == 0 && !r.IsLive() && !keep(r)
+	})
+}
+
+// removeWhere rebuilds the page without the matching records so their bytes
+// are reclaimed, returns the records removed
+func (lp *LeafPage) removeWhere(remove func(index int, r *Record) bool) []*Record {
+	records, err := lp.GetAllRecords()
+	if err != nil {
+		return nil
+	}
+
+	var kept, removed []*Record
+	for i, record := range records {
+		if remove(i, record) {
+			removed = append(removed, record)
 		} else {
-			right = mid
+			kept = append(kept, record)
+		}
+	}
+
+	if len(removed) == 0 {
+		return nil
+	}
+
+	lp.Reset()
+	for _, record := range kept {
+		if err := lp.InsertRecord(record); err != nil {
+			return nil
 		}
 	}
 
-	return nil, false
+	return removed
+}
+
+// Reset removes all records from the page
+func (lp *LeafPage) Reset() {
+	lp.page.Header.NumKeys = 0
+	binary.LittleEndian.PutUint16(lp.page.Data[0:2], 0)
 }
 
 // GetAllRecords returns all records in sorted order
@@ -185,11 +292,26 @@ func (lp *LeafPage) GetAllRecords() ([]*Record, error) {
 	return records, nil
 }
 
+// UsedSpace returns bytes occupied by the slot table and records
+func (lp *LeafPage) UsedSpace() int {
+	return len(lp.page.Data) - lp.AvailableSpace()
+}
+
+// Capacity returns the total number of bytes available for slots and records
+func (lp *LeafPage) Capacity() int {
+	return len(lp.page.Data)
+}
+
 // IsFull checks if page is full (less than threshold free space)
 func (lp *LeafPage) IsFull(threshold int) bool {
 	return lp.AvailableSpace() < threshold
 }
 
+// PageLSN returns the LSN of the last WAL entry applied to the leaf
+func (lp *LeafPage) PageLSN() uint64 {
+	return lp.page.Header.PageLSN
+}
+
 // NumRecords returns number of records
 func (lp *LeafPage) NumRecords() int {
 	return int(lp.page.Header.NumKeys)
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// A value too large to share a leaf spills into a chain of overflow pages.
+// The leaf keeps the record with the first OverflowPrefixSize bytes of the
+// value inline and a pointer to the chain holding the rest.
+// Overflow page: header NextPage links the chain, 0 on the last page
+// Data: [chunkSize: 2][chunk]
+
+const (
+	// OverflowPrefixSize is how much of a spilled value stays in the leaf
+	OverflowPrefixSize = 64
+
+	// MaxInlineRecordSize is the largest record a leaf stores whole, so any
+	// two records fit in one leaf and a split always has room
+	MaxInlineRecordSize = (PageSize-PageHeaderSize-2)/2 - 2
+
+	overflowChunkSize = PageSize - PageHeaderSize - 2 // Value bytes per overflow page
+)
+
+// SpillValue moves the value of a record too large for a leaf past its
+// inline prefix into a new chain of overflow pages stamped with lsn
+// Returns false, leaving the record as it is, if the record fits a leaf.
+func SpillValue(pager Pager, record *Record, lsn uint64) (bool, error) {
+	if record.Overflow != 0 || record.Size() <= MaxInlineRecordSize || len(record.Value) <= OverflowPrefixSize {
+		return false, nil
+	}
+
+	rest := record.Value[OverflowPrefixSize:]
+	pageIDs := make([]uint64, OverflowPages(uint32(len(record.Value))))
+	for i := range pageIDs {
+		pageID, err := pager.AllocatePage()
+		if err != nil {
+			return false, fmt.Errorf("failed to allocate overflow page: %w", err)
+		}
+		pageIDs[i] = pageID
+	}
+
+	for i, pageID := range pageIDs {
+		chunk := rest[i*overflowChunkSize : min((i+1)*overflowChunkSize, len(rest))]
+
+		page := NewPage(PageTypeOverflow)
+		page.Header.PageLSN = lsn
+		if i+1 < len(pageIDs) {
+			page.Header.NextPage = uint32(pageIDs[i+1])
+		}
+		binary.LittleEndian.PutUint16(page.Data[0:2], uint16(len(chunk)))
+		copy(page.Data[2:], chunk)
+
+		if err := pager.WritePage(pageID, page.Serialize()); err != nil {
+			return false, fmt.Errorf("failed to write overflow page %d: %w", pageID, err)
+		}
+	}
+
+	record.ValueSize = uint32(len(record.Value))
+	record.Value = record.Value[:OverflowPrefixSize]
+	record.Overflow = pageIDs[0]
+	return true, nil
+}
+
+// ReadValue returns the whole value of a record, reading its overflow chain
+// if it has one
+func ReadValue(pager Pager, record *Record) ([]byte, error) {
+	if record.Overflow == 0 {
+		return record.Value, nil
+	}
+
+	value := make([]byte, 0, record.ValueSize)
+	value = append(value, record.Value...)
+
+	err := walkOverflow(pager, record.Overflow, func(pageID uint64, page *Page) error {
+		chunkSize := int(binary.LittleEndian.Uint16(page.Data[0:2]))
+		if chunkSize > overflowChunkSize || len(value)+chunkSize > int(record.ValueSize) {
+			return fmt.Errorf("overflow page %d holds %d bytes past the value's end", pageID, chunkSize)
+		}
+		value = append(value, page.Data[2:2+chunkSize]...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(value) != int(record.ValueSize) {
+		return nil, fmt.Errorf("overflow chain at page %d holds %d bytes, expected %d",
+			record.Overflow, len(value), record.ValueSize)
+	}
+	return value, nil
+}
+
+// FreeOverflow returns the overflow pages of a record to the free list
+func FreeOverflow(pager Pager, record *Record) error {
+	if record.Overflow == 0 {
+		return nil
+	}
+
+	pageIDs, err := OverflowChain(pager, record.Overflow)
+	if err != nil {
+		return err
+	}
+	for _, pageID := range pageIDs {
+		if err := pager.FreePage(pageID); err != nil {
+			return fmt.Errorf("failed to free overflow page %d: %w", pageID, err)
+		}
+	}
+	return nil
+}
+
+// OverflowPages returns how many overflow pages a spilled value of valueSize bytes takes
+func OverflowPages(valueSize uint32) int {
+	return (int(valueSize) - OverflowPrefixSize + overflowChunkSize - 1) / overflowChunkSize
+}
+
+// CopyOverflow copies the overflow chain of a record to pageIDs, stamped
+// with lsn, and points the record at the copy
+// The old chain is left as it is.
+func CopyOverflow(pager Pager, record *Record, pageIDs []uint64, lsn uint64) error {
+	if len(pageIDs) != OverflowPages(record.ValueSize) {
+		return fmt.Errorf("overflow value of %d bytes needs %d pages, got %d",
+			record.ValueSize, OverflowPages(record.ValueSize), len(pageIDs))
+	}
+
+	i := 0
+	err := walkOverflow(pager, record.Overflow, func(pageID uint64, page *Page) error {
+		if i == len(pageIDs) {
+			return fmt.Errorf("overflow chain at page %d is longer than its value", record.Overflow)
+		}
+
+		// The walk follows the old header, the copy gets a new one
+		copied := &Page{Header: page.Header, Data: page.Data}
+		copied.Header.PageLSN = lsn
+		copied.Header.NextPage = 0
+		if i+1 < len(pageIDs) {
+			copied.Header.NextPage = uint32(pageIDs[i+1])
+		}
+		if err := pager.WritePage(pageIDs[i], copied.Serialize()); err != nil {
+			return fmt.Errorf("failed to write overflow page %d: %w", pageIDs[i], err)
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if i != len(pageIDs) {
+		return fmt.Errorf("overflow chain at page %d is shorter than its value", record.Overflow)
+	}
+
+	record.Overflow = pageIDs[0]
+	return nil
+}
+
+// OverflowChain returns the pages of the overflow chain starting at firstPage
+func OverflowChain(pager Pager, firstPage uint64) ([]uint64, error) {
+	var pageIDs []uint64
+	err := walkOverflow(pager, firstPage, func(pageID uint64, _ *Page) error {
+		pageIDs = append(pageIDs, pageID)
+		return nil
+	})
+	return pageIDs, err
+}
+
+// walkOverflow calls visit on each page of an overflow chain in order
+func walkOverflow(pager Pager, firstPage uint64, visit func(pageID uint64, page *Page) error) error {
+	seen := make(map[uint64]bool)
+	for pageID := firstPage; pageID != 0; {
+		if seen[pageID] {
+			return fmt.Errorf("overflow chain at page %d loops back to page %d", firstPage, pageID)
+		}
+		seen[pageID] = true
+
+		data, err := pager.ReadPage(pageID)
+		if err != nil {
+			return fmt.Errorf("failed to read overflow page %d: %w", pageID, err)
+		}
+		page, err := DeserializePage(data)
+		if err != nil {
+			return fmt.Errorf("failed to decode overflow page %d: %w", pageID, err)
+		}
+		if page.Header.PageType != PageTypeOverflow {
+			return fmt.Errorf("page %d in overflow chain is a %s page", pageID, page.Header.PageType)
+		}
+
+		if err := visit(pageID, page); err != nil {
+			return err
+		}
+		pageID = uint64(page.Header.NextPage)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 )
 
 // PageType define type of page
@@ -12,6 +13,7 @@ const (
 	PageTypeFree     PageType = 0 // Page is empty
 	PageTypeInternal PageType = 1 // Internal node of B+ tree
 	PageTypeLeaf     PageType = 2 // Leaf node of B+ Tree
+	PageTypeOverflow PageType = 3 // Part of a value too large for a leaf
 )
 
 func (pt PageType) String() string {
@@ -22,13 +24,19 @@ func (pt PageType) String() string {
 		return "Internal"
 	case PageTypeLeaf:
 		return "Leaf"
+	case PageTypeOverflow:
+		return "Overflow"
 	default:
 		return "Unknown"
 	}
 }
 
 const (
-	PageHeaderSize = 16 // Header size in bytes
+	PageHeaderSize = 24 // Header size in bytes
+)
+
+const (
+	checksumOffset = 12 // Header offset of the page checksum
 )
 
 // PageHeader store metadata of page
@@ -37,7 +45,39 @@ type PageHeader struct {
 	NumKeys  uint16   // 2 bytes - number of keys in page
 	NextPage uint32   // 4 bytes - pointer to next page (used for leaf linked list)
 	Parent   uint32   // 4 bytes - pointer to parent page
-	// 4 bytes reserved for padding
+	Checksum uint32   // 4 bytes - CRC32 of the page excluding this field
+	PageLSN  uint64   // 8 bytes - LSN of the last WAL entry applied to this page
+}
+
+// ErrPageCorrupt is returned when a page's checksum doesn't match its contents
+type ErrPageCorrupt struct {
+	PageID   uint64
+	Expected uint32 // Checksum stored in the header
+	Actual   uint32 // Checksum computed from the contents
+}
+
+func (e *ErrPageCorrupt) Error() string {
+	return fmt.Sprintf("page %d corrupt: checksum %08x, expected %08x", e.PageID, e.Actual, e.Expected)
+}
+
+// PageChecksum computes the CRC32 of a serialized page, skipping the checksum field
+func PageChecksum(data []byte) uint32 {
+	crc := crc32.Update(0, crcTable, data[:checksumOffset])
+	return crc32.Update(crc, crcTable, data[checksumOffset+4:])
+}
+
+// SetPageChecksum stamps the checksum into a serialized page
+func SetPageChecksum(data []byte) {
+	binary.LittleEndian.PutUint32(data[checksumOffset:checksumOffset+4], PageChecksum(data))
+}
+
+// VerifyPageChecksum checks a serialized page against its stored checksum
+func VerifyPageChecksum(id uint64, data []byte) error {
+	expected := binary.LittleEndian.Uint32(data[checksumOffset : checksumOffset+4])
+	if actual := PageChecksum(data); actual != expected {
+		return &ErrPageCorrupt{PageID: id, Expected: expected, Actual: actual}
+	}
+	return nil
 }
 
 // Page stand for a page 4096 byte = 4 KB
@@ -62,40 +102,64 @@ func NewPage(pageType PageType) *Page {
 // Serilaize change Page to []byte to write in disk
 func (p *Page) Serialize() []byte {
 	buf := make([]byte, PageSize)
+	p.SerializeTo(buf)
+	return buf
+}
 
+// SerializeTo writes the page into buf, which must be PageSize bytes
+// Lets a page be written straight into a buffer pool frame
+func (p *Page) SerializeTo(buf []byte) {
 	// Serialize header
 	binary.LittleEndian.PutUint16(buf[0:2], uint16(p.Header.PageType))
 	binary.LittleEndian.PutUint16(buf[2:4], p.Header.NumKeys)
 	binary.LittleEndian.PutUint32(buf[4:8], p.Header.NextPage)
 	binary.LittleEndian.PutUint32(buf[8:12], p.Header.Parent)
-	// bytes 12-16: reserved (padding)
+	binary.LittleEndian.PutUint64(buf[16:24], p.Header.PageLSN)
 
 	// Copy data
 	copy(buf[PageHeaderSize:], p.Data)
 
-	return buf
+	// bytes 12-16: checksum over everything else
+	SetPageChecksum(buf)
+	p.Header.Checksum = binary.LittleEndian.Uint32(buf[checksumOffset : checksumOffset+4])
 }
 
 // Deserialize change from []byte in disk to Page
 func DeserializePage(data []byte) (*Page, error) {
-	if len(data) != PageSize {
-		return nil, fmt.Errorf("invalid page size: %d, expected %d", len(data), PageSize)
+	page, err := ViewPage(data)
+	if err != nil {
+		return nil, err
 	}
+	return page.Clone(), nil
+}
 
-	page := &Page{
-		Data: make([]byte, PageSize-PageHeaderSize),
+// ViewPage decodes the header of a serialized page and leaves Data aliasing
+// data, nothing is copied. Changes through the page only reach data's data
+// area, Serialize writes the header.
+func ViewPage(data []byte) (*Page, error) {
+	if len(data) != PageSize {
+		return nil, fmt.Errorf("invalid page size: %d, expected %d", len(data), PageSize)
 	}
 
-	// Deserialize header
-	page.Header.PageType = PageType(binary.LittleEndian.Uint16(data[0:2]))
-	page.Header.NumKeys = binary.LittleEndian.Uint16(data[2:4])
-	page.Header.NextPage = binary.LittleEndian.Uint32(data[4:8])
-	page.Header.Parent = binary.LittleEndian.Uint32(data[8:12])
-
-	// Copy data
-	copy(page.Data, data[PageHeaderSize:])
+	return &Page{
+		Header: PageHeader{
+			PageType: PageType(binary.LittleEndian.Uint16(data[0:2])),
+			NumKeys:  binary.LittleEndian.Uint16(data[2:4]),
+			NextPage: binary.LittleEndian.Uint32(data[4:8]),
+			Parent:   binary.LittleEndian.Uint32(data[8:12]),
+			Checksum: binary.LittleEndian.Uint32(data[checksumOffset : checksumOffset+4]),
+			PageLSN:  binary.LittleEndian.Uint64(data[16:24]),
+		},
+		Data: data[PageHeaderSize:],
+	}, nil
+}
 
-	return page, nil
+// Clone returns a copy of the page with its own data area
+func (p *Page) Clone() *Page {
+	return &Page{
+		Header: p.Header,
+		Data:   append(make([]byte, 0, len(p.Data)), p.Data...),
+	}
 }
 
 // IsFull check if page is full
@@ -122,9 +186,10 @@ func (p *Page) IsFree() bool {
 
 // String return string representation of page
 func (p *Page) String() string {
-	return fmt.Sprintf("Page{Type: %s, NumKeys: %d, NextPage: %d, Parent: %d}",
+	return fmt.Sprintf("Page{Type: %s, NumKeys: %d, NextPage: %d, Parent: %d, PageLSN: %d}",
 		p.Header.PageType,
 		p.Header.NumKeys,
 		p.Header.NextPage,
-		p.Header.Parent)
+		p.Header.Parent,
+		p.Header.PageLSN)
 }
@@ -7,11 +7,29 @@ import (
 
 // Record stand for a KV record
 // Format: [KeySize: 4 bytes][Key: variable][ValueSize: 4 bytes][Value: variable]
+// [CreatedBy: 8 bytes][DeletedBy: 8 bytes]
+//
+// A value spilled into overflow pages has the top bit of ValueSize set, Value
+// is its inline prefix and is followed by [TotalSize: 4 bytes][Overflow: 8 bytes].
+//
+// A record is one version of a key. Transaction IDs are commit LSNs, so a
+// version is visible to a snapshot taken at LSN s when CreatedBy <= s and it
+// wasn't deleted by then (DeletedBy == 0 or DeletedBy > s).
 type Record struct {
-	Key   []byte
-	Value []byte
+	Key       []byte
+	Value     []byte
+	CreatedBy uint64 // Transaction that wrote this version
+	DeletedBy uint64 // Transaction that replaced or deleted it, 0 while live
+	Overflow  uint64 // First overflow page holding the value past Value, 0 if inline
+	ValueSize uint32 // Length of the whole value when it overflows
 }
 
+const (
+	versionSize      = 16      // Size of the CreatedBy and DeletedBy fields
+	overflowRefSize  = 12      // Size of the TotalSize and Overflow fields
+	overflowSizeFlag = 1 << 31 // Set in ValueSize when the value overflows
+)
+
 func NewRecord(key, value []byte) *Record {
 	return &Record{
 		Key:   key,
@@ -19,18 +37,36 @@ func NewRecord(key, value []byte) *Record {
 	}
 }
 
+// NewRecordFromInts creates a record with a uint32 key, encoded by Uint32Key
 func NewRecordFromInts(key uint32, value string) *Record {
-	keyBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(keyBytes, key)
 	return &Record{
-		Key:   keyBytes,
+		Key:   Uint32Key(key),
 		Value: []byte(value),
 	}
 }
 
 func (r *Record) Size() int {
-	// 4 bytes keySize + key + 4 bytes valueSize + value
-	return 4 + len(r.Key) + 4 + len(r.Value)
+	// 4 bytes keySize + key + 4 bytes valueSize + value + version stamps
+	size := 4 + len(r.Key) + 4 + len(r.Value) + versionSize
+	if r.Overflow != 0 {
+		size += overflowRefSize
+	}
+	return size
+}
+
+// IsOverflow reports whether part of the value is stored in overflow pages
+func (r *Record) IsOverflow() bool {
+	return r.Overflow != 0
+}
+
+// IsLive reports whether this is the current version of its key
+func (r *Record) IsLive() bool {
+	return r.DeletedBy == 0
+}
+
+// VisibleAt reports whether a snapshot taken at the given LSN sees this version
+func (r *Record) VisibleAt(lsn uint64) bool {
+	return r.CreatedBy <= lsn && (r.DeletedBy == 0 || r.DeletedBy > lsn)
 }
 
 func (r *Record) Serialize() []byte {
@@ -48,11 +84,27 @@ func (r *Record) Serialize() []byte {
 	offset += len(r.Key)
 
 	// Write value size
-	binary.LittleEndian.PutUint32(buf[offset:offset+4], uint32(len(r.Value)))
+	valueSize := uint32(len(r.Value))
+	if r.Overflow != 0 {
+		valueSize |= overflowSizeFlag
+	}
+	binary.LittleEndian.PutUint32(buf[offset:offset+4], valueSize)
 	offset += 4
 
 	// Write value
 	copy(buf[offset:offset+len(r.Value)], r.Value)
+	offset += len(r.Value)
+
+	// Write overflow reference
+	if r.Overflow != 0 {
+		binary.LittleEndian.PutUint32(buf[offset:offset+4], r.ValueSize)
+		binary.LittleEndian.PutUint64(buf[offset+4:offset+12], r.Overflow)
+		offset += overflowRefSize
+	}
+
+	// Write version stamps
+	binary.LittleEndian.PutUint64(buf[offset:offset+8], r.CreatedBy)
+	binary.LittleEndian.PutUint64(buf[offset+8:offset+16], r.DeletedBy)
 
 	return buf
 }
@@ -83,6 +135,8 @@ func DeserializeRecord(data []byte) (*Record, int, error) {
 
 	// Read value size
 	valueSize := binary.LittleEndian.Uint32(data[offset : offset+4])
+	overflows := valueSize&overflowSizeFlag != 0
+	valueSize &^= overflowSizeFlag
 	offset += 4
 
 	if offset+int(valueSize) > len(data) {
@@ -94,19 +148,46 @@ func DeserializeRecord(data []byte) (*Record, int, error) {
 	copy(value, data[offset:offset+int(valueSize)])
 	offset += int(valueSize)
 
+	// Read overflow reference
+	var totalSize uint32
+	var overflow uint64
+	if overflows {
+		if offset+overflowRefSize > len(data) {
+			return nil, 0, fmt.Errorf("insufficient data for overflow reference")
+		}
+		totalSize = binary.LittleEndian.Uint32(data[offset : offset+4])
+		overflow = binary.LittleEndian.Uint64(data[offset+4 : offset+12])
+		offset += overflowRefSize
+	}
+
+	if offset+versionSize > len(data) {
+		return nil, 0, fmt.Errorf("insufficient data for version stamps")
+	}
+
+	// Read version stamps
+	createdBy := binary.LittleEndian.Uint64(data[offset : offset+8])
+	deletedBy := binary.LittleEndian.Uint64(data[offset+8 : offset+16])
+	offset += versionSize
+
 	return &Record{
-		Key:   key,
-		Value: value,
+		Key:       key,
+		Value:     value,
+		CreatedBy: createdBy,
+		DeletedBy: deletedBy,
+		Overflow:  overflow,
+		ValueSize: totalSize,
 	}, offset, nil
 }
 
+// GetKeyAsUint32 decodes a key written by NewRecordFromInts
 func (r *Record) GetKeyAsUint32() (uint32, error) {
 	if len(r.Key) != 4 {
 		return 0, fmt.Errorf("key is not 4 bytes")
 	}
-	return binary.LittleEndian.Uint32(r.Key), nil
+	return binary.BigEndian.Uint32(r.Key), nil
 }
 
+// GetValueAsString returns the value, only its inline prefix if it overflows
 func (r *Record) GetValueAsString() string {
 	return string(r.Value)
 }
@@ -1,12 +1,15 @@
 package storage
 
 import (
+	"os"
 	"testing"
 )
 
 func TestRecordSerialization(t *testing.T) {
 	// Test record encoding/decoding
 	record := NewRecordFromInts(42, "Hello World")
+	record.CreatedBy = 7
+	record.DeletedBy = 9
 
 	serialized := record.Serialize()
 
@@ -27,6 +30,10 @@ func TestRecordSerialization(t *testing.T) {
 	if deserialized.GetValueAsString() != "Hello World" {
 		t.Errorf("Value = %s, expected 'Hello World'", deserialized.GetValueAsString())
 	}
+
+	if deserialized.CreatedBy != 7 || deserialized.DeletedBy != 9 {
+		t.Errorf("Versions = (%d, %d), expected (7, 9)", deserialized.CreatedBy, deserialized.DeletedBy)
+	}
 }
 
 func TestRecordList(t *testing.T) {
@@ -96,13 +103,110 @@ func TestFreeList(t *testing.T) {
 	}
 
 	// Test serialization
-	page := fl.SerializeToPage()
-	deserializedFL, err := DeserializeFreeList(page)
+	page := fl.SerializeTrunk(0)
+	pageIDs, err := deserializeTrunk(page)
 	if err != nil {
 		t.Fatalf("Failed to deserialize free list: %v", err)
 	}
 
-	if deserializedFL.Size() != 1 {
-		t.Errorf("Deserialized size = %d, expected 1", deserializedFL.Size())
+	if len(pageIDs) != 1 || pageIDs[0] != 10 {
+		t.Errorf("Deserialized page IDs = %v, expected [10]", pageIDs)
+	}
+
+	// A full page 0 moves its IDs into the next page pushed
+	fl = NewFreeList()
+	for i := 0; i <= MaxFreePageIDs(); i++ {
+		fl.Push(uint64(i + 2))
+	}
+	if fl.NumTrunks() != 2 || fl.TrunkPageID(1) != uint64(MaxFreePageIDs()+2) || fl.Size() != MaxFreePageIDs()+1 {
+		t.Errorf("NumTrunks = %d, Size = %d after filling page 0", fl.NumTrunks(), fl.Size())
+	}
+
+	// Draining page 0 pulls the trunk's IDs back and hands out the trunk page
+	pageID, ok = fl.Pop()
+	if !ok || pageID != uint64(MaxFreePageIDs()+2) || fl.NumTrunks() != 1 {
+		t.Errorf("Pop = %d with %d trunks, expected the trunk page", pageID, fl.NumTrunks())
+	}
+	pageID, _ = fl.Pop()
+	if pageID != uint64(MaxFreePageIDs()+1) {
+		t.Errorf("Pop = %d, expected %d", pageID, MaxFreePageIDs()+1)
+	}
+}
+
+func TestFilePagerFreeListChain(t *testing.T) {
+	dbFile := "test_free_list_chain.db"
+	defer os.Remove(dbFile)
+
+	pager, err := NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+
+	// Free enough pages to need several trunk pages
+	numFree := 3*MaxFreePageIDs() + 10
+	pageIDs := writeTestPages(t, pager, numFree)
+	for _, pageID := range pageIDs {
+		if err := pager.FreePage(pageID); err != nil {
+			t.Fatalf("FreePage(%d) failed: %v", pageID, err)
+		}
+	}
+
+	// Freed pages wait out a Flush before they can be reused
+	pageID, err := pager.AllocatePage()
+	if err != nil || pageID != uint64(numFree)+2 {
+		t.Errorf("AllocatePage = %d, %v, expected the file to grow", pageID, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := pager.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	if pager.FreeListSize() != numFree || pager.freeList.NumTrunks() != 4 {
+		t.Errorf("FreeListSize = %d over %d trunks, expected %d over 4",
+			pager.FreeListSize(), pager.freeList.NumTrunks(), numFree)
+	}
+	numPages := pager.NumPages()
+	pager.Close()
+
+	// The chain survives a reopen
+	pager, err = NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen pager: %v", err)
+	}
+	defer pager.Close()
+
+	if pager.FreeListSize() != numFree || pager.freeList.NumTrunks() != 4 {
+		t.Errorf("Reopened FreeListSize = %d over %d trunks, expected %d over 4",
+			pager.FreeListSize(), pager.freeList.NumTrunks(), numFree)
+	}
+
+	// Allocations reuse every freed page before the file grows
+	reused := make(map[uint64]bool)
+	for i := 0; i < numFree; i++ {
+		pageID, err := pager.AllocatePage()
+		if err != nil {
+			t.Fatalf("AllocatePage failed: %v", err)
+		}
+		if pageID < 2 || pageID >= numPages || reused[pageID] {
+			t.Fatalf("AllocatePage = %d, expected an unused freed page", pageID)
+		}
+		reused[pageID] = true
+
+		data, err := pager.ReadPage(pageID)
+		if err != nil {
+			t.Fatalf("Failed to read reused page %d: %v", pageID, err)
+		}
+		if data[PageHeaderSize] != 0 {
+			t.Errorf("Reused page %d still holds old data", pageID)
+		}
+	}
+	if pager.FreeListSize() != 0 || pager.freeList.NumTrunks() != 1 || pager.NumPages() != numPages {
+		t.Errorf("FreeListSize = %d over %d trunks with %d pages, expected an empty list and %d pages",
+			pager.FreeListSize(), pager.freeList.NumTrunks(), pager.NumPages(), numPages)
+	}
+
+	pageID, err = pager.AllocatePage()
+	if err != nil || pageID != numPages {
+		t.Errorf("AllocatePage = %d, %v, expected the file to grow to page %d", pageID, err, numPages)
 	}
 }
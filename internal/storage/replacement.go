@@ -0,0 +1,445 @@
+package storage
+
+import (
+	"cmp"
+	"container/list"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// ReplacementPolicy decides which cached page the buffer pool evicts
+// The pool calls it under its own lock, so policies needn't be thread-safe.
+type ReplacementPolicy interface {
+	// Name returns the policy name shown in stats
+	Name() string
+	// Insert records a page just loaded into the pool
+	Insert(pageID uint64)
+	// InsertCold records a page loaded ahead of use, placing it where pages
+	// are evicted first. Its first Access counts as the load.
+	InsertCold(pageID uint64)
+	// Access records a hit on a cached page
+	Access(pageID uint64)
+	// Victim picks the page to evict, never one for which pinned returns true
+	// Returns false if every page is pinned
+	Victim(pinned func(pageID uint64) bool) (uint64, bool)
+	// Remove forgets a page that left the pool
+	Remove(pageID uint64)
+	// Coldest returns up to n pages, those to be evicted soonest first,
+	// without changing any state. The background writer cleans these.
+	Coldest(n int) []uint64
+}
+
+// victimFrom returns the first unpinned page walking a list from the back
+func victimFrom(l *list.List, pinned func(uint64) bool) (uint64, bool) {
+	for e := l.Back(); e != nil; e = e.Prev() {
+		if pageID := e.Value.(uint64); !pinned(pageID) {
+			return pageID, true
+		}
+	}
+	return 0, false
+}
+
+// coldestFrom appends up to n pages walking a list from the back
+func coldestFrom(pages []uint64, l *list.List, n int) []uint64 {
+	for e := l.Back(); e != nil && len(pages) < n; e = e.Prev() {
+		pages = append(pages, e.Value.(uint64))
+	}
+	return pages
+}
+
+// LRUPolicy evicts the least recently used page
+// Cheap and good for recency, but one large scan flushes the whole hot set.
+type LRUPolicy struct {
+	order *list.List // Most recently used at the front
+	pages map[uint64]*list.Element
+}
+
+// NewLRUPolicy creates an LRU policy
+func NewLRUPolicy() *LRUPolicy {
+	return &LRUPolicy{
+		order: list.New(),
+		pages: make(map[uint64]*list.Element),
+	}
+}
+
+// Name returns "LRU"
+func (p *LRUPolicy) Name() string {
+	return "LRU"
+}
+
+// Insert puts a page at the most recently used end
+func (p *LRUPolicy) Insert(pageID uint64) {
+	p.pages[pageID] = p.order.PushFront(pageID)
+}
+
+// InsertCold puts a page at the least recently used end
+func (p *LRUPolicy) InsertCold(pageID uint64) {
+	p.pages[pageID] = p.order.PushBack(pageID)
+}
+
+// Access moves a page to the most recently used end
+func (p *LRUPolicy) Access(pageID uint64) {
+	if e, ok := p.pages[pageID]; ok {
+		p.order.MoveToFront(e)
+	}
+}
+
+// Victim returns the least recently used unpinned page
+func (p *LRUPolicy) Victim(pinned func(uint64) bool) (uint64, bool) {
+	return victimFrom(p.order, pinned)
+}
+
+// Remove forgets a page
+func (p *LRUPolicy) Remove(pageID uint64) {
+	if e, ok := p.pages[pageID]; ok {
+		p.order.Remove(e)
+		delete(p.pages, pageID)
+	}
+}
+
+// Coldest returns the least recently used pages
+func (p *LRUPolicy) Coldest(n int) []uint64 {
+	return coldestFrom(nil, p.order, n)
+}
+
+// ClockPolicy approximates LRU with a reference bit per page
+// The hand sweeps the pages in load order, clearing set bits and evicting
+// the first page whose bit is already clear. Hits only set a bit, so they
+// cost no list manipulation.
+type ClockPolicy struct {
+	ring  []clockSlot
+	pages map[uint64]int // Page -> slot in ring
+	free  []int          // Slots of removed pages, reused by Insert
+	hand  int
+}
+
+// clockSlot is one position on the clock
+type clockSlot struct {
+	pageID     uint64
+	used       bool
+	referenced bool
+}
+
+// NewClockPolicy creates a Clock policy
+func NewClockPolicy() *ClockPolicy {
+	return &ClockPolicy{pages: make(map[uint64]int)}
+}
+
+// Name returns "Clock"
+func (p *ClockPolicy) Name() string {
+	return "Clock"
+}
+
+// Insert places a page on the clock with its reference bit clear
+func (p *ClockPolicy) Insert(pageID uint64) {
+	slot := clockSlot{pageID: pageID, used: true}
+	if n := len(p.free); n > 0 {
+		index := p.free[n-1]
+		p.free = p.free[:n-1]
+		p.ring[index] = slot
+		p.pages[pageID] = index
+		return
+	}
+	p.ring = append(p.ring, slot)
+	p.pages[pageID] = len(p.ring) - 1
+}
+
+// InsertCold is Insert, a page with a clear bit already goes on the next sweep
+func (p *ClockPolicy) InsertCold(pageID uint64) {
+	p.Insert(pageID)
+}
+
+// Access sets a page's reference bit
+func (p *ClockPolicy) Access(pageID uint64) {
+	if index, ok := p.pages[pageID]; ok {
+		p.ring[index].referenced = true
+	}
+}
+
+// Victim sweeps the hand until it finds an unpinned page with a clear bit
+// Two full turns clear every bit, so a third finding nothing means all are pinned
+func (p *ClockPolicy) Victim(pinned func(uint64) bool) (uint64, bool) {
+	for step := 0; step < 2*len(p.ring)+1; step++ {
+		if p.hand >= len(p.ring) {
+			p.hand = 0
+		}
+		slot := &p.ring[p.hand]
+		p.hand++
+
+		if !slot.used || pinned(slot.pageID) {
+			continue
+		}
+		if slot.referenced {
+			slot.referenced = false
+			continue
+		}
+		return slot.pageID, true
+	}
+	return 0, false
+}
+
+// Remove frees a page's slot
+func (p *ClockPolicy) Remove(pageID uint64) {
+	if index, ok := p.pages[pageID]; ok {
+		p.ring[index] = clockSlot{}
+		p.free = append(p.free, index)
+		delete(p.pages, pageID)
+	}
+}
+
+// Coldest returns the pages ahead of the hand, those with a clear bit first
+func (p *ClockPolicy) Coldest(n int) []uint64 {
+	var pages []uint64
+	for _, referenced := range []bool{false, true} {
+		for step := 0; step < len(p.ring) && len(pages) < n; step++ {
+			slot := p.ring[(p.hand+step)%len(p.ring)]
+			if slot.used && slot.referenced == referenced {
+				pages = append(pages, slot.pageID)
+			}
+		}
+	}
+	return pages
+}
+
+// LRUKPolicy evicts the page whose K-th most recent access is oldest
+// Pages seen fewer than K times go first, oldest last access first, so a
+// scan touching pages once can't push out pages that are used repeatedly.
+// The history of evicted pages is kept for a while, otherwise two pages
+// loaded alternately would keep evicting each other before either is seen twice.
+type LRUKPolicy struct {
+	k       int
+	clock   uint64              // Logical time, advanced on every access
+	history map[uint64][]uint64 // Page -> last K access times, newest last
+
+	retained      map[uint64][]uint64 // History of evicted pages
+	retainedOrder *list.List          // Evicted pages, newest at the front
+	retainedPage  map[uint64]*list.Element
+}
+
+// NewLRUKPolicy creates an LRU-K policy, k is usually 2
+func NewLRUKPolicy(k int) *LRUKPolicy {
+	if k < 1 {
+		k = 2
+	}
+	return &LRUKPolicy{
+		k:             k,
+		history:       make(map[uint64][]uint64),
+		retained:      make(map[uint64][]uint64),
+		retainedOrder: list.New(),
+		retainedPage:  make(map[uint64]*list.Element),
+	}
+}
+
+// Name returns "LRU-K" with K filled in
+func (p *LRUKPolicy) Name() string {
+	return fmt.Sprintf("LRU-%d", p.k)
+}
+
+// Insert records the access that loaded a page, on top of any retained history
+func (p *LRUKPolicy) Insert(pageID uint64) {
+	p.InsertCold(pageID)
+	p.Access(pageID)
+}
+
+// InsertCold tracks a page with only its retained history, no history at all
+// makes it the first to go
+func (p *LRUKPolicy) InsertCold(pageID uint64) {
+	p.history[pageID] = p.retained[pageID]
+	if e, ok := p.retainedPage[pageID]; ok {
+		p.retainedOrder.Remove(e)
+		delete(p.retainedPage, pageID)
+		delete(p.retained, pageID)
+	}
+}
+
+// Access appends to a page's history, keeping the last K times
+func (p *LRUKPolicy) Access(pageID uint64) {
+	times, ok := p.history[pageID]
+	if !ok {
+		return
+	}
+	p.clock++
+	if len(times) == p.k {
+		times = append(times[:0], times[1:]...)
+	}
+	p.history[pageID] = append(times, p.clock)
+}
+
+// Victim returns the unpinned page with the largest backward K-distance
+// The scan is linear in the pool size, which is fine at buffer pool sizes
+func (p *LRUKPolicy) Victim(pinned func(uint64) bool) (uint64, bool) {
+	var victim uint64
+	found := false
+	bestKth, bestLast := uint64(math.MaxUint64), uint64(math.MaxUint64)
+
+	for pageID, times := range p.history {
+		if pinned(pageID) {
+			continue
+		}
+
+		kth, last := p.distance(times)
+		if kth < bestKth || (kth == bestKth && last < bestLast) {
+			victim, bestKth, bestLast, found = pageID, kth, last, true
+		}
+	}
+	return victim, found
+}
+
+// distance returns the K-th most recent and the last access time of a history
+// Fewer than K accesses counts as an infinitely old K-th access.
+func (p *LRUKPolicy) distance(times []uint64) (kth, last uint64) {
+	if len(times) == p.k {
+		kth = times[0]
+	}
+	if len(times) > 0 {
+		last = times[len(times)-1]
+	}
+	return kth, last
+}
+
+// Coldest returns the pages with the largest backward K-distance
+func (p *LRUKPolicy) Coldest(n int) []uint64 {
+	pages := make([]uint64, 0, len(p.history))
+	for pageID := range p.history {
+		pages = append(pages, pageID)
+	}
+	slices.SortFunc(pages, func(a, b uint64) int {
+		aKth, aLast := p.distance(p.history[a])
+		bKth, bLast := p.distance(p.history[b])
+		if c := cmp.Compare(aKth, bKth); c != 0 {
+			return c
+		}
+		return cmp.Compare(aLast, bLast)
+	})
+	return pages[:min(n, len(pages))]
+}
+
+// Remove retains a page's history, keeping as many as there are pages cached
+func (p *LRUKPolicy) Remove(pageID uint64) {
+	times, ok := p.history[pageID]
+	if !ok {
+		return
+	}
+	delete(p.history, pageID)
+
+	p.retained[pageID] = times
+	p.retainedPage[pageID] = p.retainedOrder.PushFront(pageID)
+	for p.retainedOrder.Len() > max(len(p.history), 1) {
+		oldest := p.retainedOrder.Back()
+		p.retainedOrder.Remove(oldest)
+		delete(p.retainedPage, oldest.Value.(uint64))
+		delete(p.retained, oldest.Value.(uint64))
+	}
+}
+
+// TwoQPolicy keeps pages seen once in a FIFO and promotes pages seen again
+// to an LRU (the full 2Q algorithm). Pages evicted from the FIFO are
+// remembered by ID only, a page that comes back soon after goes straight
+// to the LRU. A scan only churns the FIFO.
+type TwoQPolicy struct {
+	maxIn    int // Size the FIFO is kept to when there is a choice
+	maxGhost int // Evicted FIFO pages remembered
+
+	in     *list.List // A1in: pages seen once, newest at the front
+	hot    *list.List // Am: pages seen again, most recently used at the front
+	ghosts *list.List // A1out: IDs recently evicted from in, newest at the front
+
+	pages     map[uint64]*list.Element
+	inFIFO    map[uint64]bool
+	ghostPage map[uint64]*list.Element
+}
+
+// NewTwoQPolicy creates a 2Q policy for a pool of capacity pages
+// The FIFO gets a quarter of the pool and half a pool of evicted IDs is
+// remembered, the values suggested by the 2Q paper.
+func NewTwoQPolicy(capacity int) *TwoQPolicy {
+	return &TwoQPolicy{
+		maxIn:     max(capacity/4, 1),
+		maxGhost:  max(capacity/2, 1),
+		in:        list.New(),
+		hot:       list.New(),
+		ghosts:    list.New(),
+		pages:     make(map[uint64]*list.Element),
+		inFIFO:    make(map[uint64]bool),
+		ghostPage: make(map[uint64]*list.Element),
+	}
+}
+
+// Name returns "2Q"
+func (p *TwoQPolicy) Name() string {
+	return "2Q"
+}
+
+// Insert puts a page in the FIFO, or in the LRU if it was evicted recently
+func (p *TwoQPolicy) Insert(pageID uint64) {
+	if e, ok := p.ghostPage[pageID]; ok {
+		p.ghosts.Remove(e)
+		delete(p.ghostPage, pageID)
+		p.pages[pageID] = p.hot.PushFront(pageID)
+		return
+	}
+
+	p.pages[pageID] = p.in.PushFront(pageID)
+	p.inFIFO[pageID] = true
+}
+
+// InsertCold puts a page at the old end of the FIFO
+func (p *TwoQPolicy) InsertCold(pageID uint64) {
+	p.pages[pageID] = p.in.PushBack(pageID)
+	p.inFIFO[pageID] = true
+}
+
+// Access refreshes a page in the LRU, pages in the FIFO stay put
+// Hits while in the FIFO are usually the same burst of use that loaded the page
+func (p *TwoQPolicy) Access(pageID uint64) {
+	if e, ok := p.pages[pageID]; ok && !p.inFIFO[pageID] {
+		p.hot.MoveToFront(e)
+	}
+}
+
+// Victim takes the oldest FIFO page while the FIFO is over its share,
+// the least recently used page otherwise
+func (p *TwoQPolicy) Victim(pinned func(uint64) bool) (uint64, bool) {
+	first, second := p.hot, p.in
+	if p.in.Len() > p.maxIn {
+		first, second = p.in, p.hot
+	}
+
+	if pageID, ok := victimFrom(first, pinned); ok {
+		return pageID, true
+	}
+	return victimFrom(second, pinned)
+}
+
+// Coldest returns pages from the list Victim takes from first, then the other
+func (p *TwoQPolicy) Coldest(n int) []uint64 {
+	first, second := p.hot, p.in
+	if p.in.Len() > p.maxIn {
+		first, second = p.in, p.hot
+	}
+	return coldestFrom(coldestFrom(nil, first, n), second, n)
+}
+
+// Remove forgets a page, remembering its ID if it leaves from the FIFO
+func (p *TwoQPolicy) Remove(pageID uint64) {
+	e, ok := p.pages[pageID]
+	if !ok {
+		return
+	}
+	delete(p.pages, pageID)
+
+	if !p.inFIFO[pageID] {
+		p.hot.Remove(e)
+		return
+	}
+	p.in.Remove(e)
+	delete(p.inFIFO, pageID)
+
+	p.ghostPage[pageID] = p.ghosts.PushFront(pageID)
+	if p.ghosts.Len() > p.maxGhost {
+		oldest := p.ghosts.Back()
+		p.ghosts.Remove(oldest)
+		delete(p.ghostPage, oldest.Value.(uint64))
+	}
+}
@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"os"
+	"testing"
+)
+
+// policyCache replays accesses against a policy the way the buffer pool does,
+// with a fixed number of slots and optional pinned pages
+type policyCache struct {
+	policy   ReplacementPolicy
+	capacity int
+	resident map[uint64]bool
+	pinned   map[uint64]bool
+}
+
+// newPolicyCache creates an empty cache evicting by policy
+func newPolicyCache(policy ReplacementPolicy, capacity int) *policyCache {
+	return &policyCache{
+		policy:   policy,
+		capacity: capacity,
+		resident: make(map[uint64]bool),
+		pinned:   make(map[uint64]bool),
+	}
+}
+
+// access touches a page, returning false if every page is pinned
+func (c *policyCache) access(pageID uint64) bool {
+	if c.resident[pageID] {
+		c.policy.Access(pageID)
+		return true
+	}
+
+	if len(c.resident) >= c.capacity {
+		victim, ok := c.policy.Victim(func(id uint64) bool { return c.pinned[id] })
+		if !ok {
+			return false
+		}
+		if !c.resident[victim] || c.pinned[victim] {
+			panic("policy picked a page that isn't evictable")
+		}
+		c.policy.Remove(victim)
+		delete(c.resident, victim)
+	}
+	c.resident[pageID] = true
+	c.policy.Insert(pageID)
+	return true
+}
+
+// testPolicies returns a fresh instance of every policy for a pool of capacity pages
+func testPolicies(capacity int) []ReplacementPolicy {
+	return []ReplacementPolicy{
+		NewLRUPolicy(),
+		NewClockPolicy(),
+		NewLRUKPolicy(2),
+		NewTwoQPolicy(capacity),
+	}
+}
+
+func TestReplacementPolicyPinning(t *testing.T) {
+	for _, policy := range testPolicies(4) {
+		t.Run(policy.Name(), func(t *testing.T) {
+			c := newPolicyCache(policy, 4)
+			for id := uint64(1); id <= 4; id++ {
+				c.access(id)
+				c.pinned[id] = true
+			}
+
+			if c.access(5) {
+				t.Fatal("Loaded a page with every page pinned")
+			}
+
+			// The only unpinned page must be the victim
+			delete(c.pinned, 3)
+			if !c.access(5) {
+				t.Fatal("Failed to load a page with one page unpinned")
+			}
+			if c.resident[3] {
+				t.Error("Page 3 still cached, expected it evicted")
+			}
+			for _, id := range []uint64{1, 2, 4, 5} {
+				if !c.resident[id] {
+					t.Errorf("Page %d evicted", id)
+				}
+			}
+
+			// Removed pages are never picked again
+			policy.Remove(5)
+			delete(c.resident, 5)
+			if _, ok := policy.Victim(func(id uint64) bool { return c.pinned[id] }); ok {
+				t.Error("Victim returned a page after every remaining page was pinned")
+			}
+		})
+	}
+}
+
+func TestReplacementPolicyColdest(t *testing.T) {
+	for _, policy := range testPolicies(8) {
+		t.Run(policy.Name(), func(t *testing.T) {
+			c := newPolicyCache(policy, 8)
+			for _, id := range []uint64{1, 2, 3, 4, 5, 6, 1, 3, 7, 8, 9, 1} {
+				c.access(id)
+			}
+
+			coldest := policy.Coldest(100)
+			if len(coldest) != len(c.resident) {
+				t.Fatalf("Coldest returned %v, expected all %d cached pages", coldest, len(c.resident))
+			}
+			seen := make(map[uint64]bool)
+			for _, id := range coldest {
+				if !c.resident[id] || seen[id] {
+					t.Fatalf("Coldest returned %v, expected each cached page once", coldest)
+				}
+				seen[id] = true
+			}
+
+			// Coldest doesn't change state and agrees with the next victim
+			if again := policy.Coldest(3); len(again) != 3 || again[0] != coldest[0] {
+				t.Errorf("Coldest(3) = %v after Coldest(100) = %v", again, coldest)
+			}
+			if victim, _ := policy.Victim(func(uint64) bool { return false }); victim != coldest[0] {
+				t.Errorf("Victim = %d, Coldest starts with %d", victim, coldest[0])
+			}
+		})
+	}
+}
+
+func TestReplacementPolicyScanResistance(t *testing.T) {
+	const capacity = 16
+
+	// Hot pages 1-8 are used over and over alongside a trickle of pages used
+	// once, then a scan reads 100 pages once each while the hot pages stay
+	// in use at a lower rate
+	run := func(policy ReplacementPolicy) int {
+		c := newPolicyCache(policy, capacity)
+		for round := uint64(0); round < 10; round++ {
+			for id := uint64(1); id <= 8; id++ {
+				c.access(id)
+			}
+			for id := uint64(0); id < 4; id++ {
+				c.access(100 + round*4 + id)
+			}
+		}
+
+		for i := uint64(0); i < 100; i++ {
+			c.access(1000 + i)
+			if i%10 == 0 {
+				c.access(1 + i/10%8)
+			}
+		}
+
+		hot := 0
+		for id := uint64(1); id <= 8; id++ {
+			if c.resident[id] {
+				hot++
+			}
+		}
+		return hot
+	}
+
+	if hot := run(NewLRUPolicy()); hot > 2 {
+		t.Errorf("LRU kept %d hot pages through the scan, expected the scan to flush them", hot)
+	}
+	for _, policy := range []ReplacementPolicy{NewLRUKPolicy(2), NewTwoQPolicy(capacity)} {
+		if hot := run(policy); hot != 8 {
+			t.Errorf("%s kept %d of 8 hot pages through the scan", policy.Name(), hot)
+		}
+	}
+}
+
+func TestClockPolicySecondChance(t *testing.T) {
+	c := newPolicyCache(NewClockPolicy(), 3)
+	c.access(1)
+	c.access(2)
+	c.access(3)
+
+	// Page 1 is referenced, so the hand passes it and evicts page 2
+	c.access(1)
+	c.access(4)
+	if !c.resident[1] || c.resident[2] {
+		t.Errorf("Resident pages %v, expected page 2 evicted", c.resident)
+	}
+
+	// The sweep cleared page 1's bit, so it goes once the hand comes back
+	c.access(5)
+	c.access(6)
+	if c.resident[1] {
+		t.Errorf("Resident pages %v, expected page 1 evicted", c.resident)
+	}
+}
+
+func TestBufferPoolWithPolicy(t *testing.T) {
+	dbFile := "test_buffer_pool_policy.db"
+	defer os.Remove(dbFile)
+
+	pager, err := NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	bp := NewBufferPoolWithPolicy(pager, 4, NewTwoQPolicy(4))
+	defer bp.Close()
+
+	pageIDs := make([]uint64, 10)
+	for i := range pageIDs {
+		pageID, err := bp.AllocatePage()
+		if err != nil {
+			t.Fatalf("Failed to allocate page: %v", err)
+		}
+		pageIDs[i] = pageID
+
+		data := make([]byte, PageSize)
+		data[PageHeaderSize] = byte(i)
+		if err := bp.WritePage(pageID, data); err != nil {
+			t.Fatalf("Failed to write page %d: %v", pageID, err)
+		}
+	}
+
+	// Evicted pages were written back and read again intact
+	for i, pageID := range pageIDs {
+		data, err := bp.ReadPage(pageID)
+		if err != nil {
+			t.Fatalf("Failed to read page %d: %v", pageID, err)
+		}
+		if data[PageHeaderSize] != byte(i) {
+			t.Errorf("Page %d holds %d, expected %d", pageID, data[PageHeaderSize], i)
+		}
+	}
+
+	stats := bp.GetStats()
+	if stats.Policy != "2Q" {
+		t.Errorf("Policy = %q, expected 2Q", stats.Policy)
+	}
+	if stats.Size != 4 || stats.Evictions == 0 {
+		t.Errorf("Size = %d, Evictions = %d, expected a full pool that evicted", stats.Size, stats.Evictions)
+	}
+}
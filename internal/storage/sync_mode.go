@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SyncMode controls when writes are forced to disk with fsync
+type SyncMode int
+
+const (
+	// SyncFull fsyncs every WAL commit and every data page write
+	SyncFull SyncMode = iota
+	// SyncNormal fsyncs the WAL at commit and data pages only at checkpoints
+	SyncNormal
+	// SyncOff never fsyncs and leaves flushing to the OS
+	SyncOff
+)
+
+// String returns string representation of sync mode
+func (m SyncMode) String() string {
+	switch m {
+	case SyncFull:
+		return "FULL"
+	case SyncNormal:
+		return "NORMAL"
+	case SyncOff:
+		return "OFF"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ParseSyncMode parses a sync mode name (case insensitive)
+func ParseSyncMode(s string) (SyncMode, error) {
+	switch strings.ToUpper(s) {
+	case "FULL":
+		return SyncFull, nil
+	case "NORMAL":
+		return SyncNormal, nil
+	case "OFF":
+		return SyncOff, nil
+	default:
+		return SyncFull, fmt.Errorf("unknown sync mode: %q", s)
+	}
+}
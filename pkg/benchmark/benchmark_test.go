@@ -2,7 +2,10 @@ package benchmark
 
 import (
 	"fmt"
+	"math/rand"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -194,7 +197,21 @@ func BenchmarkMixedWorkload(b *testing.B) {
 	b.Logf("   Hit Rate: %.2f%%", stats.HitRate*100)
 }
 
-// BenchmarkInOrderTraversal measures traversal performance
+// diskPager adds a fixed latency to every page read, standing in for a disk
+// the OS page cache doesn't hide
+type diskPager struct {
+	storage.Pager
+	latency time.Duration
+}
+
+// ReadPage reads a page after waiting out the disk latency
+func (p *diskPager) ReadPage(id uint64) ([]byte, error) {
+	time.Sleep(p.latency)
+	return p.Pager.ReadPage(id)
+}
+
+// BenchmarkInOrderTraversal measures traversal performance on a cold cache,
+// with and without leaf read-ahead
 func BenchmarkInOrderTraversal(b *testing.B) {
 	dbFile := "bench_traversal.db"
 	walFile := "bench_traversal.wal"
@@ -205,16 +222,14 @@ func BenchmarkInOrderTraversal(b *testing.B) {
 	if err != nil {
 		b.Fatalf("Failed to create pager: %v", err)
 	}
-	defer pager.Close()
 
 	bufferPool := storage.NewBufferPool(pager, 128)
-	defer bufferPool.Close()
 
 	tree, err := bptree.NewBPTree(bufferPool, 100, walFile)
 	if err != nil {
 		b.Fatalf("Failed to create tree: %v", err)
 	}
-	defer tree.Close()
+	tree.SetSyncMode(storage.SyncOff)
 
 	// Insert 100k keys
 	b.Log("Preparing 100k keys...")
@@ -224,34 +239,61 @@ func BenchmarkInOrderTraversal(b *testing.B) {
 		tree.Insert(key, value)
 	}
 
-	b.ResetTimer()
-	start := time.Now()
+	// Leave everything on disk, each run starts with an empty pool
+	tree.Checkpoint()
+	tree.Close()
+	bufferPool.Close()
 
-	keys, err := tree.InOrderTraversal()
-	if err != nil {
-		b.Fatalf("Traversal failed: %v", err)
-	}
+	for _, readAhead := range []int{0, 8} {
+		b.Run(fmt.Sprintf("ReadAhead%d", readAhead), func(b *testing.B) {
+			pager, err := storage.NewFilePager(dbFile)
+			if err != nil {
+				b.Fatalf("Failed to open pager: %v", err)
+			}
+			defer pager.Close()
 
-	duration := time.Since(start)
-	b.StopTimer()
+			bufferPool := storage.NewBufferPool(&diskPager{Pager: pager, latency: 100 * time.Microsecond}, 128)
+			defer bufferPool.Close()
 
-	// Verify correctness
-	if len(keys) != 100000 {
-		b.Fatalf("Expected 100000 keys, got %d", len(keys))
-	}
+			tree, err := bptree.OpenBPTree(bufferPool, 100, walFile)
+			if err != nil {
+				b.Fatalf("Failed to open tree: %v", err)
+			}
+			defer tree.Close()
+			tree.SetReadAhead(readAhead)
 
-	// Check sorted order
-	for i := 1; i < len(keys); i++ {
-		if keys[i] <= keys[i-1] {
-			b.Fatalf("Keys not in order at index %d: %d <= %d", i, keys[i], keys[i-1])
-		}
-	}
+			b.ResetTimer()
+			start := time.Now()
 
-	b.Logf("\n📊 In-Order Traversal Benchmark:")
-	b.Logf("   Keys: 100,000")
-	b.Logf("   Duration: %v", duration)
-	b.Logf("   Throughput: %.2f keys/sec", float64(len(keys))/duration.Seconds())
-	b.Logf("   ✓ All keys in sorted order")
+			keys, err := tree.InOrderTraversal()
+			if err != nil {
+				b.Fatalf("Traversal failed: %v", err)
+			}
+
+			duration := time.Since(start)
+			b.StopTimer()
+
+			// Verify correctness
+			if len(keys) != 100000 {
+				b.Fatalf("Expected 100000 keys, got %d", len(keys))
+			}
+
+			// Check sorted order
+			for i := 1; i < len(keys); i++ {
+				if keys[i] <= keys[i-1] {
+					b.Fatalf("Keys not in order at index %d: %d <= %d", i, keys[i], keys[i-1])
+				}
+			}
+
+			stats := bufferPool.GetStats()
+			b.Logf("\n📊 In-Order Traversal Benchmark (read-ahead %d leaves):", readAhead)
+			b.Logf("   Keys: 100,000")
+			b.Logf("   Duration: %v", duration)
+			b.Logf("   Throughput: %.2f keys/sec", float64(len(keys))/duration.Seconds())
+			b.Logf("   Misses: %d, Prefetched: %d, Prefetch hits: %d", stats.Misses, stats.Prefetched, stats.PrefetchHits)
+			b.Logf("   ✓ All keys in sorted order")
+		})
+	}
 }
 
 // BenchmarkRandomInserts tests random insert performance
@@ -309,6 +351,123 @@ func BenchmarkRandomInserts(b *testing.B) {
 	b.Logf("   Buffer Pool Hit Rate: %.2f%%", stats.HitRate*100)
 }
 
+// BenchmarkConcurrentWriters measures insert throughput with group commit
+// Writers latch only the pages they change and wait for the WAL fsync after
+// applying it, so concurrent writers share fsyncs
+func BenchmarkConcurrentWriters(b *testing.B) {
+	const totalInserts = 8192
+
+	for _, writers := range []int{1, 8, 64} {
+		b.Run(fmt.Sprintf("Writers%d", writers), func(b *testing.B) {
+			dbFile := fmt.Sprintf("bench_writers_%d.db", writers)
+			walFile := fmt.Sprintf("bench_writers_%d.wal", writers)
+			defer os.Remove(dbFile)
+			defer os.Remove(walFile)
+
+			pager, err := storage.NewFilePager(dbFile)
+			if err != nil {
+				b.Fatalf("Failed to create pager: %v", err)
+			}
+			defer pager.Close()
+
+			bufferPool := storage.NewBufferPool(pager, 128)
+			defer bufferPool.Close()
+
+			tree, err := bptree.NewBPTree(bufferPool, 100, walFile)
+			if err != nil {
+				b.Fatalf("Failed to create tree: %v", err)
+			}
+			defer tree.Close()
+
+			var wg sync.WaitGroup
+			perWriter := totalInserts / writers
+
+			b.ResetTimer()
+			start := time.Now()
+
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perWriter; i++ {
+						key := uint32(w*perWriter + i)
+
+						commit, err := tree.InsertAsync(key, fmt.Sprintf("value-%d", key))
+						if err == nil {
+							err = commit.Wait()
+						}
+						if err != nil {
+							b.Errorf("Insert failed: %v", err)
+							return
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+
+			duration := time.Since(start)
+			b.StopTimer()
+
+			syncs := tree.GetWALSyncCount()
+
+			b.Logf("\n📊 %d Concurrent Writers (%d inserts):", writers, totalInserts)
+			b.Logf("   Duration: %v", duration)
+			b.Logf("   Throughput: %.2f ops/sec", float64(totalInserts)/duration.Seconds())
+			b.Logf("   WAL fsyncs: %d (%.2f inserts per fsync)", syncs, float64(totalInserts)/float64(syncs))
+		})
+	}
+}
+
+// BenchmarkBufferPoolConcurrentReads measures cached page reads from all
+// cores, with one shard against the default sharding
+func BenchmarkBufferPoolConcurrentReads(b *testing.B) {
+	const capacity = 256
+
+	for _, shards := range []int{1, storage.DefaultShards(capacity)} {
+		b.Run(fmt.Sprintf("Shards%d", shards), func(b *testing.B) {
+			dbFile := fmt.Sprintf("bench_shards_%d.db", shards)
+			defer os.Remove(dbFile)
+
+			pager, err := storage.NewFilePager(dbFile)
+			if err != nil {
+				b.Fatalf("Failed to create pager: %v", err)
+			}
+			defer pager.Close()
+
+			bufferPool := storage.NewShardedBufferPool(pager, capacity, shards, func(int) storage.ReplacementPolicy {
+				return storage.NewLRUPolicy()
+			})
+			defer bufferPool.Close()
+
+			// Every page fits, so reads only contend for the pool's locks
+			pageIDs := make([]uint64, capacity)
+			for i := range pageIDs {
+				if pageIDs[i], err = bufferPool.AllocatePage(); err != nil {
+					b.Fatalf("Failed to allocate page: %v", err)
+				}
+				if err := bufferPool.WritePage(pageIDs[i], make([]byte, storage.PageSize)); err != nil {
+					b.Fatalf("Failed to write page: %v", err)
+				}
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := bufferPool.ReadPage(pageIDs[rand.Intn(len(pageIDs))]); err != nil {
+						b.Errorf("Read failed: %v", err)
+						return
+					}
+				}
+			})
+			b.StopTimer()
+
+			stats := bufferPool.GetStats()
+			b.Logf("\n📊 Concurrent Reads (%d shards):", stats.Shards)
+			b.Logf("   Cached reads: %d", stats.Hits)
+		})
+	}
+}
+
 // Test100kCorrectnessWithTraversal verifies data integrity after 100k inserts
 func Test100kCorrectnessWithTraversal(t *testing.T) {
 	dbFile := "test_100k_correctness.db"
@@ -426,42 +585,98 @@ func Test100kCorrectnessWithTraversal(t *testing.T) {
 	t.Logf("   Evictions: %d", stats.Evictions)
 }
 
-// BenchmarkBufferPoolSizes compares performance with different buffer pool sizes
+// BenchmarkBufferPoolSizes compares replacement policies across buffer pool
+// sizes on a sequential, a scan-heavy and a zipfian read workload
 func BenchmarkBufferPoolSizes(b *testing.B) {
-	sizes := []int{32, 64, 128, 256, 512}
+	const numKeys = 20000
+	dbFile := "bench_buffer.db"
+	walFile := "bench_buffer.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
 
-	for _, size := range sizes {
-		b.Run(fmt.Sprintf("Size%d", size), func(b *testing.B) {
-			dbFile := fmt.Sprintf("bench_buffer_%d.db", size)
-			walFile := fmt.Sprintf("bench_buffer_%d.wal", size)
-			defer os.Remove(dbFile)
-			defer os.Remove(walFile)
+	// Build the tree once, every run reopens it with a cold pool
+	pager, _ := storage.NewFilePager(dbFile)
+	tree, _ := bptree.NewBPTree(storage.NewBufferPool(pager, 512), 100, walFile)
+	tree.SetSyncMode(storage.SyncOff)
+	value := strings.Repeat("v", 100)
+	for i := 0; i < numKeys; i++ {
+		tree.Insert(uint32(i), value)
+	}
+	tree.Checkpoint()
+	tree.Close()
+	pager.Close()
+
+	workloads := []struct {
+		name string
+		run  func(tree *bptree.BPTree, rng *rand.Rand)
+	}{
+		{"Sequential", func(tree *bptree.BPTree, rng *rand.Rand) {
+			for i := 0; i < 10000; i++ {
+				tree.Search(uint32(i % numKeys))
+			}
+		}},
+		// Lookups on a hot tenth of the keys, interrupted by long range scans
+		// over the rest that touch each of their pages once
+		{"ScanHeavy", func(tree *bptree.BPTree, rng *rand.Rand) {
+			for i := 0; i < 10000; i++ {
+				if i%500 == 0 {
+					start := uint32(numKeys/10 + rng.Intn(numKeys/2))
+					it := tree.Scan(start, start+numKeys/4)
+					for it.Next() {
+					}
+					it.Close()
+				}
+				tree.Search(uint32(rng.Intn(numKeys / 10)))
+			}
+		}},
+		// Skewed lookups, hot keys spread over the tree by a multiplicative hash
+		{"Zipfian", func(tree *bptree.BPTree, rng *rand.Rand) {
+			zipf := rand.NewZipf(rng, 1.1, 1, numKeys-1)
+			for i := 0; i < 10000; i++ {
+				tree.Search(uint32(zipf.Uint64() * 7919 % numKeys))
+			}
+		}},
+	}
 
-			pager, _ := storage.NewFilePager(dbFile)
-			defer pager.Close()
+	policies := []struct {
+		name string
+		new  func(size int) storage.ReplacementPolicy
+	}{
+		{"LRU", func(int) storage.ReplacementPolicy { return storage.NewLRUPolicy() }},
+		{"Clock", func(int) storage.ReplacementPolicy { return storage.NewClockPolicy() }},
+		{"LRU-2", func(int) storage.ReplacementPolicy { return storage.NewLRUKPolicy(2) }},
+		{"2Q", func(size int) storage.ReplacementPolicy { return storage.NewTwoQPolicy(size) }},
+	}
 
-			bufferPool := storage.NewBufferPool(pager, size)
-			defer bufferPool.Close()
+	sizes := []int{32, 64, 128, 256, 512}
 
-			tree, _ := bptree.NewBPTree(bufferPool, 100, walFile)
-			defer tree.Close()
+	for _, workload := range workloads {
+		for _, policy := range policies {
+			for _, size := range sizes {
+				b.Run(fmt.Sprintf("%s/%s/Size%d", workload.name, policy.name, size), func(b *testing.B) {
+					pager, _ := storage.NewFilePager(dbFile)
+					defer pager.Close()
 
-			// Insert 10k keys
-			for i := 0; i < 10000; i++ {
-				tree.Insert(uint32(i), fmt.Sprintf("value-%d", i))
-			}
+					bufferPool := storage.NewBufferPoolWithPolicy(pager, size, policy.new(size))
+					defer bufferPool.Close()
 
-			b.ResetTimer()
+					tree, _ := bptree.OpenBPTree(bufferPool, 100, walFile)
+					defer tree.Close()
 
-			// Benchmark reads
-			for i := 0; i < 10000; i++ {
-				tree.Search(uint32(i % 10000))
-			}
+					before := bufferPool.GetStats()
+					b.ResetTimer()
 
-			b.StopTimer()
+					workload.run(tree, rand.New(rand.NewSource(1)))
 
-			stats := bufferPool.GetStats()
-			b.Logf("Buffer size %d pages: Hit rate %.2f%%", size, stats.HitRate*100)
-		})
+					b.StopTimer()
+
+					after := bufferPool.GetStats()
+					hits := after.Hits - before.Hits
+					hitRate := float64(hits) / float64(hits+after.Misses-before.Misses)
+					b.ReportMetric(hitRate*100, "hit%")
+					b.Logf("%s, %s, buffer size %d pages: Hit rate %.2f%%", workload.name, policy.name, size, hitRate*100)
+				})
+			}
+		}
 	}
 }
@@ -1,25 +1,66 @@
 package bptree
 
 import (
-	"encoding/binary"
+	"errors"
 	"fmt"
-	"io"
-	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
 
 	"github.com/spaghetti-lover/sharingan-db/internal/storage"
 	"github.com/spaghetti-lover/sharingan-db/internal/wal"
 )
 
+// ErrComparatorMismatch is returned when opening a tree with a comparator
+// other than the one it was created with
+var ErrComparatorMismatch = errors.New("comparator does not match the tree")
+
+// ErrNotUint32Key is returned when a uint32 API meets a key that isn't 4 bytes long
+var ErrNotUint32Key = errors.New("key is not a uint32 key")
+
 // BPTree represents a B+ Tree index
+// Keys are byte strings ordered by the tree's comparator. The uint32 methods
+// store keys big-endian through storage.Uint32Key.
+// It is safe for concurrent use when its pager is, as BufferPool is.
+// Readers and writers latch pages on the way down and let go of a parent once
+// the child is latched (crabbing), so they only wait for each other on the
+// pages they share. Concurrent writes to the same key must be ordered by the
+// caller, Database does it with key locks.
 type BPTree struct {
 	pager    storage.Pager
 	rootPage uint64
-	order    int // Maximum number of keys per node
+	order    int // Informational only, recorded in the superblock; nodes split by page space
 	wal      *wal.WAL
+	cmp      storage.Comparator // Orders the keys
+
+	rootLatch sync.RWMutex // Guards rootPage, held exclusively while the root may change
+	latches   *latchTable  // Page latches
+	quiesce   sync.RWMutex // Shared by writes from logging to applied, exclusive to stop them
+
+	mu           sync.Mutex     // Guards the fields below
+	activeTxs    int            // Transactions begun but not yet committed or rolled back
+	snapshots    map[uint64]int // Open snapshot LSNs and how many snapshots share each
+	deadVersions int            // Deleted versions kept for snapshots, not yet pruned
+
+	locks *LockManager // Key locks held by transactions
+
+	readAhead atomic.Int32 // Leaves a scan asks the pager to load ahead, 0 disables
 }
 
-// NewBPTree creates a new B+ Tree
+// defaultReadAhead is how many leaves scans read ahead unless SetReadAhead changes it
+const defaultReadAhead = 8
+
+// NewBPTree creates a new B+ Tree ordered by storage.BytewiseComparator
 func NewBPTree(pager storage.Pager, order int, walPath string) (*BPTree, error) {
+	return NewBPTreeWithComparator(pager, order, walPath, storage.BytewiseComparator)
+}
+
+// NewBPTreeWithComparator creates a new B+ Tree ordered by cmp
+func NewBPTreeWithComparator(pager storage.Pager, order int, walPath string, cmp storage.Comparator) (*BPTree, error) {
+	if err := checkComparator(cmp); err != nil {
+		return nil, err
+	}
+
 	rootPageID, rootPage, err := allocatePageWithType(pager, storage.PageTypeLeaf)
 	if err != nil {
 		return nil, fmt.Errorf("failed to allocate root page: %w", err)
@@ -41,10 +82,24 @@ func NewBPTree(pager storage.Pager, order int, walPath string) (*BPTree, error)
 		rootPage: rootPageID,
 		order:    order,
 		wal:      walFile,
+		cmp:      cmp,
+		latches:  newLatchTable(),
+		locks:    NewLockManager(),
 	}
+	tree.readAhead.Store(defaultReadAhead)
+	tree.logBeforeData()
 
-	// Save metadata for recovery
-	if err := tree.SaveMetadata(walPath + ".meta"); err != nil {
+	if err := tree.continueLSN(); err != nil {
+		walFile.Close()
+		return nil, err
+	}
+
+	// Record root and order in the superblock for recovery, once the root is on disk
+	if err := pager.Flush(); err != nil {
+		walFile.Close()
+		return nil, fmt.Errorf("failed to flush root page: %w", err)
+	}
+	if err := tree.setRoot(rootPageID); err != nil {
 		walFile.Close() // Clean up WAL if metadata save fails
 		return nil, fmt.Errorf("failed to save metadata: %w", err)
 	}
@@ -52,8 +107,46 @@ func NewBPTree(pager storage.Pager, order int, walPath string) (*BPTree, error)
 	return tree, nil
 }
 
-// LoadBPTree loads an existing B+ Tree from disk
+// OpenBPTree loads the tree recorded in the pager's superblock,
+// or creates a new one if the database is empty
+func OpenBPTree(pager storage.Pager, order int, walPath string) (*BPTree, error) {
+	return OpenBPTreeWithComparator(pager, order, walPath, storage.BytewiseComparator)
+}
+
+// OpenBPTreeWithComparator is OpenBPTree for a tree ordered by cmp
+// An existing tree must have been created with a comparator of the same name.
+func OpenBPTreeWithComparator(pager storage.Pager, order int, walPath string, cmp storage.Comparator) (*BPTree, error) {
+	sb, err := pager.ReadSuperblock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read superblock: %w", err)
+	}
+
+	if sb.RootPageID == 0 {
+		return NewBPTreeWithComparator(pager, order, walPath, cmp)
+	}
+
+	return loadBPTree(pager, sb.RootPageID, int(sb.Order), walPath, cmp)
+}
+
+// LoadBPTree loads an existing B+ Tree ordered by storage.BytewiseComparator from disk
 func LoadBPTree(pager storage.Pager, rootPageID uint64, order int, walPath string) (*BPTree, error) {
+	return loadBPTree(pager, rootPageID, order, walPath, storage.BytewiseComparator)
+}
+
+// loadBPTree loads an existing B+ Tree ordered by cmp from disk
+func loadBPTree(pager storage.Pager, rootPageID uint64, order int, walPath string, cmp storage.Comparator) (*BPTree, error) {
+	if err := checkComparator(cmp); err != nil {
+		return nil, err
+	}
+
+	sb, err := pager.ReadSuperblock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read superblock: %w", err)
+	}
+	if sb.Comparator != "" && sb.Comparator != cmp.Name() {
+		return nil, fmt.Errorf("%w: tree uses %q, opened with %q", ErrComparatorMismatch, sb.Comparator, cmp.Name())
+	}
+
 	// Open WAL
 	walFile, err := wal.NewWAL(walPath)
 	if err != nil {
@@ -65,7 +158,12 @@ func LoadBPTree(pager storage.Pager, rootPageID uint64, order int, walPath strin
 		rootPage: rootPageID,
 		order:    order,
 		wal:      walFile,
+		cmp:      cmp,
+		latches:  newLatchTable(),
+		locks:    NewLockManager(),
 	}
+	tree.readAhead.Store(defaultReadAhead)
+	tree.logBeforeData()
 
 	// Replay WAL entries
 	if err := tree.replayWAL(); err != nil {
@@ -76,100 +174,322 @@ func LoadBPTree(pager storage.Pager, rootPageID uint64, order int, walPath strin
 	return tree, nil
 }
 
-// Insert inserts a key-value pair into the B+ Tree
+// checkComparator rejects comparators whose name the superblock can't hold
+func checkComparator(cmp storage.Comparator) error {
+	if name := cmp.Name(); name == "" || len(name) > 32 {
+		return fmt.Errorf("invalid comparator name %q: must be 1 to 32 bytes", name)
+	}
+	return nil
+}
+
+// Insert inserts a key-value pair into the B+ Tree, replacing any existing value
+// The WAL entry is durable before the tree is modified
 func (tree *BPTree) Insert(key uint32, value string) error {
+	return tree.InsertKey(storage.Uint32Key(key), value)
+}
+
+// InsertKey inserts a byte-string key, replacing any existing value
+// The WAL entry is durable before the tree is modified
+func (tree *BPTree) InsertKey(key []byte, value string) error {
+	if err := storage.CheckKey(key); err != nil {
+		return err
+	}
+
 	walEntry := &wal.Entry{
 		OpType: wal.OpInsert,
 		Key:    key,
 		Value:  value,
 	}
 
-	if err := tree.wal.Append(walEntry); err != nil {
+	commit, err := tree.logOp(walEntry)
+	if err != nil {
+		return err
+	}
+	defer tree.finishOp()
+
+	if err := commit.Wait(); err != nil {
 		return fmt.Errorf("failed to write WAL: %w", err)
 	}
 
-	record := storage.NewRecordFromInts(key, value)
+	return tree.insertWithoutWAL(walEntry.LSN, storage.NewRecord(key, []byte(value)))
+}
+
+// InsertAsync logs and applies an insert without waiting for the WAL fsync,
+// so concurrent writers can share one group commit
+// The insert is only durable once Wait on the returned commit succeeds
+func (tree *BPTree) InsertAsync(key uint32, value string) (*wal.Commit, error) {
+	return tree.InsertKeyAsync(storage.Uint32Key(key), value)
+}
 
-	// Load root page
-	rootPage, err := readPageStruct(tree.pager, tree.rootPage)
+// InsertKeyAsync is InsertAsync for a byte-string key
+func (tree *BPTree) InsertKeyAsync(key []byte, value string) (*wal.Commit, error) {
+	if err := storage.CheckKey(key); err != nil {
+		return nil, err
+	}
+
+	walEntry := &wal.Entry{
+		OpType: wal.OpInsert,
+		Key:    key,
+		Value:  value,
+	}
+
+	commit, err := tree.logOp(walEntry)
 	if err != nil {
-		return fmt.Errorf("failed to load root page: %w", err)
+		return nil, err
 	}
+	defer tree.finishOp()
 
-	// If root is leaf, handle insertion (possibly splitting)
-	if rootPage.IsLeaf() {
-		newChildKey, newChildPageID, err := tree.insertIntoLeafWithSplit(tree.rootPage, rootPage, record)
-		if err != nil {
-			return err
-		}
+	if err := tree.insertWithoutWAL(walEntry.LSN, storage.NewRecord(walEntry.Key, []byte(value))); err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
 
-		// If split occurred, create new root
-		if newChildPageID != 0 {
-			return tree.createNewRoot(tree.rootPage, newChildKey, newChildPageID)
-		}
+// logOp appends the WAL entry of a write about to be applied to the tree
+// Checkpoints and new snapshots wait until the write calls finishOp, so they
+// never see it logged but only partly applied.
+func (tree *BPTree) logOp(entry *wal.Entry) (*wal.Commit, error) {
+	tree.quiesce.RLock()
 
-		return nil
+	commit, err := tree.wal.AppendAsync(entry)
+	if err != nil {
+		tree.quiesce.RUnlock()
+		return nil, fmt.Errorf("failed to write WAL: %w", err)
+	}
+	return commit, nil
+}
+
+// finishOp marks the write logged by logOp as applied
+func (tree *BPTree) finishOp() {
+	tree.quiesce.RUnlock()
+}
+
+// logBeforeData makes the pager force the WAL up to a page's LSN before
+// writing the page, so a crash never leaves a change on disk whose log
+// record, such as a transaction's commit, was lost
+func (tree *BPTree) logBeforeData() {
+	if syncer, ok := tree.pager.(storage.WALSyncer); ok {
+		syncer.SetWALSync(tree.wal.SyncTo)
 	}
+}
 
-	// Root is internal, navigate and insert
-	return tree.insertNonLeafRoot(key, record)
+// continueLSN makes new WAL entries continue after the last checkpointed LSN,
+// so page LSNs stay comparable after the log has been truncated
+func (tree *BPTree) continueLSN() error {
+	sb, err := tree.pager.ReadSuperblock()
+	if err != nil {
+		return fmt.Errorf("failed to read superblock: %w", err)
+	}
+	tree.wal.AdvanceLSN(sb.CheckpointLSN + 1)
+	return nil
 }
 
 // replayWAL replays all WAL entries to restore state
+// Entries covered by the last checkpoint, or whose leaf already carries
+// their change, are skipped so recovery can run any number of times
 func (tree *BPTree) replayWAL() error {
+	sb, err := tree.pager.ReadSuperblock()
+	if err != nil {
+		return fmt.Errorf("failed to read superblock: %w", err)
+	}
+	tree.wal.AdvanceLSN(sb.CheckpointLSN + 1)
+
 	entries, err := tree.wal.ReadAll()
 	if err != nil {
 		return fmt.Errorf("failed to read WAL: %w", err)
 	}
 
+	if discarded := tree.wal.DiscardedBytes(); discarded > 0 {
+		fmt.Printf("⚠️  Discarded %d bytes of torn WAL tail\n", discarded)
+	}
+
 	if len(entries) == 0 {
 		return nil // Nothing to replay
 	}
 
 	fmt.Printf("🔄 Replaying %d WAL entries...\n", len(entries))
 
-	for i, entry := range entries {
+	// A checkpoint record whose truncate didn't happen still covers earlier entries
+	checkpointLSN := sb.CheckpointLSN
+	for _, entry := range entries {
+		if entry.OpType == wal.OpCheckpoint && entry.LSN > checkpointLSN {
+			checkpointLSN = entry.LSN
+		}
+	}
+
+	// A vacuum that didn't reach its closing checkpoint may have left pages
+	// that are neither in the tree nor on the free list
+	for _, entry := range entries {
+		if entry.OpType == wal.OpVacuum && entry.LSN > checkpointLSN {
+			if err := tree.reclaimPages(); err != nil {
+				return fmt.Errorf("failed to finish interrupted vacuum: %w", err)
+			}
+			break
+		}
+	}
+
+	ops, uncommitted := redoOps(entries)
+	if uncommitted > 0 {
+		fmt.Printf("⚠️  Discarding %d uncommitted transactions\n", uncommitted)
+	}
+
+	skipped := 0
+	for i, entry := range ops {
+		if entry.LSN <= checkpointLSN {
+			skipped++
+			continue
+		}
+
+		applied, err := tree.entryApplied(entry)
+		if err != nil {
+			return fmt.Errorf("failed to check WAL entry %d: %w", i, err)
+		}
+		if applied {
+			skipped++
+			continue
+		}
+
 		switch entry.OpType {
 		case wal.OpInsert:
 			// Apply insert directly to tree (without writing to WAL again)
-			record := storage.NewRecordFromInts(entry.Key, entry.Value)
-			if err := tree.insertWithoutWAL(record); err != nil {
+			record := storage.NewRecord(entry.Key, []byte(entry.Value))
+			if err := tree.insertWithoutWAL(entry.LSN, record); err != nil {
 				return fmt.Errorf("failed to replay insert at entry %d: %w", i, err)
 			}
+		case wal.OpDelete:
+			if _, err := tree.deleteWithoutWAL(entry.LSN, entry.Key); err != nil {
+				return fmt.Errorf("failed to replay delete at entry %d: %w", i, err)
+			}
 		default:
 			return fmt.Errorf("unsupported WAL operation: %d", entry.OpType)
 		}
 	}
 
-	fmt.Printf("✓ WAL replay complete\n")
+	fmt.Printf("✓ WAL replay complete (%d already applied)\n", skipped)
 
-	// Clear WAL after successful replay
-	return tree.wal.Truncate()
+	// Replayed changes must reach the data file before the log is cleared
+	return tree.Checkpoint()
+}
+
+// redoOps lists the operations recovery must apply, in the order they took effect
+// Auto-committed operations stand alone; a transaction (identified by the
+// LSN of its BEGIN record) takes effect at its commit record and its
+// operations carry that record's LSN. Aborted or unfinished ones are dropped.
+func redoOps(entries []*wal.Entry) ([]*wal.Entry, int) {
+	var ops []*wal.Entry
+	pending := make(map[uint64][]*wal.Entry)
+
+	for _, entry := range entries {
+		switch entry.OpType {
+		case wal.OpBegin:
+			pending[entry.LSN] = nil
+		case wal.OpCommit:
+			for _, op := range pending[entry.TxID] {
+				committed := *op
+				committed.LSN = entry.LSN
+				ops = append(ops, &committed)
+			}
+			delete(pending, entry.TxID)
+		case wal.OpAbort:
+			delete(pending, entry.TxID)
+		case wal.OpCheckpoint, wal.OpVacuum:
+			// Handled by the caller
+		default:
+			if entry.TxID == 0 {
+				ops = append(ops, entry)
+			} else {
+				pending[entry.TxID] = append(pending[entry.TxID], entry)
+			}
+		}
+	}
+
+	return ops, len(pending)
+}
+
+// entryApplied reports whether the leaf owning the entry's key already
+// reflects it: the page LSN is at least the entry's LSN and the key is in
+// the state the entry left it
+func (tree *BPTree) entryApplied(entry *wal.Entry) (bool, error) {
+	applied := false
+	err := tree.readLeaf(entry.Key, func(leaf *storage.LeafPage) error {
+		if leaf.PageLSN() < entry.LSN {
+			return nil
+		}
+
+		record, found := leaf.SearchRecord(entry.Key)
+		switch entry.OpType {
+		case wal.OpInsert:
+			if !found {
+				return nil
+			}
+			if err := tree.loadValue(record); err != nil {
+				return err
+			}
+			applied = record.GetValueAsString() == entry.Value
+		case wal.OpDelete:
+			applied = !found
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to find leaf page: %w", err)
+	}
+	return applied, nil
 }
 
 // insertWithoutWAL inserts without writing to WAL (used during replay)
-func (tree *BPTree) insertWithoutWAL(record *storage.Record) error {
-	key, _ := record.GetKeyAsUint32()
+// Pages written are stamped with lsn.
+func (tree *BPTree) insertWithoutWAL(lsn uint64, record *storage.Record) error {
+	key := record.Key
 
-	rootPage, err := readPageStruct(tree.pager, tree.rootPage)
+	// A value too large to share a leaf goes to overflow pages first
+	if _, err := storage.SpillValue(tree.pager, record, lsn); err != nil {
+		return err
+	}
+
+	op := tree.newWriteOp(lsn)
+	defer op.release()
+
+	// Most inserts fit in their leaf and latch nothing else
+	leafPageID, leafPage, err := op.latchLeaf(key)
+	if err != nil {
+		return err
+	}
+	inserted, err := op.insertIntoLeaf(leafPageID, leafPage, record)
 	if err != nil {
-		return fmt.Errorf("failed to load root page: %w", err)
+		return err
+	}
+	if inserted {
+		return op.finish()
 	}
+	op.discard()
 
-	if rootPage.IsLeaf() {
-		newChildKey, newChildPageID, err := tree.insertIntoLeafWithSplit(tree.rootPage, rootPage, record)
-		if err != nil {
+	// The leaf splits, latch the path down from the lowest node with room
+	leafPageID, leafPage, err = op.latchPath(key, insertSafe)
+	if err != nil {
+		return err
+	}
+
+	newChildKey, newChildPageID, err := op.insertIntoLeafWithSplit(leafPageID, leafPage, record)
+	if err != nil {
+		return err
+	}
+	if newChildPageID != 0 {
+		if err := op.insertIntoParent(leafPageID, newChildKey, newChildPageID); err != nil {
 			return err
 		}
+	}
 
-		if newChildPageID != 0 {
-			return tree.createNewRoot(tree.rootPage, newChildKey, newChildPageID)
-		}
+	return op.finish()
+}
 
-		return nil
+// insertSafe reports whether a page on an insert's path absorbs a split below
+// it without splitting itself, whatever the length of the key pushed up
+func insertSafe(page *storage.Page) bool {
+	if page.IsLeaf() {
+		return false
 	}
-
-	return tree.insertNonLeafRoot(key, record)
+	return storage.NewInternalPage(page).HasRoomFor(storage.MaxKeySize)
 }
 
 // Close closes the B+ Tree and WAL
@@ -190,87 +510,99 @@ func (tree *BPTree) GetWALSyncCount() int {
 	return tree.wal.GetSyncCount()
 }
 
-// insertNonLeafRoot handles insertion when root is internal
-func (tree *BPTree) insertNonLeafRoot(key uint32, record *storage.Record) error {
-	// Find leaf page
-	leafPageID, err := tree.findLeafPage(key)
-	if err != nil {
-		return fmt.Errorf("failed to find leaf page: %w", err)
-	}
+// SetSyncMode sets when WAL entries are fsynced
+func (tree *BPTree) SetSyncMode(mode storage.SyncMode) {
+	tree.wal.SetSyncMode(mode)
+}
 
-	leafPage, err := readPageStruct(tree.pager, leafPageID)
-	if err != nil {
-		return fmt.Errorf("failed to load leaf page: %w", err)
-	}
+// SyncMode returns the WAL's sync mode
+func (tree *BPTree) SyncMode() storage.SyncMode {
+	return tree.wal.SyncMode()
+}
 
-	// Insert into leaf (possibly splitting)
-	newChildKey, newChildPageID, err := tree.insertIntoLeafWithSplit(leafPageID, leafPage, record)
-	if err != nil {
-		return err
-	}
+// SetReadAhead sets how many leaves past the current one a scan asks the
+// pager to load in the background, 0 turns read-ahead off. Only pagers
+// implementing storage.Prefetcher, like BufferPool, read ahead.
+func (tree *BPTree) SetReadAhead(leaves int) {
+	tree.readAhead.Store(int32(max(leaves, 0)))
+}
 
-	// If split occurred, insert promoted key into parent
-	if newChildPageID != 0 {
-		return tree.insertIntoParent(leafPageID, newChildKey, newChildPageID)
-	}
+// DurableLSN returns the last WAL LSN that survives a crash
+// Pages stamped with a later LSN must not reach disk yet
+func (tree *BPTree) DurableLSN() uint64 {
+	return tree.wal.DurableLSN()
+}
 
-	return nil
+// insertIntoLeaf inserts record into leaf, replacing the live version of its key
+// Returns false if the leaf is full, the page is then left unwritten
+func (op *writeOp) insertIntoLeaf(pageID uint64, page *storage.Page, record *storage.Record) (bool, error) {
+	leaf := op.tree.leafPage(page)
+
+	// Overwrite instead of duplicating, so WAL replay is idempotent
+	op.retireVersion(leaf, record.Key)
+	record.CreatedBy = op.lsn
+	record.DeletedBy = 0
+
+	if err := leaf.InsertRecord(record); err != nil {
+		return false, nil
+	}
+	return true, op.writePage(pageID, page)
 }
 
 // insertIntoLeafWithSplit inserts record into leaf, splitting if necessary
+// An existing record with the same key is replaced
 // Returns (promotedKey, newPageID, error)
-// If no split: returns (0, 0, nil)
-func (tree *BPTree) insertIntoLeafWithSplit(pageID uint64, page *storage.Page, record *storage.Record) (uint32, uint64, error) {
-	leaf := storage.NewLeafPage(page)
-
-	// Try simple insert
-	err := leaf.InsertRecord(record)
-	if err == nil {
-		// Success without split
-		return 0, 0, writePageStruct(tree.pager, pageID, page)
+// If no split: returns (nil, 0, nil)
+func (op *writeOp) insertIntoLeafWithSplit(pageID uint64, page *storage.Page, record *storage.Record) ([]byte, uint64, error) {
+	inserted, err := op.insertIntoLeaf(pageID, page, record)
+	if err != nil || inserted {
+		return nil, 0, err
 	}
 
 	// Page is full, need to split
-	return tree.splitLeaf(pageID, page, record)
+	return op.splitLeaf(pageID, page, record)
 }
 
 // splitLeaf splits a full leaf page
 // Returns (promotedKey, newPageID, error)
-func (tree *BPTree) splitLeaf(oldPageID uint64, oldPage *storage.Page, newRecord *storage.Record) (uint32, uint64, error) {
-	oldLeaf := storage.NewLeafPage(oldPage)
+func (op *writeOp) splitLeaf(oldPageID uint64, oldPage *storage.Page, newRecord *storage.Record) ([]byte, uint64, error) {
+	oldLeaf := op.tree.leafPage(oldPage)
 
 	// Get all existing records + new record
 	allRecords, err := oldLeaf.GetAllRecords()
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to get records: %w", err)
+		return nil, 0, fmt.Errorf("failed to get records: %w", err)
 	}
 	allRecords = append(allRecords, newRecord)
 
 	// Sort records by key
-	sortRecordsByKey(allRecords)
+	sortRecordsByKey(allRecords, op.tree.cmp)
 
-	// Find split point (middle)
-	splitIndex := len(allRecords) / 2
+	// Find split point (half the bytes), keeping all versions of a key in one leaf
+	splitIndex, err := versionBoundary(allRecords, balancedSplit(allRecords, oldLeaf.Capacity()-2), op.tree.cmp)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to split leaf %d: %w", oldPageID, err)
+	}
 
 	// Create new right leaf
-	newPageID, newPage, err := allocatePageWithType(tree.pager, storage.PageTypeLeaf)
+	newPageID, newPage, err := allocatePageWithType(op.tree.pager, storage.PageTypeLeaf)
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to allocate new leaf: %w", err)
+		return nil, 0, fmt.Errorf("failed to allocate new leaf: %w", err)
 	}
-	newLeaf := storage.NewLeafPage(newPage)
+	newLeaf := op.tree.leafPage(newPage)
 
 	// Clear old leaf and re-insert left half
-	oldPage.Header.NumKeys = 0
+	oldLeaf.Reset()
 	for i := 0; i < splitIndex; i++ {
 		if err := oldLeaf.InsertRecord(allRecords[i]); err != nil {
-			return 0, 0, fmt.Errorf("failed to insert into old leaf: %w", err)
+			return nil, 0, fmt.Errorf("failed to insert into old leaf: %w", err)
 		}
 	}
 
 	// Insert right half into new leaf
 	for i := splitIndex; i < len(allRecords); i++ {
 		if err := newLeaf.InsertRecord(allRecords[i]); err != nil {
-			return 0, 0, fmt.Errorf("failed to insert into new leaf: %w", err)
+			return nil, 0, fmt.Errorf("failed to insert into new leaf: %w", err)
 		}
 	}
 
@@ -281,18 +613,15 @@ func (tree *BPTree) splitLeaf(oldPageID uint64, oldPage *storage.Page, newRecord
 	// Copy parent pointer
 	newPage.Header.Parent = oldPage.Header.Parent
 
-	// Get promoted key (first key of right leaf)
-	promotedKey, err := allRecords[splitIndex].GetKeyAsUint32()
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed to get promoted key: %w", err)
-	}
+	// Promoted key is the first key of the right leaf
+	promotedKey := allRecords[splitIndex].Key
 
 	// Write both pages
-	if err := writePageStruct(tree.pager, oldPageID, oldPage); err != nil {
-		return 0, 0, err
+	if err := op.writePage(oldPageID, oldPage); err != nil {
+		return nil, 0, err
 	}
-	if err := writePageStruct(tree.pager, newPageID, newPage); err != nil {
-		return 0, 0, err
+	if err := op.writePage(newPageID, newPage); err != nil {
+		return nil, 0, err
 	}
 
 	return promotedKey, newPageID, nil
@@ -300,204 +629,179 @@ func (tree *BPTree) splitLeaf(oldPageID uint64, oldPage *storage.Page, newRecord
 
 // insertIntoParent inserts promoted key into parent internal node
 // Handles recursive splitting up the tree
-func (tree *BPTree) insertIntoParent(leftChildID uint64, key uint32, rightChildID uint64) error {
+// The parent is already latched, an unsafe child keeps it latched
+func (op *writeOp) insertIntoParent(leftChildID uint64, key []byte, rightChildID uint64) error {
 	// Load left child to get parent pointer
-	leftChild, err := readPageStruct(tree.pager, leftChildID)
+	leftChild, err := readPageStruct(op.tree.pager, leftChildID)
 	if err != nil {
 		return fmt.Errorf("failed to load left child: %w", err)
 	}
 
 	// If no parent, create new root
 	if leftChild.Header.Parent == 0 {
-		return tree.createNewRoot(leftChildID, key, rightChildID)
+		return op.createNewRoot(leftChildID, key, rightChildID)
 	}
 
 	// Load parent
 	parentID := uint64(leftChild.Header.Parent)
-	parentPage, err := readPageStruct(tree.pager, parentID)
+	parentPage, err := readPageStruct(op.tree.pager, parentID)
 	if err != nil {
 		return fmt.Errorf("failed to load parent: %w", err)
 	}
 
-	parent := storage.NewInternalPage(parentPage)
+	parent := op.tree.internalPage(parentPage)
 
 	// Try to insert into parent
 	err = parent.InsertEntry(key, rightChildID)
 	if err == nil {
 		// Success without split - update right child's parent pointer
-		rightChild, err := readPageStruct(tree.pager, rightChildID)
-		if err != nil {
-			return err
-		}
-		rightChild.Header.Parent = uint32(parentID)
-		if err := writePageStruct(tree.pager, rightChildID, rightChild); err != nil {
+		if err := op.setParent(rightChildID, parentID); err != nil {
 			return err
 		}
 
-		return writePageStruct(tree.pager, parentID, parentPage)
+		return op.writePage(parentID, parentPage)
 	}
 
 	// Parent is full, need to split
-	return tree.splitInternal(parentID, parentPage, key, rightChildID)
+	return op.splitInternal(parentID, parentPage, key, rightChildID)
 }
 
 // splitInternal splits a full internal page
-func (tree *BPTree) splitInternal(oldPageID uint64, oldPage *storage.Page, newKey uint32, newChildID uint64) error {
-	oldInternal := storage.NewInternalPage(oldPage)
+func (op *writeOp) splitInternal(oldPageID uint64, oldPage *storage.Page, newKey []byte, newChildID uint64) error {
+	oldInternal := op.tree.internalPage(oldPage)
 
 	// Collect all entries (keys + pointers)
-	type entry struct {
-		key    uint32
-		pageID uint64
-	}
-
-	entries := make([]entry, 0, oldPage.Header.NumKeys+1)
-
-	// Get existing entries
-	for i := 0; i < int(oldPage.Header.NumKeys); i++ {
-		k, p, err := oldInternal.GetKeyPointer(i)
-		if err != nil {
-			return fmt.Errorf("failed to get entry %d: %w", i, err)
-		}
-		entries = append(entries, entry{key: k, pageID: p})
+	keys, children, err := internalEntries(oldInternal)
+	if err != nil {
+		return err
 	}
 
-	// Insert new entry in sorted position
-	inserted := false
-	for i, e := range entries {
-		if newKey < e.key {
-			entries = append(entries[:i], append([]entry{{newKey, newChildID}}, entries[i:]...)...)
-			inserted = true
-			break
-		}
-	}
-	if !inserted {
-		entries = append(entries, entry{newKey, newChildID})
-	}
+	// Insert new entry in sorted position, after equal keys
+	pos := sort.Search(len(keys), func(i int) bool {
+		return op.tree.cmp.Compare(newKey, keys[i]) < 0
+	})
+	keys = append(keys[:pos], append([][]byte{newKey}, keys[pos:]...)...)
+	children = append(children[:pos+1], append([]uint64{newChildID}, children[pos+1:]...)...)
 
-	// Find middle point
-	middleIndex := len(entries) / 2
-	middleKey := entries[middleIndex].key
+	// Find middle point, half the bytes on each side
+	middleIndex := splitPoint(keys)
+	middleKey := keys[middleIndex]
 
 	// Create new right internal page
-	newPageID, newPage, err := allocatePageWithType(tree.pager, storage.PageTypeInternal)
+	newPageID, newPage, err := allocatePageWithType(op.tree.pager, storage.PageTypeInternal)
 	if err != nil {
 		return fmt.Errorf("failed to allocate new root: %w", err)
 	}
-	newInternal := storage.NewInternalPage(newPage)
-
-	// Get leftmost pointer (before all keys)
-	leftmostPtr, err := oldInternal.GetLeftmostPointer()
-	if err != nil {
-		return err
-	}
+	newInternal := op.tree.internalPage(newPage)
 
 	// Rebuild old page (left half)
-	oldPage.Header.NumKeys = 0
-	oldInternal.SetLeftmostPointer(leftmostPtr)
-
-	for i := 0; i < middleIndex; i++ {
-		if err := oldInternal.InsertEntry(entries[i].key, entries[i].pageID); err != nil {
-			return fmt.Errorf("failed to insert into old internal: %w", err)
-		}
+	if err := rebuildInternal(oldInternal, keys[:middleIndex], children[:middleIndex+1]); err != nil {
+		return fmt.Errorf("failed to insert into old internal: %w", err)
 	}
 
 	// Build new page (right half)
 	// Leftmost pointer of new page is the pointer associated with middle key
-	newInternal.SetLeftmostPointer(entries[middleIndex].pageID)
-
-	for i := middleIndex + 1; i < len(entries); i++ {
-		if err := newInternal.InsertEntry(entries[i].key, entries[i].pageID); err != nil {
-			return fmt.Errorf("failed to insert into new internal: %w", err)
-		}
+	if err := rebuildInternal(newInternal, keys[middleIndex+1:], children[middleIndex+1:]); err != nil {
+		return fmt.Errorf("failed to insert into new internal: %w", err)
 	}
 
 	// Copy parent pointer
 	newPage.Header.Parent = oldPage.Header.Parent
 
-	// Update parent pointers of children in new page
-	// Update leftmost pointer's child
-	child, err := readPageStruct(tree.pager, entries[middleIndex].pageID)
-	if err == nil {
-		child.Header.Parent = uint32(newPageID)
-		writePageStruct(tree.pager, entries[middleIndex].pageID, child)
-	}
-
-	// Update other children
-	for i := middleIndex + 1; i < len(entries); i++ {
-		child, err := readPageStruct(tree.pager, entries[i].pageID)
-		if err == nil {
-			child.Header.Parent = uint32(newPageID)
-			writePageStruct(tree.pager, entries[i].pageID, child)
+	// Update parent pointers of children in new page, starting with the
+	// leftmost pointer's child
+	for _, childID := range children[middleIndex+1:] {
+		if err := op.setParent(childID, newPageID); err != nil {
+			return err
 		}
 	}
 
 	// Write both internal pages
-	if err := writePageStruct(tree.pager, oldPageID, oldPage); err != nil {
+	if err := op.writePage(oldPageID, oldPage); err != nil {
 		return err
 	}
-	if err := writePageStruct(tree.pager, newPageID, newPage); err != nil {
+	if err := op.writePage(newPageID, newPage); err != nil {
 		return err
 	}
 
 	// Recursively insert promoted key into parent
 	// Note: middle key is PUSHED UP (not copied like in leaf split)
-	return tree.insertIntoParent(oldPageID, middleKey, newPageID)
+	return op.insertIntoParent(oldPageID, middleKey, newPageID)
 }
 
-// createNewRoot creates a new root when current root splits
-func (tree *BPTree) createNewRoot(leftChildID uint64, key uint32, rightChildID uint64) error {
-	// Allocate new root (internal node)
-	newRootID, newRootPage, err := allocatePageWithType(tree.pager, storage.PageTypeInternal)
+// createNewRoot grows the tree by a level when the root splits
+// The root keeps its page, so the superblock doesn't change: its left half
+// moves to a new page and the root becomes the parent of both halves.
+// The old root was unsafe, so the operation still holds tree.rootLatch.
+func (op *writeOp) createNewRoot(rootID uint64, key []byte, rightChildID uint64) error {
+	rootPage, err := readPageStruct(op.tree.pager, rootID)
 	if err != nil {
-		return fmt.Errorf("failed to allocate new root: %w", err)
+		return fmt.Errorf("failed to load root: %w", err)
 	}
 
-	newRoot := storage.NewInternalPage(newRootPage)
-
-	// Set leftmost pointer to left child
-	if err := newRoot.SetLeftmostPointer(leftChildID); err != nil {
-		return err
+	leftChildID, leftPage, err := allocatePageWithType(op.tree.pager, rootPage.Header.PageType)
+	if err != nil {
+		return fmt.Errorf("failed to allocate left child of root: %w", err)
 	}
 
-	// Insert key pointing to right child
-	if err := newRoot.InsertEntry(key, rightChildID); err != nil {
-		return err
-	}
+	// The left half keeps its header, a leaf its link to the right half
+	leftPage.Header = rootPage.Header
+	leftPage.Header.Parent = uint32(rootID)
+	copy(leftPage.Data, rootPage.Data)
 
-	// Update parent pointers of children
-	leftChild, err := readPageStruct(tree.pager, leftChildID)
-	if err != nil {
+	if !leftPage.IsLeaf() {
+		_, children, err := internalEntries(op.tree.internalPage(leftPage))
+		if err != nil {
+			return err
+		}
+		for _, childID := range children {
+			if err := op.setParent(childID, leftChildID); err != nil {
+				return err
+			}
+		}
+	}
+	if err := op.writePage(leftChildID, leftPage); err != nil {
 		return err
 	}
-	leftChild.Header.Parent = uint32(newRootID)
-	if err := writePageStruct(tree.pager, leftChildID, leftChild); err != nil {
+	if err := op.setParent(rightChildID, rootID); err != nil {
 		return err
 	}
 
-	rightChild, err := readPageStruct(tree.pager, rightChildID)
-	if err != nil {
+	newRootPage := storage.NewPage(storage.PageTypeInternal)
+	newRoot := op.tree.internalPage(newRootPage)
+
+	// Set leftmost pointer to left child
+	if err := newRoot.SetLeftmostPointer(leftChildID); err != nil {
 		return err
 	}
-	rightChild.Header.Parent = uint32(newRootID)
-	if err := writePageStruct(tree.pager, rightChildID, rightChild); err != nil {
+
+	// Insert key pointing to right child
+	if err := newRoot.InsertEntry(key, rightChildID); err != nil {
 		return err
 	}
 
-	// Write new root
-	if err := writePageStruct(tree.pager, newRootID, newRootPage); err != nil {
-		return err
+	return op.writePage(rootID, newRootPage)
+}
+
+// setRoot switches the tree to another root page and records it in the
+// superblock
+// Splits and merges keep the root's page, only creating and vacuuming a tree
+// switch it. Every page under the new root must already be on disk.
+// tree.rootLatch must be held exclusively, except before the tree is shared.
+func (tree *BPTree) setRoot(rootPageID uint64) error {
+	tree.rootPage = rootPageID
+
+	sb, err := tree.pager.ReadSuperblock()
+	if err != nil {
+		return fmt.Errorf("failed to read superblock: %w", err)
 	}
 
-	// Update tree's root pointer
-	tree.rootPage = newRootID
+	sb.RootPageID = rootPageID
+	sb.Order = uint32(tree.order)
+	sb.Comparator = tree.cmp.Name()
 
-	// Update metadata file with new root
-	if tree.wal != nil {
-		metaPath := tree.wal.Path() + ".meta"
-		if err := tree.SaveMetadata(metaPath); err != nil {
-			fmt.Printf("Warning: failed to update metadata after root change: %v\n", err)
-		}
+	if err := tree.pager.WriteSuperblock(sb); err != nil {
+		return fmt.Errorf("failed to update superblock: %w", err)
 	}
 
 	return nil
@@ -505,127 +809,237 @@ func (tree *BPTree) createNewRoot(leftChildID uint64, key uint32, rightChildID u
 
 // Search searches for a key in the B+ Tree
 func (tree *BPTree) Search(key uint32) (string, bool, error) {
-	leafPageID, err := tree.findLeafPage(key)
-	if err != nil {
-		return "", false, fmt.Errorf("failed to find leaf page: %w", err)
-	}
+	return tree.SearchKey(storage.Uint32Key(key))
+}
 
-	leafPage, err := readPageStruct(tree.pager, leafPageID)
+// SearchKey searches for a byte-string key in the B+ Tree
+func (tree *BPTree) SearchKey(key []byte) (string, bool, error) {
+	var record *storage.Record
+	err := tree.readLeaf(key, func(leaf *storage.LeafPage) error {
+		found := false
+		if record, found = leaf.SearchRecord(key); !found {
+			return nil
+		}
+		return tree.loadValue(record)
+	})
 	if err != nil {
-		return "", false, fmt.Errorf("failed to load leaf page: %w", err)
+		return "", false, fmt.Errorf("failed to find leaf page: %w", err)
 	}
-
-	leaf := storage.NewLeafPage(leafPage)
-	record, found := leaf.SearchRecord(key)
-	if !found {
+	if record == nil {
 		return "", false, nil
 	}
 
 	return record.GetValueAsString(), true, nil
 }
 
-// findLeafPage navigates from root to leaf
-func (tree *BPTree) findLeafPage(key uint32) (uint64, error) {
-	currentPageID := tree.rootPage
-
-	for {
-		page, err := readPageStruct(tree.pager, currentPageID)
-		if err != nil {
-			return 0, fmt.Errorf("failed to read page %d: %w", currentPageID, err)
-		}
+// InOrderTraversal returns all uint32 keys in sorted order
+// It fails with ErrNotUint32Key on a byte-string key that isn't 4 bytes long,
+// InOrderTraversalKeys returns every key
+func (tree *BPTree) InOrderTraversal() ([]uint32, error) {
+	keys := make([]uint32, 0)
 
-		if page.IsLeaf() {
-			return currentPageID, nil
-		}
+	it := tree.ScanKeys(nil, nil)
+	defer it.Close()
 
-		internalPage := storage.NewInternalPage(page)
-		childPageID, err := internalPage.SearchChild(key)
-		if err != nil {
-			return 0, fmt.Errorf("failed to search child in page %d: %w", currentPageID, err)
+	for it.Next() {
+		key, ok := it.Uint32Key()
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNotUint32Key, formatKey(it.KeyBytes()))
 		}
-
-		currentPageID = childPageID
+		keys = append(keys, key)
 	}
-}
-
-// InOrderTraversal returns all keys in sorted order
-func (tree *BPTree) InOrderTraversal() ([]uint32, error) {
-	keys := make([]uint32, 0)
-
-	leftmostLeafID, err := tree.findLeftmostLeaf()
-	if err != nil {
+	if err := it.Err(); err != nil {
 		return nil, err
 	}
 
-	currentPageID := leftmostLeafID
-	for currentPageID != 0 {
-		page, err := readPageStruct(tree.pager, currentPageID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read page %d: %w", currentPageID, err)
-		}
+	return keys, nil
+}
 
-		leaf := storage.NewLeafPage(page)
-		records, err := leaf.GetAllRecords()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get records from page %d: %w", currentPageID, err)
-		}
+// InOrderTraversalKeys returns every key in the tree's key order
+func (tree *BPTree) InOrderTraversalKeys() ([][]byte, error) {
+	keys := make([][]byte, 0)
 
-		for _, record := range records {
-			key, _ := record.GetKeyAsUint32()
-			keys = append(keys, key)
-		}
+	it := tree.ScanKeys(nil, nil)
+	defer it.Close()
 
-		currentPageID = uint64(page.Header.NextPage)
+	for it.Next() {
+		keys = append(keys, it.KeyBytes())
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
 	}
 
 	return keys, nil
 }
 
 // findLeftmostLeaf finds leftmost leaf
+// Latches aren't taken, writers must be quiesced
 func (tree *BPTree) findLeftmostLeaf() (uint64, error) {
 	currentPageID := tree.rootPage
 
 	for {
-		page, err := readPageStruct(tree.pager, currentPageID)
+		children, err := tree.children(currentPageID)
 		if err != nil {
 			return 0, err
 		}
 
-		if page.IsLeaf() {
+		if children == nil {
 			return currentPageID, nil
 		}
 
-		internalPage := storage.NewInternalPage(page)
-		leftmostPtr, err := internalPage.GetLeftmostPointer()
-		if err != nil {
-			return 0, err
+		currentPageID = children[0]
+	}
+}
+
+// children returns the child page IDs of an internal page, nil for a leaf
+func (tree *BPTree) children(pageID uint64) ([]uint64, error) {
+	var children []uint64
+	err := viewPage(tree.pager, pageID, func(page *storage.Page) error {
+		if page.IsLeaf() {
+			return nil
 		}
 
-		currentPageID = leftmostPtr
+		internal := tree.internalPage(page)
+		children = make([]uint64, 0, internal.NumKeys()+1)
+		for i := 0; i <= internal.NumKeys(); i++ {
+			childID, err := internal.GetChild(i)
+			if err != nil {
+				return fmt.Errorf("failed to read child %d of page %d: %w", i, pageID, err)
+			}
+			children = append(children, childID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page %d: %w", pageID, err)
+	}
+	return children, nil
+}
+
+// leafRecords returns the records of a leaf and its NextPage
+func (tree *BPTree) leafRecords(pageID uint64) ([]*storage.Record, uint64, error) {
+	var records []*storage.Record
+	var nextPageID uint64
+	err := viewPage(tree.pager, pageID, func(page *storage.Page) error {
+		var err error
+		records, err = tree.leafPage(page).GetAllRecords()
+		nextPageID = uint64(page.Header.NextPage)
+		return err
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read leaf %d: %w", pageID, err)
 	}
+	return records, nextPageID, nil
 }
 
 // GetRootPageID returns root page ID
 func (tree *BPTree) GetRootPageID() uint64 {
+	tree.rootLatch.RLock()
+	defer tree.rootLatch.RUnlock()
 	return tree.rootPage
 }
 
-// GetOrder returns tree order
+// GetOrder returns the tree order recorded in the superblock, informational only
 func (tree *BPTree) GetOrder() int {
 	return tree.order
 }
 
+// Comparator returns the comparator ordering the tree's keys
+func (tree *BPTree) Comparator() storage.Comparator {
+	return tree.cmp
+}
+
+// leafPage views a page as a leaf ordered by the tree's comparator
+func (tree *BPTree) leafPage(page *storage.Page) *storage.LeafPage {
+	return storage.NewLeafPageWithComparator(page, tree.cmp)
+}
+
+// internalPage views a page as an internal node ordered by the tree's comparator
+func (tree *BPTree) internalPage(page *storage.Page) *storage.InternalPage {
+	return storage.NewInternalPageWithComparator(page, tree.cmp)
+}
+
+// readPageStruct reads a copy of a page the caller may change and write back
+// A buffer pool page is decoded straight from its pinned frame
 func readPageStruct(pager storage.Pager, pageID uint64) (*storage.Page, error) {
-	data, err := pager.ReadPage(pageID)
+	fetcher, ok := pager.(storage.PageFetcher)
+	if !ok {
+		data, err := pager.ReadPage(pageID)
+		if err != nil {
+			return nil, err
+		}
+		return storage.DeserializePage(data)
+	}
+
+	frame, err := fetcher.FetchPage(pageID)
 	if err != nil {
 		return nil, err
 	}
-	return storage.DeserializePage(data)
+	defer frame.Unpin()
+
+	frame.RLock()
+	defer frame.RUnlock()
+	return storage.DeserializePage(frame.Data())
 }
 
+// viewPage calls read with a page over the bytes of its pinned frame, which
+// stays pinned until read returns. The page must not be changed or kept, what
+// read needs afterwards it copies out: records and GetKeyPointer keys already
+// are copies.
+// The frame's latch is only held to decode the header, read may fetch other
+// pages. The page's tree latch, or quiesced writers, keep its bytes still.
+func viewPage(pager storage.Pager, pageID uint64, read func(page *storage.Page) error) error {
+	fetcher, ok := pager.(storage.PageFetcher)
+	if !ok {
+		data, err := pager.ReadPage(pageID)
+		if err != nil {
+			return err
+		}
+		page, err := storage.ViewPage(data)
+		if err != nil {
+			return err
+		}
+		return read(page)
+	}
+
+	frame, err := fetcher.FetchPage(pageID)
+	if err != nil {
+		return err
+	}
+	defer frame.Unpin()
+
+	frame.RLock()
+	page, err := storage.ViewPage(frame.Data())
+	frame.RUnlock()
+	if err != nil {
+		return err
+	}
+	return read(page)
+}
+
+// writePageStruct serializes and writes a page
+// A buffer pool page is serialized straight into its pinned frame, unless
+// the pool failed and went read-only
 func writePageStruct(pager storage.Pager, pageID uint64, page *storage.Page) error {
-	data := page.Serialize()
-	return pager.WritePage(pageID, data)
+	fetcher, ok := pager.(storage.PageFetcher)
+	if !ok {
+		return pager.WritePage(pageID, page.Serialize())
+	}
+	if err := fetcher.Err(); err != nil {
+		return err
+	}
+
+	frame, err := fetcher.FetchPage(pageID)
+	if err != nil {
+		return err
+	}
+	defer frame.Unpin()
+
+	frame.Lock()
+	defer frame.Unlock()
+	page.SerializeTo(frame.Data())
+	frame.MarkDirty()
+	return nil
 }
 
 func allocatePageWithType(pager storage.Pager, pageType storage.PageType) (uint64, *storage.Page, error) {
@@ -642,56 +1056,70 @@ func allocatePageWithType(pager storage.Pager, pageType storage.PageType) (uint6
 	return pageID, page, nil
 }
 
-// sortRecordsByKey sorts records by key (ascending)
-func sortRecordsByKey(records []*storage.Record) {
-	// TODO: Simple bubble sort (good enough for small arrays). Need to change this shit in the future
-	n := len(records)
-	for i := 0; i < n-1; i++ {
-		for j := 0; j < n-i-1; j++ {
-			key1, _ := records[j].GetKeyAsUint32()
-			key2, _ := records[j+1].GetKeyAsUint32()
-			if key1 > key2 {
-				records[j], records[j+1] = records[j+1], records[j]
-			}
-		}
-	}
+// sortRecordsByKey sorts records by key (ascending), keeping the order of
+// versions of the same key
+func sortRecordsByKey(records []*storage.Record, cmp storage.Comparator) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return cmp.Compare(records[i].Key, records[j].Key) < 0
+	})
 }
 
-// SaveMetadata saves tree metadata to a file
-func (tree *BPTree) SaveMetadata(path string) error {
-	file, err := os.Create(path)
-	if err != nil {
-		return fmt.Errorf("failed to create metadata file: %w", err)
+// balancedSplit returns the index splitting sorted records into two runs of
+// about the same number of bytes, slots included, each holding at least one
+// and neither larger than a leaf's room for records
+func balancedSplit(records []*storage.Record, room int) int {
+	totalSize := 0
+	for _, record := range records {
+		totalSize += record.Size() + 2
 	}
-	defer file.Close()
 
-	// Write: rootPageID (8 bytes) + order (4 bytes)
-	data := make([]byte, 12)
-	binary.LittleEndian.PutUint64(data[0:8], tree.rootPage)
-	binary.LittleEndian.PutUint32(data[8:12], uint32(tree.order))
-
-	if _, err := file.Write(data); err != nil {
-		return fmt.Errorf("failed to write metadata: %w", err)
+	splitIndex, size := 0, 0
+	for splitIndex < len(records)-1 && size+records[splitIndex].Size()+2 <= totalSize/2 {
+		size += records[splitIndex].Size() + 2
+		splitIndex++
 	}
 
-	return file.Sync() // Ensure metadata is flushed to disk
+	// Records up to half a leaf can leave the right run too large
+	for splitIndex < len(records)-1 && totalSize-size > room {
+		size += records[splitIndex].Size() + 2
+		splitIndex++
+	}
+	return max(splitIndex, 1)
 }
 
-// LoadMetadata loads tree metadata from a file
-func LoadMetadata(path string) (rootPageID uint64, order int, err error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return 0, 0, err
+// splitPoint returns the index of the key to push up when an internal node's
+// keys are divided into two nodes of about the same number of bytes
+// Each side keeps at least one key when there are three or more.
+func splitPoint(keys [][]byte) int {
+	totalSize := 0
+	for _, key := range keys {
+		totalSize += storage.InternalEntrySize(len(key))
 	}
-	defer file.Close()
 
-	data := make([]byte, 12)
-	if _, err := io.ReadFull(file, data); err != nil {
-		return 0, 0, fmt.Errorf("failed to read metadata: %w", err)
+	middle, size := 0, 0
+	for middle < len(keys)-1 && size+storage.InternalEntrySize(len(keys[middle])) <= totalSize/2 {
+		size += storage.InternalEntrySize(len(keys[middle]))
+		middle++
 	}
+	if len(keys) >= 3 {
+		middle = min(max(middle, 1), len(keys)-2)
+	}
+	return middle
+}
 
-	rootPageID = binary.LittleEndian.Uint64(data[0:8])
-	order = int(binary.LittleEndian.Uint32(data[8:12]))
+// loadValue replaces the inline prefix of an overflowing record with its
+// whole value. The record must come from a leaf that is still latched:
+// overflow pages are freed with the leaf latched exclusively, so the chain
+// can't be reused while it is read.
+func (tree *BPTree) loadValue(record *storage.Record) error {
+	if !record.IsOverflow() {
+		return nil
+	}
 
-	return rootPageID, order, nil
+	value, err := storage.ReadValue(tree.pager, record)
+	if err != nil {
+		return fmt.Errorf("failed to read overflow value: %w", err)
+	}
+	record.Value, record.Overflow, record.ValueSize = value, 0, 0
+	return nil
 }
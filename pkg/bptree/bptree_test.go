@@ -1,8 +1,13 @@
 package bptree
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"math/rand"
 	"os"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/spaghetti-lover/sharingan-db/internal/storage"
@@ -238,7 +243,7 @@ func TestBPTreeSearchPath(t *testing.T) {
 	root.SetLeftmostPointer(leaf1ID)
 
 	// Insert key 100 pointing to leaf2
-	root.InsertEntry(100, leaf2ID)
+	root.InsertEntry(storage.Uint32Key(100), leaf2ID)
 
 	if err := writePageStruct(pager, rootID, rootPage); err != nil {
 		t.Fatalf("Failed to write root: %v", err)
@@ -370,28 +375,28 @@ func TestBPTreeDeepTree(t *testing.T) {
 	// Create 3 internal pages (level 2)
 	internal1ID, internal1Page, _ := allocatePageWithType(pager, storage.PageTypeInternal)
 	internal1 := storage.NewInternalPage(internal1Page)
-	internal1.SetLeftmostPointer(leafPageIDs[0]) // L1
-	internal1.InsertEntry(50, leafPageIDs[1])    // 50 → L2
+	internal1.SetLeftmostPointer(leafPageIDs[0])                 // L1
+	internal1.InsertEntry(storage.Uint32Key(50), leafPageIDs[1]) // 50 → L2
 	writePageStruct(pager, internal1ID, internal1Page)
 
 	internal2ID, internal2Page, _ := allocatePageWithType(pager, storage.PageTypeInternal)
 	internal2 := storage.NewInternalPage(internal2Page)
-	internal2.SetLeftmostPointer(leafPageIDs[2]) // L3
-	internal2.InsertEntry(150, leafPageIDs[3])   // 150 → L4
+	internal2.SetLeftmostPointer(leafPageIDs[2])                  // L3
+	internal2.InsertEntry(storage.Uint32Key(150), leafPageIDs[3]) // 150 → L4
 	writePageStruct(pager, internal2ID, internal2Page)
 
 	internal3ID, internal3Page, _ := allocatePageWithType(pager, storage.PageTypeInternal)
 	internal3 := storage.NewInternalPage(internal3Page)
-	internal3.SetLeftmostPointer(leafPageIDs[4]) // L5
-	internal3.InsertEntry(250, leafPageIDs[5])   // 250 → L6
+	internal3.SetLeftmostPointer(leafPageIDs[4])                  // L5
+	internal3.InsertEntry(storage.Uint32Key(250), leafPageIDs[5]) // 250 → L6
 	writePageStruct(pager, internal3ID, internal3Page)
 
 	// Create root (level 1)
 	rootID, rootPage, _ := allocatePageWithType(pager, storage.PageTypeInternal)
 	root := storage.NewInternalPage(rootPage)
-	root.SetLeftmostPointer(internal1ID) // < 100 → Internal1
-	root.InsertEntry(100, internal2ID)   // [100, 200) → Internal2
-	root.InsertEntry(200, internal3ID)   // >= 200 → Internal3
+	root.SetLeftmostPointer(internal1ID)                  // < 100 → Internal1
+	root.InsertEntry(storage.Uint32Key(100), internal2ID) // [100, 200) → Internal2
+	root.InsertEntry(storage.Uint32Key(200), internal3ID) // >= 200 → Internal3
 	writePageStruct(pager, rootID, rootPage)
 
 	// Create tree
@@ -719,3 +724,486 @@ func TestWALRecovery(t *testing.T) {
 		t.Log("✓ All data recovered successfully")
 	}
 }
+
+func TestWALReplaySkipsAppliedEntries(t *testing.T) {
+	dbFile := "test_replay_lsn.db"
+	walFile := "test_replay_lsn.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	// Phase 1: every write goes straight to disk, so the pages already hold
+	// every logged change when we crash
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+
+		tree, err := NewBPTree(pager, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+
+		for i := uint32(1); i <= 5; i++ {
+			if err := tree.Insert(i, fmt.Sprintf("value-%d", i)); err != nil {
+				t.Fatalf("Failed to insert: %v", err)
+			}
+		}
+		if err := tree.Insert(1, "updated"); err != nil {
+			t.Fatalf("Failed to update: %v", err)
+		}
+		if _, err := tree.Delete(2); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+
+		rootPage, err := readPageStruct(pager, tree.GetRootPageID())
+		if err != nil {
+			t.Fatalf("Failed to read root: %v", err)
+		}
+		if rootPage.Header.PageLSN != 7 {
+			t.Errorf("Root PageLSN = %d, expected 7", rootPage.Header.PageLSN)
+		}
+
+		entries, err := tree.wal.ReadAll()
+		if err != nil {
+			t.Fatalf("Failed to read WAL: %v", err)
+		}
+		for _, entry := range entries {
+			applied, err := tree.entryApplied(entry)
+			if err != nil {
+				t.Fatalf("entryApplied failed: %v", err)
+			}
+			// Inserts of key 1 and key 2 were superseded by later entries
+			expected := entry.LSN > 2
+			if applied != expected {
+				t.Errorf("Entry LSN=%d: applied=%v, expected %v", entry.LSN, applied, expected)
+			}
+		}
+
+		pager.Close()
+	}
+
+	// Phase 2: recovery records the checkpoint LSN and new entries continue after it
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to reopen pager: %v", err)
+		}
+		defer pager.Close()
+
+		tree, err := OpenBPTree(pager, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to open tree: %v", err)
+		}
+		defer tree.Close()
+
+		sb, err := pager.ReadSuperblock()
+		if err != nil {
+			t.Fatalf("Failed to read superblock: %v", err)
+		}
+		// Replay ends with a checkpoint record after the 7 logged operations
+		if sb.CheckpointLSN != 8 {
+			t.Errorf("CheckpointLSN = %d, expected 8", sb.CheckpointLSN)
+		}
+
+		if value, _, _ := tree.Search(1); value != "updated" {
+			t.Errorf("Key=1: value=%s, expected updated", value)
+		}
+		if _, found, _ := tree.Search(2); found {
+			t.Error("Key=2 reappeared after recovery")
+		}
+
+		if err := tree.Insert(6, "value-6"); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+		if lsn := tree.wal.LastLSN(); lsn != 9 {
+			t.Errorf("LSN after recovery = %d, expected 9", lsn)
+		}
+	}
+}
+
+// reverseComparator orders keys from largest to smallest
+type reverseComparator struct{}
+
+func (reverseComparator) Compare(a, b []byte) int { return bytes.Compare(b, a) }
+func (reverseComparator) Name() string            { return "reverse" }
+
+// tenantKey builds a tenant-prefixed path key, its length varies with i
+func tenantKey(i int) []byte {
+	return []byte(fmt.Sprintf("tenant-%02d/users/%s%d", i%7, strings.Repeat("x", i*37%300), i))
+}
+
+// scanKeys collects the keys an iterator returns as strings
+func scanKeys(t *testing.T, it *Iterator) []string {
+	t.Helper()
+	defer it.Close()
+
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.KeyBytes()))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	return keys
+}
+
+func TestBPTreeByteKeys(t *testing.T) {
+	dbFile := "test_byte_keys.db"
+	walFile := "test_byte_keys.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+
+	tree, err := NewBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+
+	// Long keys put few separators in each internal page, so the tree grows deep
+	const numKeys = 3000
+	expected := make(map[string]string, numKeys)
+	for _, i := range rand.New(rand.NewSource(1)).Perm(numKeys) {
+		key := tenantKey(i)
+		expected[string(key)] = fmt.Sprintf("value-%d", i)
+		if err := tree.InsertKey(key, expected[string(key)]); err != nil {
+			t.Fatalf("Failed to insert key=%q: %v", key, err)
+		}
+	}
+	maxKey := bytes.Repeat([]byte{'z'}, storage.MaxKeySize)
+	expected[string(maxKey)] = "max"
+	if err := tree.InsertKey(maxKey, "max"); err != nil {
+		t.Fatalf("Failed to insert %d-byte key: %v", storage.MaxKeySize, err)
+	}
+
+	if err := tree.InsertKey(append(maxKey, 'z'), "too long"); !errors.Is(err, storage.ErrKeyTooLarge) {
+		t.Errorf("Insert of a %d-byte key returned %v, expected ErrKeyTooLarge", storage.MaxKeySize+1, err)
+	}
+	if err := tree.InsertKey(nil, "empty"); !errors.Is(err, storage.ErrEmptyKey) {
+		t.Errorf("Insert of an empty key returned %v, expected ErrEmptyKey", err)
+	}
+
+	// The leftmost path has internal pages on two levels
+	pageID := tree.GetRootPageID()
+	for level := 0; level < 2; level++ {
+		page, err := readPageStruct(pager, pageID)
+		if err != nil {
+			t.Fatalf("Failed to read page %d: %v", pageID, err)
+		}
+		if page.IsLeaf() {
+			t.Fatalf("Tree has %d internal levels after %d inserts, expected at least 2", level, numKeys)
+		}
+		pageID, _ = tree.internalPage(page).GetLeftmostPointer()
+	}
+
+	// Delete two keys out of three, merging leaves and internal pages
+	for i := 0; i < numKeys; i++ {
+		if i%3 == 0 {
+			continue
+		}
+		deleted, err := tree.DeleteKey(tenantKey(i))
+		if err != nil || !deleted {
+			t.Fatalf("DeleteKey(%q) = %v, %v", tenantKey(i), deleted, err)
+		}
+		delete(expected, string(tenantKey(i)))
+	}
+
+	sorted := make([]string, 0, len(expected))
+	for key := range expected {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	checkTree := func(tree *BPTree) {
+		t.Helper()
+		for key, value := range expected {
+			got, found, err := tree.SearchKey([]byte(key))
+			if err != nil || !found || got != value {
+				t.Fatalf("SearchKey(%q) = %q, %v, %v, expected %q", key, got, found, err, value)
+			}
+		}
+		if _, found, _ := tree.SearchKey(tenantKey(1)); found {
+			t.Errorf("Deleted key %q still found", tenantKey(1))
+		}
+
+		if got := scanKeys(t, tree.ScanKeys(nil, nil)); strings.Join(got, ",") != strings.Join(sorted, ",") {
+			t.Errorf("Full scan returned %d keys out of order, expected %d", len(got), len(sorted))
+		}
+
+		// A prefix scan returns exactly one tenant's keys
+		got := scanKeys(t, tree.ScanKeys([]byte("tenant-03/"), []byte("tenant-03/\xff")))
+		var want []string
+		for _, key := range sorted {
+			if strings.HasPrefix(key, "tenant-03/") {
+				want = append(want, key)
+			}
+		}
+		if len(want) == 0 || strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("Prefix scan returned %d keys, expected %d", len(got), len(want))
+		}
+	}
+	checkTree(tree)
+
+	// Recovery replays the byte keys logged since the tree was created
+	pager.Close()
+
+	pager, err = storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen pager: %v", err)
+	}
+	defer pager.Close()
+
+	if _, err := OpenBPTreeWithComparator(pager, 100, walFile, reverseComparator{}); !errors.Is(err, ErrComparatorMismatch) {
+		t.Fatalf("Open with another comparator returned %v, expected ErrComparatorMismatch", err)
+	}
+
+	tree, err = OpenBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen tree: %v", err)
+	}
+	defer tree.Close()
+	checkTree(tree)
+}
+
+func TestBPTreeComparator(t *testing.T) {
+	dbFile := "test_comparator.db"
+	walFile := "test_comparator.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTreeWithComparator(pager, 100, walFile, reverseComparator{})
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+
+	for _, i := range rand.New(rand.NewSource(2)).Perm(500) {
+		if err := tree.InsertKey([]byte(fmt.Sprintf("user-%04d", i)), strings.Repeat("v", 50)); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+
+	// Under the reverse order start is the larger key
+	got := scanKeys(t, tree.ScanKeys([]byte("user-0300"), []byte("user-0100")))
+	if len(got) != 201 || got[0] != "user-0300" || got[200] != "user-0100" {
+		t.Fatalf("Scan returned %d keys from %q, expected 201 keys from user-0300 down to user-0100", len(got), got[0])
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i-1] <= got[i] {
+			t.Fatalf("Scan returned %q before %q", got[i-1], got[i])
+		}
+	}
+
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	tree.Close()
+
+	if _, err := OpenBPTree(pager, 100, walFile); !errors.Is(err, ErrComparatorMismatch) {
+		t.Fatalf("Open with the default comparator returned %v, expected ErrComparatorMismatch", err)
+	}
+
+	tree, err = OpenBPTreeWithComparator(pager, 100, walFile, reverseComparator{})
+	if err != nil {
+		t.Fatalf("Failed to reopen tree: %v", err)
+	}
+	defer tree.Close()
+
+	if value, found, err := tree.SearchKey([]byte("user-0042")); err != nil || !found || value != strings.Repeat("v", 50) {
+		t.Errorf("SearchKey(user-0042) = %q, %v, %v after reopen", value, found, err)
+	}
+}
+
+// jsonBlob builds a JSON document of about size bytes
+func jsonBlob(id, size int) string {
+	return fmt.Sprintf(`{"id":%d,"blob":"%s"}`, id, strings.Repeat(string(rune('a'+id%26)), size))
+}
+
+// overflowPages returns how many overflow pages a value spills into
+func overflowPages(value string) int {
+	chunk := storage.PageSize - storage.PageHeaderSize - 2
+	return (len(value) - storage.OverflowPrefixSize + chunk - 1) / chunk
+}
+
+func TestBPTreeOverflowValues(t *testing.T) {
+	dbFile := "test_overflow_values.db"
+	walFile := "test_overflow_values.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	bufferPool := storage.NewBufferPool(pager, 64)
+	tree, err := NewBPTree(bufferPool, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+
+	// 20KB to 96KB documents, far larger than a leaf
+	expected := make(map[uint32]string)
+	for i := 0; i < 20; i++ {
+		expected[uint32(i)] = jsonBlob(i, 20000+i*4000)
+		if err := tree.Insert(uint32(i), expected[uint32(i)]); err != nil {
+			t.Fatalf("Failed to insert key=%d: %v", i, err)
+		}
+	}
+
+	checkValues := func(tree *BPTree) {
+		t.Helper()
+		for key, value := range expected {
+			got, found, err := tree.Search(key)
+			if err != nil || !found || got != value {
+				t.Fatalf("Search(%d) returned %d bytes, %v, %v, expected %d bytes", key, len(got), found, err, len(value))
+			}
+		}
+
+		it := tree.Scan(0, 19)
+		defer it.Close()
+		for it.Next() {
+			if it.Value() != expected[it.Key()] {
+				t.Fatalf("Scan returned %d bytes for key=%d, expected %d", len(it.Value()), it.Key(), len(expected[it.Key()]))
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+	}
+	checkValues(tree)
+
+	// A snapshot keeps reading the replaced document until it is closed
+	snapshot := tree.Snapshot()
+	old := expected[5]
+	expected[5] = jsonBlob(105, 30000)
+	if err := tree.Insert(5, expected[5]); err != nil {
+		t.Fatalf("Failed to replace key=5: %v", err)
+	}
+	if got, found, err := snapshot.Get(5); err != nil || !found || got != old {
+		t.Errorf("Snapshot read %d bytes of key=5, %v, %v, expected the old %d bytes", len(got), found, err, len(old))
+	}
+	if freed := pager.FreeListSize(); freed != 0 {
+		t.Errorf("%d pages freed while a snapshot can read them", freed)
+	}
+
+	snapshot.Close()
+	if _, err := tree.GarbageCollect(); err != nil {
+		t.Fatalf("GarbageCollect failed: %v", err)
+	}
+	if freed := pager.FreeListSize(); freed != overflowPages(old) {
+		t.Errorf("Garbage collection freed %d pages, expected the old value's %d", freed, overflowPages(old))
+	}
+
+	// Deleting a key frees its document's pages
+	if deleted, err := tree.Delete(6); err != nil || !deleted {
+		t.Fatalf("Delete(6) = %v, %v", deleted, err)
+	}
+	if freed := pager.FreeListSize(); freed != overflowPages(old)+overflowPages(expected[6]) {
+		t.Errorf("Free list has %d pages after delete, expected %d", freed, overflowPages(old)+overflowPages(expected[6]))
+	}
+	delete(expected, 6)
+	checkValues(tree)
+
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	tree.Close()
+
+	tree, err = OpenBPTree(bufferPool, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen tree: %v", err)
+	}
+	defer tree.Close()
+	checkValues(tree)
+
+	// Once a second checkpoint has written out the pages that pointed at
+	// them, freed pages are reused before the file grows
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	numPages, freed := pager.NumPages(), pager.FreeListSize()
+	expected[6] = jsonBlob(106, 20000)
+	if err := tree.Insert(6, expected[6]); err != nil {
+		t.Fatalf("Failed to insert key=6: %v", err)
+	}
+	if pager.NumPages() != numPages || pager.FreeListSize() != freed-overflowPages(expected[6]) {
+		t.Errorf("File grew to %d pages with %d free, expected %d pages with %d free",
+			pager.NumPages(), pager.FreeListSize(), numPages, freed-overflowPages(expected[6]))
+	}
+	checkValues(tree)
+}
+
+// TestBPTreeViewPage checks that reads work on the pinned frame itself and
+// unpin it once done
+func TestBPTreeViewPage(t *testing.T) {
+	dbFile := "test_view_page.db"
+	walFile := "test_view_page.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	const n = 2000
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	// A single shard of 16 frames, far fewer than the tree has pages
+	bufferPool := storage.NewBufferPool(pager, 16)
+	tree, err := NewBPTree(bufferPool, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	for i := uint32(0); i < n; i++ {
+		if err := tree.Insert(i, fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatalf("Failed to insert key=%d: %v", i, err)
+		}
+	}
+
+	rootID := tree.GetRootPageID()
+	frame, err := bufferPool.FetchPage(rootID)
+	if err != nil {
+		t.Fatalf("FetchPage failed: %v", err)
+	}
+	err = viewPage(bufferPool, rootID, func(page *storage.Page) error {
+		if &page.Data[0] != &frame.Data()[storage.PageHeaderSize] {
+			t.Error("View of the root doesn't share its frame's bytes")
+		}
+		return nil
+	})
+	frame.Unpin()
+	if err != nil {
+		t.Fatalf("viewPage failed: %v", err)
+	}
+
+	// Reads that left frames pinned would run out of them long before the end
+	keys, err := tree.InOrderTraversal()
+	if err != nil {
+		t.Fatalf("InOrderTraversal failed: %v", err)
+	}
+	if len(keys) != n {
+		t.Errorf("Traversal returned %d keys, expected %d", len(keys), n)
+	}
+	for i := uint32(0); i < n; i += 97 {
+		if value, found, err := tree.Search(i); err != nil || !found || value != fmt.Sprintf("value-%d", i) {
+			t.Errorf("Search(%d) = (%q, %v, %v)", i, value, found, err)
+		}
+	}
+	if report := checkViolations(t, tree, 0); report.TreePages <= 16 {
+		t.Errorf("Tree has %d pages, expected more than the pool's 16 frames", report.TreePages)
+	}
+}
@@ -0,0 +1,345 @@
+package bptree
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+// ViolationKind classifies an inconsistency Check finds
+type ViolationKind int
+
+const (
+	// ViolationUnreadable is a page that can't be read or decoded
+	ViolationUnreadable ViolationKind = iota
+	// ViolationPageType is a page of the wrong type where the tree expects a node
+	ViolationPageType
+	// ViolationKeyOrder is a key out of order within a page or across leaves
+	ViolationKeyOrder
+	// ViolationSeparator is a key outside the range the separators above it give
+	ViolationSeparator
+	// ViolationParent is a parent pointer that doesn't name the page pointing at it
+	ViolationParent
+	// ViolationLeafChain is a NextPage link that skips, repeats or reorders leaves
+	ViolationLeafChain
+	// ViolationOverflow is an overflow chain that is broken or of the wrong length
+	ViolationOverflow
+	// ViolationReachedTwice is a page more than one pointer leads to
+	ViolationReachedTwice
+	// ViolationLeaked is a page neither in the tree nor on the free list
+	ViolationLeaked
+	// ViolationFreeInUse is a page in the tree that is also on the free list
+	ViolationFreeInUse
+	// ViolationFreeList is a free list entry out of bounds or listed twice
+	ViolationFreeList
+)
+
+// String returns the kind name
+func (k ViolationKind) String() string {
+	switch k {
+	case ViolationUnreadable:
+		return "unreadable"
+	case ViolationPageType:
+		return "page type"
+	case ViolationKeyOrder:
+		return "key order"
+	case ViolationSeparator:
+		return "separator"
+	case ViolationParent:
+		return "parent"
+	case ViolationLeafChain:
+		return "leaf chain"
+	case ViolationOverflow:
+		return "overflow"
+	case ViolationReachedTwice:
+		return "reached twice"
+	case ViolationLeaked:
+		return "leaked"
+	case ViolationFreeInUse:
+		return "free in use"
+	case ViolationFreeList:
+		return "free list"
+	default:
+		return "unknown"
+	}
+}
+
+// Violation is one inconsistency Check found
+type Violation struct {
+	Kind    ViolationKind
+	PageID  uint64 // Page it was found on
+	Message string
+}
+
+// String returns the violation as "page <id>: <kind>: <message>"
+func (v Violation) String() string {
+	return fmt.Sprintf("page %d: %s: %s", v.PageID, v.Kind, v.Message)
+}
+
+// CheckReport is the outcome of Check
+type CheckReport struct {
+	Pages      uint64      // Pages in the file, 0 if the pager doesn't tell
+	TreePages  int         // Pages reached from the root, overflow pages included
+	Leaves     int         // Leaves reached from the root
+	FreePages  int         // Pages on the free list
+	Violations []Violation // Sorted by page
+}
+
+// OK reports whether no violation was found
+func (r *CheckReport) OK() bool {
+	return len(r.Violations) == 0
+}
+
+// Check walks the whole file and reports every inconsistency it finds
+//
+// It verifies that:
+//   - keys are sorted within every page and across leaves
+//   - separators bound the keys of the children between them
+//   - parent pointers name the page pointing at them
+//   - the NextPage chain links every leaf once, in key order
+//   - every page is in the tree or on the free list, never both
+//
+// Writes wait while it runs, reads carry on. The error is only for a check
+// that couldn't run, what it finds wrong is in the report.
+func (tree *BPTree) Check() (*CheckReport, error) {
+	tree.quiesce.Lock()
+	defer tree.quiesce.Unlock()
+
+	c := &checker{
+		tree:    tree,
+		report:  &CheckReport{},
+		reached: make(map[uint64]bool),
+	}
+
+	c.checkPage(tree.rootPage, 0, nil, nil)
+	c.checkLeafChain()
+	c.checkSpace()
+
+	c.report.TreePages = len(c.reached)
+	c.report.Leaves = len(c.leaves)
+	slices.SortStableFunc(c.report.Violations, func(a, b Violation) int {
+		return cmp.Compare(a.PageID, b.PageID)
+	})
+	return c.report, nil
+}
+
+// checker holds the state of one Check
+type checker struct {
+	tree    *BPTree
+	report  *CheckReport
+	reached map[uint64]bool // Pages reached from the root
+	leaves  []checkedLeaf   // Leaves in key order
+	lastKey []byte          // Last key of the leaves so far
+	lastAt  uint64          // Leaf holding lastKey
+}
+
+// checkedLeaf is a leaf the walk reached
+type checkedLeaf struct {
+	pageID uint64
+	next   uint64 // Its NextPage
+}
+
+// add records a violation
+func (c *checker) add(kind ViolationKind, pageID uint64, format string, args ...any) {
+	c.report.Violations = append(c.report.Violations, Violation{
+		Kind:    kind,
+		PageID:  pageID,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// reach marks a page reached from parentID, false if it already was
+func (c *checker) reach(pageID, parentID uint64) bool {
+	if c.reached[pageID] {
+		c.add(ViolationReachedTwice, pageID, "page %d points at it again", parentID)
+		return false
+	}
+	c.reached[pageID] = true
+	return true
+}
+
+// checkPage checks the subtree at pageID, whose keys must fall in [low, high)
+// nil bounds are open
+func (c *checker) checkPage(pageID, parentID uint64, low, high []byte) {
+	if !c.reach(pageID, parentID) {
+		return
+	}
+
+	// Separators are copied out, so the page is unpinned before descending
+	var keys [][]byte
+	var children []uint64
+	err := viewPage(c.tree.pager, pageID, func(page *storage.Page) error {
+		if uint64(page.Header.Parent) != parentID {
+			c.add(ViolationParent, pageID, "parent pointer is %d, page %d points at it", page.Header.Parent, parentID)
+		}
+
+		switch page.Header.PageType {
+		case storage.PageTypeLeaf:
+			c.checkLeaf(pageID, page, low, high)
+		case storage.PageTypeInternal:
+			var err error
+			if keys, children, err = internalEntries(c.tree.internalPage(page)); err != nil {
+				c.add(ViolationUnreadable, pageID, "%v", err)
+			}
+		default:
+			c.add(ViolationPageType, pageID, "%s page where the tree expects a node", page.Header.PageType)
+		}
+		return nil
+	})
+	if err != nil {
+		c.add(ViolationUnreadable, pageID, "%v", err)
+		return
+	}
+
+	if children != nil {
+		c.checkInternal(pageID, keys, children, low, high)
+	}
+}
+
+// checkInternal checks the separators of an internal page and descends
+func (c *checker) checkInternal(pageID uint64, keys [][]byte, children []uint64, low, high []byte) {
+	for i, key := range keys {
+		if i > 0 && c.tree.cmp.Compare(keys[i-1], key) >= 0 {
+			c.add(ViolationKeyOrder, pageID, "separator %s at %d doesn't sort after %s", formatKey(key), i, formatKey(keys[i-1]))
+		}
+		if !c.inRange(key, low, high) {
+			c.add(ViolationSeparator, pageID, "separator %s outside %s", formatKey(key), formatRange(low, high))
+		}
+	}
+
+	for i, childID := range children {
+		childLow, childHigh := low, high
+		if i > 0 {
+			childLow = keys[i-1]
+		}
+		if i < len(keys) {
+			childHigh = keys[i]
+		}
+		c.checkPage(childID, pageID, childLow, childHigh)
+	}
+}
+
+// checkLeaf checks the keys and overflow chains of a leaf
+func (c *checker) checkLeaf(pageID uint64, page *storage.Page, low, high []byte) {
+	records, err := c.tree.leafPage(page).GetAllRecords()
+	if err != nil {
+		c.add(ViolationUnreadable, pageID, "%v", err)
+		return
+	}
+
+	for i, record := range records {
+		// Versions of a key sit side by side, equal keys are fine
+		if i > 0 && c.tree.cmp.Compare(records[i-1].Key, record.Key) > 0 {
+			c.add(ViolationKeyOrder, pageID, "key %s at %d sorts before %s", formatKey(record.Key), i, formatKey(records[i-1].Key))
+		}
+		if !c.inRange(record.Key, low, high) {
+			c.add(ViolationSeparator, pageID, "key %s outside %s", formatKey(record.Key), formatRange(low, high))
+		}
+		if record.IsOverflow() {
+			c.checkOverflow(pageID, record)
+		}
+	}
+
+	// Versions of a key share a leaf, so keys across leaves are strictly ordered
+	if len(records) > 0 {
+		first := records[0].Key
+		if c.lastKey != nil && c.tree.cmp.Compare(c.lastKey, first) >= 0 {
+			c.add(ViolationKeyOrder, pageID, "first key %s doesn't sort after %s, the last key of leaf %d",
+				formatKey(first), formatKey(c.lastKey), c.lastAt)
+		}
+		c.lastKey, c.lastAt = records[len(records)-1].Key, pageID
+	}
+
+	c.leaves = append(c.leaves, checkedLeaf{pageID: pageID, next: uint64(page.Header.NextPage)})
+}
+
+// checkOverflow checks the overflow chain of a record in leafID
+func (c *checker) checkOverflow(leafID uint64, record *storage.Record) {
+	chain, err := storage.OverflowChain(c.tree.pager, record.Overflow)
+	if err != nil {
+		c.add(ViolationOverflow, leafID, "key %s: %v", formatKey(record.Key), err)
+	} else if expected := storage.OverflowPages(record.ValueSize); len(chain) != expected {
+		c.add(ViolationOverflow, leafID, "key %s: %d-byte value in %d overflow pages, expected %d",
+			formatKey(record.Key), record.ValueSize, len(chain), expected)
+	}
+
+	for _, pageID := range chain {
+		c.reach(pageID, leafID)
+	}
+}
+
+// checkLeafChain checks that each leaf's NextPage names the next leaf in key order
+func (c *checker) checkLeafChain() {
+	for i, leaf := range c.leaves {
+		expected := uint64(0)
+		if i+1 < len(c.leaves) {
+			expected = c.leaves[i+1].pageID
+		}
+		if leaf.next != expected {
+			c.add(ViolationLeafChain, leaf.pageID, "NextPage is %d, expected %d", leaf.next, expected)
+		}
+	}
+}
+
+// checkSpace checks that every page is in the tree or on the free list, not both
+func (c *checker) checkSpace() {
+	resizer, ok := c.tree.pager.(storage.Resizer)
+	if !ok {
+		return
+	}
+	numPages := resizer.NumPages()
+	free := resizer.FreePageIDs()
+	c.report.Pages = numPages
+	c.report.FreePages = len(free)
+
+	listed := make(map[uint64]bool, len(free))
+	for _, pageID := range free {
+		switch {
+		case pageID <= storage.SuperblockPageID || pageID >= numPages:
+			c.add(ViolationFreeList, pageID, "free list holds a page outside [%d, %d)", storage.SuperblockPageID+1, numPages)
+		case listed[pageID]:
+			c.add(ViolationFreeList, pageID, "listed twice on the free list")
+		case c.reached[pageID]:
+			c.add(ViolationFreeInUse, pageID, "page is in the tree and on the free list")
+		}
+		listed[pageID] = true
+	}
+
+	for pageID := uint64(storage.SuperblockPageID + 1); pageID < numPages; pageID++ {
+		if !c.reached[pageID] && !listed[pageID] {
+			c.add(ViolationLeaked, pageID, "page is neither in the tree nor on the free list")
+		}
+	}
+}
+
+// inRange reports whether low <= key < high, nil bounds are open
+func (c *checker) inRange(key, low, high []byte) bool {
+	if low != nil && c.tree.cmp.Compare(key, low) < 0 {
+		return false
+	}
+	return high == nil || c.tree.cmp.Compare(key, high) < 0
+}
+
+// formatKey prints a key as text when it is printable, in hex otherwise
+func formatKey(key []byte) string {
+	for _, b := range key {
+		if b < 0x20 || b > 0x7e {
+			return fmt.Sprintf("%x", key)
+		}
+	}
+	return fmt.Sprintf("%q", key)
+}
+
+// formatRange prints the key range [low, high), nil bounds are open
+func formatRange(low, high []byte) string {
+	lowText, highText := "-inf", "+inf"
+	if low != nil {
+		lowText = formatKey(low)
+	}
+	if high != nil {
+		highText = formatKey(high)
+	}
+	return fmt.Sprintf("[%s, %s)", lowText, highText)
+}
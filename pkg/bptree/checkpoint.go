@@ -0,0 +1,83 @@
+package bptree
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+// Checkpoint makes every logged change durable in the data file and clears the WAL
+//
+// Steps:
+//  1. Flush dirty pages (and fsync) through the pager
+//  2. Append a checkpoint record, its LSN covers every earlier entry
+//  3. Persist root, order and checkpoint LSN in the superblock
+//  4. Truncate the WAL, unless transactions are still open
+//
+// A crash between any two steps is safe: replay skips entries at or below
+// the newest checkpoint it can find, in the superblock or in the log.
+// Writes in flight finish first and new ones wait, reads carry on.
+func (tree *BPTree) Checkpoint() error {
+	tree.quiesce.Lock()
+	defer tree.quiesce.Unlock()
+
+	return tree.checkpointLocked()
+}
+
+// checkpointLocked runs a checkpoint, tree.quiesce must be held exclusively
+func (tree *BPTree) checkpointLocked() error {
+	if err := tree.pager.Flush(); err != nil {
+		return fmt.Errorf("failed to flush pages: %w", err)
+	}
+
+	record := &wal.Entry{OpType: wal.OpCheckpoint}
+	if err := tree.wal.Append(record); err != nil {
+		return fmt.Errorf("failed to write checkpoint record: %w", err)
+	}
+
+	sb, err := tree.pager.ReadSuperblock()
+	if err != nil {
+		return fmt.Errorf("failed to read superblock: %w", err)
+	}
+
+	sb.RootPageID = tree.rootPage
+	sb.Order = uint32(tree.order)
+	sb.Comparator = tree.cmp.Name()
+	sb.CheckpointLSN = record.LSN
+
+	if err := tree.pager.WriteSuperblock(sb); err != nil {
+		return fmt.Errorf("failed to update superblock: %w", err)
+	}
+
+	// Open transactions still need their earlier records at commit time,
+	// the log is truncated by a later checkpoint once they finish
+	if tree.ActiveTxs() > 0 {
+		return nil
+	}
+
+	if err := tree.wal.Truncate(); err != nil {
+		return fmt.Errorf("failed to truncate WAL: %w", err)
+	}
+
+	return nil
+}
+
+// WALSize returns the current size of the WAL in bytes
+func (tree *BPTree) WALSize() (int64, error) {
+	return tree.wal.Size()
+}
+
+// WALAge returns how long the oldest un-checkpointed entry has been in the WAL
+func (tree *BPTree) WALAge() time.Duration {
+	return tree.wal.Age()
+}
+
+// CheckpointLSN returns the LSN of the last completed checkpoint
+func (tree *BPTree) CheckpointLSN() (uint64, error) {
+	sb, err := tree.pager.ReadSuperblock()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read superblock: %w", err)
+	}
+	return sb.CheckpointLSN, nil
+}
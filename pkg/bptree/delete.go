@@ -0,0 +1,510 @@
+package bptree
+
+import (
+	"fmt"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+// Delete removes a key from the B+ Tree
+// Returns true if the key existed
+func (tree *BPTree) Delete(key uint32) (bool, error) {
+	return tree.DeleteKey(storage.Uint32Key(key))
+}
+
+// DeleteKey removes a byte-string key from the B+ Tree
+// Returns true if the key existed
+func (tree *BPTree) DeleteKey(key []byte) (bool, error) {
+	walEntry := &wal.Entry{
+		OpType: wal.OpDelete,
+		Key:    key,
+	}
+
+	commit, err := tree.logOp(walEntry)
+	if err != nil {
+		return false, err
+	}
+	defer tree.finishOp()
+
+	if err := commit.Wait(); err != nil {
+		return false, fmt.Errorf("failed to write WAL: %w", err)
+	}
+
+	return tree.deleteWithoutWAL(walEntry.LSN, key)
+}
+
+// DeleteAsync logs and applies a delete without waiting for the WAL fsync
+// The delete is only durable once Wait on the returned commit succeeds
+func (tree *BPTree) DeleteAsync(key uint32) (bool, *wal.Commit, error) {
+	return tree.DeleteKeyAsync(storage.Uint32Key(key))
+}
+
+// DeleteKeyAsync is DeleteAsync for a byte-string key
+func (tree *BPTree) DeleteKeyAsync(key []byte) (bool, *wal.Commit, error) {
+	walEntry := &wal.Entry{
+		OpType: wal.OpDelete,
+		Key:    key,
+	}
+
+	commit, err := tree.logOp(walEntry)
+	if err != nil {
+		return false, nil, err
+	}
+	defer tree.finishOp()
+
+	deleted, err := tree.deleteWithoutWAL(walEntry.LSN, walEntry.Key)
+	if err != nil {
+		return false, nil, err
+	}
+	return deleted, commit, nil
+}
+
+// deleteWithoutWAL deletes without writing to WAL (used during replay)
+// Pages written are stamped with lsn.
+func (tree *BPTree) deleteWithoutWAL(lsn uint64, key []byte) (bool, error) {
+	op := tree.newWriteOp(lsn)
+	defer op.release()
+
+	leafPageID, leafPage, err := op.latchLeaf(key)
+	if err != nil {
+		return false, err
+	}
+
+	leaf := tree.leafPage(leafPage)
+	live, found := leaf.SearchRecord(key)
+	if !found {
+		return false, nil
+	}
+
+	// An open snapshot may still read the old value, keep it as a deleted version
+	live.DeletedBy = lsn
+	if tree.versionNeeded(live) {
+		leaf.MarkDeleted(key, lsn)
+		op.deadVersions++
+	} else {
+		leaf.DeleteRecord(key)
+		op.removed = append(op.removed, live)
+		op.pruneVersions(leaf, key)
+	}
+
+	if err := op.writePage(leafPageID, leafPage); err != nil {
+		return false, err
+	}
+	if err := op.finish(); err != nil {
+		return true, err
+	}
+
+	// Root leaf is allowed to be empty
+	if leafPage.Header.Parent == 0 || !leafUnderflow(leaf) {
+		return true, nil
+	}
+
+	op.release()
+	if err := op.rebalance(key); err != nil {
+		return true, err
+	}
+
+	return true, nil
+}
+
+// rebalance fixes the leaf covering key if it underflows
+// The leaf was written and unlatched since it shrank, so it is checked again
+// with the path latched.
+func (op *writeOp) rebalance(key []byte) error {
+	leafPageID, leafPage, err := op.latchPath(key, deleteSafe)
+	if err != nil {
+		return err
+	}
+
+	if leafPage.Header.Parent == 0 || !leafUnderflow(storage.NewLeafPage(leafPage)) {
+		return nil
+	}
+
+	if err := op.rebalanceLeaf(leafPageID, leafPage); err != nil {
+		return fmt.Errorf("failed to rebalance leaf %d: %w", leafPageID, err)
+	}
+	return nil
+}
+
+// deleteSafe reports whether a page on a rebalance's path can lose an entry,
+// however long its key, without underflowing itself
+func deleteSafe(page *storage.Page) bool {
+	if page.IsLeaf() {
+		return false
+	}
+	internal := storage.NewInternalPage(page)
+	if page.Header.Parent == 0 {
+		// The root only changes once its last key goes
+		return internal.NumKeys() > 1
+	}
+	return internal.UsedSpace()-storage.InternalEntrySize(storage.MaxKeySize) >= internal.Capacity()/2
+}
+
+// leafUnderflow reports whether a leaf is less than half full
+func leafUnderflow(leaf *storage.LeafPage) bool {
+	return leaf.UsedSpace() < leaf.Capacity()/2
+}
+
+// internalUnderflow reports whether an internal node is less than half full
+func internalUnderflow(internal *storage.InternalPage) bool {
+	return internal.UsedSpace() < internal.Capacity()/2
+}
+
+// entriesSize returns the bytes an internal node holding keys uses
+func entriesSize(keys [][]byte) int {
+	size := 0
+	for _, key := range keys {
+		size += storage.InternalEntrySize(len(key))
+	}
+	return size
+}
+
+// siblingPair locates an adjacent sibling of a child under the same parent
+// Returns (leftID, rightID, separatorIndex) where separatorIndex is the parent key between them
+func siblingPair(parent *storage.InternalPage, childID uint64) (uint64, uint64, int, error) {
+	index := parent.ChildIndex(childID)
+	if index < 0 {
+		return 0, 0, 0, fmt.Errorf("page %d not found in parent", childID)
+	}
+
+	if parent.NumKeys() == 0 {
+		return 0, 0, 0, fmt.Errorf("page %d has no siblings", childID)
+	}
+
+	// Prefer left sibling, fall back to right sibling for the leftmost child
+	if index > 0 {
+		leftID, err := parent.GetChild(index - 1)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		return leftID, childID, index - 1, nil
+	}
+
+	rightID, err := parent.GetChild(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return childID, rightID, 0, nil
+}
+
+// rebalanceLeaf fixes an underflowing leaf by merging with or borrowing from a sibling
+// The leaf and its parent are latched, the sibling is latched here
+func (op *writeOp) rebalanceLeaf(pageID uint64, page *storage.Page) error {
+	tree := op.tree
+
+	parentID := uint64(page.Header.Parent)
+	parentPage, err := readPageStruct(tree.pager, parentID)
+	if err != nil {
+		return fmt.Errorf("failed to load parent: %w", err)
+	}
+	parent := tree.internalPage(parentPage)
+
+	leftID, rightID, sepIndex, err := siblingPair(parent, pageID)
+	if err != nil {
+		return err
+	}
+	op.latchSibling(pageID, leftID, rightID)
+
+	leftPage, err := readPageStruct(tree.pager, leftID)
+	if err != nil {
+		return err
+	}
+	rightPage, err := readPageStruct(tree.pager, rightID)
+	if err != nil {
+		return err
+	}
+	left := tree.leafPage(leftPage)
+	right := tree.leafPage(rightPage)
+
+	leftRecords, err := left.GetAllRecords()
+	if err != nil {
+		return err
+	}
+	rightRecords, err := right.GetAllRecords()
+	if err != nil {
+		return err
+	}
+	allRecords := append(leftRecords, rightRecords...)
+
+	// Both pages carry a 2-byte slot count, only one is needed after merging
+	if left.UsedSpace()+right.UsedSpace()-2 <= left.Capacity() {
+		// Merge right into left
+		for _, record := range rightRecords {
+			if err := left.InsertRecord(record); err != nil {
+				return fmt.Errorf("failed to merge into leaf %d: %w", leftID, err)
+			}
+		}
+		leftPage.Header.NextPage = rightPage.Header.NextPage
+
+		if err := op.writePage(leftID, leftPage); err != nil {
+			return err
+		}
+		if err := tree.pager.FreePage(rightID); err != nil {
+			return fmt.Errorf("failed to free leaf %d: %w", rightID, err)
+		}
+
+		if err := parent.RemoveEntry(sepIndex); err != nil {
+			return err
+		}
+		if err := op.writePage(parentID, parentPage); err != nil {
+			return err
+		}
+
+		return op.rebalanceInternal(parentID, parentPage)
+	}
+
+	// Redistribute records so both leaves hold about the same number of bytes
+	splitIndex, err := versionBoundary(allRecords, balancedSplit(allRecords, left.Capacity()-2), tree.cmp)
+	if err != nil {
+		return fmt.Errorf("failed to redistribute leaf %d: %w", leftID, err)
+	}
+
+	// A longer separator may not fit the parent, the leaf is left underfull
+	separator := allRecords[splitIndex].Key
+	if !parent.CanSetKey(sepIndex, separator) {
+		return nil
+	}
+
+	left.Reset()
+	for _, record := range allRecords[:splitIndex] {
+		if err := left.InsertRecord(record); err != nil {
+			return fmt.Errorf("failed to redistribute into leaf %d: %w", leftID, err)
+		}
+	}
+	right.Reset()
+	for _, record := range allRecords[splitIndex:] {
+		if err := right.InsertRecord(record); err != nil {
+			return fmt.Errorf("failed to redistribute into leaf %d: %w", rightID, err)
+		}
+	}
+
+	if err := parent.SetKey(sepIndex, separator); err != nil {
+		return err
+	}
+
+	if err := op.writePage(leftID, leftPage); err != nil {
+		return err
+	}
+	if err := op.writePage(rightID, rightPage); err != nil {
+		return err
+	}
+	return op.writePage(parentID, parentPage)
+}
+
+// rebalanceInternal fixes an underflowing internal node, collapsing the root when it empties
+// The node is latched, and so is its parent when the node isn't safe
+func (op *writeOp) rebalanceInternal(pageID uint64, page *storage.Page) error {
+	tree := op.tree
+	internal := tree.internalPage(page)
+
+	if page.Header.Parent == 0 {
+		if internal.NumKeys() > 0 {
+			return nil
+		}
+
+		// Root has a single child left, pull it up
+		childID, err := internal.GetLeftmostPointer()
+		if err != nil {
+			return err
+		}
+		return op.collapseRoot(pageID, childID)
+	}
+
+	if !internalUnderflow(internal) {
+		return nil
+	}
+
+	parentID := uint64(page.Header.Parent)
+	parentPage, err := readPageStruct(tree.pager, parentID)
+	if err != nil {
+		return fmt.Errorf("failed to load parent: %w", err)
+	}
+	parent := tree.internalPage(parentPage)
+
+	leftID, rightID, sepIndex, err := siblingPair(parent, pageID)
+	if err != nil {
+		return err
+	}
+	op.latchSibling(pageID, leftID, rightID)
+
+	leftPage, err := readPageStruct(tree.pager, leftID)
+	if err != nil {
+		return err
+	}
+	rightPage, err := readPageStruct(tree.pager, rightID)
+	if err != nil {
+		return err
+	}
+	left := tree.internalPage(leftPage)
+	right := tree.internalPage(rightPage)
+
+	separator, _, err := parent.GetKeyPointer(sepIndex)
+	if err != nil {
+		return err
+	}
+
+	// Pull the separator down between the two nodes' entries
+	leftKeys, leftChildren, err := internalEntries(left)
+	if err != nil {
+		return err
+	}
+	rightKeys, rightChildren, err := internalEntries(right)
+	if err != nil {
+		return err
+	}
+	keys := append(append(leftKeys, separator), rightKeys...)
+	children := append(leftChildren, rightChildren...)
+
+	if entriesSize(keys) <= left.Capacity() {
+		// Merge right into left
+		if err := rebuildInternal(left, keys, children); err != nil {
+			return err
+		}
+		for _, childID := range rightChildren {
+			if err := op.setParent(childID, leftID); err != nil {
+				return err
+			}
+		}
+
+		if err := op.writePage(leftID, leftPage); err != nil {
+			return err
+		}
+		if err := tree.pager.FreePage(rightID); err != nil {
+			return fmt.Errorf("failed to free internal page %d: %w", rightID, err)
+		}
+
+		if err := parent.RemoveEntry(sepIndex); err != nil {
+			return err
+		}
+		if err := op.writePage(parentID, parentPage); err != nil {
+			return err
+		}
+
+		return op.rebalanceInternal(parentID, parentPage)
+	}
+
+	// Redistribute: the middle key moves up to become the new separator,
+	// unless it is too long for the parent and the node is left underfull
+	middle := splitPoint(keys)
+	if !parent.CanSetKey(sepIndex, keys[middle]) {
+		return nil
+	}
+	if err := rebuildInternal(left, keys[:middle], children[:middle+1]); err != nil {
+		return err
+	}
+	if err := rebuildInternal(right, keys[middle+1:], children[middle+1:]); err != nil {
+		return err
+	}
+	if err := parent.SetKey(sepIndex, keys[middle]); err != nil {
+		return err
+	}
+
+	// Children that changed sides need their parent pointer updated
+	for i, childID := range children {
+		newParent := leftID
+		if i > middle {
+			newParent = rightID
+		}
+		wasLeft := i < len(leftChildren)
+		if wasLeft != (newParent == leftID) {
+			if err := op.setParent(childID, newParent); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := op.writePage(leftID, leftPage); err != nil {
+		return err
+	}
+	if err := op.writePage(rightID, rightPage); err != nil {
+		return err
+	}
+	return op.writePage(parentID, parentPage)
+}
+
+// collapseRoot shrinks the tree by a level once the root has a single child
+// The child moves into the root's page, so the superblock doesn't change, and
+// its own page is freed. A root down to one key wasn't safe, so
+// tree.rootLatch is held.
+func (op *writeOp) collapseRoot(rootID, childID uint64) error {
+	tree := op.tree
+	if !op.holds(childID) {
+		op.latch(childID)
+	}
+
+	child, err := readPageStruct(tree.pager, childID)
+	if err != nil {
+		return fmt.Errorf("failed to load child of root: %w", err)
+	}
+
+	if !child.IsLeaf() {
+		_, grandchildren, err := internalEntries(tree.internalPage(child))
+		if err != nil {
+			return err
+		}
+		for _, id := range grandchildren {
+			if err := op.setParent(id, rootID); err != nil {
+				return err
+			}
+		}
+	}
+
+	child.Header.Parent = 0
+	if err := op.writePage(rootID, child); err != nil {
+		return err
+	}
+	return tree.pager.FreePage(childID)
+}
+
+// internalEntries returns the keys and child pointers of an internal node
+func internalEntries(internal *storage.InternalPage) ([][]byte, []uint64, error) {
+	keys := make([][]byte, 0, internal.NumKeys())
+	children := make([]uint64, 0, internal.NumKeys()+1)
+
+	leftmost, err := internal.GetLeftmostPointer()
+	if err != nil {
+		return nil, nil, err
+	}
+	children = append(children, leftmost)
+
+	for i := 0; i < internal.NumKeys(); i++ {
+		k, p, err := internal.GetKeyPointer(i)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, k)
+		children = append(children, p)
+	}
+
+	return keys, children, nil
+}
+
+// latchSibling latches whichever of a sibling pair isn't the page already latched
+func (op *writeOp) latchSibling(pageID, leftID, rightID uint64) {
+	if leftID == pageID {
+		op.latch(rightID)
+	} else {
+		op.latch(leftID)
+	}
+}
+
+// rebuildInternal overwrites an internal node with the given keys and children
+// len(children) must be len(keys)+1
+func rebuildInternal(internal *storage.InternalPage, keys [][]byte, children []uint64) error {
+	if len(children) != len(keys)+1 {
+		return fmt.Errorf("invalid internal node: %d keys, %d children", len(keys), len(children))
+	}
+
+	internal.Reset()
+	if err := internal.SetLeftmostPointer(children[0]); err != nil {
+		return err
+	}
+	for i, key := range keys {
+		if err := internal.InsertEntry(key, children[i+1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
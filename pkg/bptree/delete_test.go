@@ -0,0 +1,249 @@
+package bptree
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+func TestBPTreeDeleteSimple(t *testing.T) {
+	dbFile := "test_delete_simple.db"
+	walFile := "test_delete_simple.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	for _, key := range []uint32{10, 20, 30} {
+		if err := tree.Insert(key, fmt.Sprintf("value-%d", key)); err != nil {
+			t.Fatalf("Failed to insert key=%d: %v", key, err)
+		}
+	}
+
+	deleted, err := tree.Delete(20)
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if !deleted {
+		t.Error("Delete(20) returned false for existing key")
+	}
+
+	deleted, err = tree.Delete(999)
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if deleted {
+		t.Error("Delete(999) returned true for missing key")
+	}
+
+	if _, found, _ := tree.Search(20); found {
+		t.Error("Key=20 still found after delete")
+	}
+	for _, key := range []uint32{10, 30} {
+		if _, found, _ := tree.Search(key); !found {
+			t.Errorf("Key=%d lost after deleting another key", key)
+		}
+	}
+}
+
+// TestBPTreeDeleteMergeAndCollapse deletes enough keys to force leaf and
+// internal merges, redistribution and root collapse
+func TestBPTreeDeleteMergeAndCollapse(t *testing.T) {
+	testCases := []struct {
+		name      string
+		numKeys   int
+		valueSize int
+	}{
+		// Many records per leaf: exercises leaf redistribution
+		{"SmallValues", 1000, 100},
+		// Records just small enough to stay inline, one or two per leaf: the
+		// tree grows two internal levels
+		{"LargeValues", 450, 1900},
+		// Values in overflow pages, freed as their keys go
+		{"OverflowValues", 300, 20000},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testDeleteMergeAndCollapse(t, tc.numKeys, tc.valueSize)
+		})
+	}
+}
+
+func testDeleteMergeAndCollapse(t *testing.T, numKeys int, valueSize int) {
+	dbFile := "test_delete_merge.db"
+	walFile := "test_delete_merge.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	bufferPool := storage.NewBufferPool(pager, 256)
+
+	tree, err := NewBPTree(bufferPool, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	// Splits and collapses keep the root on the page the tree was created with
+	rootPageID := tree.GetRootPageID()
+
+	padding := strings.Repeat("x", valueSize)
+	for i := 0; i < numKeys; i++ {
+		if err := tree.Insert(uint32(i), fmt.Sprintf("%d-%s", i, padding)); err != nil {
+			t.Fatalf("Failed to insert key=%d: %v", i, err)
+		}
+	}
+
+	if tree.GetRootPageID() != rootPageID {
+		t.Errorf("Root moved from page %d to %d", rootPageID, tree.GetRootPageID())
+	}
+	rootPage, err := readPageStruct(tree.pager, rootPageID)
+	if err != nil {
+		t.Fatalf("Failed to read root: %v", err)
+	}
+	if rootPage.IsLeaf() {
+		t.Fatal("Expected a multi-level tree before deleting")
+	}
+
+	rng := rand.New(rand.NewSource(42))
+	order := rng.Perm(numKeys)
+
+	// Delete the first half and verify the rest is intact
+	for _, i := range order[:numKeys/2] {
+		deleted, err := tree.Delete(uint32(i))
+		if err != nil {
+			t.Fatalf("Delete(%d) failed: %v", i, err)
+		}
+		if !deleted {
+			t.Fatalf("Delete(%d) returned false", i)
+		}
+	}
+
+	for _, i := range order[numKeys/2:] {
+		value, found, err := tree.Search(uint32(i))
+		if err != nil {
+			t.Fatalf("Search(%d) failed: %v", i, err)
+		}
+		if !found || !strings.HasPrefix(value, fmt.Sprintf("%d-", i)) {
+			t.Fatalf("Key=%d missing or wrong after deletes", i)
+		}
+	}
+
+	keys, err := tree.InOrderTraversal()
+	if err != nil {
+		t.Fatalf("InOrderTraversal failed: %v", err)
+	}
+	if len(keys) != numKeys-numKeys/2 {
+		t.Errorf("Traversal returned %d keys, expected %d", len(keys), numKeys-numKeys/2)
+	}
+	for i := 1; i < len(keys); i++ {
+		if keys[i-1] >= keys[i] {
+			t.Fatalf("Keys not sorted at %d: %d >= %d", i, keys[i-1], keys[i])
+		}
+	}
+
+	// Delete the rest, the tree should collapse back to a single leaf
+	for _, i := range order[numKeys/2:] {
+		if _, err := tree.Delete(uint32(i)); err != nil {
+			t.Fatalf("Delete(%d) failed: %v", i, err)
+		}
+	}
+
+	if tree.GetRootPageID() != rootPageID {
+		t.Errorf("Root moved from page %d to %d", rootPageID, tree.GetRootPageID())
+	}
+	rootPage, err = readPageStruct(tree.pager, rootPageID)
+	if err != nil {
+		t.Fatalf("Failed to read root: %v", err)
+	}
+	if !rootPage.IsLeaf() || rootPage.Header.NumKeys != 0 {
+		t.Errorf("Expected empty leaf root, got %s", rootPage)
+	}
+	if rootPage.Header.Parent != 0 {
+		t.Errorf("Root has parent pointer %d", rootPage.Header.Parent)
+	}
+
+	if pager.FreeListSize() == 0 {
+		t.Error("Expected freed pages on the free list")
+	}
+
+	t.Logf("✓ Tree collapsed to root %d, %d pages freed", tree.GetRootPageID(), pager.FreeListSize())
+}
+
+func TestBPTreeDeleteWALReplay(t *testing.T) {
+	dbFile := "test_delete_replay.db"
+	walFile := "test_delete_replay.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	var rootPageID uint64
+
+	// Phase 1: insert and delete, then crash without closing the tree
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+
+		tree, err := NewBPTree(pager, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+		rootPageID = tree.GetRootPageID()
+
+		for i := uint32(1); i <= 5; i++ {
+			if err := tree.Insert(i, "value"); err != nil {
+				t.Fatalf("Failed to insert: %v", err)
+			}
+		}
+		if _, err := tree.Delete(3); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+
+		pager.Close()
+	}
+
+	// Phase 2: replay must re-apply the delete
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to reopen pager: %v", err)
+		}
+		defer pager.Close()
+
+		tree, err := LoadBPTree(pager, rootPageID, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to load tree: %v", err)
+		}
+		defer tree.Close()
+
+		if _, found, _ := tree.Search(3); found {
+			t.Error("Key=3 reappeared after WAL replay")
+		}
+		for _, key := range []uint32{1, 2, 4, 5} {
+			if _, found, _ := tree.Search(key); !found {
+				t.Errorf("Key=%d not found after WAL replay", key)
+			}
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package bptree
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+// Iterator is a cursor over a key range of the B+ Tree
+// Leaves are copied one at a time. When a leaf is used up the next one is
+// found by descending from the root to the smallest key past it, so splits
+// and merges made between calls to Next don't make the cursor skip or
+// repeat keys. Writers aren't blocked while a cursor is open.
+//
+// Usage:
+//
+//	it := tree.Scan(10, 20)
+//	defer it.Close()
+//	for it.Next() {
+//		fmt.Println(it.KeyBytes(), it.Value())
+//	}
+//	if err := it.Err(); err != nil { ... }
+type Iterator struct {
+	tree     *BPTree
+	start    []byte
+	end      []byte            // nil for no upper bound
+	records  []*storage.Record // Records of the current leaf
+	pos      int               // Next record to return in records
+	high     []byte            // Smallest key past the current leaf
+	hasHigh  bool              // False on the rightmost leaf
+	static   bool              // records hold the whole result, don't load leaves
+	snapshot *Snapshot         // Read view, nil to read the latest versions
+	key      []byte
+	value    string
+	err      error
+	done     bool
+}
+
+// Scan returns an iterator over keys in [start, end] (inclusive)
+func (tree *BPTree) Scan(start, end uint32) *Iterator {
+	return tree.ScanKeys(storage.Uint32Key(start), storage.Uint32Key(end))
+}
+
+// ScanKeys returns an iterator over byte-string keys in [start, end]
+// (inclusive), a nil start or end leaves that side unbounded
+func (tree *BPTree) ScanKeys(start, end []byte) *Iterator {
+	return tree.scan(start, end, nil)
+}
+
+// scan returns an iterator over [start, end] as of snapshot, nil for the
+// latest versions
+// The snapshot is set before the first seek, which loads the values it sees.
+func (tree *BPTree) scan(start, end []byte, snapshot *Snapshot) *Iterator {
+	it := &Iterator{
+		tree:     tree,
+		start:    start,
+		end:      end,
+		snapshot: snapshot,
+	}
+
+	if it.pastEnd(start) {
+		it.done = true
+		return it
+	}
+
+	it.seek(start)
+	return it
+}
+
+// pastEnd reports whether key sorts after the end of the range
+func (it *Iterator) pastEnd(key []byte) bool {
+	return it.end != nil && it.tree.cmp.Compare(key, it.end) > 0
+}
+
+// seek loads the leaf covering key and positions the cursor at key
+// The leaves after it are read ahead when the pager supports it.
+func (it *Iterator) seek(key []byte) {
+	prefetcher, _ := it.tree.pager.(storage.Prefetcher)
+	ahead := 0
+	if prefetcher != nil {
+		ahead = int(it.tree.readAhead.Load())
+	}
+
+	var records []*storage.Record
+	high, hasHigh, next, err := it.tree.readLeafAhead(key, it.end, ahead, func(leaf *storage.LeafPage) error {
+		var err error
+		if records, err = leaf.GetAllRecords(); err != nil {
+			return fmt.Errorf("failed to get records from leaf: %w", err)
+		}
+
+		// Values in range are read while the leaf is latched
+		for _, record := range records {
+			if it.tree.cmp.Compare(record.Key, key) < 0 || !it.visible(record) {
+				continue
+			}
+			if it.pastEnd(record.Key) {
+				break
+			}
+			if err := it.tree.loadValue(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		it.err = fmt.Errorf("failed to find leaf page: %w", err)
+		return
+	}
+	if len(next) > 0 {
+		prefetcher.Prefetch(next)
+	}
+
+	it.records = records
+	it.pos = 0
+	it.high = high
+	it.hasHigh = hasHigh
+
+	// Keys below the seek key were returned from the previous leaf, or
+	// are below start
+	for it.pos < len(it.records) && it.tree.cmp.Compare(it.records[it.pos].Key, key) < 0 {
+		it.pos++
+	}
+}
+
+// advanceLeaf loads the leaf holding the keys after the current one
+func (it *Iterator) advanceLeaf() bool {
+	if it.static || !it.hasHigh || it.pastEnd(it.high) {
+		return false
+	}
+
+	it.seek(it.high)
+	return true
+}
+
+// Next advances to the next key in range, returns false when exhausted or on error
+func (it *Iterator) Next() bool {
+	if it.snapshot != nil && it.snapshot.closed && !it.done {
+		it.err = ErrSnapshotClosed
+	}
+
+	for !it.done && it.err == nil {
+		if it.pos >= len(it.records) {
+			if !it.advanceLeaf() {
+				it.done = true
+				return false
+			}
+			continue
+		}
+
+		record := it.records[it.pos]
+		it.pos++
+
+		if !it.visible(record) {
+			continue
+		}
+
+		if it.pastEnd(record.Key) {
+			it.done = true
+			return false
+		}
+
+		it.key = record.Key
+		it.value = record.GetValueAsString()
+		return true
+	}
+
+	return false
+}
+
+// visible reports whether the iterator's read view sees a version
+func (it *Iterator) visible(record *storage.Record) bool {
+	if it.snapshot != nil {
+		return it.snapshot.visible(record)
+	}
+	return record.IsLive()
+}
+
+// Key returns the key at the current position decoded as a uint32 key
+//
+// Deprecated: Key reads a key that isn't 4 bytes long as 0, use Uint32Key or
+// KeyBytes instead.
+func (it *Iterator) Key() uint32 {
+	key, _ := it.Uint32Key()
+	return key
+}
+
+// Uint32Key returns the key at the current position decoded as a uint32 key,
+// false if it isn't 4 bytes long
+func (it *Iterator) Uint32Key() (uint32, bool) {
+	if len(it.key) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(it.key), true
+}
+
+// KeyBytes returns the key at the current position
+func (it *Iterator) KeyBytes() []byte {
+	return it.key
+}
+
+// Value returns the value at the current position
+func (it *Iterator) Value() string {
+	return it.value
+}
+
+// Err returns the first error encountered during iteration
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the iterator
+func (it *Iterator) Close() error {
+	it.done = true
+	it.records = nil
+	return nil
+}
@@ -0,0 +1,97 @@
+package bptree
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+func TestBPTreeScan(t *testing.T) {
+	dbFile := "test_scan.db"
+	walFile := "test_scan.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	bufferPool := storage.NewBufferPool(pager, 64)
+
+	tree, err := NewBPTree(bufferPool, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	// Even keys only, enough to span many leaves
+	for i := uint32(0); i < 2000; i += 2 {
+		if err := tree.Insert(i, fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatalf("Failed to insert key=%d: %v", i, err)
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		start    uint32
+		end      uint32
+		expected []uint32
+	}{
+		{"SingleKey", 10, 10, []uint32{10}},
+		{"OddBounds", 9, 15, []uint32{10, 12, 14}},
+		{"MissingKey", 11, 11, nil},
+		{"Reversed", 20, 10, nil},
+		{"BeyondMax", 1996, 5000, []uint32{1996, 1998}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			it := tree.Scan(tc.start, tc.end)
+			defer it.Close()
+
+			var keys []uint32
+			for it.Next() {
+				if it.Value() != fmt.Sprintf("value-%d", it.Key()) {
+					t.Errorf("Key=%d: value=%s", it.Key(), it.Value())
+				}
+				keys = append(keys, it.Key())
+			}
+			if err := it.Err(); err != nil {
+				t.Fatalf("Scan failed: %v", err)
+			}
+
+			if len(keys) != len(tc.expected) {
+				t.Fatalf("Scan(%d, %d) returned %v, expected %v", tc.start, tc.end, keys, tc.expected)
+			}
+			for i := range keys {
+				if keys[i] != tc.expected[i] {
+					t.Errorf("Key[%d] = %d, expected %d", i, keys[i], tc.expected[i])
+				}
+			}
+		})
+	}
+
+	// A range crossing many leaves must return every key exactly once
+	t.Run("AcrossLeaves", func(t *testing.T) {
+		it := tree.Scan(100, 1899)
+		defer it.Close()
+
+		expected := uint32(100)
+		for it.Next() {
+			if it.Key() != expected {
+				t.Fatalf("Key = %d, expected %d", it.Key(), expected)
+			}
+			expected += 2
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		if expected != 1900 {
+			t.Errorf("Scan stopped before key %d", expected)
+		}
+	})
+}
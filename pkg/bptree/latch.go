@@ -0,0 +1,398 @@
+package bptree
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+// latchTable hands out a read/write latch per page
+// Latches are short-lived and only protect a page's contents while it is
+// read or changed, unlike the key locks transactions hold until commit.
+type latchTable struct {
+	mu      sync.Mutex
+	latches map[uint64]*pageLatch
+}
+
+// pageLatch is a page latch and the number of goroutines using it
+type pageLatch struct {
+	sync.RWMutex
+	refs int
+}
+
+// newLatchTable creates an empty latch table
+func newLatchTable() *latchTable {
+	return &latchTable{latches: make(map[uint64]*pageLatch)}
+}
+
+// acquire latches a page, shared or exclusive
+func (lt *latchTable) acquire(pageID uint64, exclusive bool) {
+	lt.mu.Lock()
+	latch, ok := lt.latches[pageID]
+	if !ok {
+		latch = &pageLatch{}
+		lt.latches[pageID] = latch
+	}
+	latch.refs++
+	lt.mu.Unlock()
+
+	if exclusive {
+		latch.Lock()
+	} else {
+		latch.RLock()
+	}
+}
+
+// release unlatches a page, dropping the latch once nobody uses it
+func (lt *latchTable) release(pageID uint64, exclusive bool) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	latch := lt.latches[pageID]
+	if exclusive {
+		latch.Unlock()
+	} else {
+		latch.RUnlock()
+	}
+
+	if latch.refs--; latch.refs == 0 {
+		delete(lt.latches, pageID)
+	}
+}
+
+// users returns how many goroutines hold or wait for the latch of a page
+func (lt *latchTable) users(pageID uint64) int {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if latch, ok := lt.latches[pageID]; ok {
+		return latch.refs
+	}
+	return 0
+}
+
+// drainReaders waits for the readers that entered the tree at rootID before
+// the root changed to leave it
+// Every page is latched exclusively below its latched parent, and readers
+// only move down, so none slips past. A reader that read rootID but hadn't
+// latched it yet is still a user of its latch, the walk is repeated until
+// none is left. Writers must be quiesced.
+func (tree *BPTree) drainReaders(rootID uint64) error {
+	for {
+		tree.latches.acquire(rootID, true)
+		err := tree.drainSubtree(rootID)
+		waiting := tree.latches.users(rootID) > 1
+		tree.latches.release(rootID, true)
+
+		if err != nil || !waiting {
+			return err
+		}
+	}
+}
+
+// drainSubtree latches every page under pageID exclusively in turn, pageID
+// must be latched exclusively
+func (tree *BPTree) drainSubtree(pageID uint64) error {
+	children, err := tree.children(pageID)
+	if err != nil {
+		return err
+	}
+
+	for _, childID := range children {
+		tree.latches.acquire(childID, true)
+		err = tree.drainSubtree(childID)
+		tree.latches.release(childID, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLeaf descends to the leaf covering key with latch crabbing: each child
+// is latched before its parent is released, so the path can't change under
+// the descent. visit reads the leaf in its pinned frame while it is still
+// latched, so the overflow pages of its records can't be freed under it.
+func (tree *BPTree) readLeaf(key []byte, visit func(*storage.LeafPage) error) error {
+	_, _, _, err := tree.readLeafAhead(key, nil, 0, visit)
+	return err
+}
+
+// readLeafAhead is readLeaf for scans, also returning up to ahead leaves that
+// follow the one found, stopping past the leaf covering end, nil for no end.
+// They are the leaf's right siblings under the same parent, no further.
+// high is the smallest key that belongs to a later leaf, hasHigh is false
+// for the rightmost leaf.
+func (tree *BPTree) readLeafAhead(key, end []byte, ahead int, visit func(*storage.LeafPage) error) (high []byte, hasHigh bool, next []uint64, err error) {
+	tree.rootLatch.RLock()
+	pageID := tree.rootPage
+	tree.latches.acquire(pageID, false)
+	tree.rootLatch.RUnlock()
+
+	for {
+		var childID uint64
+		leaf := false
+		err := viewPage(tree.pager, pageID, func(page *storage.Page) error {
+			if page.IsLeaf() {
+				leaf = true
+				return visit(tree.leafPage(page))
+			}
+
+			internal := tree.internalPage(page)
+			var err error
+			if childID, err = internal.SearchChild(key); err != nil {
+				return fmt.Errorf("failed to search child in page %d: %w", pageID, err)
+			}
+
+			// The separator right of the child bounds every key below it
+			index := internal.ChildIndex(childID)
+			if index < internal.NumKeys() {
+				separator, _, _ := internal.GetKeyPointer(index)
+				high, hasHigh = separator, true
+			}
+
+			// Siblings are only leaves on the last level, keep the latest ones
+			next = next[:0]
+			for i := index; i < internal.NumKeys() && len(next) < ahead; i++ {
+				low, siblingID, err := internal.GetKeyPointer(i)
+				if err != nil || (end != nil && tree.cmp.Compare(low, end) > 0) {
+					break
+				}
+				next = append(next, siblingID)
+			}
+			return nil
+		})
+		if leaf {
+			tree.latches.release(pageID, false)
+			return high, hasHigh, next, err
+		}
+		if err != nil {
+			tree.latches.release(pageID, false)
+			return nil, false, nil, fmt.Errorf("failed to read page %d: %w", pageID, err)
+		}
+
+		tree.latches.acquire(childID, false)
+		tree.latches.release(pageID, false)
+		pageID = childID
+	}
+}
+
+// writeOp is an insert or delete being applied to the tree
+// It carries the LSN stamped on the pages it writes and the exclusive
+// latches it holds, root side first.
+type writeOp struct {
+	tree         *BPTree
+	lsn          uint64
+	rootLatched  bool              // tree.rootLatch is held, the root may change
+	latched      []uint64          // Pages latched exclusively, root side first
+	deadVersions int               // Change to tree.deadVersions once the writes land
+	removed      []*storage.Record // Records removed from leaves, their overflow pages are freed once the writes land
+}
+
+// newWriteOp starts a write stamped with lsn
+func (tree *BPTree) newWriteOp(lsn uint64) *writeOp {
+	return &writeOp{tree: tree, lsn: lsn}
+}
+
+// latch latches a page exclusively until the operation releases it
+func (op *writeOp) latch(pageID uint64) {
+	op.tree.latches.acquire(pageID, true)
+	op.latched = append(op.latched, pageID)
+}
+
+// holds reports whether the operation has the page latched
+func (op *writeOp) holds(pageID uint64) bool {
+	for _, id := range op.latched {
+		if id == pageID {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseAncestors drops every latch above the last page latched
+// Called once that page is safe: changes below it can't reach its parents
+func (op *writeOp) releaseAncestors() {
+	if op.rootLatched {
+		op.tree.rootLatch.Unlock()
+		op.rootLatched = false
+	}
+	if len(op.latched) > 1 {
+		for _, id := range op.latched[:len(op.latched)-1] {
+			op.tree.latches.release(id, true)
+		}
+		op.latched = op.latched[len(op.latched)-1:]
+	}
+}
+
+// release drops every latch the operation holds
+func (op *writeOp) release() {
+	if op.rootLatched {
+		op.tree.rootLatch.Unlock()
+		op.rootLatched = false
+	}
+	for _, id := range op.latched {
+		op.tree.latches.release(id, true)
+	}
+	op.latched = nil
+}
+
+// discard releases the latches and forgets changes that weren't written,
+// so the operation can start over down a different path
+func (op *writeOp) discard() {
+	op.release()
+	op.deadVersions = 0
+	op.removed = nil
+}
+
+// finish accounts for the operation's writes once they have landed: the
+// change in deleted versions kept, and the overflow pages of removed records
+// going back to the free list
+func (op *writeOp) finish() error {
+	op.tree.addDeadVersions(op.deadVersions)
+	op.deadVersions = 0
+
+	removed := op.removed
+	op.removed = nil
+	for _, record := range removed {
+		if err := storage.FreeOverflow(op.tree.pager, record); err != nil {
+			return fmt.Errorf("failed to free value of removed record: %w", err)
+		}
+	}
+	return nil
+}
+
+// latchLeaf latches the leaf covering key exclusively, crabbing down with
+// shared latches. Enough for writes that stay inside the leaf.
+func (op *writeOp) latchLeaf(key []byte) (uint64, *storage.Page, error) {
+	tree := op.tree
+
+	tree.rootLatch.RLock()
+	pageID := tree.rootPage
+	exclusive, err := tree.isLeaf(pageID)
+	if err != nil {
+		tree.rootLatch.RUnlock()
+		return 0, nil, err
+	}
+	tree.latches.acquire(pageID, exclusive)
+	tree.rootLatch.RUnlock()
+
+	for {
+		leaf, childID, err := op.descend(pageID, key, nil)
+		if err != nil {
+			tree.latches.release(pageID, exclusive)
+			return 0, nil, err
+		}
+
+		if leaf != nil {
+			op.latched = append(op.latched, pageID)
+			return pageID, leaf, nil
+		}
+
+		childExclusive, err := tree.isLeaf(childID)
+		if err != nil {
+			tree.latches.release(pageID, exclusive)
+			return 0, nil, err
+		}
+		tree.latches.acquire(childID, childExclusive)
+		tree.latches.release(pageID, exclusive)
+		pageID, exclusive = childID, childExclusive
+	}
+}
+
+// isLeaf reports whether a page is a leaf
+// A page keeps its type while its parent, or rootLatch for the root, is latched
+func (tree *BPTree) isLeaf(pageID uint64) (bool, error) {
+	leaf := false
+	err := viewPage(tree.pager, pageID, func(page *storage.Page) error {
+		leaf = page.IsLeaf()
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to read page %d: %w", pageID, err)
+	}
+	return leaf, nil
+}
+
+// latchPath latches the path to the leaf covering key exclusively, keeping an
+// ancestor latched only while the pages below it aren't safe, meaning a split
+// or merge of theirs could change it
+func (op *writeOp) latchPath(key []byte, safe func(*storage.Page) bool) (uint64, *storage.Page, error) {
+	tree := op.tree
+
+	tree.rootLatch.Lock()
+	op.rootLatched = true
+	pageID := tree.rootPage
+
+	for {
+		op.latch(pageID)
+		isSafe := false
+		leaf, childID, err := op.descend(pageID, key, func(page *storage.Page) {
+			isSafe = safe(page)
+		})
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if isSafe {
+			op.releaseAncestors()
+		}
+
+		if leaf != nil {
+			return pageID, leaf, nil
+		}
+		pageID = childID
+	}
+}
+
+// descend reads a page on the way to the leaf covering key, inspect, unless
+// nil, sees it first. An internal page is only viewed in its frame for the child to
+// follow, a leaf is returned as a copy the operation changes and writes back.
+func (op *writeOp) descend(pageID uint64, key []byte, inspect func(*storage.Page)) (*storage.Page, uint64, error) {
+	var leaf *storage.Page
+	var childID uint64
+	err := viewPage(op.tree.pager, pageID, func(page *storage.Page) error {
+		if inspect != nil {
+			inspect(page)
+		}
+		if page.IsLeaf() {
+			leaf = page.Clone()
+			return nil
+		}
+
+		var err error
+		if childID, err = op.tree.internalPage(page).SearchChild(key); err != nil {
+			return fmt.Errorf("failed to search child: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read page %d: %w", pageID, err)
+	}
+	return leaf, childID, nil
+}
+
+// writePage writes a page, stamping it with the operation's LSN
+func (op *writeOp) writePage(pageID uint64, page *storage.Page) error {
+	if op.lsn > page.Header.PageLSN {
+		page.Header.PageLSN = op.lsn
+	}
+	return writePageStruct(op.tree.pager, pageID, page)
+}
+
+// setParent updates the parent pointer stored in a child page
+// A child outside the latched path is latched just for the update
+func (op *writeOp) setParent(childID uint64, parentID uint64) error {
+	if !op.holds(childID) {
+		op.tree.latches.acquire(childID, true)
+		defer op.tree.latches.release(childID, true)
+	}
+
+	child, err := readPageStruct(op.tree.pager, childID)
+	if err != nil {
+		return fmt.Errorf("failed to load child %d: %w", childID, err)
+	}
+	child.Header.Parent = uint32(parentID)
+	return op.writePage(childID, child)
+}
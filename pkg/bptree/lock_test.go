@@ -0,0 +1,197 @@
+package bptree
+
+import (
+	"math/rand"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockAsync requests a lock in the background, the result arrives on the channel
+func lockAsync(lm *LockManager, owner uint64, key uint32, mode LockMode) <-chan error {
+	result := make(chan error, 1)
+	go func() { result <- lm.Lock(owner, key, mode) }()
+	return result
+}
+
+// expectBlocked fails if the request finished within a short grace period
+func expectBlocked(t *testing.T, result <-chan error) {
+	t.Helper()
+	select {
+	case err := <-result:
+		t.Fatalf("Lock returned %v, expected it to wait", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestLockManager(t *testing.T) {
+	t.Run("SharedIsCompatible", func(t *testing.T) {
+		lm := NewLockManager()
+		if err := lm.Lock(1, 10, LockShared); err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		if err := lm.Lock(2, 10, LockShared); err != nil {
+			t.Fatalf("Second shared lock failed: %v", err)
+		}
+
+		result := lockAsync(lm, 3, 10, LockExclusive)
+		expectBlocked(t, result)
+
+		lm.ReleaseAll(1)
+		expectBlocked(t, result)
+		lm.ReleaseAll(2)
+		if err := <-result; err != nil {
+			t.Fatalf("Exclusive lock failed after release: %v", err)
+		}
+		if mode, ok := lm.Held(3, 10); !ok || mode != LockExclusive {
+			t.Errorf("Held(3, 10) = (%s, %v), expected (X, true)", mode, ok)
+		}
+	})
+
+	t.Run("Upgrade", func(t *testing.T) {
+		lm := NewLockManager()
+		lm.Lock(1, 10, LockShared)
+		lm.Lock(2, 10, LockShared)
+
+		result := lockAsync(lm, 1, 10, LockExclusive)
+		expectBlocked(t, result)
+
+		lm.ReleaseAll(2)
+		if err := <-result; err != nil {
+			t.Fatalf("Upgrade failed: %v", err)
+		}
+
+		// Re-requesting a weaker mode keeps the stronger one
+		lm.Lock(1, 10, LockShared)
+		if mode, _ := lm.Held(1, 10); mode != LockExclusive {
+			t.Errorf("Mode after shared re-request = %s, expected X", mode)
+		}
+	})
+
+	t.Run("Deadlock", func(t *testing.T) {
+		lm := NewLockManager()
+		lm.Lock(1, 10, LockExclusive)
+		lm.Lock(2, 20, LockExclusive)
+
+		result := lockAsync(lm, 1, 20, LockExclusive)
+		expectBlocked(t, result)
+
+		// 2 waiting for 10 closes the cycle, so 2 is the victim
+		if err := lm.Lock(2, 10, LockExclusive); err != ErrDeadlock {
+			t.Fatalf("Lock returned %v, expected ErrDeadlock", err)
+		}
+		lm.ReleaseAll(2)
+
+		if err := <-result; err != nil {
+			t.Fatalf("Survivor's lock failed: %v", err)
+		}
+		if lm.Deadlocks() != 1 {
+			t.Errorf("Deadlocks = %d, expected 1", lm.Deadlocks())
+		}
+	})
+
+	t.Run("UpgradeDeadlock", func(t *testing.T) {
+		lm := NewLockManager()
+		lm.Lock(1, 10, LockShared)
+		lm.Lock(2, 10, LockShared)
+
+		result := lockAsync(lm, 1, 10, LockExclusive)
+		expectBlocked(t, result)
+
+		if err := lm.Lock(2, 10, LockExclusive); err != ErrDeadlock {
+			t.Fatalf("Second upgrade returned %v, expected ErrDeadlock", err)
+		}
+		lm.ReleaseAll(2)
+
+		if err := <-result; err != nil {
+			t.Fatalf("First upgrade failed: %v", err)
+		}
+	})
+}
+
+func TestLockManagerStress(t *testing.T) {
+	lm := NewLockManager()
+
+	const (
+		numWorkers = 16
+		numRounds  = 200
+		numKeys    = 8
+	)
+
+	// Each round locks a few random keys in random order and modes, a
+	// deadlock victim releases everything and starts over. Exclusive
+	// holders check nobody else is inside their key.
+	var inside [numKeys]int32
+	var insideMu sync.Mutex
+
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(w)))
+
+			for round := 0; round < numRounds; round++ {
+				owner := uint64(w*numRounds + round + 1)
+
+				for {
+					var exclusive []uint32
+					ok := true
+					for i := 0; i < 3; i++ {
+						key := uint32(rng.Intn(numKeys))
+						mode := LockMode(rng.Intn(2))
+						if err := lm.Lock(owner, key, mode); err != nil {
+							if err != ErrDeadlock {
+								t.Errorf("Lock failed: %v", err)
+							}
+							ok = false
+							break
+						}
+						if mode == LockExclusive && !slices.Contains(exclusive, key) {
+							exclusive = append(exclusive, key)
+						}
+					}
+
+					if ok {
+						insideMu.Lock()
+						for _, key := range exclusive {
+							if inside[key]++; inside[key] != 1 {
+								t.Errorf("Key %d held exclusively by two owners", key)
+							}
+						}
+						insideMu.Unlock()
+
+						runtime.Gosched()
+
+						insideMu.Lock()
+						for _, key := range exclusive {
+							inside[key]--
+						}
+						insideMu.Unlock()
+					}
+
+					lm.ReleaseAll(owner)
+					if ok {
+						break
+					}
+				}
+			}
+		}(w)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("Workers stuck, a deadlock went undetected")
+	}
+
+	t.Logf("%d deadlocks broken", lm.Deadlocks())
+}
@@ -0,0 +1,225 @@
+package bptree
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+func TestSnapshotIsolation(t *testing.T) {
+	dbFile := "test_snapshot.db"
+	walFile := "test_snapshot.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(storage.NewBufferPool(pager, 64), 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	for i := uint32(0); i < 10; i++ {
+		if err := tree.Insert(i, fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+
+	snap := tree.Snapshot()
+
+	// Update, delete and insert after the snapshot was taken
+	tree.Insert(1, "updated")
+	tree.Insert(1, "updated-twice")
+	tree.Delete(2)
+	tree.Insert(100, "new")
+
+	t.Run("Get", func(t *testing.T) {
+		for key, expected := range map[uint32]string{1: "value-1", 2: "value-2", 3: "value-3"} {
+			value, found, err := snap.Get(key)
+			if err != nil || !found || value != expected {
+				t.Errorf("Snapshot Get(%d) = (%q, %v, %v), expected %q", key, value, found, err, expected)
+			}
+		}
+		if _, found, _ := snap.Get(100); found {
+			t.Error("Snapshot sees key 100 inserted after it")
+		}
+
+		if value, _, _ := tree.Search(1); value != "updated-twice" {
+			t.Errorf("Search(1) = %s, expected the latest version", value)
+		}
+		if _, found, _ := tree.Search(2); found {
+			t.Error("Deleted key 2 still visible to latest reads")
+		}
+	})
+
+	t.Run("Scan", func(t *testing.T) {
+		it := snap.Scan(0, 1000)
+		defer it.Close()
+
+		expected := uint32(0)
+		for it.Next() {
+			if it.Key() != expected || it.Value() != fmt.Sprintf("value-%d", expected) {
+				t.Fatalf("Snapshot scan returned (%d, %s), expected key %d", it.Key(), it.Value(), expected)
+			}
+			expected++
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		if expected != 10 {
+			t.Errorf("Snapshot scan returned %d keys, expected 10", expected)
+		}
+
+		keys, _ := tree.InOrderTraversal()
+		if len(keys) != 10 {
+			t.Errorf("Latest view has %d keys, expected 10", len(keys))
+		}
+	})
+
+	t.Run("GarbageCollect", func(t *testing.T) {
+		// "updated" was never visible to any snapshot, so it wasn't kept
+		if tree.DeadVersions() != 2 {
+			t.Errorf("DeadVersions = %d, expected 2", tree.DeadVersions())
+		}
+
+		// Still needed by the open snapshot
+		if n, _ := tree.GarbageCollect(); n != 0 {
+			t.Errorf("GarbageCollect removed %d versions under an open snapshot", n)
+		}
+
+		snap.Close()
+		if _, _, err := snap.Get(1); err != ErrSnapshotClosed {
+			t.Errorf("Get on closed snapshot returned %v, expected ErrSnapshotClosed", err)
+		}
+
+		n, err := tree.GarbageCollect()
+		if err != nil {
+			t.Fatalf("GarbageCollect failed: %v", err)
+		}
+		if n != 2 || tree.DeadVersions() != 0 {
+			t.Errorf("GarbageCollect removed %d versions, %d left, expected 2 and 0", n, tree.DeadVersions())
+		}
+	})
+}
+
+func TestSnapshotScanDuringWrites(t *testing.T) {
+	dbFile := "test_snapshot_scan.db"
+	walFile := "test_snapshot_scan.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(storage.NewBufferPool(pager, 64), 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	for i := uint32(0); i < 1000; i += 2 {
+		tree.Insert(i, "old")
+	}
+
+	snap := tree.Snapshot()
+	defer snap.Close()
+
+	it := snap.Scan(0, 5000)
+	defer it.Close()
+
+	// Interleave writes that split and merge leaves with the scan
+	count := 0
+	for it.Next() {
+		if it.Key()%2 != 0 || it.Value() != "old" {
+			t.Fatalf("Snapshot scan returned (%d, %s) written after it", it.Key(), it.Value())
+		}
+		count++
+
+		tree.Insert(it.Key()+1, "new")
+		tree.Insert(it.Key()+2000, "new")
+		tree.Insert(it.Key(), "overwritten")
+		if count%3 == 0 {
+			tree.Delete(it.Key())
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if count != 500 {
+		t.Errorf("Snapshot scan returned %d keys, expected 500", count)
+	}
+
+	// Closing the snapshot lets GC remove every replaced version
+	snap.Close()
+	if _, err := tree.GarbageCollect(); err != nil {
+		t.Fatalf("GarbageCollect failed: %v", err)
+	}
+	if tree.DeadVersions() != 0 {
+		t.Errorf("DeadVersions = %d after GC, expected 0", tree.DeadVersions())
+	}
+
+	keys, _ := tree.InOrderTraversal()
+	if expected := 500 - 500/3 + 500 + 500; len(keys) != expected {
+		t.Errorf("%d keys after GC, expected %d", len(keys), expected)
+	}
+}
+
+// TestSnapshotScanOverflow checks that a snapshot scan reads the whole of an
+// overflowed value only the snapshot still sees
+func TestSnapshotScanOverflow(t *testing.T) {
+	dbFile := "test_snapshot_overflow.db"
+	walFile := "test_snapshot_overflow.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(storage.NewBufferPool(pager, 64), 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	old := strings.Repeat("o", 20000)
+	if err := tree.Insert(1, old); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	snap := tree.Snapshot()
+	defer snap.Close()
+
+	if err := tree.Insert(1, strings.Repeat("n", 20000)); err != nil {
+		t.Fatalf("Failed to overwrite: %v", err)
+	}
+
+	it := snap.Scan(0, 10)
+	defer it.Close()
+	if !it.Next() {
+		t.Fatalf("Snapshot scan returned nothing: %v", it.Err())
+	}
+	if it.Key() != 1 || it.Value() != old {
+		t.Errorf("Snapshot scan returned key=%d with %d bytes, expected key=1 with its %d-byte old value",
+			it.Key(), len(it.Value()), len(old))
+	}
+	if it.Next() {
+		t.Errorf("Snapshot scan returned key=%d past the only key", it.Key())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+}
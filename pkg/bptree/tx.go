@@ -0,0 +1,320 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+// ErrTxDone is returned when using a transaction after Commit or Rollback
+var ErrTxDone = errors.New("transaction has already been committed or rolled back")
+
+// Tx is a multi-statement transaction
+// Writes are logged as they happen but only buffered in the transaction;
+// the tree sees all of them at once on commit, none on rollback or crash.
+// Reads take shared key locks and writes exclusive ones, held until the
+// transaction ends, so concurrent transactions are serializable on the keys
+// they touch. Scan takes no locks and doesn't protect against phantoms.
+// Tx is not safe for concurrent use, but any number of transactions can run
+// side by side.
+type Tx struct {
+	tree   *BPTree
+	id     uint64              // LSN of the BEGIN record
+	writes map[string]*txWrite // Latest write per key
+	done   bool
+}
+
+// txWrite is a buffered write, a delete when deleted is set
+type txWrite struct {
+	value   string
+	deleted bool
+}
+
+// Begin starts a transaction
+func (tree *BPTree) Begin() (*Tx, error) {
+	// Counted first, a checkpoint must not truncate the BEGIN record away
+	tree.mu.Lock()
+	tree.activeTxs++
+	tree.mu.Unlock()
+
+	entry := &wal.Entry{OpType: wal.OpBegin}
+	if _, err := tree.wal.AppendAsync(entry); err != nil {
+		tree.endTx()
+		return nil, fmt.Errorf("failed to write WAL: %w", err)
+	}
+
+	// Begin LSNs are unique and keep increasing across restarts
+	return &Tx{
+		tree:   tree,
+		id:     entry.LSN,
+		writes: make(map[string]*txWrite),
+	}, nil
+}
+
+// Locks returns the lock manager transactions lock keys in
+func (tree *BPTree) Locks() *LockManager {
+	return tree.locks
+}
+
+// ActiveTxs returns the number of transactions that haven't finished
+func (tree *BPTree) ActiveTxs() int {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	return tree.activeTxs
+}
+
+// endTx stops counting a transaction as active
+func (tree *BPTree) endTx() {
+	tree.mu.Lock()
+	tree.activeTxs--
+	tree.mu.Unlock()
+}
+
+// ID returns the transaction ID
+func (tx *Tx) ID() uint64 {
+	return tx.id
+}
+
+// Lock acquires a key lock for the transaction, waiting for conflicting holders
+// Put, Get and Delete lock on their own, Lock takes extra keys up front.
+// On ErrDeadlock the transaction must be rolled back.
+func (tx *Tx) Lock(key uint32, mode LockMode) error {
+	return tx.LockKey(storage.Uint32Key(key), mode)
+}
+
+// LockKey is Lock for a byte-string key
+func (tx *Tx) LockKey(key []byte, mode LockMode) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	return tx.tree.locks.LockKey(tx.id, key, mode)
+}
+
+// lock acquires a key lock, rolling the transaction back if it is a deadlock victim
+func (tx *Tx) lock(key []byte, mode LockMode) error {
+	err := tx.LockKey(key, mode)
+	if errors.Is(err, ErrDeadlock) {
+		tx.Rollback()
+	}
+	return err
+}
+
+// log appends one of the transaction's records without waiting for fsync
+func (tx *Tx) log(op wal.OpType, key []byte, value string) (*wal.Commit, error) {
+	entry := &wal.Entry{
+		TxID:   tx.id,
+		OpType: op,
+		Key:    key,
+		Value:  value,
+	}
+
+	commit, err := tx.tree.wal.AppendAsync(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write WAL: %w", err)
+	}
+	return commit, nil
+}
+
+// Put inserts or replaces a key within the transaction
+func (tx *Tx) Put(key uint32, value string) error {
+	return tx.PutKey(storage.Uint32Key(key), value)
+}
+
+// PutKey inserts or replaces a byte-string key within the transaction
+// A key the tree can't store is refused here rather than at commit
+func (tx *Tx) PutKey(key []byte, value string) error {
+	if err := storage.CheckKey(key); err != nil {
+		return err
+	}
+	if err := tx.lock(key, LockExclusive); err != nil {
+		return err
+	}
+
+	if _, err := tx.log(wal.OpInsert, key, value); err != nil {
+		return err
+	}
+
+	tx.writes[string(key)] = &txWrite{value: value}
+	return nil
+}
+
+// Delete removes a key within the transaction, returns true if it existed
+func (tx *Tx) Delete(key uint32) (bool, error) {
+	return tx.DeleteKey(storage.Uint32Key(key))
+}
+
+// DeleteKey removes a byte-string key within the transaction, returns true if it existed
+func (tx *Tx) DeleteKey(key []byte) (bool, error) {
+	if err := tx.lock(key, LockExclusive); err != nil {
+		return false, err
+	}
+
+	_, found, err := tx.GetKey(key)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := tx.log(wal.OpDelete, key, ""); err != nil {
+		return false, err
+	}
+
+	tx.writes[string(key)] = &txWrite{deleted: true}
+	return found, nil
+}
+
+// Get reads a key, seeing the transaction's own writes
+func (tx *Tx) Get(key uint32) (string, bool, error) {
+	return tx.GetKey(storage.Uint32Key(key))
+}
+
+// GetKey reads a byte-string key, seeing the transaction's own writes
+func (tx *Tx) GetKey(key []byte) (string, bool, error) {
+	if err := tx.lock(key, LockShared); err != nil {
+		return "", false, err
+	}
+
+	if w, ok := tx.writes[string(key)]; ok {
+		return w.value, !w.deleted, nil
+	}
+
+	return tx.tree.SearchKey(key)
+}
+
+// Scan returns an iterator over keys in [start, end], seeing the transaction's own writes
+func (tx *Tx) Scan(start, end uint32) *Iterator {
+	return tx.ScanKeys(storage.Uint32Key(start), storage.Uint32Key(end))
+}
+
+// ScanKeys returns an iterator over byte-string keys in [start, end], seeing
+// the transaction's own writes, a nil start or end leaves that side unbounded
+func (tx *Tx) ScanKeys(start, end []byte) *Iterator {
+	if tx.done {
+		return &Iterator{err: ErrTxDone, done: true}
+	}
+
+	it := &Iterator{
+		tree:   tx.tree,
+		start:  start,
+		end:    end,
+		static: true,
+	}
+	if it.pastEnd(start) {
+		it.done = true
+		return it
+	}
+
+	// Merge the committed range with the write set into one sorted snapshot
+	merged := make(map[string]string)
+
+	base := tx.tree.ScanKeys(start, end)
+	for base.Next() {
+		merged[string(base.KeyBytes())] = base.Value()
+	}
+	if err := base.Err(); err != nil {
+		it.err = err
+		return it
+	}
+
+	for key, w := range tx.writes {
+		if (start != nil && tx.tree.cmp.Compare([]byte(key), start) < 0) || it.pastEnd([]byte(key)) {
+			continue
+		}
+		if w.deleted {
+			delete(merged, key)
+		} else {
+			merged[key] = w.value
+		}
+	}
+
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	tx.sortKeys(keys)
+
+	it.records = make([]*storage.Record, len(keys))
+	for i, key := range keys {
+		it.records[i] = storage.NewRecord([]byte(key), []byte(merged[key]))
+	}
+	return it
+}
+
+// sortKeys sorts keys in the tree's key order
+func (tx *Tx) sortKeys(keys []string) {
+	sort.Slice(keys, func(i, j int) bool {
+		return tx.tree.cmp.Compare([]byte(keys[i]), []byte(keys[j])) < 0
+	})
+}
+
+// Commit makes the transaction's writes visible and waits until they are durable
+func (tx *Tx) Commit() error {
+	commit, err := tx.CommitAsync()
+	if err != nil {
+		return err
+	}
+	return commit.Wait()
+}
+
+// CommitAsync writes the commit record and applies the writes to the tree
+// without waiting for the WAL fsync, so concurrent commits can share it
+// The transaction is only durable once Wait on the returned commit succeeds
+func (tx *Tx) CommitAsync() (*wal.Commit, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	tx.done = true
+	defer tx.tree.locks.ReleaseAll(tx.id)
+
+	// Logged like a single write, a checkpoint can't come between the commit
+	// record and the tree changes, nor truncate the log while it is active
+	commit, err := tx.tree.logOp(&wal.Entry{TxID: tx.id, OpType: wal.OpCommit})
+	tx.tree.endTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.tree.finishOp()
+
+	keys := make([]string, 0, len(tx.writes))
+	for key := range tx.writes {
+		keys = append(keys, key)
+	}
+	tx.sortKeys(keys)
+
+	for _, key := range keys {
+		w := tx.writes[key]
+		if w.deleted {
+			// Pages touched by the transaction carry the commit LSN, as in replay
+			if _, err := tx.tree.deleteWithoutWAL(commit.LSN(), []byte(key)); err != nil {
+				return nil, fmt.Errorf("failed to apply delete of key %s: %w", formatKey([]byte(key)), err)
+			}
+			continue
+		}
+		if err := tx.tree.insertWithoutWAL(commit.LSN(), storage.NewRecord([]byte(key), []byte(w.value))); err != nil {
+			return nil, fmt.Errorf("failed to apply insert of key %s: %w", formatKey([]byte(key)), err)
+		}
+	}
+
+	tx.writes = nil
+	return commit, nil
+}
+
+// Rollback discards the transaction's writes
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	tx.tree.endTx()
+	tx.writes = nil
+	tx.tree.locks.ReleaseAll(tx.id)
+
+	// Not waited on: a lost abort record reads as an unfinished transaction,
+	// which recovery drops just the same
+	if _, err := tx.log(wal.OpAbort, nil, ""); err != nil {
+		return err
+	}
+	return nil
+}
@@ -1,62 +1,298 @@
 package database
 
 import (
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/spaghetti-lover/sharingan-db/internal/bptree"
 	"github.com/spaghetti-lover/sharingan-db/internal/storage"
 	"github.com/spaghetti-lover/sharingan-db/pkg/query"
 )
 
+// checkpointPollInterval is how often the background checkpointer checks the WAL
+const checkpointPollInterval = 100 * time.Millisecond
+
+// ErrNotUint32Key is returned by Keys when a key isn't 4 bytes long
+var ErrNotUint32Key = bptree.ErrNotUint32Key
+
+// ErrPreSuperblock is returned by Open for a file written before the superblock
+// Open it with the release that wrote it and copy the keys into a new database
+var ErrPreSuperblock = storage.ErrPreSuperblock
+
 type Database struct {
+	mu         sync.Mutex // Serializes checkpoints and guards their counters
 	tree       *bptree.BPTree
 	pager      storage.Pager
 	bufferPool *storage.BufferPool
+	opts       Options
+
+	autocommits atomic.Uint64 // Lock owner IDs for single-key writes
+
+	checkpoints   int
+	checkpointErr error // First background checkpoint failure, returned by Close
+	stop          chan struct{}
+	done          chan struct{}
+}
+
+// SyncMode controls when writes are forced to disk
+type SyncMode = storage.SyncMode
+
+const (
+	// SyncFull fsyncs every commit and every page write
+	SyncFull = storage.SyncFull
+	// SyncNormal fsyncs at commits and checkpoints only
+	SyncNormal = storage.SyncNormal
+	// SyncOff leaves flushing to the OS, fastest for bulk loads
+	SyncOff = storage.SyncOff
+)
+
+// Options configures a database
+type Options struct {
+	// SyncMode applies to both the WAL and the data file
+	SyncMode SyncMode
+	// CheckpointWALSize triggers a checkpoint once the WAL reaches this many bytes, 0 disables
+	CheckpointWALSize int64
+	// CheckpointInterval triggers a checkpoint once the oldest WAL entry is this old, 0 disables
+	CheckpointInterval time.Duration
+	// BackgroundWriterInterval runs the buffer pool's background writer at this interval, 0 disables
+	BackgroundWriterInterval time.Duration
 }
 
-// Open opens or creates a database
+// DefaultOptions returns the options used by Open
+func DefaultOptions() Options {
+	return Options{
+		CheckpointWALSize:  4 << 20,
+		CheckpointInterval: time.Minute,
+		SyncMode:           SyncFull,
+	}
+}
+
+// Open opens or creates a database with default options
+// An existing database is loaded from its superblock and the WAL is replayed
 func Open(path string) (*Database, error) {
+	return OpenWithOptions(path, DefaultOptions())
+}
+
+// OpenWithOptions opens or creates a database with the given options
+func OpenWithOptions(path string, opts Options) (*Database, error) {
 	pager, err := storage.NewFilePager(path + ".db")
 	if err != nil {
 		return nil, err
 	}
+	pager.SetSyncMode(opts.SyncMode)
 
 	bufferPool := storage.NewBufferPool(pager, 128)
 
-	tree, err := bptree.NewBPTree(bufferPool, 100, path+".wal")
+	tree, err := bptree.OpenBPTree(bufferPool, 100, path+".wal")
 	if err != nil {
 		bufferPool.Close()
-		pager.Close()
 		return nil, err
 	}
 
-	return &Database{
+	tree.SetSyncMode(opts.SyncMode)
+
+	if opts.BackgroundWriterInterval > 0 {
+		bufferPool.StartBackgroundWriter(storage.BackgroundWriterConfig{
+			Interval:   opts.BackgroundWriterInterval,
+			DurableLSN: tree.DurableLSN,
+		})
+	}
+
+	db := &Database{
 		tree:       tree,
 		pager:      pager,
 		bufferPool: bufferPool,
-	}, nil
+		opts:       opts,
+	}
+
+	if opts.CheckpointWALSize > 0 || opts.CheckpointInterval > 0 {
+		db.stop = make(chan struct{})
+		db.done = make(chan struct{})
+		go db.checkpointLoop()
+	}
+
+	return db, nil
+}
+
+// checkpointLoop runs checkpoints and version GC in the background until the database is closed
+func (db *Database) checkpointLoop() {
+	defer close(db.done)
+
+	ticker := time.NewTicker(checkpointPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.stop:
+			return
+		case <-ticker.C:
+			db.mu.Lock()
+			// Versions kept for snapshots can all go once the last one closes
+			if db.tree.DeadVersions() > 0 && db.tree.OpenSnapshots() == 0 {
+				if _, err := db.tree.GarbageCollect(); err != nil && db.checkpointErr == nil {
+					db.checkpointErr = err
+				}
+			}
+			if db.checkpointDue() {
+				if err := db.checkpointLocked(); err != nil && db.checkpointErr == nil {
+					db.checkpointErr = err
+				}
+			}
+			db.mu.Unlock()
+		}
+	}
+}
+
+// checkpointDue reports whether the WAL has reached the configured size or age
+func (db *Database) checkpointDue() bool {
+	// Open transactions pin the log, wait until they finish
+	if db.tree.ActiveTxs() > 0 {
+		return false
+	}
+
+	size, err := db.tree.WALSize()
+	if err != nil || size == 0 {
+		return false
+	}
+	if db.opts.CheckpointWALSize > 0 && size >= db.opts.CheckpointWALSize {
+		return true
+	}
+	return db.opts.CheckpointInterval > 0 && db.tree.WALAge() >= db.opts.CheckpointInterval
+}
+
+// checkpointLocked runs a checkpoint, db.mu must be held
+func (db *Database) checkpointLocked() error {
+	if err := db.tree.Checkpoint(); err != nil {
+		return err
+	}
+	db.checkpoints++
+	return nil
+}
+
+// Checkpoint flushes dirty pages, records the checkpoint and truncates the WAL
+func (db *Database) Checkpoint() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.checkpointLocked()
+}
+
+// Vacuum rebuilds the database into densely packed pages and truncates the
+// file, writes wait while it runs
+func (db *Database) Vacuum() (*bptree.VacuumStats, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.tree.Vacuum()
+}
+
+// Check walks the database file and reports every inconsistency it finds,
+// writes wait while it runs
+func (db *Database) Check() (*bptree.CheckReport, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.tree.Check()
 }
 
 // Close closes the database
+// The buffer pool flushes dirty pages and closes the underlying pager
 func (db *Database) Close() error {
+	if db.stop != nil {
+		close(db.stop)
+		<-db.done
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	if db.bufferPool != nil {
-		db.bufferPool.Close()
+		db.bufferPool.StopBackgroundWriter()
+	}
+
+	firstErr := db.checkpointErr
+	if db.tree != nil && db.tree.DeadVersions() > 0 {
+		if _, err := db.tree.GarbageCollect(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
 	if db.tree != nil {
-		db.tree.Close()
+		if err := db.tree.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
-	if db.pager != nil {
-		return db.pager.Close()
+	if db.bufferPool != nil {
+		if err := db.bufferPool.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
-	return nil
+	return firstErr
+}
+
+// lockKey takes an exclusive lock on key for a single-key write so it waits for
+// transactions holding the key, returns the release function
+func (db *Database) lockKey(key []byte) (func(), error) {
+	// Transaction IDs are LSNs, the top bit keeps these owners apart from them
+	owner := 1<<63 | db.autocommits.Add(1)
+
+	locks := db.tree.Locks()
+	if err := locks.LockKey(owner, key, bptree.LockExclusive); err != nil {
+		return nil, err
+	}
+	return func() { locks.ReleaseAll(owner) }, nil
 }
 
 // Put inserts a key-value pair
+// The WAL fsync happens outside the key lock so concurrent writers share it
 func (db *Database) Put(key uint32, value string) error {
-	return db.tree.Insert(key, value)
+	return db.PutKey(storage.Uint32Key(key), value)
+}
+
+// PutKey inserts a byte-string key, such as a user ID or a tenant-prefixed path
+// Keys are 1 to storage.MaxKeySize bytes
+func (db *Database) PutKey(key []byte, value string) error {
+	if err := storage.CheckKey(key); err != nil {
+		return err
+	}
+	unlock, err := db.lockKey(key)
+	if err != nil {
+		return err
+	}
+
+	commit, err := db.tree.InsertKeyAsync(key, value)
+	unlock()
+	if err != nil {
+		return err
+	}
+	return commit.Wait()
 }
 
 // Get retrieves a value by key
 func (db *Database) Get(key uint32) (string, bool, error) {
-	return db.tree.Search(key)
+	return db.GetKey(storage.Uint32Key(key))
+}
+
+// GetKey retrieves a value by byte-string key
+func (db *Database) GetKey(key []byte) (string, bool, error) {
+	return db.tree.SearchKey(key)
+}
+
+// Delete removes a key, returns true if it existed
+func (db *Database) Delete(key uint32) (bool, error) {
+	return db.DeleteKey(storage.Uint32Key(key))
+}
+
+// DeleteKey removes a byte-string key, returns true if it existed
+func (db *Database) DeleteKey(key []byte) (bool, error) {
+	unlock, err := db.lockKey(key)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, commit, err := db.tree.DeleteKeyAsync(key)
+	unlock()
+	if err != nil {
+		return false, err
+	}
+	return deleted, commit.Wait()
 }
 
 // Query executes SQL query
@@ -64,29 +300,74 @@ func (db *Database) Query(sql string) (string, error) {
 	return query.ExecuteSQL(sql, db.tree)
 }
 
-// Keys returns all keys in sorted order
+// Keys returns all uint32 keys in sorted order
+// It fails with ErrNotUint32Key on a byte-string key that isn't 4 bytes long,
+// ByteKeys returns every key
 func (db *Database) Keys() ([]uint32, error) {
 	return db.tree.InOrderTraversal()
 }
 
+// ByteKeys returns every key in sorted order
+func (db *Database) ByteKeys() ([][]byte, error) {
+	return db.tree.InOrderTraversalKeys()
+}
+
+// Scan returns an iterator over keys in [start, end]
+// The iterator can be used while other goroutines write to the database
+func (db *Database) Scan(start, end uint32) *bptree.Iterator {
+	return db.tree.Scan(start, end)
+}
+
+// ScanKeys returns an iterator over byte-string keys in [start, end], a nil
+// start or end leaves that side unbounded. Read keys with KeyBytes.
+func (db *Database) ScanKeys(start, end []byte) *bptree.Iterator {
+	return db.tree.ScanKeys(start, end)
+}
+
 // Stats returns database statistics
 func (db *Database) Stats() *Stats {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	poolStats := db.bufferPool.GetStats()
-	keys, _ := db.tree.InOrderTraversal()
+	keys, _ := db.tree.InOrderTraversalKeys()
+	walSize, _ := db.tree.WALSize()
 
 	return &Stats{
-		TotalKeys:      len(keys),
-		RootPageID:     db.tree.GetRootPageID(),
-		TreeOrder:      db.tree.GetOrder(),
-		CacheHitRate:   poolStats.HitRate,
-		BufferPoolSize: poolStats.Size,
+		TotalKeys:        len(keys),
+		RootPageID:       db.tree.GetRootPageID(),
+		TreeOrder:        db.tree.GetOrder(),
+		CacheHitRate:     poolStats.HitRate,
+		BufferPoolSize:   poolStats.Size,
+		BufferPoolErr:    db.bufferPool.Err(),
+		BackgroundWrites: poolStats.BackgroundWrites,
+		EvictionWrites:   poolStats.EvictionWrites,
+		WALSize:          walSize,
+		WALSyncs:         db.tree.GetWALSyncCount(),
+		Checkpoints:      db.checkpoints,
+		SyncMode:         db.opts.SyncMode,
+		Snapshots:        db.tree.OpenSnapshots(),
+		DeadVersions:     db.tree.DeadVersions(),
+		ActiveTxs:        db.tree.ActiveTxs(),
+		Deadlocks:        db.tree.Locks().Deadlocks(),
 	}
 }
 
 type Stats struct {
-	TotalKeys      int
-	RootPageID     uint64
-	TreeOrder      int
-	CacheHitRate   float64
-	BufferPoolSize int
+	TotalKeys        int
+	RootPageID       uint64
+	TreeOrder        int
+	CacheHitRate     float64
+	BufferPoolSize   int
+	BufferPoolErr    error  // Set once a failed write-back made the pool read-only
+	BackgroundWrites uint64 // Dirty pages the background writer cleaned ahead of eviction
+	EvictionWrites   uint64 // Dirty pages eviction had to write itself
+	WALSize          int64
+	WALSyncs         int
+	Checkpoints      int
+	SyncMode         SyncMode
+	Snapshots        int
+	DeadVersions     int
+	ActiveTxs        int
+	Deadlocks        int
 }
@@ -0,0 +1,732 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/bptree"
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+// removeDatabaseFiles deletes every file belonging to a database path
+func removeDatabaseFiles(path string) {
+	os.Remove(path + ".db")
+	os.Remove(path + ".wal")
+}
+
+func TestDatabaseReopen(t *testing.T) {
+	path := "test_reopen"
+	removeDatabaseFiles(path)
+	defer removeDatabaseFiles(path)
+
+	const numKeys = 3000
+
+	// Phase 1: create, write enough to split the root, close
+	{
+		db, err := Open(path)
+		if err != nil {
+			t.Fatalf("Failed to open database: %v", err)
+		}
+
+		for i := uint32(0); i < numKeys; i++ {
+			if err := db.Put(i, fmt.Sprintf("value-%d", i)); err != nil {
+				t.Fatalf("Put(%d) failed: %v", i, err)
+			}
+		}
+		if _, err := db.Delete(42); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+
+	// Phase 2: reopen and read everything back, twice to cover a second replay
+	for round := 0; round < 2; round++ {
+		db, err := Open(path)
+		if err != nil {
+			t.Fatalf("Round %d: failed to reopen database: %v", round, err)
+		}
+
+		for i := uint32(0); i < numKeys; i++ {
+			value, found, err := db.Get(i)
+			if err != nil {
+				t.Fatalf("Round %d: Get(%d) failed: %v", round, i, err)
+			}
+			if i == 42 {
+				if found {
+					t.Errorf("Round %d: deleted key 42 reappeared", round)
+				}
+				continue
+			}
+			if !found {
+				t.Fatalf("Round %d: key %d not found after reopen", round, i)
+			}
+			if value != fmt.Sprintf("value-%d", i) {
+				t.Errorf("Round %d: key %d = %s", round, i, value)
+			}
+		}
+
+		keys, err := db.Keys()
+		if err != nil {
+			t.Fatalf("Round %d: Keys failed: %v", round, err)
+		}
+		if len(keys) != numKeys-1 {
+			t.Errorf("Round %d: %d keys after reopen, expected %d", round, len(keys), numKeys-1)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatalf("Round %d: Close failed: %v", round, err)
+		}
+	}
+}
+
+func TestDatabaseOverwrite(t *testing.T) {
+	path := "test_overwrite"
+	removeDatabaseFiles(path)
+	defer removeDatabaseFiles(path)
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Put(1, "first"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := db.Put(1, "second"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	value, found, err := db.Get(1)
+	if err != nil || !found || value != "second" {
+		t.Errorf("Get(1) = (%q, %v, %v), expected (\"second\", true, nil)", value, found, err)
+	}
+
+	keys, _ := db.Keys()
+	if len(keys) != 1 {
+		t.Errorf("Overwrite left %d keys, expected 1", len(keys))
+	}
+}
+
+func TestDatabaseCheckpoint(t *testing.T) {
+	path := "test_checkpoint"
+	removeDatabaseFiles(path)
+	defer removeDatabaseFiles(path)
+
+	db, err := OpenWithOptions(path, Options{})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	for i := uint32(0); i < 100; i++ {
+		if err := db.Put(i, fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatalf("Put(%d) failed: %v", i, err)
+		}
+	}
+
+	if err := db.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+
+	stats := db.Stats()
+	if stats.WALSize != 0 {
+		t.Errorf("WAL size after checkpoint = %d, expected 0", stats.WALSize)
+	}
+	if stats.Checkpoints != 1 {
+		t.Errorf("Checkpoints = %d, expected 1", stats.Checkpoints)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	keys, _ := db.Keys()
+	if len(keys) != 100 {
+		t.Errorf("%d keys after reopen, expected 100", len(keys))
+	}
+}
+
+func TestDatabaseVacuum(t *testing.T) {
+	path := "test_vacuum"
+	removeDatabaseFiles(path)
+	defer removeDatabaseFiles(path)
+
+	db, err := OpenWithOptions(path, Options{})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	for i := uint32(0); i < 2000; i++ {
+		if err := db.Put(i, strings.Repeat("v", 200)); err != nil {
+			t.Fatalf("Put(%d) failed: %v", i, err)
+		}
+	}
+	for i := uint32(100); i < 2000; i++ {
+		if _, err := db.Delete(i); err != nil {
+			t.Fatalf("Delete(%d) failed: %v", i, err)
+		}
+	}
+
+	stats, err := db.Vacuum()
+	if err != nil {
+		t.Fatalf("Vacuum failed: %v", err)
+	}
+	if stats.BytesReclaimed <= 0 {
+		t.Errorf("Vacuum = %+v, expected bytes reclaimed", stats)
+	}
+	if info, err := os.Stat(path + ".db"); err != nil {
+		t.Errorf("Failed to stat database file: %v", err)
+	} else if info.Size() != int64(stats.PagesAfter)*storage.PageSize {
+		t.Errorf("File is %d bytes after vacuum, expected %d pages", info.Size(), stats.PagesAfter)
+	}
+
+	if report, err := db.Check(); err != nil || !report.OK() {
+		t.Errorf("Check after vacuum = %+v, %v, expected no violations", report, err)
+	}
+
+	if result, err := db.Query("VACUUM;"); err != nil || !strings.HasPrefix(result, "OK, 0 bytes reclaimed") {
+		t.Errorf("Query(VACUUM) = %q, %v, expected nothing left to reclaim", result, err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	keys, _ := db.Keys()
+	if len(keys) != 100 {
+		t.Errorf("%d keys after vacuum and reopen, expected 100", len(keys))
+	}
+}
+
+func TestDatabaseBackgroundCheckpoint(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts Options
+	}{
+		{"BySize", Options{CheckpointWALSize: 1024}},
+		{"ByAge", Options{CheckpointInterval: 50 * time.Millisecond}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := "test_background_checkpoint"
+			removeDatabaseFiles(path)
+			defer removeDatabaseFiles(path)
+
+			db, err := OpenWithOptions(path, tc.opts)
+			if err != nil {
+				t.Fatalf("Failed to open database: %v", err)
+			}
+			defer db.Close()
+
+			for i := uint32(0); i < 100; i++ {
+				if err := db.Put(i, fmt.Sprintf("value-%d", i)); err != nil {
+					t.Fatalf("Put(%d) failed: %v", i, err)
+				}
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for db.Stats().Checkpoints == 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("Background checkpoint never ran")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if value, found, _ := db.Get(42); !found || value != "value-42" {
+				t.Errorf("Get(42) = (%q, %v) after checkpoint", value, found)
+			}
+		})
+	}
+}
+
+func TestDatabaseBackgroundWriter(t *testing.T) {
+	path := "test_background_writer"
+	removeDatabaseFiles(path)
+	defer removeDatabaseFiles(path)
+
+	db, err := OpenWithOptions(path, Options{SyncMode: SyncOff, BackgroundWriterInterval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	for i := uint32(0); i < 2000; i++ {
+		if err := db.Put(i, fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatalf("Put(%d) failed: %v", i, err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for db.Stats().BackgroundWrites == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Background writer never wrote a page")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	db, err = OpenWithOptions(path, Options{})
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	if keys, _ := db.Keys(); len(keys) != 2000 {
+		t.Errorf("%d keys after reopen, expected 2000", len(keys))
+	}
+}
+
+func TestDatabaseSyncModes(t *testing.T) {
+	for _, mode := range []SyncMode{SyncFull, SyncNormal, SyncOff} {
+		t.Run(mode.String(), func(t *testing.T) {
+			path := "test_sync_mode"
+			removeDatabaseFiles(path)
+			defer removeDatabaseFiles(path)
+
+			db, err := OpenWithOptions(path, Options{SyncMode: mode})
+			if err != nil {
+				t.Fatalf("Failed to open database: %v", err)
+			}
+
+			for i := uint32(0); i < 500; i++ {
+				if err := db.Put(i, fmt.Sprintf("value-%d", i)); err != nil {
+					t.Fatalf("Put(%d) failed: %v", i, err)
+				}
+			}
+
+			if stats := db.Stats(); stats.SyncMode != mode {
+				t.Errorf("Stats().SyncMode = %s, expected %s", stats.SyncMode, mode)
+			}
+
+			if err := db.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			db, err = OpenWithOptions(path, Options{SyncMode: mode})
+			if err != nil {
+				t.Fatalf("Failed to reopen database: %v", err)
+			}
+			defer db.Close()
+
+			keys, _ := db.Keys()
+			if len(keys) != 500 {
+				t.Errorf("%d keys after clean reopen, expected 500", len(keys))
+			}
+		})
+	}
+}
+
+func TestDatabaseTransaction(t *testing.T) {
+	path := "test_transaction"
+	removeDatabaseFiles(path)
+	defer removeDatabaseFiles(path)
+
+	db, err := OpenWithOptions(path, Options{})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	db.Put(1, "100")
+	db.Put(2, "0")
+
+	// Move the balance between two keys atomically
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	tx.Put(1, "40")
+	tx.Put(2, "60")
+
+	if value, _, _ := db.Get(2); value != "0" {
+		t.Errorf("Uncommitted write visible outside the transaction: key=2 value=%s", value)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	tx, _ = db.Begin()
+	tx.Put(1, "0")
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	for key, expected := range map[uint32]string{1: "40", 2: "60"} {
+		if value, _, _ := db.Get(key); value != expected {
+			t.Errorf("Key=%d: value=%s after reopen, expected %s", key, value, expected)
+		}
+	}
+}
+
+func TestDatabaseSnapshot(t *testing.T) {
+	path := "test_snapshot"
+	removeDatabaseFiles(path)
+	defer removeDatabaseFiles(path)
+
+	db, err := OpenWithOptions(path, Options{})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	for i := uint32(0); i < 500; i++ {
+		db.Put(i, "old")
+	}
+
+	snap := db.Snapshot()
+	it := snap.Scan(0, 499)
+
+	// Writers run while the snapshot scan is in progress
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := uint32(0); i < 500; i++ {
+			db.Put(i, "new")
+			db.Put(i+1000, "new")
+		}
+	}()
+
+	count := 0
+	for it.Next() {
+		if it.Value() != "old" {
+			t.Fatalf("Key=%d: snapshot scan saw value %s", it.Key(), it.Value())
+		}
+		count++
+	}
+	<-done
+
+	if err := it.Err(); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if count != 500 {
+		t.Errorf("Snapshot scan returned %d keys, expected 500", count)
+	}
+	if value, _, _ := snap.Get(7); value != "old" {
+		t.Errorf("Snapshot Get(7) = %s, expected old", value)
+	}
+
+	snap.Close()
+	if _, err := db.GarbageCollect(); err != nil {
+		t.Fatalf("GarbageCollect failed: %v", err)
+	}
+	if stats := db.Stats(); stats.Snapshots != 0 || stats.DeadVersions != 0 || stats.TotalKeys != 1000 {
+		t.Errorf("Stats after GC = %+v", stats)
+	}
+}
+
+func TestDatabaseByteKeys(t *testing.T) {
+	path := "test_byte_keys"
+	removeDatabaseFiles(path)
+	defer removeDatabaseFiles(path)
+
+	db, err := OpenWithOptions(path, Options{})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	userKey := func(tenant, user int) []byte {
+		return []byte(fmt.Sprintf("tenant-%d/users/%03d", tenant, user))
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := db.PutKey(userKey(i%3, i), fmt.Sprintf("user-%d", i)); err != nil {
+			t.Fatalf("PutKey failed: %v", err)
+		}
+	}
+	db.Put(7, "seven")
+	if _, err := db.DeleteKey(userKey(0, 0)); err != nil {
+		t.Fatalf("DeleteKey failed: %v", err)
+	}
+
+	snap := db.Snapshot()
+
+	// One transaction moves a user to another tenant
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	value, found, err := tx.GetKey(userKey(1, 1))
+	if err != nil || !found {
+		t.Fatalf("tx.GetKey = (%q, %v, %v)", value, found, err)
+	}
+	tx.PutKey(userKey(2, 1), value)
+	tx.DeleteKey(userKey(1, 1))
+	if got := scanCount(t, tx.ScanKeys([]byte("tenant-2/"), []byte("tenant-2/\xff"))); got != 34 {
+		t.Errorf("tx.ScanKeys saw %d users of tenant 2, expected 34", got)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// The snapshot still sees the user under the old tenant
+	if value, found, _ := snap.GetKey(userKey(1, 1)); !found || value != "user-1" {
+		t.Errorf("snap.GetKey = (%q, %v), expected the moved user", value, found)
+	}
+	if got := scanCount(t, snap.ScanKeys([]byte("tenant-1/"), []byte("tenant-1/\xff"))); got != 33 {
+		t.Errorf("Snapshot scan saw %d users of tenant 1, expected 33", got)
+	}
+	snap.Close()
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	if _, found, _ := db.GetKey(userKey(1, 1)); found {
+		t.Error("Moved user still under its old tenant after reopen")
+	}
+	if value, _, _ := db.GetKey(userKey(2, 1)); value != "user-1" {
+		t.Errorf("Moved user = %q after reopen", value)
+	}
+	if got := scanCount(t, db.ScanKeys([]byte("tenant-1/"), []byte("tenant-1/\xff"))); got != 32 {
+		t.Errorf("Scan saw %d users of tenant 1, expected 32", got)
+	}
+
+	// 99 users and one uint32 key: Keys refuses the users rather than dropping them
+	if keys, err := db.Keys(); !errors.Is(err, ErrNotUint32Key) {
+		t.Errorf("Keys() = (%v, %v), expected ErrNotUint32Key", keys, err)
+	}
+	if keys, _ := db.ByteKeys(); len(keys) != 100 {
+		t.Errorf("ByteKeys returned %d keys, expected 100", len(keys))
+	}
+	if stats := db.Stats(); stats.TotalKeys != 100 {
+		t.Errorf("Stats.TotalKeys = %d, expected 100", stats.TotalKeys)
+	}
+}
+
+// scanCount counts the keys an iterator returns
+func scanCount(t *testing.T, it *bptree.Iterator) int {
+	t.Helper()
+	defer it.Close()
+
+	count := 0
+	for it.Next() {
+		count++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	return count
+}
+
+func TestDatabaseConcurrentTransactions(t *testing.T) {
+	path := "test_concurrent_tx"
+	removeDatabaseFiles(path)
+	defer removeDatabaseFiles(path)
+
+	db, err := OpenWithOptions(path, Options{SyncMode: SyncOff})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	const (
+		numAccounts  = 5
+		numWorkers   = 8
+		numTransfers = 50
+		initial      = 1000
+	)
+	for i := uint32(0); i < numAccounts; i++ {
+		db.Put(i, strconv.Itoa(initial))
+	}
+
+	// transfer moves amount between two accounts, reading both first so
+	// transfers in opposite directions deadlock on the shared-to-exclusive upgrade
+	transfer := func(from, to uint32, amount int) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		balances := make(map[uint32]int)
+		for _, key := range []uint32{from, to} {
+			value, _, err := tx.Get(key)
+			if err != nil {
+				return err
+			}
+			balances[key], _ = strconv.Atoi(value)
+		}
+
+		if err := tx.Put(from, strconv.Itoa(balances[from]-amount)); err != nil {
+			return err
+		}
+		if err := tx.Put(to, strconv.Itoa(balances[to]+amount)); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	deadlocks := 0
+
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(w)))
+
+			for i := 0; i < numTransfers; i++ {
+				from := uint32(rng.Intn(numAccounts))
+				to := (from + 1 + uint32(rng.Intn(numAccounts-1))) % numAccounts
+				amount := rng.Intn(10)
+
+				for {
+					err := transfer(from, to, amount)
+					if err == nil {
+						break
+					}
+					if err != ErrDeadlock {
+						t.Errorf("Transfer failed: %v", err)
+						return
+					}
+					mu.Lock()
+					deadlocks++
+					mu.Unlock()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Serializable transfers never create or destroy money
+	total := 0
+	for i := uint32(0); i < numAccounts; i++ {
+		value, _, _ := db.Get(i)
+		balance, _ := strconv.Atoi(value)
+		total += balance
+	}
+	if total != numAccounts*initial {
+		t.Errorf("Total balance = %d, expected %d", total, numAccounts*initial)
+	}
+	if db.Stats().ActiveTxs != 0 {
+		t.Errorf("%d transactions left open", db.Stats().ActiveTxs)
+	}
+	t.Logf("%d deadlocks retried", deadlocks)
+}
+
+func TestDatabaseConcurrentReadsAndWrites(t *testing.T) {
+	path := "test_concurrent_rw"
+	removeDatabaseFiles(path)
+	defer removeDatabaseFiles(path)
+
+	// A small WAL limit keeps the background checkpointer busy too
+	db, err := OpenWithOptions(path, Options{SyncMode: SyncOff, CheckpointWALSize: 32 << 10})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	const (
+		numKeys    = 500
+		numWriters = 8
+		numReaders = 4
+	)
+
+	// Writers overwrite the same keys, values always start with their key
+	var writers sync.WaitGroup
+	for w := 0; w < numWriters; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for i := 0; i < numKeys; i++ {
+				key := uint32((i*7 + w) % numKeys)
+				if err := db.Put(key, fmt.Sprintf("%d-from-%d", key, w)); err != nil {
+					t.Errorf("Put(%d) failed: %v", key, err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for r := 0; r < numReaders; r++ {
+		readers.Add(1)
+		go func(r int) {
+			defer readers.Done()
+			rng := rand.New(rand.NewSource(int64(r)))
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				key := uint32(rng.Intn(numKeys))
+				value, found, err := db.Get(key)
+				if err != nil {
+					t.Errorf("Get(%d) failed: %v", key, err)
+					return
+				}
+				if found && !strings.HasPrefix(value, fmt.Sprintf("%d-", key)) {
+					t.Errorf("Get(%d) returned %q", key, value)
+				}
+
+				it := db.Scan(0, numKeys)
+				last := -1
+				for it.Next() {
+					if int(it.Key()) <= last {
+						t.Errorf("Scan returned %d after %d", it.Key(), last)
+					}
+					last = int(it.Key())
+				}
+				if err := it.Err(); err != nil {
+					t.Errorf("Scan failed: %v", err)
+				}
+				it.Close()
+			}
+		}(r)
+	}
+
+	writers.Wait()
+	close(done)
+	readers.Wait()
+
+	keys, err := db.Keys()
+	if err != nil {
+		t.Fatalf("Keys failed: %v", err)
+	}
+	if len(keys) != numKeys {
+		t.Errorf("%d keys, expected %d", len(keys), numKeys)
+	}
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"github.com/spaghetti-lover/sharingan-db/internal/bptree"
+)
+
+// ErrSnapshotClosed is returned when reading through a closed snapshot
+var ErrSnapshotClosed = bptree.ErrSnapshotClosed
+
+// Snapshot is a consistent read view of the database
+// It keeps seeing the data as of Snapshot() while writers carry on
+type Snapshot struct {
+	db   *Database
+	snap *bptree.Snapshot
+}
+
+// Snapshot opens a read view of every write committed so far
+// Close it when done, old versions are kept around while it is open
+func (db *Database) Snapshot() *Snapshot {
+	return &Snapshot{db: db, snap: db.tree.Snapshot()}
+}
+
+// Get retrieves a value as of the snapshot
+func (s *Snapshot) Get(key uint32) (string, bool, error) {
+	return s.snap.Get(key)
+}
+
+// GetKey retrieves a value by byte-string key as of the snapshot
+func (s *Snapshot) GetKey(key []byte) (string, bool, error) {
+	return s.snap.GetKey(key)
+}
+
+// Scan returns an iterator over keys in [start, end] as of the snapshot
+// The iterator can be used while other goroutines write to the database
+func (s *Snapshot) Scan(start, end uint32) *bptree.Iterator {
+	return s.snap.Scan(start, end)
+}
+
+// ScanKeys returns an iterator over byte-string keys in [start, end] as of the
+// snapshot, a nil start or end leaves that side unbounded
+func (s *Snapshot) ScanKeys(start, end []byte) *bptree.Iterator {
+	return s.snap.ScanKeys(start, end)
+}
+
+// Close releases the snapshot
+func (s *Snapshot) Close() error {
+	return s.snap.Close()
+}
+
+// GarbageCollect removes old versions no open snapshot can see
+// Returns the number of versions removed
+func (db *Database) GarbageCollect() (int, error) {
+	return db.tree.GarbageCollect()
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"github.com/spaghetti-lover/sharingan-db/internal/bptree"
+)
+
+// ErrTxDone is returned when using a transaction after Commit or Rollback
+var ErrTxDone = bptree.ErrTxDone
+
+// ErrDeadlock is returned when a transaction was aborted to break a deadlock
+// The transaction is already rolled back, retry it from Begin
+var ErrDeadlock = bptree.ErrDeadlock
+
+// Tx is a multi-statement transaction
+// Its writes become visible to other readers atomically on Commit. A
+// transaction waiting for a key lock only holds up those that want the key.
+type Tx struct {
+	db *Database
+	tx *bptree.Tx
+}
+
+// Begin starts a transaction
+func (db *Database) Begin() (*Tx, error) {
+	tx, err := db.tree.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{db: db, tx: tx}, nil
+}
+
+// Put inserts or replaces a key within the transaction
+// A deadlock victim is rolled back before ErrDeadlock is returned
+func (tx *Tx) Put(key uint32, value string) error {
+	return tx.tx.Put(key, value)
+}
+
+// PutKey inserts or replaces a byte-string key within the transaction
+func (tx *Tx) PutKey(key []byte, value string) error {
+	return tx.tx.PutKey(key, value)
+}
+
+// Get retrieves a value, seeing the transaction's own writes
+func (tx *Tx) Get(key uint32) (string, bool, error) {
+	return tx.tx.Get(key)
+}
+
+// GetKey retrieves a value by byte-string key, seeing the transaction's own writes
+func (tx *Tx) GetKey(key []byte) (string, bool, error) {
+	return tx.tx.GetKey(key)
+}
+
+// Delete removes a key within the transaction, returns true if it existed
+func (tx *Tx) Delete(key uint32) (bool, error) {
+	return tx.tx.Delete(key)
+}
+
+// DeleteKey removes a byte-string key within the transaction, returns true if it existed
+func (tx *Tx) DeleteKey(key []byte) (bool, error) {
+	return tx.tx.DeleteKey(key)
+}
+
+// Scan returns an iterator over keys in [start, end], seeing the transaction's own writes
+func (tx *Tx) Scan(start, end uint32) *bptree.Iterator {
+	return tx.tx.Scan(start, end)
+}
+
+// ScanKeys returns an iterator over byte-string keys in [start, end], seeing
+// the transaction's own writes, a nil start or end leaves that side unbounded
+func (tx *Tx) ScanKeys(start, end []byte) *bptree.Iterator {
+	return tx.tx.ScanKeys(start, end)
+}
+
+// Commit applies the transaction and waits until it is durable
+// Concurrent commits share the WAL fsync
+func (tx *Tx) Commit() error {
+	return tx.tx.Commit()
+}
+
+// Rollback discards the transaction's writes
+func (tx *Tx) Rollback() error {
+	return tx.tx.Rollback()
+}
@@ -23,6 +23,8 @@ func (e *Executor) Execute(stmt Statement) (string, error) {
 		return e.executeSelect(s)
 	case *InsertStatement:
 		return e.executeInsert(s)
+	case *VacuumStatement:
+		return e.executeVacuum()
 	default:
 		return "", fmt.Errorf("unsupported statement type: %T", stmt)
 	}
@@ -62,6 +64,17 @@ func (e *Executor) executeInsert(stmt *InsertStatement) (string, error) {
 	return "OK", nil
 }
 
+// executeVacuum compacts the database file
+func (e *Executor) executeVacuum() (string, error) {
+	stats, err := e.tree.Vacuum()
+	if err != nil {
+		return "", fmt.Errorf("vacuum failed: %w", err)
+	}
+
+	return fmt.Sprintf("OK, %d bytes reclaimed (%d -> %d pages)",
+		stats.BytesReclaimed, stats.PagesBefore, stats.PagesAfter), nil
+}
+
 // ParseAndExecute is a convenience function that parses and executes SQL
 func ParseAndExecute(sql string, tree *bptree.BPTree) (string, error) {
 	// Tokenize
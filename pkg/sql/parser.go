@@ -31,6 +31,13 @@ func (s *InsertStatement) Type() string {
 	return "INSERT"
 }
 
+// VacuumStatement represents VACUUM
+type VacuumStatement struct{}
+
+func (s *VacuumStatement) Type() string {
+	return "VACUUM"
+}
+
 // Parser parses tokens into SQL statements
 type Parser struct {
 	tokens []Token
@@ -62,6 +69,8 @@ func (p *Parser) Parse() (Statement, error) {
 		return p.parseSelect()
 	case "INSERT":
 		return p.parseInsert()
+	case "VACUUM":
+		return p.parseVacuum()
 	default:
 		return nil, fmt.Errorf("unsupported statement: %s", token.Value)
 	}
@@ -202,6 +211,24 @@ func (p *Parser) parseInsert() (Statement, error) {
 	}, nil
 }
 
+// parseVacuum parses: VACUUM
+func (p *Parser) parseVacuum() (Statement, error) {
+	if err := p.expect(TokenKeyword, "VACUUM"); err != nil {
+		return nil, err
+	}
+
+	// Optional semicolon
+	if p.current().Type == TokenSemicolon {
+		p.advance()
+	}
+
+	if p.current().Type != TokenEOF {
+		return nil, fmt.Errorf("unexpected %v after VACUUM", p.current())
+	}
+
+	return &VacuumStatement{}, nil
+}
+
 func (p *Parser) current() Token {
 	if p.pos >= len(p.tokens) {
 		return Token{Type: TokenEOF, Value: ""}
@@ -105,3 +105,42 @@ func TestParserInsert(t *testing.T) {
 		})
 	}
 }
+
+func TestParserVacuum(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectError bool
+	}{
+		{"VACUUM;", false},
+		{"vacuum", false},
+		{"VACUUM kv;", true}, // Nothing follows VACUUM
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokenizer := NewTokenizer(tt.input)
+			tokens, err := tokenizer.Tokenize()
+			if err != nil {
+				t.Fatalf("Tokenize failed: %v", err)
+			}
+
+			parser := NewParser(tokens)
+			stmt, err := parser.Parse()
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+
+			if _, ok := stmt.(*VacuumStatement); !ok {
+				t.Fatalf("Expected VacuumStatement, got %T", stmt)
+			}
+		})
+	}
+}
@@ -160,6 +160,7 @@ func (t *Tokenizer) readIdentifierOrKeyword() {
 		"VALUES": true,
 		"FROM":   true,
 		"WHERE":  true,
+		"VACUUM": true,
 	}
 
 	if keywords[upper] {
@@ -1,80 +1,265 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 )
 
-// BufferPool implements an LRU cache for pages
+// BufferPool implements a page cache, evicting by a ReplacementPolicy
+// Pages are spread over shards by page ID. Each shard has its own lock,
+// replacement policy and share of the capacity, so fetches of pages in
+// different shards never wait for each other.
 type BufferPool struct {
 	capacity int
-	cache    map[uint64]*cacheNode
-	head     *cacheNode // Most recently used
-	tail     *cacheNode // Least recently used
+	shards   []*poolShard
 	pager    Pager      // Underlying pager
-	mu       sync.RWMutex
-	hits     uint64 // Cache hits
-	misses   uint64 // Cache misses
-	evicts   uint64 // Evictions
+	mu       sync.Mutex // Serializes pager calls that change the free list or superblock, taken after a shard's lock
+
+	failMu sync.RWMutex
+	failed error // Why the pool went read-only, nil while healthy
+
+	writer     atomic.Pointer[backgroundWriter] // Nil unless the background writer runs
+	bgRounds   atomic.Uint64                    // Background writer rounds
+	bgWrites   atomic.Uint64                    // Pages written by the background writer
+	bgWALWaits atomic.Uint64                    // Pages it skipped until the WAL caught up
+
+	prefetchWG sync.WaitGroup // Read-ahead goroutines still running
 }
 
-// cacheNode represents a node in the doubly linked list
+// poolShard caches the pages whose ID maps to it
+type poolShard struct {
+	bp          *BufferPool
+	capacity    int
+	cache       map[uint64]*cacheNode
+	policy      ReplacementPolicy // Picks the page to evict
+	mu          sync.Mutex
+	hits        uint64 // Cache hits
+	misses      uint64 // Cache misses
+	evicts      uint64 // Evictions
+	evictWrites uint64 // Dirty pages written back by eviction
+
+	prefetching  map[uint64]*prefetchLoad // Pages being read ahead
+	unusedAhead  int                      // Read-ahead pages not fetched yet
+	prefetched   uint64                   // Pages loaded by read-ahead
+	prefetchHits uint64                   // Read-ahead pages that were then fetched
+}
+
+// cacheNode is a page held in the cache
 type cacheNode struct {
 	pageID uint64
 	data   []byte
-	prev   *cacheNode
-	next   *cacheNode
-	dirty  bool // Track if page needs to be written back
+	dirty  atomic.Bool  // Track if page needs to be written back
+	pins   int          // Frames handed out and not unpinned yet
+	latch  sync.RWMutex // Guards data while it is read, changed or written back
+	ahead  bool         // Loaded by read-ahead and not fetched yet
+}
+
+// ErrAllPagesPinned is returned when a page must be loaded but every frame is pinned
+var ErrAllPagesPinned = errors.New("buffer pool full: every page is pinned")
+
+// ErrBufferPoolFailed is returned for writes once a dirty page couldn't be
+// written back. The pool keeps serving reads but accepts no more changes,
+// the WAL still holds every change the cached pages are missing on disk.
+var ErrBufferPoolFailed = errors.New("buffer pool failed, it is read-only")
+
+// Frame is a page pinned in the buffer pool
+// A pinned page is never evicted, so Data stays valid until Unpin. Data is
+// shared with everyone else who fetched the page: read it under RLock and
+// change it under Lock. The pool latches the frame itself to write it back,
+// so don't fetch other pages while holding a frame's latch.
+type Frame struct {
+	shard    *poolShard
+	node     *cacheNode
+	unpinned bool
+}
+
+// ID returns the page ID
+func (f *Frame) ID() uint64 {
+	return f.node.pageID
 }
 
-// NewBufferPool creates a new buffer pool
+// Data returns the cached page, changes made to it are seen by every reader
+func (f *Frame) Data() []byte {
+	return f.node.data
+}
+
+// MarkDirty records that Data changed and must be written back
+func (f *Frame) MarkDirty() {
+	f.node.dirty.Store(true)
+}
+
+// Lock latches the frame for changing Data
+func (f *Frame) Lock() {
+	f.node.latch.Lock()
+}
+
+// Unlock releases the latch taken by Lock
+func (f *Frame) Unlock() {
+	f.node.latch.Unlock()
+}
+
+// RLock latches the frame for reading Data
+func (f *Frame) RLock() {
+	f.node.latch.RLock()
+}
+
+// RUnlock releases the latch taken by RLock
+func (f *Frame) RUnlock() {
+	f.node.latch.RUnlock()
+}
+
+// Unpin releases the frame, the page may be evicted once nobody pins it
+// Data must not be used afterwards. Unpinning twice is a no-op.
+func (f *Frame) Unpin() {
+	f.shard.mu.Lock()
+	defer f.shard.mu.Unlock()
+
+	if !f.unpinned {
+		f.node.pins--
+		f.unpinned = true
+	}
+}
+
+const (
+	minShardPages = 16 // Smallest shard DefaultShards makes
+	maxShards     = 32 // Most shards DefaultShards makes
+)
+
+// DefaultShards returns the number of shards NewBufferPool splits capacity
+// pages into: one per 16 pages, at most 32. Pools under 32 pages keep a
+// single shard, so eviction still sees every page.
+func DefaultShards(capacity int) int {
+	return min(max(capacity/minShardPages, 1), maxShards)
+}
+
+// NewBufferPool creates a new buffer pool with LRU eviction and DefaultShards shards
 func NewBufferPool(pager Pager, capacity int) *BufferPool {
 	if capacity < 1 {
 		capacity = 64 // Default capacity
 	}
+	return NewShardedBufferPool(pager, capacity, DefaultShards(capacity), func(int) ReplacementPolicy {
+		return NewLRUPolicy()
+	})
+}
+
+// NewBufferPoolWithPolicy creates a single shard buffer pool evicting by policy
+// The policy must be new, the pool owns it from here on.
+func NewBufferPoolWithPolicy(pager Pager, capacity int, policy ReplacementPolicy) *BufferPool {
+	return NewShardedBufferPool(pager, capacity, 1, func(int) ReplacementPolicy {
+		return policy
+	})
+}
+
+// NewShardedBufferPool creates a buffer pool split into shards, each evicting
+// by a new policy that newPolicy creates for the shard's capacity
+func NewShardedBufferPool(pager Pager, capacity int, shards int, newPolicy func(capacity int) ReplacementPolicy) *BufferPool {
+	if capacity < 1 {
+		capacity = 64 // Default capacity
+	}
+	shards = min(max(shards, 1), capacity)
 
 	bp := &BufferPool{
 		capacity: capacity,
-		cache:    make(map[uint64]*cacheNode, capacity),
+		shards:   make([]*poolShard, shards),
 		pager:    pager,
 	}
+	for i := range bp.shards {
+		// Spread the remainder over the first shards
+		shardCapacity := capacity / shards
+		if i < capacity%shards {
+			shardCapacity++
+		}
 
-	// Initialize dummy head and tail
-	bp.head = &cacheNode{}
-	bp.tail = &cacheNode{}
-	bp.head.next = bp.tail
-	bp.tail.prev = bp.head
-
+		bp.shards[i] = &poolShard{
+			bp:          bp,
+			capacity:    shardCapacity,
+			cache:       make(map[uint64]*cacheNode, shardCapacity),
+			policy:      newPolicy(shardCapacity),
+			prefetching: make(map[uint64]*prefetchLoad),
+		}
+	}
 	return bp
 }
 
-// ReadPage reads a page (from cache or disk)
-func (bp *BufferPool) ReadPage(id uint64) ([]byte, error) {
-	bp.mu.Lock()
-	defer bp.mu.Unlock()
+// shard returns the shard caching a page
+func (bp *BufferPool) shard(id uint64) *poolShard {
+	return bp.shards[id%uint64(len(bp.shards))]
+}
+
+// FetchPage pins a page in the pool and returns its frame, reading the page
+// from disk on a miss. Unpin the frame when done with it.
+func (bp *BufferPool) FetchPage(id uint64) (*Frame, error) {
+	return bp.shard(id).pin(id, false)
+}
+
+// pin fetches a page and pins it
+// A write overwrites the whole page, so a miss gets a zeroed frame instead
+// of a disk read and a hit isn't counted
+func (s *poolShard) pin(id uint64, write bool) (*Frame, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A page being read ahead is waited for rather than read twice. Once is
+	// enough, a read-ahead started since is dropped so that scans evicting
+	// and reading the page again can't keep the fetch waiting
+	if load := s.prefetching[id]; load != nil {
+		s.mu.Unlock()
+		<-load.done
+		s.mu.Lock()
+		s.cancelPrefetch(id)
+	}
 
 	// Check cache first
-	if node, exists := bp.cache[id]; exists {
-		bp.hits++
-		bp.moveToHead(node)
-		// Return a copy to prevent external modification
-		dataCopy := make([]byte, len(node.data))
-		copy(dataCopy, node.data)
-		return dataCopy, nil
-	}
-
-	// Cache miss - read from disk
-	bp.misses++
-	data, err := bp.pager.ReadPage(id)
+	if node, exists := s.cache[id]; exists {
+		if !write {
+			s.hits++
+		}
+		if node.ahead {
+			node.ahead = false
+			s.unusedAhead--
+			s.prefetchHits++
+		}
+		s.policy.Access(id)
+		node.pins++
+		return &Frame{shard: s, node: node}, nil
+	}
+
+	s.misses++
+
+	var data []byte
+	if write {
+		data = make([]byte, PageSize)
+	} else {
+		var err error
+		if data, err = s.bp.pager.ReadPage(id); err != nil {
+			return nil, err
+		}
+	}
+
+	node, err := s.addToCache(id, data, false)
 	if err != nil {
 		return nil, err
 	}
+	node.pins++
+	return &Frame{shard: s, node: node}, nil
+}
 
-	bp.addToCache(id, data, false)
+// ReadPage reads a page (from cache or disk)
+func (bp *BufferPool) ReadPage(id uint64) ([]byte, error) {
+	frame, err := bp.FetchPage(id)
+	if err != nil {
+		return nil, err
+	}
+	defer frame.Unpin()
+
+	frame.RLock()
+	defer frame.RUnlock()
 
-	// Return a copy
-	dataCopy := make([]byte, len(data))
-	copy(dataCopy, data)
+	// Return a copy to prevent external modification
+	dataCopy := make([]byte, PageSize)
+	copy(dataCopy, frame.Data())
 	return dataCopy, nil
 }
 
@@ -83,166 +268,383 @@ func (bp *BufferPool) WritePage(id uint64, data []byte) error {
 	if len(data) != PageSize {
 		return fmt.Errorf("invalid page size: %d, expected %d", len(data), PageSize)
 	}
-
-	bp.mu.Lock()
-	defer bp.mu.Unlock()
-
-	// Check if page is in cache
-	if node, exists := bp.cache[id]; exists {
-		// Update cached data
-		copy(node.data, data)
-		node.dirty = true
-		bp.moveToHead(node)
-		return nil
+	if err := bp.Err(); err != nil {
+		return err
 	}
 
-	// Not in cache - this is a cache miss for writes
-	bp.misses++
+	frame, err := bp.shard(id).pin(id, true)
+	if err != nil {
+		return err
+	}
+	defer frame.Unpin()
 
-	// Add to cache
-	dataCopy := make([]byte, PageSize)
-	copy(dataCopy, data)
-	bp.addToCache(id, dataCopy, true)
+	frame.Lock()
+	defer frame.Unlock()
 
+	copy(frame.Data(), data)
+	frame.MarkDirty()
 	return nil
 }
 
 // AllocatePage allocates a new page
 func (bp *BufferPool) AllocatePage() (uint64, error) {
+	if err := bp.Err(); err != nil {
+		return 0, err
+	}
+
 	// Delegate to underlying pager
-	return bp.pager.AllocatePage()
+	bp.mu.Lock()
+	pageID, err := bp.pager.AllocatePage()
+	bp.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	s := bp.shard(pageID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cancelPrefetch(pageID)
+	return pageID, nil
 }
 
-// Close flushes all dirty pages and closes underlying pager
-func (bp *BufferPool) Close() error {
+// FreePage drops a page from the cache and returns it to the pager's free list
+func (bp *BufferPool) FreePage(id uint64) error {
+	if err := bp.Err(); err != nil {
+		return err
+	}
+
+	s := bp.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A freed page must never be written back, so discard it without flushing
+	if node, exists := s.cache[id]; exists {
+		if node.pins > 0 {
+			return fmt.Errorf("failed to free page %d: page is pinned", id)
+		}
+		// Wait out a background write of the page
+		node.latch.Lock()
+		node.latch.Unlock()
+
+		s.removeNode(node)
+	}
+	s.cancelPrefetch(id)
+
 	bp.mu.Lock()
 	defer bp.mu.Unlock()
 
-	// Flush all dirty pages
-	for pageID, node := range bp.cache {
-		if node.dirty {
-			if err := bp.pager.WritePage(pageID, node.data); err != nil {
-				return fmt.Errorf("failed to flush page %d: %w", pageID, err)
+	return bp.pager.FreePage(id)
+}
+
+// NumPages returns the number of pages in the underlying pager's file
+func (bp *BufferPool) NumPages() uint64 {
+	if resizer, ok := bp.pager.(Resizer); ok {
+		return resizer.NumPages()
+	}
+	return 0
+}
+
+// FreePageIDs returns every page on the underlying pager's free list
+func (bp *BufferPool) FreePageIDs() []uint64 {
+	resizer, ok := bp.pager.(Resizer)
+	if !ok {
+		return nil
+	}
+
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return resizer.FreePageIDs()
+}
+
+// Resize drops the pages past numPages and those in free from the cache
+// without writing them back, then resizes the underlying pager
+func (bp *BufferPool) Resize(numPages uint64, free []uint64) error {
+	if err := bp.Err(); err != nil {
+		return err
+	}
+	resizer, ok := bp.pager.(Resizer)
+	if !ok {
+		return fmt.Errorf("pager %T can't be resized", bp.pager)
+	}
+
+	dropped := make(map[uint64]bool, len(free))
+	for _, pageID := range free {
+		dropped[pageID] = true
+	}
+
+	for _, s := range bp.shards {
+		s.mu.Lock()
+		for pageID, node := range s.cache {
+			if pageID < numPages && !dropped[pageID] {
+				continue
+			}
+			if node.pins > 0 {
+				s.mu.Unlock()
+				return fmt.Errorf("failed to drop page %d: page is pinned", pageID)
+			}
+			// Wait out a background write of the page
+			node.latch.Lock()
+			node.latch.Unlock()
+
+			s.removeNode(node)
+		}
+		for pageID := range s.prefetching {
+			if pageID >= numPages || dropped[pageID] {
+				s.cancelPrefetch(pageID)
 			}
 		}
+		s.mu.Unlock()
 	}
 
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	return resizer.Resize(numPages, free)
+}
+
+// SetWALSync hands the function forcing the WAL to the underlying pager,
+// which every write-back goes through
+func (bp *BufferPool) SetWALSync(sync func(lsn uint64) error) {
+	if syncer, ok := bp.pager.(WALSyncer); ok {
+		syncer.SetWALSync(sync)
+	}
+}
+
+// ReadSuperblock returns the superblock from the underlying pager
+func (bp *BufferPool) ReadSuperblock() (*Superblock, error) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	return bp.pager.ReadSuperblock()
+}
+
+// WriteSuperblock writes the superblock directly to the underlying pager
+// The superblock bypasses the cache so it is durable as soon as this returns
+func (bp *BufferPool) WriteSuperblock(sb *Superblock) error {
+	if err := bp.Err(); err != nil {
+		return err
+	}
+
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	return bp.pager.WriteSuperblock(sb)
+}
+
+// Close flushes all dirty pages and closes underlying pager
+// A failed pool writes nothing, recovery replays the WAL instead.
+func (bp *BufferPool) Close() error {
+	bp.StopBackgroundWriter()
+	bp.prefetchWG.Wait()
+
+	if err := bp.Err(); err != nil {
+		return errors.Join(err, bp.pager.Close())
+	}
+
+	// Flush all dirty pages
+	if err := bp.writeAll(); err != nil {
+		return err
+	}
+
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
 	return bp.pager.Close()
 }
 
 // Flush writes all dirty pages to disk (but doesn't close pager)
+// A failed pool refuses, so a checkpoint can't truncate the WAL it still needs
 func (bp *BufferPool) Flush() error {
+	if err := bp.Err(); err != nil {
+		return err
+	}
+
+	if err := bp.writeAll(); err != nil {
+		return err
+	}
+
 	bp.mu.Lock()
 	defer bp.mu.Unlock()
 
-	for pageID, node := range bp.cache {
-		if node.dirty {
-			if err := bp.pager.WritePage(pageID, node.data); err != nil {
+	return bp.pager.Flush()
+}
+
+// writeAll writes back every dirty page, one shard at a time
+func (bp *BufferPool) writeAll() error {
+	for _, s := range bp.shards {
+		s.mu.Lock()
+		for pageID, node := range s.cache {
+			if _, err := bp.writeBack(node); err != nil {
+				s.mu.Unlock()
 				return fmt.Errorf("failed to flush page %d: %w", pageID, err)
 			}
-			node.dirty = false
 		}
+		s.mu.Unlock()
 	}
-
 	return nil
 }
 
-// addToCache adds a page to the cache (evicts LRU if full)
-func (bp *BufferPool) addToCache(pageID uint64, data []byte, dirty bool) {
+// writeBack writes a dirty page to the pager, reporting whether it was dirty
+// The frame is latched exclusively, the pager stamps the checksum into it
+func (bp *BufferPool) writeBack(node *cacheNode) (bool, error) {
+	node.latch.Lock()
+	defer node.latch.Unlock()
+
+	return bp.writeBackLatched(node)
+}
+
+// writeBackLatched is writeBack for a frame the caller latched exclusively
+func (bp *BufferPool) writeBackLatched(node *cacheNode) (bool, error) {
+	if !node.dirty.Load() {
+		return false, nil
+	}
+	if err := bp.pager.WritePage(node.pageID, node.data); err != nil {
+		return false, err
+	}
+	node.dirty.Store(false)
+	return true, nil
+}
+
+// addToCache adds a page to the shard (evicts a page if full)
+// A page read ahead goes in cold, where the policy evicts first
+func (s *poolShard) addToCache(pageID uint64, data []byte, ahead bool) (*cacheNode, error) {
 	// Check if we need to evict
-	if len(bp.cache) >= bp.capacity {
-		bp.evictLRU()
+	if len(s.cache) >= s.capacity {
+		if err := s.evict(); err != nil {
+			return nil, err
+		}
 	}
 
 	// Create new node
-	dataCopy := make([]byte, PageSize)
-	copy(dataCopy, data)
-
 	node := &cacheNode{
 		pageID: pageID,
-		data:   dataCopy,
-		dirty:  dirty,
+		data:   data,
+		ahead:  ahead,
 	}
 
 	// Add to map
-	bp.cache[pageID] = node
+	s.cache[pageID] = node
+
+	// Let the policy track it
+	if ahead {
+		s.unusedAhead++
+		s.policy.InsertCold(pageID)
+	} else {
+		s.policy.Insert(pageID)
+	}
 
-	// Add to head of list (most recently used)
-	bp.addToHead(node)
+	return node, nil
 }
 
-// evictLRU removes the least recently used page
-func (bp *BufferPool) evictLRU() {
-	// Get tail node (LRU)
-	lru := bp.tail.prev
-	if lru == bp.head {
-		return // Empty list
+// evict removes the page the policy picks among those not pinned
+// Pages read ahead are spared until fetched while anything else can go. A
+// dirty page that can't be written back stays cached and fails the pool,
+// after that only clean pages are evicted.
+func (s *poolShard) evict() error {
+	failed := s.bp.Err()
+	evictable := func(node *cacheNode) bool {
+		return node.pins == 0 && (failed == nil || !node.dirty.Load())
 	}
-
-	// Write dirty page to disk before eviction
-	if lru.dirty {
-		if err := bp.pager.WritePage(lru.pageID, lru.data); err != nil {
-			// Log error but continue (in production, handle this better)
-			fmt.Printf("Warning: failed to write page %d during eviction: %v\n", lru.pageID, err)
+	victimID, ok := s.policy.Victim(func(pageID uint64) bool {
+		node := s.cache[pageID]
+		return !evictable(node) || node.ahead
+	})
+	if !ok && s.unusedAhead > 0 {
+		victimID, ok = s.policy.Victim(func(pageID uint64) bool {
+			return !evictable(s.cache[pageID])
+		})
+	}
+	if !ok {
+		if failed != nil {
+			return failed
 		}
+		return ErrAllPagesPinned
 	}
+	victim := s.cache[victimID]
 
-	// Remove from list
-	bp.removeNode(lru)
-
-	// Remove from map
-	delete(bp.cache, lru.pageID)
+	// Write dirty page to disk before eviction
+	written, err := s.bp.writeBack(victim)
+	if err != nil {
+		s.bp.fail(fmt.Errorf("failed to write page %d during eviction: %w", victim.pageID, err))
+		return s.bp.Err()
+	}
+	if written {
+		s.evictWrites++
+		s.bp.writer.Load().wake() // Eviction had to wait for a write, the writer is behind
+	}
 
-	bp.evicts++
+	s.removeNode(victim)
+	s.evicts++
+	return nil
 }
 
-// moveToHead moves a node to the head (mark as most recently used)
-func (bp *BufferPool) moveToHead(node *cacheNode) {
-	bp.removeNode(node)
-	bp.addToHead(node)
+// removeNode drops a page from the shard and its policy
+func (s *poolShard) removeNode(node *cacheNode) {
+	if node.ahead {
+		s.unusedAhead--
+	}
+	s.policy.Remove(node.pageID)
+	delete(s.cache, node.pageID)
 }
 
-// addToHead adds a node to the head of the list
-func (bp *BufferPool) addToHead(node *cacheNode) {
-	node.next = bp.head.next
-	node.prev = bp.head
+// Err returns why the pool went read-only, nil while it is healthy
+// The error wraps ErrBufferPoolFailed and the write error that caused it.
+func (bp *BufferPool) Err() error {
+	bp.failMu.RLock()
+	defer bp.failMu.RUnlock()
 
-	bp.head.next.prev = node
-	bp.head.next = node
+	if bp.failed == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrBufferPoolFailed, bp.failed)
 }
 
-// removeNode removes a node from the list
-func (bp *BufferPool) removeNode(node *cacheNode) {
-	node.prev.next = node.next
-	node.next.prev = node.prev
+// fail turns the pool read-only, the first cause is kept
+func (bp *BufferPool) fail(err error) {
+	bp.failMu.Lock()
+	defer bp.failMu.Unlock()
+
+	if bp.failed == nil {
+		bp.failed = err
+	}
 }
 
-// GetStats returns cache statistics
+// GetStats returns cache statistics summed over the shards
 func (bp *BufferPool) GetStats() BufferPoolStats {
-	bp.mu.RLock()
-	defer bp.mu.RUnlock()
+	stats := BufferPoolStats{
+		Policy:             bp.shards[0].policy.Name(),
+		Shards:             len(bp.shards),
+		Capacity:           bp.capacity,
+		BackgroundRounds:   bp.bgRounds.Load(),
+		BackgroundWrites:   bp.bgWrites.Load(),
+		BackgroundWALWaits: bp.bgWALWaits.Load(),
+	}
 
-	hitRate := float64(0)
-	if bp.hits+bp.misses > 0 {
-		hitRate = float64(bp.hits) / float64(bp.hits+bp.misses)
+	for _, s := range bp.shards {
+		s.mu.Lock()
+		stats.Size += len(s.cache)
+		stats.Hits += s.hits
+		stats.Misses += s.misses
+		stats.Evictions += s.evicts
+		stats.EvictionWrites += s.evictWrites
+		stats.Prefetched += s.prefetched
+		stats.PrefetchHits += s.prefetchHits
+		stats.DirtyPages += s.countDirtyPages()
+		s.mu.Unlock()
 	}
 
-	return BufferPoolStats{
-		Capacity:   bp.capacity,
-		Size:       len(bp.cache),
-		Hits:       bp.hits,
-		Misses:     bp.misses,
-		Evictions:  bp.evicts,
-		HitRate:    hitRate,
-		DirtyPages: bp.countDirtyPages(),
+	if stats.Hits+stats.Misses > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(stats.Hits+stats.Misses)
 	}
+	return stats
 }
 
-// countDirtyPages counts number of dirty pages in cache
-func (bp *BufferPool) countDirtyPages() int {
+// countDirtyPages counts number of dirty pages in the shard
+func (s *poolShard) countDirtyPages() int {
 	count := 0
-	for _, node := range bp.cache {
-		if node.dirty {
+	for _, node := range s.cache {
+		if node.dirty.Load() {
 			count++
 		}
 	}
@@ -251,6 +653,8 @@ func (bp *BufferPool) countDirtyPages() int {
 
 // BufferPoolStats holds cache statistics
 type BufferPoolStats struct {
+	Policy     string
+	Shards     int
 	Capacity   int
 	Size       int
 	Hits       uint64
@@ -258,12 +662,20 @@ type BufferPoolStats struct {
 	Evictions  uint64
 	HitRate    float64
 	DirtyPages int
+
+	EvictionWrites     uint64 // Dirty pages eviction had to write itself
+	BackgroundRounds   uint64 // Background writer passes over the coldest pages
+	BackgroundWrites   uint64 // Dirty pages written by the background writer
+	BackgroundWALWaits uint64 // Pages skipped because the WAL wasn't durable up to their LSN
+
+	Prefetched   uint64 // Pages loaded by read-ahead
+	PrefetchHits uint64 // Read-ahead pages fetched afterwards
 }
 
 // String returns a formatted string of stats
 func (s BufferPoolStats) String() string {
 	return fmt.Sprintf(
-		"BufferPool{Capacity: %d, Size: %d, Hits: %d, Misses: %d, Evictions: %d, HitRate: %.2f%%, DirtyPages: %d}",
-		s.Capacity, s.Size, s.Hits, s.Misses, s.Evictions, s.HitRate*100, s.DirtyPages,
+		"BufferPool{Policy: %s, Shards: %d, Capacity: %d, Size: %d, Hits: %d, Misses: %d, Evictions: %d, HitRate: %.2f%%, DirtyPages: %d}",
+		s.Policy, s.Shards, s.Capacity, s.Size, s.Hits, s.Misses, s.Evictions, s.HitRate*100, s.DirtyPages,
 	)
 }
@@ -1,7 +1,10 @@
 package storage
 
 import (
+	"errors"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -276,6 +279,186 @@ func TestBufferPoolFlush(t *testing.T) {
 	}
 }
 
+func TestBufferPoolPinning(t *testing.T) {
+	dbFile := "test_buffer_pin.db"
+	defer os.Remove(dbFile)
+
+	pager, err := NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	bp := NewBufferPool(pager, 3)
+
+	pageIDs := make([]uint64, 4)
+	for i := range pageIDs {
+		if pageIDs[i], err = bp.AllocatePage(); err != nil {
+			t.Fatalf("Failed to allocate page: %v", err)
+		}
+	}
+
+	// Pin a full pool, nothing can be loaded
+	frames := make([]*Frame, 3)
+	for i := range frames {
+		if frames[i], err = bp.FetchPage(pageIDs[i]); err != nil {
+			t.Fatalf("Failed to fetch page %d: %v", pageIDs[i], err)
+		}
+	}
+	if _, err := bp.FetchPage(pageIDs[3]); err != ErrAllPagesPinned {
+		t.Fatalf("FetchPage with every page pinned returned %v, expected ErrAllPagesPinned", err)
+	}
+
+	// Changes through one frame are seen through another without copies
+	frames[0].Lock()
+	frames[0].Data()[PageHeaderSize] = 0xCD
+	frames[0].MarkDirty()
+	frames[0].Unlock()
+
+	again, err := bp.FetchPage(pageIDs[0])
+	if err != nil {
+		t.Fatalf("Failed to fetch pinned page: %v", err)
+	}
+	if again.Data()[PageHeaderSize] != 0xCD {
+		t.Errorf("Second frame sees %#x, expected 0xCD", again.Data()[PageHeaderSize])
+	}
+	again.Unpin()
+
+	// Only the unpinned page can make room
+	frames[1].Unpin()
+	frames[1].Unpin() // No-op, must not unpin for someone else
+	if _, err := bp.ReadPage(pageIDs[3]); err != nil {
+		t.Fatalf("Failed to read page after unpinning: %v", err)
+	}
+	if stats := bp.GetStats(); stats.Evictions != 1 {
+		t.Errorf("Evictions = %d, expected 1", stats.Evictions)
+	}
+
+	hits := bp.GetStats().Hits
+	bp.ReadPage(pageIDs[0])
+	bp.ReadPage(pageIDs[2])
+	if stats := bp.GetStats(); stats.Hits != hits+2 {
+		t.Errorf("Pinned pages were evicted: %d hits, expected %d", stats.Hits, hits+2)
+	}
+
+	if err := bp.FreePage(pageIDs[2]); err == nil {
+		t.Error("Freeing a pinned page succeeded")
+	}
+
+	frames[0].Unpin()
+	frames[2].Unpin()
+	if err := bp.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	data, err := pager.ReadPage(pageIDs[0])
+	if err != nil {
+		t.Fatalf("Failed to read page from disk: %v", err)
+	}
+	if data[PageHeaderSize] != 0xCD {
+		t.Errorf("Flushed page holds %#x, expected 0xCD", data[PageHeaderSize])
+	}
+}
+
+// failingPager is a FilePager whose page writes fail while failWrites is set
+type failingPager struct {
+	*FilePager
+	failWrites bool
+}
+
+// WritePage fails with an I/O error while failWrites is set
+func (p *failingPager) WritePage(id uint64, data []byte) error {
+	if p.failWrites {
+		return errors.New("injected write failure")
+	}
+	return p.FilePager.WritePage(id, data)
+}
+
+func TestBufferPoolEvictionWriteFailure(t *testing.T) {
+	dbFile := "test_buffer_fail.db"
+	defer os.Remove(dbFile)
+
+	filePager, err := NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	pager := &failingPager{FilePager: filePager}
+	defer pager.Close()
+
+	bp := NewBufferPool(pager, 2)
+
+	pageIDs := make([]uint64, 4)
+	for i := range pageIDs {
+		if pageIDs[i], err = bp.AllocatePage(); err != nil {
+			t.Fatalf("Failed to allocate page: %v", err)
+		}
+	}
+
+	// Page 0 is dirty and clean page 1 is more recently used, so the first
+	// miss tries to write page 0 back
+	data := make([]byte, PageSize)
+	data[PageHeaderSize] = 0xAB
+	if err := bp.WritePage(pageIDs[0], data); err != nil {
+		t.Fatalf("Failed to write page: %v", err)
+	}
+	if _, err := bp.ReadPage(pageIDs[1]); err != nil {
+		t.Fatalf("Failed to read page: %v", err)
+	}
+
+	pager.failWrites = true
+	if _, err := bp.ReadPage(pageIDs[2]); !errors.Is(err, ErrBufferPoolFailed) {
+		t.Fatalf("ReadPage with a failing write-back returned %v, expected ErrBufferPoolFailed", err)
+	}
+	pager.failWrites = false
+
+	cause := bp.Err()
+	if !errors.Is(cause, ErrBufferPoolFailed) || !strings.Contains(cause.Error(), "injected write failure") {
+		t.Fatalf("Err() = %v, expected the write failure", cause)
+	}
+
+	// The dirty page was kept, not dropped
+	got, err := bp.ReadPage(pageIDs[0])
+	if err != nil {
+		t.Fatalf("Failed to read the unwritten page: %v", err)
+	}
+	if got[PageHeaderSize] != 0xAB {
+		t.Errorf("Unwritten page holds %#x, expected 0xAB", got[PageHeaderSize])
+	}
+
+	// Reads still evict clean pages, every change is refused
+	if _, err := bp.ReadPage(pageIDs[3]); err != nil {
+		t.Errorf("Read in a failed pool returned %v, expected it to evict a clean page", err)
+	}
+	if err := bp.WritePage(pageIDs[1], data); !errors.Is(err, ErrBufferPoolFailed) {
+		t.Errorf("WritePage returned %v, expected ErrBufferPoolFailed", err)
+	}
+	if _, err := bp.AllocatePage(); !errors.Is(err, ErrBufferPoolFailed) {
+		t.Errorf("AllocatePage returned %v, expected ErrBufferPoolFailed", err)
+	}
+	if err := bp.Flush(); !errors.Is(err, ErrBufferPoolFailed) {
+		t.Errorf("Flush returned %v, expected ErrBufferPoolFailed", err)
+	}
+
+	// Only the dirty page is left to evict and it can't be dropped
+	frame, err := bp.FetchPage(pageIDs[3])
+	if err != nil {
+		t.Fatalf("Failed to fetch page: %v", err)
+	}
+	defer frame.Unpin()
+	if _, err := bp.ReadPage(pageIDs[2]); !errors.Is(err, ErrBufferPoolFailed) {
+		t.Errorf("ReadPage needing the dirty frame returned %v, expected ErrBufferPoolFailed", err)
+	}
+
+	// Nothing reached the disk
+	onDisk, err := filePager.ReadPage(pageIDs[0])
+	if err != nil {
+		t.Fatalf("Failed to read page from disk: %v", err)
+	}
+	if onDisk[PageHeaderSize] != 0 {
+		t.Errorf("Disk page holds %#x, expected the write to be missing", onDisk[PageHeaderSize])
+	}
+}
+
 func TestBufferPoolSequentialAccess(t *testing.T) {
 	dbFile := "test_buffer_sequential.db"
 	defer os.Remove(dbFile)
@@ -359,3 +542,79 @@ func BenchmarkBufferPoolRead(b *testing.B) {
 	stats := bp.GetStats()
 	b.Logf("Hit rate: %.2f%%", stats.HitRate*100)
 }
+
+func TestShardedBufferPool(t *testing.T) {
+	dbFile := "test_buffer_pool_sharded.db"
+	defer os.Remove(dbFile)
+
+	pager, err := NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	bp := NewShardedBufferPool(pager, 64, 4, func(capacity int) ReplacementPolicy {
+		return NewTwoQPolicy(capacity)
+	})
+	defer bp.Close()
+
+	pageIDs := make([]uint64, 100)
+	for i := range pageIDs {
+		if pageIDs[i], err = bp.AllocatePage(); err != nil {
+			t.Fatalf("Failed to allocate page: %v", err)
+		}
+		data := make([]byte, PageSize)
+		data[PageHeaderSize] = byte(i)
+		if err := bp.WritePage(pageIDs[i], data); err != nil {
+			t.Fatalf("Failed to write page %d: %v", pageIDs[i], err)
+		}
+	}
+
+	// Readers hit every shard at once
+	var wg sync.WaitGroup
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := range pageIDs {
+				i = (i + r*13) % len(pageIDs)
+				data, err := bp.ReadPage(pageIDs[i])
+				if err != nil {
+					t.Errorf("Failed to read page %d: %v", pageIDs[i], err)
+					return
+				}
+				if data[PageHeaderSize] != byte(i) {
+					t.Errorf("Page %d holds %d, expected %d", pageIDs[i], data[PageHeaderSize], i)
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	// Each shard fills up on its own, the totals add up across them
+	stats := bp.GetStats()
+	if stats.Shards != 4 || stats.Policy != "2Q" || stats.Capacity != 64 || stats.Size != 64 {
+		t.Errorf("Shards = %d, Policy = %q, Capacity = %d, Size = %d, expected 4 full 2Q shards of 64 pages",
+			stats.Shards, stats.Policy, stats.Capacity, stats.Size)
+	}
+	if stats.Hits+stats.Misses != 100+8*100 {
+		t.Errorf("Hits + Misses = %d, expected one per write and read", stats.Hits+stats.Misses)
+	}
+	if stats.Evictions != stats.Misses-uint64(stats.Size) {
+		t.Errorf("Evictions = %d with %d misses, expected every miss beyond the capacity to evict", stats.Evictions, stats.Misses)
+	}
+
+	if err := bp.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if stats := bp.GetStats(); stats.DirtyPages != 0 {
+		t.Errorf("DirtyPages = %d after Flush", stats.DirtyPages)
+	}
+
+	if shards := NewBufferPool(pager, 128).GetStats().Shards; shards != 8 {
+		t.Errorf("NewBufferPool(128) has %d shards, expected 8", shards)
+	}
+	if shards := NewBufferPool(pager, 10).GetStats().Shards; shards != 1 {
+		t.Errorf("NewBufferPool(10) has %d shards, expected 1", shards)
+	}
+}
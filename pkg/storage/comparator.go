@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// MaxKeySize is the largest key a tree accepts, so every internal page
+// holds several separators and a split always finds room in the parent
+const MaxKeySize = 512
+
+// ErrKeyTooLarge is returned for keys longer than MaxKeySize
+var ErrKeyTooLarge = fmt.Errorf("key larger than %d bytes", MaxKeySize)
+
+// ErrEmptyKey is returned for zero-length keys
+var ErrEmptyKey = errors.New("key is empty")
+
+// Comparator orders the keys of a tree
+// The name is recorded in the superblock when the tree is created, a tree
+// only opens again with a comparator of the same name.
+type Comparator interface {
+	// Compare returns -1, 0 or +1 as a sorts before, equal to or after b
+	Compare(a, b []byte) int
+	// Name identifies the ordering, at most 32 bytes
+	Name() string
+}
+
+// bytewiseComparator orders keys as unsigned byte strings
+type bytewiseComparator struct{}
+
+func (bytewiseComparator) Compare(a, b []byte) int { return bytes.Compare(a, b) }
+func (bytewiseComparator) Name() string            { return "bytewise" }
+
+// BytewiseComparator orders keys lexicographically by byte, the default
+// uint32 keys are stored big-endian so they sort numerically under it
+var BytewiseComparator Comparator = bytewiseComparator{}
+
+// Uint32Key encodes a uint32 key so that byte order matches numeric order
+func Uint32Key(key uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, key)
+	return buf
+}
+
+// CheckKey rejects keys a tree can't store
+func CheckKey(key []byte) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	if len(key) > MaxKeySize {
+		return fmt.Errorf("%w: %d bytes", ErrKeyTooLarge, len(key))
+	}
+	return nil
+}
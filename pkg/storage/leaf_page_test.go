@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 )
 
@@ -42,7 +44,7 @@ func TestLeafPageInsertAndSearch(t *testing.T) {
 	}
 
 	// Test search
-	record, found := leafPage.SearchRecord(100)
+	record, found := leafPage.SearchRecord(Uint32Key(100))
 	if !found {
 		t.Error("Record with key 100 not found")
 	}
@@ -51,7 +53,7 @@ func TestLeafPageInsertAndSearch(t *testing.T) {
 	}
 
 	// Test search not found
-	_, found = leafPage.SearchRecord(999)
+	_, found = leafPage.SearchRecord(Uint32Key(999))
 	if found {
 		t.Error("Should not find record with key 999")
 	}
@@ -79,3 +81,136 @@ func TestLeafPageFull(t *testing.T) {
 		t.Errorf("Page should fit at least 10 records, only fit %d", i)
 	}
 }
+
+func TestLeafPageDeleteRecord(t *testing.T) {
+	page := NewPage(PageTypeLeaf)
+	leafPage := NewLeafPage(page)
+
+	for _, key := range []uint32{10, 20, 30, 40} {
+		if err := leafPage.InsertRecord(NewRecordFromInts(key, "value")); err != nil {
+			t.Fatalf("Failed to insert record: %v", err)
+		}
+	}
+	spaceBefore := leafPage.AvailableSpace()
+
+	if !leafPage.DeleteRecord(Uint32Key(20)) {
+		t.Fatal("DeleteRecord(20) returned false")
+	}
+	if leafPage.DeleteRecord(Uint32Key(999)) {
+		t.Error("DeleteRecord(999) returned true for missing key")
+	}
+
+	if leafPage.NumRecords() != 3 {
+		t.Errorf("NumRecords = %d, expected 3", leafPage.NumRecords())
+	}
+	if _, found := leafPage.SearchRecord(Uint32Key(20)); found {
+		t.Error("Record with key 20 still found")
+	}
+
+	// Deleted bytes must be reclaimed (record + slot)
+	reclaimed := leafPage.AvailableSpace() - spaceBefore
+	if expected := NewRecordFromInts(20, "value").Size() + 2; reclaimed != expected {
+		t.Errorf("Reclaimed %d bytes, expected %d", reclaimed, expected)
+	}
+
+	for _, key := range []uint32{10, 30, 40} {
+		if _, found := leafPage.SearchRecord(Uint32Key(key)); !found {
+			t.Errorf("Record with key %d not found", key)
+		}
+	}
+}
+
+func TestLeafPageVersions(t *testing.T) {
+	leafPage := NewLeafPage(NewPage(PageTypeLeaf))
+
+	// Key 10 written at LSN 1, replaced at LSN 5
+	old := NewRecordFromInts(10, "old")
+	old.CreatedBy = 1
+	leafPage.InsertRecord(old)
+	if !leafPage.MarkDeleted(Uint32Key(10), 5) {
+		t.Fatal("MarkDeleted found no live version")
+	}
+
+	current := NewRecordFromInts(10, "new")
+	current.CreatedBy = 5
+	leafPage.InsertRecord(current)
+
+	if record, found := leafPage.SearchRecord(Uint32Key(10)); !found || record.GetValueAsString() != "new" {
+		t.Errorf("SearchRecord(10) returned %v, expected the live version", record)
+	}
+
+	testCases := []struct {
+		lsn      uint64
+		expected string
+	}{
+		{0, ""},
+		{1, "old"},
+		{4, "old"},
+		{5, "new"},
+		{9, "new"},
+	}
+	for _, tc := range testCases {
+		record, found := leafPage.SearchVisible(Uint32Key(10), tc.lsn)
+		if tc.expected == "" {
+			if found {
+				t.Errorf("LSN %d: found %s, expected nothing", tc.lsn, record.GetValueAsString())
+			}
+			continue
+		}
+		if !found || record.GetValueAsString() != tc.expected {
+			t.Errorf("LSN %d: found=%v, expected %s", tc.lsn, found, tc.expected)
+		}
+	}
+
+	// A snapshot still needs the old version
+	if removed := leafPage.PruneVersions(Uint32Key(10), func(*Record) bool { return true }); len(removed) != 0 {
+		t.Errorf("PruneVersions removed %d needed versions", len(removed))
+	}
+	if removed := leafPage.PruneVersions(Uint32Key(10), func(*Record) bool { return false }); len(removed) != 1 {
+		t.Errorf("PruneVersions removed %d versions, expected 1", len(removed))
+	}
+	if leafPage.NumRecords() != 1 {
+		t.Errorf("NumRecords = %d after prune, expected 1", leafPage.NumRecords())
+	}
+
+	// Deleting removes only the live version
+	if !leafPage.DeleteRecord(Uint32Key(10)) {
+		t.Error("DeleteRecord(10) found no live version")
+	}
+	if _, found := leafPage.SearchRecord(Uint32Key(10)); found {
+		t.Error("Key 10 still found after delete")
+	}
+}
+
+// reverseComparator orders keys from largest to smallest
+type reverseComparator struct{}
+
+func (reverseComparator) Compare(a, b []byte) int { return bytes.Compare(b, a) }
+func (reverseComparator) Name() string            { return "reverse" }
+
+func TestLeafPageComparator(t *testing.T) {
+	page := NewPage(PageTypeLeaf)
+	leafPage := NewLeafPageWithComparator(page, reverseComparator{})
+
+	for _, key := range []string{"bob", "alice", "carol/admin", "carol"} {
+		if err := leafPage.InsertRecord(NewRecord([]byte(key), []byte("v-"+key))); err != nil {
+			t.Fatalf("Failed to insert %q: %v", key, err)
+		}
+	}
+
+	records, _ := leafPage.GetAllRecords()
+	var keys []string
+	for _, record := range records {
+		keys = append(keys, string(record.Key))
+	}
+	if strings.Join(keys, ",") != "carol/admin,carol,bob,alice" {
+		t.Errorf("Records in order %v, expected the comparator's order", keys)
+	}
+
+	if record, found := leafPage.SearchRecord([]byte("carol")); !found || string(record.Value) != "v-carol" {
+		t.Errorf("SearchRecord(carol) = %v, %v", record, found)
+	}
+	if _, found := leafPage.SearchRecord([]byte("car")); found {
+		t.Error("SearchRecord found a key that is only a prefix of one stored")
+	}
+}
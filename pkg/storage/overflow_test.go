@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestOverflowValue(t *testing.T) {
+	dbFile := "test_overflow.db"
+	defer os.Remove(dbFile)
+
+	pager, err := NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	// A record that fits a leaf stays inline
+	small := NewRecord([]byte("small"), bytes.Repeat([]byte("s"), 1000))
+	if spilled, err := SpillValue(pager, small, 1); err != nil || spilled {
+		t.Fatalf("SpillValue of a %d-byte record = %v, %v, expected it kept inline", small.Size(), spilled, err)
+	}
+
+	value := make([]byte, 50000)
+	for i := range value {
+		value[i] = byte(i * 7)
+	}
+	record := NewRecord([]byte("tenant-1/blob"), value)
+	record.CreatedBy = 9
+
+	spilled, err := SpillValue(pager, record, 9)
+	if err != nil || !spilled {
+		t.Fatalf("SpillValue = %v, %v, expected the value spilled", spilled, err)
+	}
+	if record.Size() > MaxInlineRecordSize || !bytes.Equal(record.Value, value[:OverflowPrefixSize]) {
+		t.Errorf("Spilled record is %d bytes with a %d-byte prefix", record.Size(), len(record.Value))
+	}
+
+	chain, err := OverflowChain(pager, record.Overflow)
+	if err != nil {
+		t.Fatalf("OverflowChain failed: %v", err)
+	}
+	if expected := (len(value) - OverflowPrefixSize + overflowChunkSize - 1) / overflowChunkSize; len(chain) != expected {
+		t.Errorf("Chain has %d pages, expected %d", len(chain), expected)
+	}
+
+	// The reference to the chain survives the leaf
+	page := NewPage(PageTypeLeaf)
+	leaf := NewLeafPage(page)
+	if err := leaf.InsertRecord(record); err != nil {
+		t.Fatalf("Failed to insert spilled record: %v", err)
+	}
+	stored, found := leaf.SearchRecord([]byte("tenant-1/blob"))
+	if !found || !stored.IsOverflow() || stored.ValueSize != uint32(len(value)) || stored.CreatedBy != 9 {
+		t.Fatalf("Stored record = %+v, expected the overflow reference kept", stored)
+	}
+
+	got, err := ReadValue(pager, stored)
+	if err != nil {
+		t.Fatalf("ReadValue failed: %v", err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Errorf("ReadValue returned %d bytes, expected the %d-byte value", len(got), len(value))
+	}
+
+	// A copy of the chain reads back the same value
+	copied := *stored
+	pageIDs := writeTestPages(t, pager, len(chain))
+	if err := CopyOverflow(pager, &copied, pageIDs, 10); err != nil {
+		t.Fatalf("CopyOverflow failed: %v", err)
+	}
+	if got, err := ReadValue(pager, &copied); err != nil || copied.Overflow != pageIDs[0] || !bytes.Equal(got, value) {
+		t.Errorf("Copied chain at page %d read %d bytes, %v, expected the value at page %d",
+			copied.Overflow, len(got), err, pageIDs[0])
+	}
+
+	if err := FreeOverflow(pager, stored); err != nil {
+		t.Fatalf("FreeOverflow failed: %v", err)
+	}
+	if pager.FreeListSize() != len(chain) {
+		t.Errorf("Free list has %d pages, expected the %d overflow pages", pager.FreeListSize(), len(chain))
+	}
+}
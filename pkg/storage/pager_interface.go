@@ -8,8 +8,46 @@ type Pager interface {
 	WritePage(id uint64, data []byte) error
 	// AllocatePage allocate a new page and return ID
 	AllocatePage() (uint64, error)
+	// FreePage return a page to the free list for reuse
+	FreePage(id uint64) error
+	// ReadSuperblock returns a copy of the database superblock
+	ReadSuperblock() (*Superblock, error)
+	// WriteSuperblock durably replaces the database superblock
+	WriteSuperblock(sb *Superblock) error
+	// Flush makes all written pages durable
+	Flush() error
 	// Close closes database file
 	Close() error
 }
 
-const PageSize = 4096
\ No newline at end of file
+const PageSize = 4096
+
+// PageFetcher is a pager that can hand out pinned pages without copying them
+type PageFetcher interface {
+	// FetchPage pins a page and returns its frame, Unpin it when done
+	FetchPage(id uint64) (*Frame, error)
+	// Err returns why the pager stopped accepting changes, nil while it is healthy
+	Err() error
+}
+
+// WALSyncer is a pager that makes the WAL durable up to a page's LSN before
+// writing the page, so no change reaches the data file ahead of its log
+// record. Set before the pager is shared.
+type WALSyncer interface {
+	// SetWALSync sets the function forcing the WAL up to an LSN
+	SetWALSync(sync func(lsn uint64) error)
+}
+
+// Resizer is a pager that exposes the layout of its file, so a vacuum can
+// rearrange it and a check can account for every page
+type Resizer interface {
+	// NumPages returns the number of pages in the file
+	NumPages() uint64
+	// FreePageIDs returns every page on the free list, pages freed since the
+	// last Flush included
+	FreePageIDs() []uint64
+	// Resize sets the file to numPages pages and replaces the free list with
+	// free. Pages past the end and pages freed since the last Flush are
+	// forgotten, the caller knows every page in use.
+	Resize(numPages uint64, free []uint64) error
+}
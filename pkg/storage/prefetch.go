@@ -0,0 +1,79 @@
+package storage
+
+// prefetchLoad is a page being read ahead
+type prefetchLoad struct {
+	done      chan struct{} // Closed once the read finished, cached or not
+	cancelled bool          // The page was freed or reallocated meanwhile, drop the read
+}
+
+// Prefetcher is a pager that can load pages ahead of use
+type Prefetcher interface {
+	// Prefetch starts loading pages in the background and returns at once
+	Prefetch(ids []uint64)
+}
+
+// Prefetch reads pages ahead of use in the background, so a later fetch
+// finds them cached instead of waiting for the disk. Pages already cached or
+// on their way are skipped. Pages read ahead go in at the cold end of the
+// replacement policy and count as loaded, not used, when first fetched, so
+// a scan reading ahead doesn't push hot pages out. Until fetched they are
+// only evicted when nothing else can be, and at most a quarter of each
+// shard is read ahead at a time.
+func (bp *BufferPool) Prefetch(ids []uint64) {
+	if bp.Err() != nil {
+		return
+	}
+
+	for _, id := range ids {
+		bp.shard(id).startPrefetch(id)
+	}
+}
+
+// startPrefetch starts reading one page ahead unless it is cached, already
+// on its way or the shard has read ahead as much as it may
+func (s *poolShard) startPrefetch(id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.unusedAhead+len(s.prefetching) >= max(s.capacity/4, 1) {
+		return
+	}
+	if _, cached := s.cache[id]; cached || s.prefetching[id] != nil {
+		return
+	}
+
+	load := &prefetchLoad{done: make(chan struct{})}
+	s.prefetching[id] = load
+	s.bp.prefetchWG.Add(1)
+	go s.prefetch(id, load)
+}
+
+// prefetch reads one page without holding the shard lock, then caches it
+// Fetches of the page wait for the read, so nobody can load, change and
+// write the page back in the meantime and leave the read stale.
+func (s *poolShard) prefetch(id uint64, load *prefetchLoad) {
+	defer s.bp.prefetchWG.Done()
+
+	data, err := s.bp.pager.ReadPage(id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.prefetching, id)
+	close(load.done)
+
+	// Read-ahead is a hint, a page that can't be read or cached is skipped
+	if err != nil || load.cancelled || s.bp.Err() != nil {
+		return
+	}
+	if _, err := s.addToCache(id, data, true); err == nil {
+		s.prefetched++
+	}
+}
+
+// cancelPrefetch drops an in-flight read of a page that was freed or reallocated
+func (s *poolShard) cancelPrefetch(id uint64) {
+	if load := s.prefetching[id]; load != nil {
+		load.cancelled = true
+	}
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"os"
+	"testing"
+)
+
+// gatedPager holds page reads until the gate is opened
+type gatedPager struct {
+	Pager
+	gate chan struct{}
+}
+
+// ReadPage reads a page once the gate is open
+func (p *gatedPager) ReadPage(id uint64) ([]byte, error) {
+	<-p.gate
+	return p.Pager.ReadPage(id)
+}
+
+// writeTestPages writes n pages straight to the pager, each marked with its index
+func writeTestPages(t *testing.T, pager Pager, n int) []uint64 {
+	t.Helper()
+	pageIDs := make([]uint64, n)
+	for i := range pageIDs {
+		pageID, err := pager.AllocatePage()
+		if err != nil {
+			t.Fatalf("Failed to allocate page: %v", err)
+		}
+		pageIDs[i] = pageID
+
+		data := make([]byte, PageSize)
+		data[PageHeaderSize] = byte(i)
+		if err := pager.WritePage(pageID, data); err != nil {
+			t.Fatalf("Failed to write page %d: %v", pageID, err)
+		}
+	}
+	return pageIDs
+}
+
+// isCached reports whether a page is in the pool
+func isCached(bp *BufferPool, id uint64) bool {
+	s := bp.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, cached := s.cache[id]
+	return cached
+}
+
+func TestBufferPoolPrefetch(t *testing.T) {
+	dbFile := "test_buffer_pool_prefetch.db"
+	defer os.Remove(dbFile)
+
+	pager, err := NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	pageIDs := writeTestPages(t, pager, 40)
+
+	bp := NewBufferPoolWithPolicy(pager, 16, NewLRUKPolicy(2))
+	defer bp.Close()
+
+	// Pages 0-3 are hot
+	for round := 0; round < 2; round++ {
+		for _, pageID := range pageIDs[:4] {
+			if _, err := bp.ReadPage(pageID); err != nil {
+				t.Fatalf("Failed to read page %d: %v", pageID, err)
+			}
+		}
+	}
+
+	// A scan reads the rest, each page fetched after being read ahead
+	for i := 4; i < len(pageIDs); i++ {
+		bp.Prefetch(pageIDs[i+1 : min(i+4, len(pageIDs))])
+
+		data, err := bp.ReadPage(pageIDs[i])
+		if err != nil {
+			t.Fatalf("Failed to read page %d: %v", pageIDs[i], err)
+		}
+		if data[PageHeaderSize] != byte(i) {
+			t.Errorf("Page %d holds %d, expected %d", pageIDs[i], data[PageHeaderSize], i)
+		}
+	}
+
+	stats := bp.GetStats()
+	if stats.Misses != 5 || stats.Prefetched != 35 || stats.PrefetchHits != 35 {
+		t.Errorf("Misses = %d, Prefetched = %d, PrefetchHits = %d, expected only the first scan page missed",
+			stats.Misses, stats.Prefetched, stats.PrefetchHits)
+	}
+
+	// Pages read ahead went in cold and didn't push the hot pages out
+	for _, pageID := range pageIDs[:4] {
+		if !isCached(bp, pageID) {
+			t.Errorf("Hot page %d evicted by the scan", pageID)
+		}
+	}
+}
+
+func TestBufferPoolPrefetchFreed(t *testing.T) {
+	dbFile := "test_buffer_pool_prefetch_freed.db"
+	defer os.Remove(dbFile)
+
+	pager, err := NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	pageIDs := writeTestPages(t, pager, 2)
+
+	gated := &gatedPager{Pager: pager, gate: make(chan struct{})}
+	bp := NewBufferPool(gated, 8)
+	defer bp.Close()
+
+	// The first page is freed while its read is still in flight
+	bp.Prefetch(pageIDs)
+	if err := bp.FreePage(pageIDs[0]); err != nil {
+		t.Fatalf("Failed to free page %d: %v", pageIDs[0], err)
+	}
+	close(gated.gate)
+	bp.prefetchWG.Wait()
+
+	stats := bp.GetStats()
+	if stats.Prefetched != 1 || stats.Size != 1 {
+		t.Errorf("Prefetched = %d, Size = %d, expected the freed page's read dropped", stats.Prefetched, stats.Size)
+	}
+
+	freedCached, keptCached := isCached(bp, pageIDs[0]), isCached(bp, pageIDs[1])
+	if freedCached || !keptCached {
+		t.Errorf("Freed page cached = %v, other page cached = %v", freedCached, keptCached)
+	}
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	SuperblockPageID = 1
+
+	SuperblockMagic uint32 = 0x53484447 // "SHDG"
+	FormatVersion   uint16 = 1          // First layout with a superblock
+
+	superblockSize     = 84  // Serialized bytes including trailing CRC
+	superblockSlotSize = 512 // Each copy has a sector of page 1 to itself
+	superblockSlots    = 2
+
+	maxComparatorName = 32 // Bytes reserved for the comparator name
+)
+
+// ErrPreSuperblock is returned when opening a file written before the
+// superblock, which kept its root node in page 1 and its metadata in a
+// <wal>.meta file
+var ErrPreSuperblock = errors.New("pre-superblock file, rebuild required")
+
+// crcTable is the CRC32 table used for on-disk checksums
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Superblock holds database-wide metadata stored in page 1
+// Page 1 holds two copies, one per 512-byte slot, and writes alternate
+// between them. A torn write damages at most the copy being written, the
+// other still opens the file. The copy with the highest sequence wins.
+// Layout of a copy (little endian):
+// [magic: 4][version: 2][reserved: 2][pageSize: 4][order: 4]
+// [rootPageID: 8][freeListHead: 8][checkpointLSN: 8][comparator: 32]
+// [sequence: 8][crc32: 4]
+type Superblock struct {
+	Magic         uint32
+	Version       uint16
+	PageSize      uint32
+	Order         uint32
+	RootPageID    uint64 // 0 means no tree has been created yet
+	FreeListHead  uint64
+	CheckpointLSN uint64
+	Comparator    string // Name of the comparator ordering the tree's keys
+	Sequence      uint64 // Bumped by every write, picks the newest copy
+}
+
+// NewSuperblock returns the superblock of an empty database
+func NewSuperblock() *Superblock {
+	return &Superblock{
+		Magic:        SuperblockMagic,
+		Version:      FormatVersion,
+		PageSize:     PageSize,
+		FreeListHead: FreeListPageID,
+	}
+}
+
+// Serialize encodes the superblock into one copy
+func (sb *Superblock) Serialize() []byte {
+	buf := make([]byte, superblockSize)
+	sb.encodeFields(buf)
+	binary.LittleEndian.PutUint64(buf[72:80], sb.Sequence)
+
+	// Checksum lets us reject a torn or foreign superblock
+	binary.LittleEndian.PutUint32(buf[80:84], crc32.Checksum(buf[0:80], crcTable))
+
+	return buf
+}
+
+// DeserializeSuperblock decodes and validates one copy of the superblock
+func DeserializeSuperblock(buf []byte) (*Superblock, error) {
+	sb, err := decodeSuperblock(buf)
+	if err != nil {
+		return nil, err
+	}
+	if err := sb.validate(); err != nil {
+		return nil, err
+	}
+	return sb, nil
+}
+
+// decodeSuperblock decodes one copy of the superblock, only checking it is intact
+func decodeSuperblock(buf []byte) (*Superblock, error) {
+	if len(buf) < superblockSize {
+		return nil, fmt.Errorf("insufficient data for superblock")
+	}
+
+	expected := binary.LittleEndian.Uint32(buf[80:84])
+	if actual := crc32.Checksum(buf[0:80], crcTable); actual != expected {
+		return nil, fmt.Errorf("superblock checksum mismatch: %08x != %08x", actual, expected)
+	}
+
+	sb := decodeFields(buf)
+	sb.Sequence = binary.LittleEndian.Uint64(buf[72:80])
+	return sb, nil
+}
+
+// encodeFields writes the fields before the sequence into buf[0:72]
+func (sb *Superblock) encodeFields(buf []byte) {
+	binary.LittleEndian.PutUint32(buf[0:4], sb.Magic)
+	binary.LittleEndian.PutUint16(buf[4:6], sb.Version)
+	binary.LittleEndian.PutUint32(buf[8:12], sb.PageSize)
+	binary.LittleEndian.PutUint32(buf[12:16], sb.Order)
+	binary.LittleEndian.PutUint64(buf[16:24], sb.RootPageID)
+	binary.LittleEndian.PutUint64(buf[24:32], sb.FreeListHead)
+	binary.LittleEndian.PutUint64(buf[32:40], sb.CheckpointLSN)
+	copy(buf[40:40+maxComparatorName], sb.Comparator)
+}
+
+// decodeFields reads the fields before the sequence from buf[0:72]
+func decodeFields(buf []byte) *Superblock {
+	return &Superblock{
+		Magic:         binary.LittleEndian.Uint32(buf[0:4]),
+		Version:       binary.LittleEndian.Uint16(buf[4:6]),
+		PageSize:      binary.LittleEndian.Uint32(buf[8:12]),
+		Order:         binary.LittleEndian.Uint32(buf[12:16]),
+		RootPageID:    binary.LittleEndian.Uint64(buf[16:24]),
+		FreeListHead:  binary.LittleEndian.Uint64(buf[24:32]),
+		CheckpointLSN: binary.LittleEndian.Uint64(buf[32:40]),
+		Comparator:    string(bytes.TrimRight(buf[40:40+maxComparatorName], "\x00")),
+	}
+}
+
+// validate checks that this build can open the file the superblock describes
+func (sb *Superblock) validate() error {
+	if sb.Magic != SuperblockMagic {
+		return fmt.Errorf("not a sharingan database: bad magic %08x", sb.Magic)
+	}
+	if sb.Version != FormatVersion {
+		return fmt.Errorf("unsupported format version %d: this release reads version %d", sb.Version, FormatVersion)
+	}
+	if sb.PageSize != PageSize {
+		return fmt.Errorf("page size mismatch: file uses %d, expected %d", sb.PageSize, PageSize)
+	}
+	return nil
+}
+
+// String returns string representation of superblock
+func (sb *Superblock) String() string {
+	return fmt.Sprintf("Superblock{Version: %d, PageSize: %d, Order: %d, Root: %d, FreeListHead: %d, CheckpointLSN: %d, Comparator: %q, Sequence: %d}",
+		sb.Version, sb.PageSize, sb.Order, sb.RootPageID, sb.FreeListHead, sb.CheckpointLSN, sb.Comparator, sb.Sequence)
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSuperblockSerialization(t *testing.T) {
+	sb := NewSuperblock()
+	sb.RootPageID = 42
+	sb.Order = 100
+	sb.CheckpointLSN = 7
+	sb.Sequence = 3
+
+	buf := sb.Serialize()
+
+	decoded, err := DeserializeSuperblock(buf)
+	if err != nil {
+		t.Fatalf("Failed to deserialize superblock: %v", err)
+	}
+	if *decoded != *sb {
+		t.Errorf("Decoded %s, expected %s", decoded, sb)
+	}
+
+	// Any flipped bit must be caught by the checksum
+	buf[16] ^= 0x01
+	if _, err := DeserializeSuperblock(buf); err == nil {
+		t.Error("Expected checksum error for corrupted superblock")
+	}
+}
+
+func TestSuperblockPersistence(t *testing.T) {
+	dbFile := "test_superblock.db"
+	defer os.Remove(dbFile)
+
+	pager, err := NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+
+	sb, err := pager.ReadSuperblock()
+	if err != nil {
+		t.Fatalf("Failed to read superblock: %v", err)
+	}
+	if sb.RootPageID != 0 {
+		t.Errorf("New database has root %d, expected 0", sb.RootPageID)
+	}
+
+	sb.RootPageID = 5
+	sb.Order = 64
+	if err := pager.WriteSuperblock(sb); err != nil {
+		t.Fatalf("Failed to write superblock: %v", err)
+	}
+	pager.Close()
+
+	// Reopen and verify
+	pager2, err := NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen pager: %v", err)
+	}
+	defer pager2.Close()
+
+	sb2, err := pager2.ReadSuperblock()
+	if err != nil {
+		t.Fatalf("Failed to read superblock: %v", err)
+	}
+	if sb2.RootPageID != 5 || sb2.Order != 64 {
+		t.Errorf("Reopened %s, expected root 5 and order 64", sb2)
+	}
+
+	if err := pager2.FreePage(SuperblockPageID); err == nil {
+		t.Error("Freeing the superblock page should fail")
+	}
+}
+
+func TestSuperblockRejectsForeignFile(t *testing.T) {
+	dbFile := "test_superblock_foreign.db"
+	defer os.Remove(dbFile)
+
+	// Two zeroed pages: no magic in page 1
+	if err := os.WriteFile(dbFile, make([]byte, 2*PageSize), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if _, err := NewFilePager(dbFile); err == nil {
+		t.Error("Expected error opening file without superblock")
+	}
+}
+
+func TestSuperblockTornCopy(t *testing.T) {
+	dbFile := "test_superblock_torn.db"
+	defer os.Remove(dbFile)
+
+	pager, err := NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	for root := uint64(5); root <= 6; root++ {
+		sb, _ := pager.ReadSuperblock()
+		sb.RootPageID = root
+		if err := pager.WriteSuperblock(sb); err != nil {
+			t.Fatalf("Failed to write superblock: %v", err)
+		}
+	}
+	sb, _ := pager.ReadSuperblock()
+	pager.Close()
+
+	// Tear the copy written last, the one before it takes over
+	file, err := os.OpenFile(dbFile, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	offset := SuperblockPageID*PageSize + int64(sb.Sequence%superblockSlots)*superblockSlotSize
+	if _, err := file.WriteAt(make([]byte, 40), offset+40); err != nil {
+		t.Fatalf("Failed to tear superblock: %v", err)
+	}
+	file.Close()
+
+	pager, err = NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to open file with a torn superblock copy: %v", err)
+	}
+	defer pager.Close()
+
+	sb2, _ := pager.ReadSuperblock()
+	if sb2.RootPageID != 5 || sb2.Sequence != sb.Sequence-1 {
+		t.Errorf("Opened %s, expected root 5 from the older copy", sb2)
+	}
+
+	// The next write goes over the torn copy
+	sb2.RootPageID = 7
+	if err := pager.WriteSuperblock(sb2); err != nil {
+		t.Fatalf("Failed to write superblock: %v", err)
+	}
+	pager.Close()
+
+	pager, err = NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen pager: %v", err)
+	}
+	if sb3, _ := pager.ReadSuperblock(); sb3.RootPageID != 7 {
+		t.Errorf("Reopened %s, expected root 7", sb3)
+	}
+}
+
+func TestSuperblockPreSuperblockFile(t *testing.T) {
+	dbFile := "test_superblock_pre_superblock.db"
+	defer os.Remove(dbFile)
+
+	// Files from before the superblock: a free list in page 0 and the root
+	// leaf in page 1, with a 16-byte header and no checksums
+	data := make([]byte, 2*PageSize)
+	binary.LittleEndian.PutUint16(data[PageSize:], uint16(PageTypeLeaf))
+	binary.LittleEndian.PutUint16(data[PageSize+2:], 3)
+	if err := os.WriteFile(dbFile, data, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	_, err := NewFilePager(dbFile)
+	if !errors.Is(err, ErrPreSuperblock) {
+		t.Errorf("Opening a pre-superblock file = %v, expected ErrPreSuperblock", err)
+	}
+
+	// A file stamped with another version names it
+	pager, err := NewFilePager(dbFile + ".new")
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer os.Remove(dbFile + ".new")
+	sb, _ := pager.ReadSuperblock()
+	pager.Close()
+
+	sb.Version = FormatVersion + 1
+	sb.Sequence++
+	file, err := os.OpenFile(dbFile+".new", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	if _, err := file.WriteAt(sb.Serialize(), SuperblockPageID*PageSize); err != nil {
+		t.Fatalf("Failed to write superblock: %v", err)
+	}
+	file.Close()
+
+	_, err = NewFilePager(dbFile + ".new")
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("unsupported format version %d", FormatVersion+1)) {
+		t.Errorf("Opening a version %d file = %v, expected an unsupported version error", FormatVersion+1, err)
+	}
+}
@@ -2,10 +2,15 @@ package wal
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"sync"
+	"time"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
 )
 
 // OpType represents the type of operation
@@ -15,137 +20,424 @@ const (
 	OpInsert OpType = 0x01
 	OpDelete OpType = 0x02
 	OpUpdate OpType = 0x03
+
+	// OpCheckpoint marks that every earlier entry has reached the data file
+	OpCheckpoint OpType = 0x04
+
+	// Transaction boundaries, the entry's TxID names the transaction
+	OpBegin  OpType = 0x05
+	OpCommit OpType = 0x06
+	OpAbort  OpType = 0x07
+
+	// OpVacuum marks the start of a vacuum, recovery reclaims the pages an
+	// unfinished one left behind
+	OpVacuum OpType = 0x08
+)
+
+const (
+	// Record framing: [length: 4][crc32: 4][lsn: 8][payload: length bytes]
+	// The CRC covers lsn and payload
+	recordHeaderSize = 16
+
+	// Payload: [opType: 1][txID: 8][keySize: 4][key][valueSize: 4][value]
+	payloadHeaderSize = 17 // Payload bytes besides the key and value
+
+	// maxPayloadSize guards against allocating garbage lengths from a torn header
+	maxPayloadSize = 64 << 20
 )
 
+// crcTable is the CRC32 table used for record checksums
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errInvalidRecord marks a torn or corrupt record, ending the valid log prefix
+var errInvalidRecord = errors.New("invalid WAL record")
+
 // Entry represents a single WAL entry
 type Entry struct {
+	LSN    uint64 // Log sequence number, assigned by Append
+	TxID   uint64 // Owning transaction, 0 for auto-committed operations
 	OpType OpType
-	Key    uint32
+	Key    []byte
 	Value  string
 }
 
 // WAL represents a Write-Ahead Log
+//
+// Appends use group commit: each caller writes its record under the mutex,
+// then waits until some fsync covers its LSN. Whichever waiter finds no sync
+// in progress becomes the leader and fsyncs everything written so far, so
+// concurrent writers share one fsync instead of paying one each.
 type WAL struct {
-	file  *os.File
-	mu    sync.Mutex
-	path  string
-	syncs int // Counter for fsync operations
+	file      *os.File
+	mu        sync.Mutex
+	synced    *sync.Cond // Signalled when a group fsync finishes
+	path      string
+	syncs     int    // Counter for fsync operations
+	nextLSN   uint64 // LSN given to the next appended entry
+	syncedLSN uint64 // Every entry up to this LSN is durable
+	syncing   bool   // A leader is running fsync
+	syncErr   error  // Set by a failed fsync, the log can't be trusted after it
+	syncMode  storage.SyncMode
+	discarded int64     // Bytes of torn or corrupt tail removed when opening
+	oldest    time.Time // When the oldest entry still in the log was appended, zero if empty
+}
+
+// Commit is a future for the durability of an appended entry
+type Commit struct {
+	wal *WAL
+	lsn uint64
+}
+
+// LSN returns the LSN assigned to the entry
+func (c *Commit) LSN() uint64 {
+	return c.lsn
+}
+
+// Wait blocks until the entry is durable on disk
+func (c *Commit) Wait() error {
+	return c.wal.waitDurable(c.lsn)
 }
 
 // NewWAL creates a new WAL file
+// An existing log is scanned and any torn or corrupt tail is truncated,
+// so appends always follow the last valid record
 func NewWAL(path string) (*WAL, error) {
 	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open WAL file: %w", err)
 	}
 
-	return &WAL{
-		file:  file,
-		path:  path,
-		syncs: 0,
-	}, nil
+	w := &WAL{
+		file:    file,
+		path:    path,
+		syncs:   0,
+		nextLSN: 1,
+	}
+	w.synced = sync.NewCond(&w.mu)
+
+	if err := w.recover(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return w, nil
 }
 
-// Append writes an entry to the WAL
+// recover finds the end of the valid log prefix and truncates everything after it
+func (w *WAL) recover() error {
+	entries, validEnd, err := w.scan()
+	if err != nil {
+		return err
+	}
+
+	if len(entries) > 0 {
+		w.nextLSN = entries[len(entries)-1].LSN + 1
+		w.oldest = time.Now() // Append times aren't logged, age from open
+	}
+	w.syncedLSN = w.nextLSN - 1
+
+	info, err := w.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat WAL: %w", err)
+	}
+
+	if info.Size() > validEnd {
+		w.discarded = info.Size() - validEnd
+		if err := w.file.Truncate(validEnd); err != nil {
+			return fmt.Errorf("failed to truncate torn WAL tail: %w", err)
+		}
+		if err := w.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync WAL after truncate: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Append writes an entry to the WAL and waits until it is durable
 func (w *WAL) Append(entry *Entry) error {
+	commit, err := w.AppendAsync(entry)
+	if err != nil {
+		return err
+	}
+	return commit.Wait()
+}
+
+// AppendAsync writes an entry to the WAL without waiting for fsync
+// The entry is not durable until Wait on the returned commit succeeds
+func (w *WAL) AppendAsync(entry *Entry) (*Commit, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if w.syncErr != nil {
+		return nil, fmt.Errorf("WAL unusable after failed sync: %w", w.syncErr)
+	}
+
 	// Serialize entry
+	entry.LSN = w.nextLSN
 	data := w.serializeEntry(entry)
 
 	// Write to file
 	if _, err := w.file.Write(data); err != nil {
-		return fmt.Errorf("failed to write WAL entry: %w", err)
+		return nil, fmt.Errorf("failed to write WAL entry: %w", err)
+	}
+
+	w.nextLSN++
+	if w.oldest.IsZero() {
+		w.oldest = time.Now()
 	}
+	return &Commit{wal: w, lsn: entry.LSN}, nil
+}
+
+// SyncTo blocks until every entry up to lsn is durable
+// A data page stamped with lsn may be written once it returns. An lsn past
+// the last entry waits for the last entry.
+func (w *WAL) SyncTo(lsn uint64) error {
+	w.mu.Lock()
+	lsn = min(lsn, w.nextLSN-1)
+	w.mu.Unlock()
+
+	return w.waitDurable(lsn)
+}
+
+// waitDurable blocks until lsn is covered by an fsync, leading one if none is running
+func (w *WAL) waitDurable(lsn uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Durability is left to the OS
+	if w.syncMode == storage.SyncOff {
+		return nil
+	}
+
+	for w.syncedLSN < lsn {
+		if w.syncErr != nil {
+			return fmt.Errorf("failed to sync WAL: %w", w.syncErr)
+		}
+		if w.syncing {
+			w.synced.Wait()
+			continue
+		}
+
+		// Become the leader: one fsync covers everything written so far
+		w.syncing = true
+		target := w.nextLSN - 1
+
+		w.mu.Unlock()
+		err := w.file.Sync()
+		w.mu.Lock()
 
-	// Flush to disk (fsync)
-	if err := w.file.Sync(); err != nil {
-		return fmt.Errorf("failed to sync WAL: %w", err)
+		w.syncing = false
+		if err != nil {
+			w.syncErr = err
+		} else {
+			w.syncs++
+			if target > w.syncedLSN {
+				w.syncedLSN = target
+			}
+		}
+		w.synced.Broadcast()
 	}
 
-	w.syncs++
 	return nil
 }
 
-// serializeEntry converts an entry to bytes
+// serializeEntry converts an entry to a framed, checksummed record
 func (w *WAL) serializeEntry(entry *Entry) []byte {
 	valueBytes := []byte(entry.Value)
-	valueSize := uint32(len(valueBytes))
+	payloadSize := payloadHeaderSize + len(entry.Key) + len(valueBytes)
 
-	// Total size: 1 (opType) + 4 (key) + 4 (valueSize) + len(value)
-	data := make([]byte, 9+valueSize)
+	data := make([]byte, recordHeaderSize+payloadSize)
 
-	data[0] = byte(entry.OpType)
-	binary.LittleEndian.PutUint32(data[1:5], entry.Key)
-	binary.LittleEndian.PutUint32(data[5:9], valueSize)
-	copy(data[9:], valueBytes)
+	// Payload
+	payload := data[recordHeaderSize:]
+	payload[0] = byte(entry.OpType)
+	binary.LittleEndian.PutUint64(payload[1:9], entry.TxID)
+	binary.LittleEndian.PutUint32(payload[9:13], uint32(len(entry.Key)))
+	copy(payload[13:], entry.Key)
+	valueStart := 13 + len(entry.Key)
+	binary.LittleEndian.PutUint32(payload[valueStart:valueStart+4], uint32(len(valueBytes)))
+	copy(payload[valueStart+4:], valueBytes)
+
+	// Header
+	binary.LittleEndian.PutUint32(data[0:4], uint32(payloadSize))
+	binary.LittleEndian.PutUint64(data[8:16], entry.LSN)
+	binary.LittleEndian.PutUint32(data[4:8], crc32.Checksum(data[8:], crcTable))
 
 	return data
 }
 
-// ReadAll reads all entries from the WAL
+// ReadAll reads all valid entries from the WAL
+// Reading stops at the first torn or corrupt record
 func (w *WAL) ReadAll() ([]*Entry, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	entries, _, err := w.scan()
+	return entries, err
+}
+
+// scan reads records from the start of the file up to the first invalid one
+// Returns the entries and the byte offset where the valid prefix ends
+func (w *WAL) scan() ([]*Entry, int64, error) {
 	// Seek to beginning
-	if _, err := w.file.Seek(0, 0); err != nil {
-		return nil, fmt.Errorf("failed to seek WAL: %w", err)
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, fmt.Errorf("failed to seek WAL: %w", err)
 	}
 
 	entries := make([]*Entry, 0)
+	validEnd := int64(0)
+	lastLSN := uint64(0)
 
 	for {
-		entry, err := w.readEntry()
-		if err == io.EOF {
+		entry, size, err := w.readEntry()
+		if err == io.EOF || errors.Is(err, errInvalidRecord) {
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to read WAL entry: %w", err)
+			return nil, 0, fmt.Errorf("failed to read WAL entry: %w", err)
+		}
+
+		// LSNs only move forward, anything else is leftover garbage
+		if entry.LSN <= lastLSN {
+			break
 		}
+		lastLSN = entry.LSN
 
 		entries = append(entries, entry)
+		validEnd += size
 	}
 
 	// Seek back to end for future appends
-	if _, err := w.file.Seek(0, 2); err != nil {
-		return nil, fmt.Errorf("failed to seek to end: %w", err)
+	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
+		return nil, 0, fmt.Errorf("failed to seek to end: %w", err)
 	}
 
-	return entries, nil
+	return entries, validEnd, nil
 }
 
-// readEntry reads a single entry from the current file position
-func (w *WAL) readEntry() (*Entry, error) {
-	// Read header (9 bytes: 1 opType + 4 key + 4 valueSize)
-	header := make([]byte, 9)
-	if _, err := io.ReadFull(w.file, header); err != nil {
-		return nil, err
+// readEntry reads a single record from the current file position
+// Returns the entry and the number of bytes it occupies
+func (w *WAL) readEntry() (*Entry, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	n, err := io.ReadFull(w.file, header)
+	if err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	if err == io.ErrUnexpectedEOF {
+		return nil, 0, fmt.Errorf("%w: torn header (%d bytes)", errInvalidRecord, n)
+	}
+	if err != nil {
+		return nil, 0, err
 	}
 
-	opType := OpType(header[0])
-	key := binary.LittleEndian.Uint32(header[1:5])
-	valueSize := binary.LittleEndian.Uint32(header[5:9])
+	payloadSize := binary.LittleEndian.Uint32(header[0:4])
+	checksum := binary.LittleEndian.Uint32(header[4:8])
+	lsn := binary.LittleEndian.Uint64(header[8:16])
+
+	if payloadSize < payloadHeaderSize || payloadSize > maxPayloadSize {
+		return nil, 0, fmt.Errorf("%w: bad length %d", errInvalidRecord, payloadSize)
+	}
 
-	// Read value
-	valueBytes := make([]byte, valueSize)
-	if _, err := io.ReadFull(w.file, valueBytes); err != nil {
-		return nil, fmt.Errorf("failed to read value: %w", err)
+	payload := make([]byte, payloadSize)
+	if _, err := io.ReadFull(w.file, payload); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, 0, fmt.Errorf("%w: torn payload", errInvalidRecord)
+		}
+		return nil, 0, err
+	}
+
+	crc := crc32.Update(crc32.Checksum(header[8:16], crcTable), crcTable, payload)
+	if crc != checksum {
+		return nil, 0, fmt.Errorf("%w: checksum mismatch at LSN %d", errInvalidRecord, lsn)
+	}
+
+	keySize := binary.LittleEndian.Uint32(payload[9:13])
+	if payloadHeaderSize+uint64(keySize) > uint64(payloadSize) {
+		return nil, 0, fmt.Errorf("%w: key size %d exceeds record", errInvalidRecord, keySize)
+	}
+	valueStart := 13 + keySize
+	valueSize := binary.LittleEndian.Uint32(payload[valueStart : valueStart+4])
+	if payloadHeaderSize+uint64(keySize)+uint64(valueSize) != uint64(payloadSize) {
+		return nil, 0, fmt.Errorf("%w: value size %d exceeds record", errInvalidRecord, valueSize)
+	}
+
+	var key []byte
+	if keySize > 0 {
+		key = payload[13:valueStart]
 	}
 
 	return &Entry{
-		OpType: opType,
+		LSN:    lsn,
+		TxID:   binary.LittleEndian.Uint64(payload[1:9]),
+		OpType: OpType(payload[0]),
 		Key:    key,
-		Value:  string(valueBytes),
-	}, nil
+		Value:  string(payload[valueStart+4:]),
+	}, int64(recordHeaderSize + payloadSize), nil
+}
+
+// LastLSN returns the LSN of the most recently appended entry, 0 if none
+func (w *WAL) LastLSN() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.nextLSN - 1
+}
+
+// DurableLSN returns the last LSN that survives a crash
+// With SyncOff durability is left to the OS, so every written entry counts
+func (w *WAL) DurableLSN() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.syncMode == storage.SyncOff {
+		return w.nextLSN - 1
+	}
+	return w.syncedLSN
+}
+
+// AdvanceLSN makes sure the next appended entry gets at least the given LSN
+// Used after truncation so LSNs keep increasing across log generations
+func (w *WAL) AdvanceLSN(next uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if next > w.nextLSN {
+		w.nextLSN = next
+	}
+}
+
+// SetSyncMode sets when appended entries are fsynced
+// FULL and NORMAL fsync when a commit is waited on, OFF never does
+func (w *WAL) SetSyncMode(mode storage.SyncMode) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.syncMode = mode
+}
+
+// SyncMode returns the WAL's sync mode
+func (w *WAL) SyncMode() storage.SyncMode {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.syncMode
+}
+
+// DiscardedBytes returns how many bytes of torn or corrupt tail were
+// truncated when the WAL was opened
+func (w *WAL) DiscardedBytes() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.discarded
 }
 
 // Truncate clears the WAL file
+// Every entry must already be covered by a checkpoint, unsynced entries are dropped
 func (w *WAL) Truncate() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	for w.syncing {
+		w.synced.Wait()
+	}
+
 	if err := w.file.Truncate(0); err != nil {
 		return fmt.Errorf("failed to truncate WAL: %w", err)
 	}
@@ -155,6 +447,7 @@ func (w *WAL) Truncate() error {
 	}
 
 	w.syncs = 0
+	w.oldest = time.Time{}
 	return nil
 }
 
@@ -163,10 +456,18 @@ func (w *WAL) Close() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	if err := w.file.Sync(); err != nil {
-		return fmt.Errorf("failed to sync before close: %w", err)
+	// Let an in-flight group sync finish before the file goes away
+	for w.syncing {
+		w.synced.Wait()
 	}
 
+	if w.syncMode != storage.SyncOff {
+		if err := w.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync before close: %w", err)
+		}
+	}
+	w.syncedLSN = w.nextLSN - 1 // Pages written after Close have nothing to wait for
+
 	if err := w.file.Close(); err != nil {
 		return fmt.Errorf("failed to close WAL: %w", err)
 	}
@@ -194,6 +495,17 @@ func (w *WAL) Size() (int64, error) {
 	return info.Size(), nil
 }
 
+// Age returns how long the oldest entry has been in the log, 0 if empty
+func (w *WAL) Age() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.oldest.IsZero() {
+		return 0
+	}
+	return time.Since(w.oldest)
+}
+
 // Exists checks if WAL file exists and has content
 func Exists(path string) bool {
 	info, err := os.Stat(path)
@@ -1,8 +1,13 @@
 package wal
 
 import (
+	"bytes"
+	"fmt"
 	"os"
+	"sync"
 	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
 )
 
 func TestWALBasicOperations(t *testing.T) {
@@ -18,9 +23,9 @@ func TestWALBasicOperations(t *testing.T) {
 
 	// Append entries
 	entries := []*Entry{
-		{OpType: OpInsert, Key: 100, Value: "naruto"},
-		{OpType: OpInsert, Key: 200, Value: "sasuke"},
-		{OpType: OpInsert, Key: 50, Value: "sakura"},
+		{OpType: OpInsert, Key: storage.Uint32Key(100), Value: "naruto"},
+		{OpType: OpInsert, Key: []byte("konoha/users/sasuke"), Value: "sasuke"},
+		{OpType: OpDelete, Key: []byte("k"), Value: ""},
 	}
 
 	for _, entry := range entries {
@@ -47,8 +52,8 @@ func TestWALBasicOperations(t *testing.T) {
 		if entry.OpType != entries[i].OpType {
 			t.Errorf("Entry %d: OpType=%d, expected %d", i, entry.OpType, entries[i].OpType)
 		}
-		if entry.Key != entries[i].Key {
-			t.Errorf("Entry %d: Key=%d, expected %d", i, entry.Key, entries[i].Key)
+		if !bytes.Equal(entry.Key, entries[i].Key) {
+			t.Errorf("Entry %d: Key=%q, expected %q", i, entry.Key, entries[i].Key)
 		}
 		if entry.Value != entries[i].Value {
 			t.Errorf("Entry %d: Value=%s, expected %s", i, entry.Value, entries[i].Value)
@@ -70,9 +75,9 @@ func TestWALPersistence(t *testing.T) {
 		}
 
 		entries := []*Entry{
-			{OpType: OpInsert, Key: 1, Value: "one"},
-			{OpType: OpInsert, Key: 2, Value: "two"},
-			{OpType: OpInsert, Key: 3, Value: "three"},
+			{OpType: OpInsert, Key: storage.Uint32Key(1), Value: "one"},
+			{OpType: OpInsert, Key: storage.Uint32Key(2), Value: "two"},
+			{OpType: OpInsert, Key: storage.Uint32Key(3), Value: "three"},
 		}
 
 		for _, entry := range entries {
@@ -128,7 +133,7 @@ func TestWALTruncate(t *testing.T) {
 	for i := 1; i <= 5; i++ {
 		entry := &Entry{
 			OpType: OpInsert,
-			Key:    uint32(i),
+			Key:    storage.Uint32Key(uint32(i)),
 			Value:  "value",
 		}
 		if err := w.Append(entry); err != nil {
@@ -162,6 +167,125 @@ func TestWALTruncate(t *testing.T) {
 	t.Log("✓ Truncate successful")
 }
 
+func TestWALTornTailRecovery(t *testing.T) {
+	walPath := "test_torn_tail.wal"
+	defer os.Remove(walPath)
+
+	var validSize int64
+
+	// Write 3 complete entries, then half of a 4th
+	{
+		w, err := NewWAL(walPath)
+		if err != nil {
+			t.Fatalf("Failed to create WAL: %v", err)
+		}
+
+		for i := 1; i <= 3; i++ {
+			if err := w.Append(&Entry{OpType: OpInsert, Key: storage.Uint32Key(uint32(i)), Value: "value"}); err != nil {
+				t.Fatalf("Failed to append: %v", err)
+			}
+		}
+		validSize, _ = w.Size()
+
+		torn := w.serializeEntry(&Entry{LSN: 4, OpType: OpInsert, Key: storage.Uint32Key(4), Value: "torn-value"})
+		if _, err := w.file.Write(torn[:len(torn)/2]); err != nil {
+			t.Fatalf("Failed to write torn entry: %v", err)
+		}
+		w.Close()
+	}
+
+	// Reopen: the torn tail must be dropped, the prefix kept
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer w.Close()
+
+	entries, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed on torn log: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Read %d entries, expected 3", len(entries))
+	}
+	for i, entry := range entries {
+		if entry.LSN != uint64(i+1) {
+			t.Errorf("Entry %d: LSN=%d, expected %d", i, entry.LSN, i+1)
+		}
+	}
+
+	size, _ := w.Size()
+	if size != validSize {
+		t.Errorf("WAL size after recovery = %d, expected %d", size, validSize)
+	}
+	if w.DiscardedBytes() == 0 {
+		t.Error("DiscardedBytes() = 0, expected torn bytes to be reported")
+	}
+	t.Logf("✓ Discarded %d bytes of torn tail", w.DiscardedBytes())
+
+	// New appends continue the sequence and are readable
+	entry := &Entry{OpType: OpInsert, Key: storage.Uint32Key(99), Value: "after"}
+	if err := w.Append(entry); err != nil {
+		t.Fatalf("Failed to append after recovery: %v", err)
+	}
+	if entry.LSN != 4 {
+		t.Errorf("LSN after recovery = %d, expected 4", entry.LSN)
+	}
+
+	entries, _ = w.ReadAll()
+	if len(entries) != 4 || !bytes.Equal(entries[3].Key, storage.Uint32Key(99)) {
+		t.Errorf("Expected 4 entries ending with key 99, got %d", len(entries))
+	}
+}
+
+func TestWALCorruptRecord(t *testing.T) {
+	walPath := "test_corrupt_record.wal"
+	defer os.Remove(walPath)
+
+	{
+		w, err := NewWAL(walPath)
+		if err != nil {
+			t.Fatalf("Failed to create WAL: %v", err)
+		}
+		for i := 1; i <= 5; i++ {
+			if err := w.Append(&Entry{OpType: OpInsert, Key: storage.Uint32Key(uint32(i)), Value: "value"}); err != nil {
+				t.Fatalf("Failed to append: %v", err)
+			}
+		}
+		w.Close()
+	}
+
+	// Flip a bit inside the value of the 3rd record
+	data, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatalf("Failed to read WAL file: %v", err)
+	}
+	recordSize := len(data) / 5
+	data[2*recordSize+recordHeaderSize+payloadHeaderSize] ^= 0x01
+	if err := os.WriteFile(walPath, data, 0644); err != nil {
+		t.Fatalf("Failed to write WAL file: %v", err)
+	}
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer w.Close()
+
+	entries, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+
+	// Everything from the corrupt record onwards is discarded
+	if len(entries) != 2 {
+		t.Errorf("Read %d entries, expected 2", len(entries))
+	}
+	if w.DiscardedBytes() != int64(3*recordSize) {
+		t.Errorf("DiscardedBytes() = %d, expected %d", w.DiscardedBytes(), 3*recordSize)
+	}
+}
+
 func BenchmarkWALAppend(b *testing.B) {
 	walPath := "bench_append.wal"
 	defer os.Remove(walPath)
@@ -171,7 +295,7 @@ func BenchmarkWALAppend(b *testing.B) {
 
 	entry := &Entry{
 		OpType: OpInsert,
-		Key:    100,
+		Key:    storage.Uint32Key(100),
 		Value:  "benchmark-value",
 	}
 
@@ -183,3 +307,154 @@ func BenchmarkWALAppend(b *testing.B) {
 
 	b.Logf("Performed %d fsync operations", w.GetSyncCount())
 }
+
+func TestWALGroupCommit(t *testing.T) {
+	walPath := "test_group_commit.wal"
+	defer os.Remove(walPath)
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer w.Close()
+
+	const writers = 32
+	const perWriter = 20
+
+	// Every writer appends, then all of them wait at once, so every waiter
+	// overlaps the first leader's fsync
+	var written, wg sync.WaitGroup
+	written.Add(writers)
+	errs := make(chan error, writers)
+	for g := 0; g < writers; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			var last *Commit
+			for i := 0; i < perWriter; i++ {
+				entry := &Entry{OpType: OpInsert, Key: storage.Uint32Key(uint32(g*perWriter + i)), Value: fmt.Sprintf("v%d", i)}
+				commit, err := w.AppendAsync(entry)
+				if err != nil {
+					errs <- err
+					break
+				}
+				last = commit
+			}
+
+			written.Done()
+			written.Wait()
+			if last != nil {
+				if err := last.Wait(); err != nil {
+					errs <- err
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	entries, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read entries: %v", err)
+	}
+	if len(entries) != writers*perWriter {
+		t.Fatalf("Read %d entries, expected %d", len(entries), writers*perWriter)
+	}
+
+	// LSNs are dense and in file order even with interleaved writers
+	for i, entry := range entries {
+		if entry.LSN != uint64(i+1) {
+			t.Fatalf("Entry %d: LSN=%d, expected %d", i, entry.LSN, i+1)
+		}
+	}
+
+	syncs := w.GetSyncCount()
+	t.Logf("✓ %d appends used %d fsyncs", len(entries), syncs)
+	if syncs != 1 {
+		t.Errorf("Concurrent writers used %d fsyncs, expected one shared group commit", syncs)
+	}
+}
+
+func TestWALAppendAsync(t *testing.T) {
+	walPath := "test_append_async.wal"
+	defer os.Remove(walPath)
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer w.Close()
+
+	var commits []*Commit
+	for i := uint32(0); i < 10; i++ {
+		commit, err := w.AppendAsync(&Entry{OpType: OpInsert, Key: storage.Uint32Key(i), Value: "value"})
+		if err != nil {
+			t.Fatalf("AppendAsync failed: %v", err)
+		}
+		commits = append(commits, commit)
+	}
+
+	if syncs := w.GetSyncCount(); syncs != 0 {
+		t.Errorf("AppendAsync performed %d fsyncs before Wait", syncs)
+	}
+
+	// Waiting on the last commit makes every earlier entry durable too
+	if err := commits[len(commits)-1].Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	for _, commit := range commits {
+		if err := commit.Wait(); err != nil {
+			t.Fatalf("Wait(LSN=%d) failed: %v", commit.LSN(), err)
+		}
+	}
+
+	if syncs := w.GetSyncCount(); syncs != 1 {
+		t.Errorf("Syncs = %d, expected 1", syncs)
+	}
+}
+
+func TestWALSyncModes(t *testing.T) {
+	testCases := []struct {
+		mode          storage.SyncMode
+		expectedSyncs int
+	}{
+		{storage.SyncFull, 5},
+		{storage.SyncNormal, 5},
+		{storage.SyncOff, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.mode.String(), func(t *testing.T) {
+			walPath := "test_sync_mode.wal"
+			os.Remove(walPath)
+			defer os.Remove(walPath)
+
+			w, err := NewWAL(walPath)
+			if err != nil {
+				t.Fatalf("Failed to create WAL: %v", err)
+			}
+			defer w.Close()
+
+			w.SetSyncMode(tc.mode)
+			for i := uint32(0); i < 5; i++ {
+				if err := w.Append(&Entry{OpType: OpInsert, Key: storage.Uint32Key(i), Value: "value"}); err != nil {
+					t.Fatalf("Append failed: %v", err)
+				}
+			}
+
+			if syncs := w.GetSyncCount(); syncs != tc.expectedSyncs {
+				t.Errorf("Syncs = %d, expected %d", syncs, tc.expectedSyncs)
+			}
+
+			entries, err := w.ReadAll()
+			if err != nil || len(entries) != 5 {
+				t.Errorf("ReadAll = (%d entries, %v), expected 5 entries", len(entries), err)
+			}
+		})
+	}
+}